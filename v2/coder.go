@@ -1,23 +1,252 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	// Import fmt for error message
-	"github.com/golang/glog"                    // Import glog
+	"github.com/golang/glog" // Import glog
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/anthropic"
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/gemini"
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/provider"
 	"github.com/zicongmei/ai-coder/v2/pkg/flow" // Import the new flow package
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+	"github.com/zicongmei/ai-coder/v2/pkg/modifyFiles"
+	"github.com/zicongmei/ai-coder/v2/pkg/prompt"
 	"github.com/zicongmei/ai-coder/v2/pkg/utils"
 )
 
+// stringList is a flag.Value that accumulates the values of a repeatable flag.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// parseFileNotes converts "path=note" flag values into a map keyed by path.
+// Notes given for the same path are joined with a space.
+func parseFileNotes(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	notes := make(map[string]string, len(values))
+	for _, value := range values {
+		path, note, ok := strings.Cut(value, "=")
+		path = strings.TrimSpace(path)
+		if !ok || path == "" {
+			return nil, fmt.Errorf("%q is not of the form path=note", value)
+		}
+		if existing := notes[path]; existing != "" {
+			note = existing + " " + note
+		}
+		notes[path] = note
+	}
+	return notes, nil
+}
+
+// splitCSV splits a comma-separated flag value, dropping empty entries.
+func splitCSV(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// flashModel is the model selected by the --flash alias.
+const flashModel = "gemini-2.5-flash"
+
+// resolveModel applies the --flash alias to the --model value. modelSet reports
+// whether --model was given explicitly; combining it with --flash is an error
+// unless both name the same model.
+func resolveModel(model string, modelSet, flash bool) (string, error) {
+	if !flash {
+		return model, nil
+	}
+	if modelSet && model != flashModel {
+		return "", fmt.Errorf("--flash selects %q and conflicts with --model %q", flashModel, model)
+	}
+	return flashModel, nil
+}
+
+// logLevelFlags returns the glog flag values that implement --quiet and --verbose.
+// --verbose raises the verbosity to 2. --quiet keeps informational logs, which include
+// V(0) and cannot be filtered by verbosity, off stderr so only warnings and errors
+// appear there; the log files still get everything. Flags in explicit, i.e. given on
+// the command line, are left alone. Combining quiet and verbose is an error.
+func logLevelFlags(quiet, verbose bool, explicit map[string]bool) (map[string]string, error) {
+	if quiet && verbose {
+		return nil, errors.New("--quiet and --verbose cannot be combined")
+	}
+	values := map[string]string{}
+	if verbose {
+		values["v"] = "2"
+	}
+	if quiet {
+		values["alsologtostderr"] = "false"
+		values["stderrthreshold"] = "WARNING"
+	}
+	for name := range values {
+		if explicit[name] {
+			delete(values, name)
+		}
+	}
+	return values, nil
+}
+
+// readPromptFile returns the prompt for --prompt-file: the content of path with
+// surrounding whitespace trimmed. The file is an alternative to --prompt, so giving
+// both is an error, as is an empty file.
+func readPromptFile(prompt, path string) (string, error) {
+	if prompt != "" {
+		return "", errors.New("--prompt and --prompt-file cannot be combined")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the prompt file: %w", err)
+	}
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return "", fmt.Errorf("prompt file %q is empty", path)
+	}
+	return text, nil
+}
+
 // Config holds the command-line arguments for the coder application.
 type Config struct {
-	FileList string // Path to a file containing a list of files to process
-	Flash    bool   // Whether to use flash mode
-	Model    string // Model to use
-	Inplace  bool   // Whether to modify the files in place
-	Prompt   string // The prompt to send to the AI
-	Tools    string // Comma-separated list of tools to enable
+	FileList string     // Path to a file containing a list of files to process
+	Files    stringList // Individual files to process, in addition to the file list
+	SinceGit string     // Git ref; the files changed since it are processed too
+	Provider string     // AI provider: "gemini" or "anthropic"
+	Flash    bool       // Alias for --model gemini-2.5-flash
+	Model    string     // Model to use
+	Inplace  bool       // Whether to modify the files in place
+	Prompt   string     // The prompt to send to the AI
+	Tools    string     // Comma-separated list of tools to enable
+
+	MaxFileSize       int64         // Maximum size (in bytes) of a single input file
+	TruncateOversized bool          // Whether to truncate oversized files instead of skipping them
+	TimeoutPerFile    time.Duration // Files taking longer than this to read are skipped; 0 disables the limit
+	RetryOnParseFail  int           // Number of times to re-send the prompt when the response cannot be parsed
+	MaxTotalDuration  time.Duration // Wall-clock budget for the whole run, retries included; 0 disables it
+	AutoSelect        bool          // Whether to first ask the AI which files are relevant and send only those
+	RequireTokenCount bool          // Whether to fail when the prompt's tokens cannot be counted instead of estimating
+	AutoRepair        int           // Number of follow-ups asking the AI to reformat a response that cannot be parsed
+	SkipMissing       bool          // Whether to skip missing files and unmatched globs instead of failing
+
+	Excludes     stringList // Glob patterns of file list entries to skip
+	ContextFiles stringList // Read-only reference files included in the prompt
+	Attachments  stringList // Binary files (images, PDFs) sent inline with the prompt
+
+	Project  string // Google Cloud project for the Vertex AI backend
+	Location string // Google Cloud location for the Vertex AI backend
+
+	APIKeyFile string // File holding the API key, used if GEMINI_API_KEY (or ANTHROPIC_API_KEY) is unset
+
+	Format     string // Output format for in-place modification: "fulltext", "diff" or "search-replace"
+	LineEnding string // Line ending for patched files: "auto", "lf" or "crlf"
+	CheckStale string // What to do if files change while waiting for the AI: "off", "warn" or "abort"
+
+	Interactive bool // Whether to read follow-up instructions from stdin after each turn
+	Stats       bool // Whether to print an end-of-run summary
+
+	Only     string // Comma-separated list of files to write; other changes are skipped
+	AllowExt string // Comma-separated list of file extensions that may be written
+	AllowNew bool   // Whether full-text responses may write files that were not requested
+	Gofmt    bool   // Whether to gofmt the Go files written from AI responses
+
+	Fuzzy          bool   // Whether diff hunks that do not match exactly may be applied nearby by fuzzy matching
+	DiffEngine     string // How diff hunks are placed: "lines", "text" or "auto"
+	PreserveIndent bool   // Whether to restore the .editorconfig or original indentation of changed files
+	NormalizeEOL   bool   // Whether to convert the line endings of full-text blocks to each file's own
+	TrimTrailing   bool   // Whether to strip trailing whitespace from full-text blocks
+
+	MarkerNonce string // Nonce included in the file markers, or "random" to generate one
+	LengthHints bool   // Whether to state file lengths in the markers and reject blocks that disagree
+
+	StrictTransport bool // Whether full-text responses carry each file's content base64-encoded
+
+	LogFormat string // Log output format: "text" or "json"
+
+	Out        string // Path to also write the raw AI response to
+	NoOpen     bool   // Whether to skip opening the response in a browser
+	NoProgress bool   // Whether to hide the progress indicator shown while waiting for the AI
+
+	AuditLog      string // Path of a JSONL file to append a summary of each run to
+	DryRun        bool   // Whether to print a diff of the AI's changes instead of writing them
+	CompressDumps bool   // Whether to gzip the prompt and response dumps in the temporary directory
+
+	JSONResult bool   // Whether to print a JSON description of the run to stdout
+	Output     string // Path to write the JSON description of the run to instead of stdout
+
+	FileNotes stringList // Per-file notes for the prompt, each as "path=note"
+
+	PromptFile   string // File holding the prompt, used instead of --prompt
+	PromptPrefix string // Text placed before the prompt, e.g. a standard preamble
+	PromptSuffix string // Text placed after the prompt
+
+	CompressContext bool // Whether to strip comments and blank lines from Go/JS files the AI does not rewrite
+
+	Version bool // Print version information and exit
+	Quiet   bool // Only show warnings and errors on stderr
+	Verbose bool // Log at verbosity 2
+
+	ApplyPatch    string // Path of a saved unified diff to apply without contacting the AI
+	ApplyFullText string // Path of a saved full-text response to apply without contacting the AI
+	Replay        string // Path of a saved raw AI response to apply without contacting the AI
+	Undo          bool   // Revert the files changed by the last apply
+
+	TokenReport  bool   // Print the token count of each file and exit without sending the prompt
+	CountTokens  bool   // Print the token count of the complete prompt and exit without sending it
+	TasksFile    string // File of prompts (optionally with their own files) to run one after another
+	StdinContent bool   // Edit the content piped on stdin and write the result to stdout
+
+	ParallelFiles bool // Send the prompt once per file, with that file alone, instead of once for all files
+	Concurrency   int  // Maximum number of --parallel-files prompts in flight at once
+
+	BatchFiles  int // Send the files in batches of at most this many, one prompt each; 0 disables it
+	BatchTokens int // Send the files in batches of at most this many estimated tokens; 0 disables it
+
+	MaxOutputTokens int           // Maximum number of tokens the AI may generate; 0 uses the model default
+	Timeout         time.Duration // Deadline for each request to the AI endpoint; 0 disables it
+	Candidates      int           // Number of alternative responses to request; with --inplace the first that applies is used
+	ModelFallback   string        // Model to switch to when Model is overloaded or out of quota; empty disables it
+}
+
+// countTokensOutput returns os.Stdout, where --count-tokens prints the prompt's token
+// count, if countTokens is set, and nil otherwise.
+func countTokensOutput(countTokens bool) io.Writer {
+	if !countTokens {
+		return nil
+	}
+	return os.Stdout
+}
+
+// progressWriter returns os.Stderr if it is a terminal, so the progress indicator
+// stays out of redirected and CI output, and nil otherwise. It also returns nil if
+// disabled is set or the terminal cannot redraw a line (TERM=dumb).
+func progressWriter(disabled bool) io.Writer {
+	if disabled || os.Getenv("TERM") == "dumb" {
+		return nil
+	}
+	info, err := os.Stderr.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return os.Stderr
 }
 
 func main() {
@@ -37,59 +266,411 @@ func main() {
 	var cfg Config
 
 	// Define command-line flags. glog also registers its own flags (e.g., -v, -logtostderr).
+	flag.BoolVar(&cfg.Version, "version", false, "Print version and build information, then exit")
+	flag.BoolVar(&cfg.Quiet, "quiet", false, "Only log warnings and errors to stderr and hide the progress indicator (the log files still get everything); an explicit -stderrthreshold overrides it")
+	flag.BoolVar(&cfg.Verbose, "verbose", false, "Log more detail, like -v=2; an explicit -v overrides it")
+	flag.StringVar(&cfg.ApplyPatch, "apply-patch", "", "Apply a saved unified diff (e.g. /tmp/unifiedDiff.txt, or - for stdin) to the files on disk without contacting the AI")
+	flag.BoolVar(&cfg.Undo, "undo", false, "Revert the files changed by the last apply (run again to revert the one before) and exit")
+	flag.StringVar(&cfg.ApplyFullText, "apply-fulltext", "", "Apply a saved full-text response with BEGIN/END file blocks (e.g. ai_raw_output_*.txt, or - for stdin) to the files on disk without contacting the AI")
+	flag.StringVar(&cfg.Replay, "replay", "", "Apply a raw AI response saved by an earlier run (ai_raw_output_*.txt) to the current files, using --format, without contacting the AI")
+	flag.BoolVar(&cfg.CountTokens, "count-tokens", false, "Build the prompt, print its token count to stdout and exit without sending it, e.g. for cost planning (an estimate, with a warning, if the model cannot count)")
+	flag.BoolVar(&cfg.TokenReport, "token-report", false, "Print the token count of each file, largest first, and exit without sending the prompt (--prompt is optional)")
+	flag.BoolVar(&cfg.ParallelFiles, "parallel-files", false, "Send the prompt once per file, with that file alone, and apply each response separately; for instructions that apply to every file independently (e.g. adding a license header)")
+	flag.IntVar(&cfg.Concurrency, "concurrency", 4, "With --parallel-files, the maximum number of prompts sent at once")
+	flag.IntVar(&cfg.BatchFiles, "batch-files", 0, "Split the files into batches of at most this many, sending one prompt per batch and applying each response before the next batch; for changes to many large files whose single response would exceed the output limit (0 disables batching)")
+	flag.IntVar(&cfg.BatchTokens, "batch-tokens", 0, "Like --batch-files, but limit each batch to about this many tokens of file content (estimated at 4 bytes per token); can be combined with --batch-files")
+	flag.StringVar(&cfg.TasksFile, "tasks-file", "", "File of tasks run one after another, each applied before the next: one prompt per line, or a JSON object per line with \"prompt\" and optional \"file_list\"/\"files\"")
+	flag.BoolVar(&cfg.StdinContent, "stdin-content", false, "Edit a single file piped on stdin and write the complete modified content to stdout, e.g. 'cat foo.go | coder --stdin-content --prompt \"add logging\" > bar.go'; no file list is used")
 	flag.StringVar(&cfg.FileList, "file-list", "", "Path to a file containing a list of files to process")
-	flag.BoolVar(&cfg.Flash, "flash", false, "[Deprecated] Use flash mode for AI interaction")
+	flag.Var(&cfg.Files, "file", "Path of a file to process; may be repeated and combined with --file-list")
+	flag.StringVar(&cfg.SinceGit, "since-git", "", "Git ref (e.g. 'main' or 'HEAD~3'); also process every file changed since it, as listed by 'git diff --name-only <ref>' (deleted files are left out)")
+	flag.StringVar(&cfg.Provider, "provider", provider.Gemini, "AI provider: "+strings.Join(provider.Names(), ", ")+" (anthropic is Claude; needs $ANTHROPIC_API_KEY or --api-key-file, and defaults --model to "+anthropic.DefaultModel+")")
+	flag.BoolVar(&cfg.Flash, "flash", false, "Alias for --model "+flashModel)
 	flag.StringVar(&cfg.Model, "model", "gemini-3-pro-preview", "Model to use")
-	flag.BoolVar(&cfg.Inplace, "inplace", false, "Modify the files in place (requires --file-list)")
+	flag.StringVar(&cfg.ModelFallback, "model-fallback", "", "Model of the same provider to switch to, e.g. '"+flashModel+"', when the model is overloaded (HTTP 503) or out of quota (HTTP 429); the failed request is re-sent to it and it serves the rest of the run")
+	flag.BoolVar(&cfg.Inplace, "inplace", false, "Modify the files in place (requires --file-list or --file)")
 	flag.StringVar(&cfg.Prompt, "prompt", "", "The prompt string to send to the AI")
 	flag.StringVar(&cfg.Tools, "tools", "", "Comma-separated list of tools to enable (e.g., 'google-search,url-context' or 'all')")
+	flag.Int64Var(&cfg.MaxFileSize, "max-file-size", flow.DefaultMaxFileSize, "Maximum size in bytes of a single input file; larger files are skipped (0 disables the limit)")
+	flag.BoolVar(&cfg.TruncateOversized, "truncate-oversized", false, "Truncate files larger than --max-file-size with a marker instead of skipping them")
+	flag.DurationVar(&cfg.TimeoutPerFile, "timeout-per-file", 0, "Skip any input file that takes longer than this to read, e.g. '5s' on a slow network mount (0 disables the limit); skipped files are reported")
+	flag.StringVar(&cfg.Format, "format", prompt.FormatFullText, "Output format requested from the AI for in-place modification: 'fulltext', 'diff' or 'search-replace' (edits anchored on unique snippets, for large files)")
+	flag.StringVar(&cfg.LineEnding, "line-ending", modifyFiles.LineEndingAuto, "Line ending for files patched in diff format (and full-text files with --normalize-eol): 'auto' (keep each file's own), 'lf' or 'crlf'")
+	flag.StringVar(&cfg.CheckStale, "check-stale", flow.CheckStaleWarn, "With --inplace, what to do if a file changes on disk between building the prompt and applying the response: 'off', 'warn' or 'abort'")
+	flag.StringVar(&cfg.Out, "out", "", "Also write the raw AI response to this file (e.g. changes.diff); with --interactive it holds the latest response")
+	flag.BoolVar(&cfg.NoOpen, "no-open", false, "Without --inplace, do not open the response in a browser (useful with --out)")
+	flag.StringVar(&cfg.AuditLog, "audit-log", "", "Append one JSON line per run (time, model, input tokens, response length, duration, success or error) to this file")
+	flag.BoolVar(&cfg.DryRun, "dry-run", false, "Print a unified diff of the changes the AI response would make to stdout instead of writing the files (implies --inplace); also applies to --replay, --apply-patch and --apply-fulltext")
+	flag.BoolVar(&cfg.CompressDumps, "compress-dumps", false, "Gzip the prompt, raw response and transcript dumps in the temporary directory (ai_*.txt.gz); --replay and --apply-* read them as is")
+	flag.BoolVar(&cfg.NoProgress, "no-progress", false, "Do not show the spinner with the elapsed time while waiting for the AI (it is already hidden when stderr is not a terminal)")
+	flag.BoolVar(&cfg.JSONResult, "json-result", false, "At the end of the run, print a JSON document describing it (prompt, model, token count, changed files with content hashes) to stdout")
+	flag.StringVar(&cfg.Output, "output", "", "Write the --json-result document to this file instead of stdout (implies --json-result)")
+	flag.BoolVar(&cfg.Interactive, "interactive", false, "After each response, read a follow-up instruction from stdin and continue the conversation")
+	flag.StringVar(&cfg.Only, "only", "", "Comma-separated list of files to write with --inplace; changes to other files are skipped")
+	flag.StringVar(&cfg.AllowExt, "allow-ext", "", "Comma-separated list of file extensions (e.g. '.go,.md') that --inplace may write; changes to other files are rejected (default: all)")
+	flag.BoolVar(&cfg.AllowNew, "allow-new", false, "With --inplace, let the AI create or change files that were not in the requested file set (refused by default)")
+	flag.StringVar(&cfg.MarkerNonce, "marker-nonce", "", "Nonce to include in the file start/end markers, so files that contain the default marker text parse correctly; 'random' generates one for this run")
+	flag.BoolVar(&cfg.LengthHints, "length-hints", false, "State each file's length in bytes in its start marker and ask the AI to do the same; with --inplace, a full-text block whose length disagrees is treated as malformed and not written")
+	flag.BoolVar(&cfg.StrictTransport, "strict-transport", false, "With --format fulltext, ask the AI to return each file's content base64-encoded between the markers and decode it when applying, so content containing marker text, unusual whitespace or non-UTF-8 bytes is written exactly")
+	flag.BoolVar(&cfg.Gofmt, "gofmt", false, "With --inplace (or --apply-patch/--apply-fulltext), run gofmt on every .go file the AI writes, in any --format; files that do not parse are written as returned and reported")
+	flag.StringVar(&cfg.DiffEngine, "diff-engine", modifyFiles.DiffEngineLines, "With --inplace and --format diff (or --apply-patch), how hunks are placed: 'lines' (in order, like patch), 'text' (each hunk on its own where its text matches, accepting hunks out of order) or 'auto' (lines, falling back to text for a file it cannot patch)")
+	flag.BoolVar(&cfg.Fuzzy, "fuzzy", false, "With --inplace and --format diff (or --apply-patch), apply a hunk whose lines do not match the file exactly within a few lines of its stated position, ignoring trailing whitespace and blank lines missing on either side; such files are reported")
+	flag.BoolVar(&cfg.PreserveIndent, "preserve-indent", false, "With --inplace, re-indent each changed file with the indent_style of its .editorconfig or, without one, the tabs or spaces detected in the original file")
+	flag.BoolVar(&cfg.NormalizeEOL, "normalize-eol", false, "With --inplace and --format fulltext (or --apply-fulltext), convert the line endings of each written file to the file's original convention, or to --line-ending if set to lf or crlf")
+	flag.BoolVar(&cfg.TrimTrailing, "trim-trailing", false, "With --inplace and --format fulltext (or --apply-fulltext), remove trailing spaces and tabs from every line of each written file")
+	flag.BoolVar(&cfg.Stats, "stats", false, "Print an end-of-run summary (files read, tokens, response size, files changed, elapsed time)")
+	flag.StringVar(&cfg.LogFormat, "log-format", logging.FormatText, "Log output format: 'text' (glog) or 'json' (key events as JSON lines on stderr; glog still writes its log files)")
+	flag.StringVar(&cfg.PromptFile, "prompt-file", "", "Read the prompt from this file instead of --prompt, e.g. a long or reused instruction; --prompt-prefix and --prompt-suffix still wrap it")
+	flag.StringVar(&cfg.PromptPrefix, "prompt-prefix", "", "Text placed on its own line before --prompt (or --prompt-file), e.g. a team's standard preamble")
+	flag.StringVar(&cfg.PromptSuffix, "prompt-suffix", "", "Text placed on its own line after --prompt (or --prompt-file)")
+	flag.Var(&cfg.FileNotes, "file-note", "Per-file guidance for the AI as 'path=note', emitted right before that file in the prompt (repeatable)")
+	flag.IntVar(&cfg.MaxOutputTokens, "max-output-tokens", 0, "Maximum number of tokens the AI may generate (0 uses the model default); raise it if large responses get clipped")
+	flag.IntVar(&cfg.Candidates, "candidates", 1, "Number of alternative responses to request per prompt (Gemini only); with --inplace, the first that parses and applies cleanly is used, which helps with flaky formatting")
+	flag.DurationVar(&cfg.Timeout, "timeout", 10*time.Minute, "Deadline for each request to the AI endpoint, e.g. '90s' or '15m' (0 disables it); a timed-out token count falls back to an estimate")
+	flag.StringVar(&cfg.Project, "project", "", "Google Cloud project for the Vertex AI backend (defaults to $GOOGLE_CLOUD_PROJECT)")
+	flag.StringVar(&cfg.APIKeyFile, "api-key-file", "", "File holding the API key, or sm://projects/P/secrets/S[/versions/V] to read it from Secret Manager, used if $GEMINI_API_KEY is unset (defaults to $GEMINI_API_KEY_FILE); takes precedence over ADC and Vertex AI. With --provider anthropic, used if $ANTHROPIC_API_KEY is unset (defaults to $ANTHROPIC_API_KEY_FILE)")
+	flag.StringVar(&cfg.Location, "location", "", "Google Cloud location for the Vertex AI backend (defaults to $GOOGLE_CLOUD_LOCATION)")
+	flag.BoolVar(&cfg.CompressContext, "compress-context", false, "Strip comments and blank lines from Go and JavaScript files in the prompt to save tokens; with --inplace only --context-file files are compressed, since the files to edit are rewritten from the response")
+	flag.Var(&cfg.ContextFiles, "context-file", "Path of a read-only reference file to include in the prompt; the AI may not change it (repeatable)")
+	flag.Var(&cfg.Attachments, "attach", "Path of an image, PDF or other binary file to send inline with the prompt (repeatable)")
+	flag.Var(&cfg.Excludes, "exclude", "Glob pattern of files to drop from the file list, matched against the relative path and base name (repeatable)")
+	flag.BoolVar(&cfg.SkipMissing, "skip-missing", false, "Skip files that do not exist and file list globs that match nothing, with a warning, instead of failing")
+	flag.BoolVar(&cfg.AutoSelect, "auto-select", false, "Before the main request, send the AI only the file paths and ask which are relevant to the prompt; the other files are left out of the prompt and cannot be changed")
+	flag.BoolVar(&cfg.RequireTokenCount, "require-token-count", false, "Fail if the AI endpoint cannot count the prompt's tokens (after retries) instead of continuing with a local estimate")
+	flag.IntVar(&cfg.RetryOnParseFail, "retry-on-parse-fail", 0, "Number of times to re-send the prompt when the AI response cannot be parsed (requires --inplace)")
+	flag.DurationVar(&cfg.MaxTotalDuration, "max-total-duration", 0, "Abort the run, whatever retries remain, once it has taken this long in total, e.g. '20m' (0 disables the limit); covers every request, --auto-repair and --retry-on-parse-fail")
+	flag.IntVar(&cfg.AutoRepair, "auto-repair", 0, "Number of follow-up messages asking the AI to reformat a response that cannot be parsed, tried before --retry-on-parse-fail (requires --inplace)")
+
+	defaultUsage := flag.Usage
+	flag.Usage = func() {
+		defaultUsage()
+		fmt.Fprint(flag.CommandLine.Output(), "\n"+exitCodeDoc)
+	}
 
 	// Parse the flags. This single call parses both custom flags and glog's flags.
 	flag.Parse()
 
+	if cfg.Version {
+		fmt.Println(versionString())
+		return
+	}
+
+	switch cfg.LogFormat {
+	case logging.FormatText:
+	case logging.FormatJSON:
+		// Keep stderr machine-readable: glog text, errors included, goes only to its log
+		// files unless -stderrthreshold is given, and exitWith reports failures as events.
+		if err := flag.Set("alsologtostderr", "false"); err != nil {
+			glog.Errorf("Failed to disable -alsologtostderr for JSON logging: %v", err)
+		}
+		if err := flag.Set("logtostderr", "false"); err != nil {
+			glog.Errorf("Failed to disable -logtostderr for JSON logging: %v", err)
+		}
+		thresholdSet := false
+		flag.Visit(func(f *flag.Flag) { thresholdSet = thresholdSet || f.Name == "stderrthreshold" })
+		if !thresholdSet {
+			if err := flag.Set("stderrthreshold", "FATAL"); err != nil {
+				glog.Errorf("Failed to raise -stderrthreshold for JSON logging: %v", err)
+			}
+		}
+		logging.SetJSONOutput(os.Stderr)
+	default:
+		glog.Errorf("Validation Error: --log-format must be %q or %q, got %q.", logging.FormatText, logging.FormatJSON, cfg.LogFormat)
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to invalid --log-format argument.")
+	}
+
+	// -alsologtostderr is set above, so only the flags the user can still choose are tracked.
+	explicitFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "alsologtostderr" {
+			explicitFlags[f.Name] = true
+		}
+	})
+	// JSON logs already keep glog text off stderr, so --quiet only applies to text logs.
+	levelFlags, err := logLevelFlags(cfg.Quiet && cfg.LogFormat == logging.FormatText, cfg.Verbose, explicitFlags)
+	if err != nil {
+		glog.Errorf("Validation Error: %v", err)
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to conflicting --quiet and --verbose arguments.")
+	}
+	for name, value := range levelFlags {
+		if err := flag.Set(name, value); err != nil {
+			glog.Errorf("Failed to set -%s=%s: %v", name, value, err)
+		}
+	}
+
 	glog.V(1).Info("Application started. Parsing command-line arguments and validating configuration.")
 
+	if cfg.Undo {
+		runUndo()
+		return
+	}
+	if cfg.ApplyPatch != "" || cfg.ApplyFullText != "" {
+		runApplySaved(cfg)
+		return
+	}
+
+	if cfg.PromptFile != "" {
+		prompt, err := readPromptFile(cfg.Prompt, cfg.PromptFile)
+		if err != nil {
+			glog.Errorf("Validation Error: %v", err)
+			flag.Usage()
+			exitWith(exitConfig, "Exiting due to an invalid --prompt-file argument.")
+		}
+		cfg.Prompt = prompt
+	}
+
 	// Basic validation for required arguments.
-	// Using glog.Fatal for unrecoverable startup errors, which also flushes logs and exits.
-	if cfg.FileList == "" {
-		glog.Error("Validation Error: --file-list is a required argument.")
+	// exitWith reports unrecoverable startup errors with exitConfig, flushing the logs.
+	if cfg.StdinContent && (cfg.FileList != "" || len(cfg.Files) > 0 || cfg.SinceGit != "" || cfg.Inplace || cfg.Interactive || cfg.TasksFile != "" || cfg.Replay != "" || cfg.TokenReport || cfg.AutoSelect || cfg.DryRun) {
+		glog.Error("Validation Error: --stdin-content edits the content on stdin and cannot be combined with --file-list, --file, --since-git, --inplace, --interactive, --tasks-file, --replay, --token-report, --auto-select or --dry-run.")
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to conflicting --stdin-content arguments.")
+	}
+
+	if cfg.FileList == "" && len(cfg.Files) == 0 && cfg.SinceGit == "" && cfg.TasksFile == "" && !cfg.StdinContent && len(flow.PromptFileRefs(cfg.Prompt)) == 0 {
+		glog.Error("Validation Error: at least one of --file-list, --file or --since-git, or an @file reference in --prompt, is required.")
 		flag.Usage() // Prints flag usage information to stderr
-		glog.Fatal("Exiting due to missing --file-list argument.")
+		exitWith(exitConfig, "Exiting due to missing --file-list, --file and --since-git arguments.")
+	}
+
+	if cfg.Replay == "" && !cfg.TokenReport && cfg.TasksFile == "" && cfg.Prompt == "" {
+		glog.Error("Validation Error: --prompt (or --prompt-file) is a required argument.")
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to missing --prompt argument.")
 	}
 
-	if cfg.Prompt == "" {
-		glog.Error("Validation Error: --prompt is a required argument.")
+	if cfg.TasksFile != "" && (cfg.Prompt != "" || cfg.Interactive || cfg.Replay != "") {
+		glog.Error("Validation Error: --tasks-file cannot be combined with --prompt, --interactive or --replay.")
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to conflicting --tasks-file arguments.")
+	}
+
+	if cfg.CountTokens && (cfg.TokenReport || cfg.TasksFile != "" || cfg.Interactive || cfg.Replay != "" || cfg.AutoSelect || cfg.ParallelFiles || cfg.BatchFiles > 0 || cfg.BatchTokens > 0 || cfg.StdinContent || cfg.JSONResult || cfg.Output != "") {
+		glog.Error("Validation Error: --count-tokens cannot be combined with --token-report, --tasks-file, --interactive, --replay, --auto-select, --parallel-files, --batch-files, --batch-tokens, --stdin-content, --json-result or --output.")
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to conflicting --count-tokens arguments.")
+	}
+	if cfg.ParallelFiles && (cfg.TasksFile != "" || cfg.Interactive || cfg.Replay != "" || cfg.TokenReport || cfg.AutoSelect || cfg.JSONResult || cfg.Output != "" || cfg.StdinContent) {
+		glog.Error("Validation Error: --parallel-files cannot be combined with --tasks-file, --interactive, --replay, --token-report, --auto-select, --json-result, --output or --stdin-content.")
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to conflicting --parallel-files arguments.")
+	}
+	batched := cfg.BatchFiles > 0 || cfg.BatchTokens > 0
+	if cfg.BatchFiles < 0 || cfg.BatchTokens < 0 {
+		glog.Errorf("Validation Error: --batch-files and --batch-tokens must not be negative, got %d and %d.", cfg.BatchFiles, cfg.BatchTokens)
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to invalid batch size arguments.")
+	}
+	if batched && (cfg.ParallelFiles || cfg.TasksFile != "" || cfg.Interactive || cfg.Replay != "" || cfg.TokenReport || cfg.AutoSelect || cfg.JSONResult || cfg.Output != "" || cfg.StdinContent) {
+		glog.Error("Validation Error: --batch-files and --batch-tokens cannot be combined with --parallel-files, --tasks-file, --interactive, --replay, --token-report, --auto-select, --json-result, --output or --stdin-content.")
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to conflicting batch arguments.")
+	}
+	if cfg.Concurrency < 1 {
+		glog.Errorf("Validation Error: --concurrency must be at least 1, got %d.", cfg.Concurrency)
 		flag.Usage()
-		glog.Fatal("Exiting due to missing --prompt argument.")
+		exitWith(exitConfig, "Exiting due to invalid --concurrency argument.")
 	}
 
-	// This specific validation is somewhat redundant if --file-list is already required,
+	if cfg.Format != prompt.FormatFullText && cfg.Format != prompt.FormatDiff && cfg.Format != prompt.FormatSearchReplace {
+		glog.Errorf("Validation Error: --format must be %q, %q or %q, got %q.", prompt.FormatFullText, prompt.FormatDiff, prompt.FormatSearchReplace, cfg.Format)
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to invalid --format argument.")
+	}
+	if cfg.StrictTransport && cfg.Format != prompt.FormatFullText {
+		glog.Errorf("Validation Error: --strict-transport only applies to --format %q, got %q.", prompt.FormatFullText, cfg.Format)
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to --strict-transport specified with another --format.")
+	}
+
+	if err := modifyFiles.ValidateLineEnding(cfg.LineEnding); err != nil {
+		glog.Errorf("Validation Error: --line-ending: %v", err)
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to invalid --line-ending argument.")
+	}
+	if err := modifyFiles.ValidateDiffEngine(cfg.DiffEngine); err != nil {
+		glog.Errorf("Validation Error: --diff-engine: %v", err)
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to invalid --diff-engine argument.")
+	}
+
+	if err := flow.ValidateCheckStale(cfg.CheckStale); err != nil {
+		glog.Errorf("Validation Error: --check-stale: %v", err)
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to invalid --check-stale argument.")
+	}
+
+	fileNotes, err := parseFileNotes(cfg.FileNotes)
+	if err != nil {
+		glog.Errorf("Validation Error: --file-note: %v", err)
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to invalid --file-note argument.")
+	}
+
+	if _, err := gemini.ParseTools(cfg.Tools); err != nil {
+		glog.Errorf("Validation Error: --tools: %v", err)
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to invalid --tools argument.")
+	}
+
+	modelSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "model" {
+			modelSet = true
+		}
+	})
+	if !slices.Contains(provider.Names(), cfg.Provider) {
+		glog.Errorf("Validation Error: unknown --provider %q; known providers: %s.", cfg.Provider, strings.Join(provider.Names(), ", "))
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to invalid --provider argument.")
+	}
+	if cfg.Provider != provider.Gemini && cfg.Flash {
+		glog.Errorf("Validation Error: --flash selects the Gemini model %q and cannot be used with --provider %s.", flashModel, cfg.Provider)
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to conflicting --flash and --provider arguments.")
+	}
+	if cfg.Candidates < 1 {
+		glog.Errorf("Validation Error: --candidates must be at least 1, got %d.", cfg.Candidates)
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to invalid --candidates argument.")
+	}
+	if cfg.Provider == provider.Anthropic && !modelSet {
+		cfg.Model = anthropic.DefaultModel
+	}
+	cfg.Model, err = resolveModel(cfg.Model, modelSet, cfg.Flash)
+	if err != nil {
+		glog.Errorf("Validation Error: %v", err)
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to conflicting --flash and --model arguments.")
+	}
+	if cfg.ModelFallback != "" && cfg.ModelFallback == cfg.Model {
+		glog.Errorf("Validation Error: --model-fallback %q is the model already in use.", cfg.ModelFallback)
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to invalid --model-fallback argument.")
+	}
+
+	if cfg.Replay != "" || cfg.DryRun {
+		// Replaying only makes sense as an in-place apply, and a dry run previews one.
+		cfg.Inplace = true
+	}
+
+	if cfg.MarkerNonce == "random" {
+		if cfg.Replay != "" {
+			glog.Error("Validation Error: --marker-nonce=random cannot match a saved response; pass the nonce logged by the original run.")
+			flag.Usage()
+			exitWith(exitConfig, "Exiting due to --marker-nonce=random specified with --replay.")
+		}
+		if cfg.MarkerNonce, err = utils.RandomNonce(); err != nil {
+			exitWith(exitFailure, fmt.Sprintf("Failed to generate a marker nonce: %v", err))
+		}
+	}
+
+	only := splitCSV(cfg.Only)
+	if len(only) > 0 && !cfg.Inplace {
+		glog.Error("Validation Error: --only requires --inplace.")
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to --only specified without --inplace.")
+	}
+
+	if cfg.TruncateOversized && cfg.Inplace {
+		// The model would return the truncated content, and writing it back would lose the tail.
+		glog.Error("Validation Error: --truncate-oversized cannot be combined with --inplace, --dry-run or --replay.")
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to --truncate-oversized specified with --inplace.")
+	}
+
+	allowedExts := splitCSV(cfg.AllowExt)
+
+	// This specific validation is somewhat redundant if a file source is already required,
 	// but kept for consistency with the original code's logic flow.
-	if cfg.Inplace && cfg.FileList == "" {
-		glog.Error("Validation Error: --inplace requires --file-list to be specified.")
+	if cfg.Inplace && cfg.FileList == "" && len(cfg.Files) == 0 && cfg.SinceGit == "" && cfg.TasksFile == "" && len(flow.PromptFileRefs(cfg.Prompt)) == 0 {
+		glog.Error("Validation Error: --inplace requires --file-list, --file, --since-git or an @file reference in --prompt to be specified.")
 		flag.Usage()
-		glog.Fatal("Exiting due to --inplace specified without --file-list.")
+		exitWith(exitConfig, "Exiting due to --inplace specified without --file-list or --file.")
 	}
 
 	// Log the parsed configuration at verbosity level 0 (always visible by default).
 	glog.V(0).Infof("Coder application starting with the following configuration:")
 	glog.V(0).Infof("  File List: %q", cfg.FileList)
-	glog.V(0).Infof("  Flash Mode: %t", cfg.Flash)
-	if cfg.Flash {
-		cfg.Model = "gemini-2.5-flash"
-		glog.V(0).Infof("Replace model to %q due to flash mode.", cfg.Model)
+	glog.V(0).Infof("  Files: %q", []string(cfg.Files))
+	if cfg.SinceGit != "" {
+		glog.V(0).Infof("  Since Git Ref: %q", cfg.SinceGit)
 	}
+	glog.V(0).Infof("  Provider: %q", cfg.Provider)
 	glog.V(0).Infof("  Model: %q", cfg.Model)
+	if cfg.ModelFallback != "" {
+		glog.V(0).Infof("  Model Fallback: %q", cfg.ModelFallback)
+	}
 	glog.V(0).Infof("  Tools: %q", cfg.Tools)
+	glog.V(0).Infof("  Max Output Tokens: %d", cfg.MaxOutputTokens)
+	glog.V(0).Infof("  Timeout: %s", cfg.Timeout)
+	glog.V(0).Infof("  Candidates: %d", cfg.Candidates)
+	glog.V(0).Infof("  Max File Size: %d bytes (truncate oversized: %t)", cfg.MaxFileSize, cfg.TruncateOversized)
+	glog.V(0).Infof("  Timeout Per File: %s", cfg.TimeoutPerFile)
 
 	glog.V(0).Infof("  In-place Modification: %t", cfg.Inplace)
+	if cfg.Replay != "" {
+		glog.V(0).Infof("  Replay: %q", cfg.Replay)
+	}
+	glog.V(0).Infof("  Format: %q", cfg.Format)
+	glog.V(0).Infof("  Line Ending: %q", cfg.LineEnding)
+	glog.V(0).Infof("  Check Stale Files: %q", cfg.CheckStale)
+	glog.V(0).Infof("  Interactive: %t", cfg.Interactive)
+	if cfg.Out != "" {
+		glog.V(0).Infof("  Output File: %q", cfg.Out)
+	}
+	glog.V(0).Infof("  No Open: %t", cfg.NoOpen)
+	if cfg.AuditLog != "" {
+		glog.V(0).Infof("  Audit Log: %q", cfg.AuditLog)
+	}
+	glog.V(0).Infof("  Dry Run: %t", cfg.DryRun)
+	glog.V(0).Infof("  Compress Dumps: %t", cfg.CompressDumps)
+	if cfg.ParallelFiles {
+		glog.V(0).Infof("  Parallel Files: %t (concurrency %d)", cfg.ParallelFiles, cfg.Concurrency)
+	}
+	if batched {
+		glog.V(0).Infof("  Batches: at most %d files and %d tokens each (0 is unlimited)", cfg.BatchFiles, cfg.BatchTokens)
+	}
+	if len(only) > 0 {
+		glog.V(0).Infof("  Only: %q", only)
+	}
+	glog.V(0).Infof("  Allow New Files: %t", cfg.AllowNew)
+	glog.V(0).Infof("  Gofmt: %t", cfg.Gofmt)
+	glog.V(0).Infof("  Fuzzy: %t", cfg.Fuzzy)
+	glog.V(0).Infof("  Diff Engine: %s", cfg.DiffEngine)
+	glog.V(0).Infof("  Preserve Indent: %t", cfg.PreserveIndent)
+	glog.V(0).Infof("  Normalize EOL: %t", cfg.NormalizeEOL)
+	glog.V(0).Infof("  Trim Trailing: %t", cfg.TrimTrailing)
+	glog.V(0).Infof("  Length Hints: %t", cfg.LengthHints)
+	glog.V(0).Infof("  Strict Transport: %t", cfg.StrictTransport)
+	if cfg.MarkerNonce != "" {
+		glog.V(0).Infof("  Marker Nonce: %q (use --marker-nonce %s to --replay this run's response)", cfg.MarkerNonce, cfg.MarkerNonce)
+	}
+	if len(allowedExts) > 0 {
+		glog.V(0).Infof("  Allowed Extensions: %q", allowedExts)
+	}
+	glog.V(0).Infof("  Retries on Parse Failure: %d", cfg.RetryOnParseFail)
+	glog.V(0).Infof("  Require Token Count: %t", cfg.RequireTokenCount)
+	glog.V(0).Infof("  Auto Repair Attempts: %d", cfg.AutoRepair)
+	glog.V(0).Infof("  Max Total Duration: %s", cfg.MaxTotalDuration)
+	glog.V(0).Infof("  Exclude Patterns: %q", []string(cfg.Excludes))
+	glog.V(0).Infof("  Skip Missing Files: %t", cfg.SkipMissing)
+	glog.V(0).Infof("  Auto Select Files: %t", cfg.AutoSelect)
+	glog.V(0).Infof("  Context Files: %q", []string(cfg.ContextFiles))
+	glog.V(0).Infof("  Attachments: %q", []string(cfg.Attachments))
+	glog.V(0).Infof("  Compress Context: %t", cfg.CompressContext)
 	glog.V(0).Infof("  Prompt provided (length: %d characters).", len(cfg.Prompt))
+	if cfg.PromptPrefix != "" || cfg.PromptSuffix != "" {
+		glog.V(0).Infof("  Prompt prefix/suffix provided (lengths: %d/%d characters).", len(cfg.PromptPrefix), len(cfg.PromptSuffix))
+	}
 	// Log the full prompt content at a higher verbosity level for debugging purposes.
 	glog.V(2).Infof("  Full Prompt Content: %q", cfg.Prompt)
 
 	// Placeholder for the actual AI coding logic.
 	glog.V(0).Info("\n--- Placeholder for actual AI coding logic ---")
-	glog.V(0).Infof("Logic will read files from: %q", cfg.FileList)
+	glog.V(0).Infof("Logic will read files from: %q and %q", cfg.FileList, []string(cfg.Files))
 	// Log a truncated version of the prompt to avoid excessively long log lines for the actual call.
 	glog.V(0).Infof("Logic will send prompt to AI (excerpt): %q...", utils.TruncateString(cfg.Prompt, 50))
 	if cfg.Inplace {
@@ -100,11 +681,252 @@ func main() {
 
 	glog.V(0).Info("-------------------------------------------")
 
+	var jsonResult io.Writer
+	if cfg.Output != "" {
+		jsonFile, err := os.Create(cfg.Output)
+		if err != nil {
+			glog.Errorf("Validation Error: --output: %v", err)
+			exitWith(exitConfig, "Exiting due to unwritable --output path.")
+		}
+		defer jsonFile.Close()
+		jsonResult = jsonFile
+	} else if cfg.JSONResult {
+		jsonResult = os.Stdout
+	}
+
 	// Call the new flow.Run function to execute the main logic
-	if err := flow.Run(cfg.FileList, cfg.Prompt, cfg.Model, cfg.Inplace, cfg.Tools); err != nil {
+	opts := flow.Options{
+		FileListPath:      cfg.FileList,
+		Files:             cfg.Files,
+		SinceGit:          cfg.SinceGit,
+		Prompt:            cfg.Prompt,
+		Inplace:           cfg.Inplace,
+		MaxFileSize:       cfg.MaxFileSize,
+		TruncateOversized: cfg.TruncateOversized,
+		FileReadTimeout:   cfg.TimeoutPerFile,
+		RetryOnParseFail:  cfg.RetryOnParseFail,
+		MaxTotalDuration:  cfg.MaxTotalDuration,
+		AutoSelect:        cfg.AutoSelect,
+		RequireTokenCount: cfg.RequireTokenCount,
+		AutoRepair:        cfg.AutoRepair,
+		Candidates:        cfg.Candidates,
+		Excludes:          cfg.Excludes,
+		SkipMissing:       cfg.SkipMissing,
+		Format:            cfg.Format,
+		LineEnding:        cfg.LineEnding,
+		CheckStale:        cfg.CheckStale,
+		Interactive:       cfg.Interactive,
+		Stats:             cfg.Stats,
+		Only:              only,
+		AllowNew:          cfg.AllowNew,
+		AllowedExts:       allowedExts,
+		Gofmt:             cfg.Gofmt,
+		Fuzzy:             cfg.Fuzzy,
+		DiffEngine:        cfg.DiffEngine,
+		PreserveIndent:    cfg.PreserveIndent,
+		NormalizeEOL:      cfg.NormalizeEOL,
+		TrimTrailing:      cfg.TrimTrailing,
+		LengthHints:       cfg.LengthHints,
+		StrictTransport:   cfg.StrictTransport,
+		DryRun:            cfg.DryRun,
+		AuditLog:          cfg.AuditLog,
+		MarkerNonce:       cfg.MarkerNonce,
+		OutPath:           cfg.Out,
+		NoOpen:            cfg.NoOpen,
+		CompressDumps:     cfg.CompressDumps,
+		JSONResult:        jsonResult,
+		CountTokens:       countTokensOutput(cfg.CountTokens),
+		Progress:          progressWriter(cfg.NoProgress || cfg.Quiet || cfg.LogFormat == logging.FormatJSON), // JSON logs own stderr
+		PromptPrefix:      cfg.PromptPrefix,
+		PromptSuffix:      cfg.PromptSuffix,
+		ContextFiles:      cfg.ContextFiles,
+		CompressContext:   cfg.CompressContext,
+		Attachments:       cfg.Attachments,
+		FileNotes:         fileNotes,
+	}
+	// The run is canceled on SIGINT or SIGTERM: the AI request in flight is aborted and
+	// an apply in progress is rolled back.
+	ctx := cancelOnSignal()
+	if cfg.Replay != "" {
+		if err := flow.Replay(ctx, cfg.Replay, opts); err != nil {
+			glog.Errorf("Replaying the saved AI response failed: %v", err)
+			logging.ErrorEvent("replay_failed", err, nil)
+			glog.Flush()
+			os.Exit(exitCodeFor(err))
+		}
+		logging.Event("replay_completed", map[string]interface{}{"path": cfg.Replay})
+		glog.V(0).Info("Coder application finished successfully.")
+		return
+	}
+
+	// Construct the AI engine; flow.Run only depends on the AIEngine interface.
+	engineCfg := provider.Config{
+		Provider: cfg.Provider,
+		Model:    cfg.Model,
+		Tools:    cfg.Tools,
+		Project:  cfg.Project,
+		Location: cfg.Location,
+
+		APIKeyFile:      cfg.APIKeyFile,
+		Context:         ctx,
+		MaxOutputTokens: int32(cfg.MaxOutputTokens),
+		Timeout:         cfg.Timeout,
+		CandidateCount:  int32(cfg.Candidates),
+	}
+	aiEngine, err := provider.NewEngine(engineCfg)
+	if err != nil {
+		glog.Errorf("Failed to initialize AI engine: %v", err)
+		logging.ErrorEvent("engine_init_failed", err, nil)
+		glog.Flush()
+		os.Exit(exitAI)
+	}
+	if cfg.ModelFallback != "" {
+		engineCfg.Model = cfg.ModelFallback
+		if opts.Fallback, err = provider.NewEngine(engineCfg); err != nil {
+			glog.Errorf("Failed to initialize the fallback AI engine for model %q: %v", cfg.ModelFallback, err)
+			logging.ErrorEvent("engine_init_failed", err, map[string]interface{}{"model": cfg.ModelFallback})
+			glog.Flush()
+			os.Exit(exitAI)
+		}
+	}
+	if aiEngine.ModelName() != cfg.Model {
+		glog.V(0).Infof("AI engine is using model %q (requested %q).", aiEngine.ModelName(), cfg.Model)
+	}
+
+	if cfg.StdinContent {
+		if err := flow.RunStdin(ctx, aiEngine, opts, os.Stdin, os.Stdout); err != nil {
+			glog.Errorf("Editing the content from stdin failed: %v", err)
+			logging.ErrorEvent("stdin_failed", err, nil)
+			glog.Flush()
+			os.Exit(exitCodeFor(err))
+		}
+		glog.V(0).Info("Coder application finished successfully.")
+		return
+	}
+
+	if cfg.TasksFile != "" {
+		tasks, err := flow.ReadTasks(cfg.TasksFile)
+		if err == nil {
+			_, err = flow.RunTasks(ctx, aiEngine, tasks, opts)
+		}
+		if err != nil {
+			glog.Errorf("Running the tasks in %q failed: %v", cfg.TasksFile, err)
+			logging.ErrorEvent("tasks_failed", err, nil)
+			glog.Flush()
+			os.Exit(exitCodeFor(err))
+		}
+		logging.Event("tasks_completed", map[string]interface{}{"path": cfg.TasksFile})
+		glog.V(0).Info("Coder application finished successfully.")
+		return
+	}
+
+	if cfg.ParallelFiles {
+		if _, err := flow.RunPerFile(ctx, aiEngine, opts, cfg.Concurrency); err != nil {
+			glog.Errorf("Running the prompt on each file failed: %v", err)
+			logging.ErrorEvent("parallel_files_failed", err, nil)
+			glog.Flush()
+			os.Exit(exitCodeFor(err))
+		}
+		logging.Event("parallel_files_completed", nil)
+		glog.V(0).Info("Coder application finished successfully.")
+		return
+	}
+
+	if batched {
+		if _, err := flow.RunBatches(ctx, aiEngine, opts, cfg.BatchFiles, cfg.BatchTokens); err != nil {
+			glog.Errorf("Running the prompt on the batches of files failed: %v", err)
+			logging.ErrorEvent("batches_failed", err, nil)
+			glog.Flush()
+			os.Exit(exitCodeFor(err))
+		}
+		logging.Event("batches_completed", nil)
+		glog.V(0).Info("Coder application finished successfully.")
+		return
+	}
+
+	if cfg.TokenReport {
+		if err := flow.TokenReport(ctx, aiEngine, opts, os.Stdout); err != nil {
+			glog.Errorf("Token report failed: %v", err)
+			logging.ErrorEvent("token_report_failed", err, nil)
+			glog.Flush()
+			os.Exit(exitCodeFor(err))
+		}
+		glog.V(0).Info("Coder application finished successfully.")
+		return
+	}
+
+	if err := flow.Run(ctx, aiEngine, opts); err != nil {
 		glog.Errorf("AI coding flow failed: %v", err)
-		os.Exit(1)
+		logging.ErrorEvent("flow_failed", err, nil)
+		glog.Flush()
+		os.Exit(exitCodeFor(err))
 	}
+	logging.Event("flow_completed", map[string]interface{}{"model": aiEngine.ModelName()})
 
 	glog.V(0).Info("Coder application finished successfully.")
+}
+
+// runUndo implements --undo: it reverts the files changed by the last apply, without an
+// AI engine.
+func runUndo() {
+	if err := flow.Undo(); err != nil {
+		glog.Errorf("Undo failed: %v", err)
+		logging.ErrorEvent("undo_failed", err, nil)
+		glog.Flush()
+		os.Exit(exitCodeFor(err))
+	}
+	logging.Event("undo_completed", nil)
+}
+
+// runApplySaved implements --apply-patch and --apply-fulltext: it applies the saved
+// diff or full-text response without an AI engine, so --prompt and the file list are not needed.
+func runApplySaved(cfg Config) {
+	if cfg.ApplyPatch != "" && cfg.ApplyFullText != "" {
+		glog.Error("Validation Error: --apply-patch and --apply-fulltext cannot be used together.")
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to conflicting --apply-patch and --apply-fulltext arguments.")
+	}
+	if err := modifyFiles.ValidateLineEnding(cfg.LineEnding); err != nil {
+		glog.Errorf("Validation Error: --line-ending: %v", err)
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to invalid --line-ending argument.")
+	}
+	if err := modifyFiles.ValidateDiffEngine(cfg.DiffEngine); err != nil {
+		glog.Errorf("Validation Error: --diff-engine: %v", err)
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to invalid --diff-engine argument.")
+	}
+	if cfg.MarkerNonce == "random" {
+		glog.Error("Validation Error: --marker-nonce=random cannot match a saved response; pass the nonce logged by the original run.")
+		flag.Usage()
+		exitWith(exitConfig, "Exiting due to --marker-nonce=random specified with a saved response.")
+	}
+	opts := flow.Options{
+		LineEnding:      cfg.LineEnding,
+		Only:            splitCSV(cfg.Only),
+		ContextFiles:    cfg.ContextFiles,
+		AllowedExts:     splitCSV(cfg.AllowExt),
+		Gofmt:           cfg.Gofmt,
+		Fuzzy:           cfg.Fuzzy,
+		DiffEngine:      cfg.DiffEngine,
+		PreserveIndent:  cfg.PreserveIndent,
+		NormalizeEOL:    cfg.NormalizeEOL,
+		TrimTrailing:    cfg.TrimTrailing,
+		MarkerNonce:     cfg.MarkerNonce,
+		LengthHints:     cfg.LengthHints,
+		StrictTransport: cfg.StrictTransport,
+		DryRun:          cfg.DryRun,
+	}
+
+	path, event, apply := cfg.ApplyPatch, "apply_patch", flow.ApplyPatchFile
+	if cfg.ApplyFullText != "" {
+		path, event, apply = cfg.ApplyFullText, "apply_fulltext", flow.ApplyFullTextFile
+	}
+	if err := apply(cancelOnSignal(), path, opts); err != nil {
+		glog.Errorf("Applying %q failed: %v", path, err)
+		logging.ErrorEvent(event+"_failed", err, nil)
+		glog.Flush()
+		os.Exit(exitCodeFor(err))
+	}
+	logging.Event(event+"_completed", map[string]interface{}{"path": path})
 }
\ No newline at end of file
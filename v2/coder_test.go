@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveModel(t *testing.T) {
+	tests := []struct {
+		name     string
+		model    string
+		modelSet bool
+		flash    bool
+		want     string
+		wantErr  bool
+	}{
+		{name: "Default model", model: "gemini-3-pro-preview", want: "gemini-3-pro-preview"},
+		{name: "Explicit model", model: "gemini-2.5-pro", modelSet: true, want: "gemini-2.5-pro"},
+		{name: "Flash overrides the default", model: "gemini-3-pro-preview", flash: true, want: flashModel},
+		{name: "Flash agrees with --model", model: flashModel, modelSet: true, flash: true, want: flashModel},
+		{name: "Flash conflicts with --model", model: "gemini-2.5-pro", modelSet: true, flash: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveModel(tt.model, tt.modelSet, tt.flash)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveModel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveModel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogLevelFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		quiet    bool
+		verbose  bool
+		explicit map[string]bool
+		want     map[string]string
+		wantErr  bool
+	}{
+		{name: "Neither", want: map[string]string{}},
+		{name: "Verbose", verbose: true, want: map[string]string{"v": "2"}},
+		{name: "Quiet", quiet: true, want: map[string]string{"alsologtostderr": "false", "stderrthreshold": "WARNING"}},
+		{name: "Explicit -v wins", verbose: true, explicit: map[string]bool{"v": true}, want: map[string]string{}},
+		{name: "Explicit -stderrthreshold wins", quiet: true, explicit: map[string]bool{"stderrthreshold": true}, want: map[string]string{"alsologtostderr": "false"}},
+		{name: "Both", quiet: true, verbose: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := logLevelFlags(tt.quiet, tt.verbose, tt.explicit)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("logLevelFlags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("logLevelFlags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadPromptFile(t *testing.T) {
+	dir := t.TempDir()
+	promptPath := filepath.Join(dir, "prompt.txt")
+	if err := os.WriteFile(promptPath, []byte("\nFollow the style guide.\nAdd tests.\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	emptyPath := filepath.Join(dir, "empty.txt")
+	if err := os.WriteFile(emptyPath, []byte(" \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		prompt  string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{name: "Trimmed content", path: promptPath, want: "Follow the style guide.\nAdd tests."},
+		{name: "Combined with --prompt", prompt: "Refactor.", path: promptPath, wantErr: true},
+		{name: "Empty file", path: emptyPath, wantErr: true},
+		{name: "Missing file", path: filepath.Join(dir, "missing.txt"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readPromptFile(tt.prompt, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readPromptFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("readPromptFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
\ No newline at end of file
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/zicongmei/ai-coder/v2/pkg/flow"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// Exit codes of the coder application, for use in scripts.
+const (
+	exitOK      = 0 // Success
+	exitFailure = 1 // Any failure not covered by a more specific code
+	exitConfig  = 2 // Invalid flags or input files
+	exitAI      = 3 // The AI endpoint failed, e.g. authentication, quota or network errors
+	exitApply   = 4 // The AI response could not be parsed or applied to the files
+
+	exitInterrupted = 130 // The run was interrupted by SIGINT or SIGTERM (128 + SIGINT)
+)
+
+// exitCodeDoc describes the exit codes for the usage message.
+const exitCodeDoc = `Exit codes:
+  0    success
+  1    other failure
+  2    invalid configuration (flags, file list or input files)
+  3    AI endpoint failure (authentication, quota, network)
+  4    the AI response could not be parsed or applied
+  130  interrupted (SIGINT or SIGTERM)
+`
+
+// exitCodeFor maps an error returned by flow.Run to the process exit code.
+// Any error after an interrupt is reported as exitInterrupted, and the graceful
+// shutdown is logged.
+func exitCodeFor(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case interrupted.Load():
+		glog.Warningf("Shut down gracefully after an interrupt: %v", err)
+		return exitInterrupted
+	case errors.Is(err, flow.ErrConfig):
+		return exitConfig
+	case errors.Is(err, flow.ErrAI):
+		return exitAI
+	case errors.Is(err, flow.ErrApply):
+		return exitApply
+	default:
+		return exitFailure
+	}
+}
+
+// exitWith logs args as an error, also as an "exit" event with --log-format=json,
+// flushes the logs and exits with code. Unlike glog.Fatal it prints no goroutine stack
+// traces, which only obscure a usage error.
+func exitWith(code int, args ...interface{}) {
+	glog.ErrorDepth(1, args...)
+	logging.ErrorEvent("exit", errors.New(fmt.Sprint(args...)), map[string]interface{}{"code": code})
+	glog.Flush()
+	os.Exit(code)
+}
\ No newline at end of file
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+	"github.com/zicongmei/ai-coder/v2/pkg/flow"
+)
+
+func TestExitCodeFor(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(filePath, []byte("a\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", filePath, err)
+	}
+	listPath := filepath.Join(dir, "list.txt")
+	if err := os.WriteFile(listPath, []byte(filePath+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", listPath, err)
+	}
+
+	tests := []struct {
+		name   string
+		engine *mock.Client
+		opts   flow.Options
+		want   int
+	}{
+		{
+			name:   "Apply failure",
+			engine: mock.NewClient("not a file block"),
+			opts:   flow.Options{FileListPath: listPath, Prompt: "p", Inplace: true},
+			want:   exitApply,
+		},
+		{
+			name:   "AI failure",
+			engine: &mock.Client{Err: errors.New("quota exceeded")},
+			opts:   flow.Options{FileListPath: listPath, Prompt: "p", Inplace: true},
+			want:   exitAI,
+		},
+		{
+			name:   "Config failure",
+			engine: mock.NewClient(""),
+			opts:   flow.Options{FileListPath: filepath.Join(dir, "missing.txt"), Prompt: "p", Inplace: true},
+			want:   exitConfig,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := flow.Run(context.Background(), tt.engine, tt.opts)
+			if got := exitCodeFor(err); got != tt.want {
+				t.Errorf("exitCodeFor(%v) = %d, want %d", err, got, tt.want)
+			}
+		})
+	}
+
+	if got := exitCodeFor(nil); got != exitOK {
+		t.Errorf("exitCodeFor(nil) = %d, want %d", got, exitOK)
+	}
+	if got := exitCodeFor(errors.New("other")); got != exitFailure {
+		t.Errorf("exitCodeFor(other) = %d, want %d", got, exitFailure)
+	}
+
+	interrupted.Store(true)
+	defer interrupted.Store(false)
+	if got := exitCodeFor(errors.New("context canceled")); got != exitInterrupted {
+		t.Errorf("exitCodeFor() after an interrupt = %d, want %d", got, exitInterrupted)
+	}
+	if got := exitCodeFor(nil); got != exitOK {
+		t.Errorf("exitCodeFor(nil) after an interrupt = %d, want %d", got, exitOK)
+	}
+}
+
+func TestExitWith(t *testing.T) {
+	if os.Getenv("CODER_TEST_EXIT_WITH") == "1" {
+		exitWith(exitConfig, "Exiting due to a bad flag.")
+		return
+	}
+	// exitWith ends the process, so run it in a child test binary.
+	cmd := exec.Command(os.Args[0], "-test.run=^TestExitWith$")
+	cmd.Env = append(os.Environ(), "CODER_TEST_EXIT_WITH=1")
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitConfig {
+		t.Fatalf("exitWith(exitConfig) exited with %v, want code %d; output:\n%s", err, exitConfig, out)
+	}
+	if !strings.Contains(string(out), "Exiting due to a bad flag.") || strings.Contains(string(out), "goroutine ") {
+		t.Errorf("exitWith() output = %q, want the message without stack traces", out)
+	}
+}
\ No newline at end of file
@@ -3,6 +3,7 @@ module github.com/zicongmei/ai-coder/v2
 go 1.24.8
 
 require (
+	cloud.google.com/go/auth v0.17.0
 	github.com/golang/glog v1.2.5
 	github.com/yuin/goldmark v1.7.13
 	google.golang.org/genai v1.39.0
@@ -10,7 +11,6 @@ require (
 
 require (
 	cloud.google.com/go v0.116.0 // indirect
-	cloud.google.com/go/auth v0.17.0 // indirect
 	cloud.google.com/go/compute/metadata v0.9.0 // indirect
 	github.com/felixge/httpsnoop v1.0.4 // indirect
 	github.com/go-logr/logr v1.4.3 // indirect
@@ -0,0 +1,296 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+// DefaultModel is the Claude model used when no model is configured.
+const DefaultModel = "claude-sonnet-4-5"
+
+const (
+	defaultBaseURL = "https://api.anthropic.com"
+	apiVersion     = "2023-06-01" // Value of the anthropic-version header
+
+	// defaultMaxTokens is sent when no output limit is configured, because the Messages
+	// API requires one. Whole files are returned in the response, so it is generous.
+	defaultMaxTokens = 32000
+)
+
+// Stop reasons reported by the Messages API that need handling.
+const (
+	stopMaxTokens = "max_tokens"
+	stopRefusal   = "refusal"
+)
+
+// Client implements the AIEngine interface for Anthropic's Claude models, using the
+// Messages API over HTTP.
+type Client struct {
+	httpClient *http.Client
+	baseURL    string
+	apiKey     string
+	modelName  string
+
+	maxOutputTokens int32         // Maximum number of tokens to generate; 0 uses defaultMaxTokens
+	timeout         time.Duration // Deadline for each API request; 0 means no deadline
+}
+
+// Config holds the settings used to construct a Claude Client.
+type Config struct {
+	ModelName string // Model to use, e.g. "claude-sonnet-4-5"; DefaultModel if empty
+	BaseURL   string // Endpoint of the API; defaults to https://api.anthropic.com
+
+	APIKey     string // API key; if empty, it is looked up with GetAPIKey(APIKeyFile)
+	APIKeyFile string // File holding the API key, used if ANTHROPIC_API_KEY is unset; falls back to ANTHROPIC_API_KEY_FILE
+
+	MaxOutputTokens int32         // Maximum number of tokens to generate; 0 uses a generous default
+	Timeout         time.Duration // Deadline for each API request; 0 means no deadline
+}
+
+// NewClient initializes a new Claude client for modelName. If apiKey is empty, the key
+// is read from the environment (see GetAPIKey).
+func NewClient(modelName, apiKey string) (aiEndpoint.AIEngine, error) {
+	return NewClientWithConfig(Config{ModelName: modelName, APIKey: apiKey})
+}
+
+// NewClientWithConfig initializes a new Claude client from clientCfg.
+func NewClientWithConfig(clientCfg Config) (aiEndpoint.AIEngine, error) {
+	modelName := clientCfg.ModelName
+	if modelName == "" {
+		modelName = DefaultModel
+	}
+	baseURL := strings.TrimSuffix(clientCfg.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+
+	apiKey := clientCfg.APIKey
+	if apiKey == "" {
+		var err error
+		if apiKey, err = GetAPIKey(clientCfg.APIKeyFile); err != nil {
+			logging.Errorf("Failed to get the Anthropic API key: %v", err)
+			return nil, fmt.Errorf("%w: %w", aiEndpoint.ErrAuth, err)
+		}
+	}
+	logging.V(0).Info("Anthropic client successfully created.")
+	logging.V(0).Infof("Using %q model.", modelName)
+	if clientCfg.MaxOutputTokens > 0 {
+		logging.V(0).Infof("Max output tokens: %d", clientCfg.MaxOutputTokens)
+	}
+
+	return &Client{
+		httpClient:      http.DefaultClient,
+		baseURL:         baseURL,
+		apiKey:          apiKey,
+		modelName:       modelName,
+		maxOutputTokens: clientCfg.MaxOutputTokens,
+		timeout:         clientCfg.Timeout,
+	}, nil
+}
+
+// message is a turn of a conversation in the Messages API.
+type message struct {
+	Role    string         `json:"role"` // "user" or "assistant"
+	Content []contentBlock `json:"content"`
+}
+
+// contentBlock is a part of a message: text, or an image or document sent inline.
+type contentBlock struct {
+	Type   string       `json:"type"` // "text", "image" or "document"
+	Text   string       `json:"text,omitempty"`
+	Source *blockSource `json:"source,omitempty"`
+}
+
+// blockSource holds the base64-encoded data of an image or document block.
+type blockSource struct {
+	Type      string `json:"type"` // Always "base64"
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type messagesRequest struct {
+	Model     string    `json:"model"`
+	MaxTokens int32     `json:"max_tokens"`
+	Messages  []message `json:"messages"`
+}
+
+type messagesResponse struct {
+	Content    []contentBlock `json:"content"`
+	StopReason string         `json:"stop_reason"`
+}
+
+type countTokensRequest struct {
+	Model    string    `json:"model"`
+	Messages []message `json:"messages"`
+}
+
+type countTokensResponse struct {
+	InputTokens int `json:"input_tokens"`
+}
+
+// SendPrompt sends a string prompt to Claude and returns the AI's response as a string.
+func (c *Client) SendPrompt(ctx context.Context, prompt string) (string, error) {
+	return c.SendConversation(ctx, []aiEndpoint.Message{{Role: aiEndpoint.RoleUser, Text: prompt}})
+}
+
+// SendConversation sends the conversation history to Claude and returns the AI's reply
+// as a string.
+func (c *Client) SendConversation(ctx context.Context, history []aiEndpoint.Message) (string, error) {
+	logging.V(1).Infof("Sending conversation of %d messages to Anthropic AI...", len(history))
+	if len(history) > 0 {
+		logging.V(2).Infof("Latest message content (truncated): %q", utils.TruncateString(history[len(history)-1].Text, 200))
+	}
+
+	maxTokens := c.maxOutputTokens
+	if maxTokens <= 0 {
+		maxTokens = defaultMaxTokens
+	}
+	req := messagesRequest{Model: c.modelName, MaxTokens: maxTokens, Messages: toMessages(history)}
+	var resp messagesResponse
+	if err := c.post(ctx, "/v1/messages", req, &resp); err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			builder.WriteString(block.Text)
+		}
+	}
+	result := builder.String()
+
+	if resp.StopReason == stopRefusal {
+		logging.Errorf("Claude refused to respond (stop reason %q).", resp.StopReason)
+		return "", fmt.Errorf("%w: stop reason %s", aiEndpoint.ErrBlocked, resp.StopReason)
+	}
+	if result == "" {
+		logging.Warning("Anthropic response was empty.")
+	}
+
+	logging.V(1).Infof("Received response from Anthropic (length: %d).", len(result))
+	logging.V(2).Infof("Full Anthropic response (truncated): %q", utils.TruncateString(result, 200))
+
+	if resp.StopReason == stopMaxTokens {
+		logging.Warningf("Claude stopped at the output token limit; the response (length: %d) is incomplete.", len(result))
+		return result, fmt.Errorf("anthropic stop reason %s: %w", stopMaxTokens, aiEndpoint.ErrTruncated)
+	}
+	return result, nil
+}
+
+// CountTokens counts the tokens of the given prompt with the token-counting endpoint.
+// The request shares the client's per-request timeout.
+func (c *Client) CountTokens(ctx context.Context, prompt string) (int, error) {
+	logging.V(1).Infof("Requesting token count for prompt in model %q.", c.modelName)
+	req := countTokensRequest{Model: c.modelName, Messages: toMessages([]aiEndpoint.Message{{Role: aiEndpoint.RoleUser, Text: prompt}})}
+	var resp countTokensResponse
+	if err := c.post(ctx, "/v1/messages/count_tokens", req, &resp); err != nil {
+		return 0, err
+	}
+	return resp.InputTokens, nil
+}
+
+// ModelName returns the name of the Claude model used by this client.
+func (c *Client) ModelName() string {
+	return c.modelName
+}
+
+// AuthHint implements aiEndpoint.AuthHinter.
+func (c *Client) AuthHint() string {
+	return "Check that ANTHROPIC_API_KEY (or --api-key-file) is valid."
+}
+
+// toMessages converts the conversation history to Messages API messages.
+// Attachments become image or document blocks; unsupported types are skipped.
+func toMessages(history []aiEndpoint.Message) []message {
+	messages := make([]message, 0, len(history))
+	for _, msg := range history {
+		role := "user"
+		if msg.Role == aiEndpoint.RoleModel {
+			role = "assistant"
+		}
+		content := []contentBlock{{Type: "text", Text: msg.Text}}
+		for _, a := range msg.Attachments {
+			blockType := attachmentBlockType(a.MIMEType)
+			if blockType == "" {
+				logging.Warningf("Claude does not accept %q attachments; skipping %q.", a.MIMEType, a.Name)
+				continue
+			}
+			logging.V(1).Infof("Attaching %q (%s, %d bytes) to the message.", a.Name, a.MIMEType, len(a.Data))
+			content = append(content, contentBlock{
+				Type:   blockType,
+				Source: &blockSource{Type: "base64", MediaType: a.MIMEType, Data: base64.StdEncoding.EncodeToString(a.Data)},
+			})
+		}
+		messages = append(messages, message{Role: role, Content: content})
+	}
+	return messages
+}
+
+// attachmentBlockType returns the content block type for an attachment of the given
+// MIME type, or "" if the Messages API does not accept it inline.
+func attachmentBlockType(mimeType string) string {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return "image"
+	case "application/pdf":
+		return "document"
+	}
+	return ""
+}
+
+// post sends body as JSON to the API path and decodes the JSON response into out.
+// Errors are classified (see classifyError); if the request times out or ctx is
+// canceled, the error wraps aiEndpoint.ErrTimeout.
+func (c *Client) post(ctx context.Context, path string, body, out interface{}) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	what := "failed to call the Anthropic API " + path
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", c.apiKey)
+	req.Header.Set("Anthropic-Version", apiVersion)
+
+	resp, err := c.httpClient.Do(req)
+	if err == nil {
+		defer resp.Body.Close()
+		var data []byte
+		if data, err = io.ReadAll(resp.Body); err == nil {
+			if resp.StatusCode != http.StatusOK {
+				err = newAPIError(resp.StatusCode, data)
+			} else if err = json.Unmarshal(data, out); err != nil {
+				err = fmt.Errorf("invalid response: %w", err)
+			}
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		logging.Errorf("Anthropic request did not complete: %v", ctx.Err())
+		return fmt.Errorf("%s: %w: %w", what, aiEndpoint.ErrTimeout, ctx.Err())
+	}
+	if err != nil {
+		logging.Errorf("Anthropic request to %s failed: %v", path, err)
+		return classifyError(what, err)
+	}
+	return nil
+}
\ No newline at end of file
@@ -0,0 +1,174 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+)
+
+// newTestClient returns a Client sending requests to a test server that checks the
+// headers, records the decoded request body in *got and replies with status and reply.
+func newTestClient(t *testing.T, status int, reply string, got *map[string]interface{}) *Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get("X-Api-Key"); key != "test-key" {
+			t.Errorf("x-api-key header = %q, want %q", key, "test-key")
+		}
+		if version := r.Header.Get("Anthropic-Version"); version != apiVersion {
+			t.Errorf("anthropic-version header = %q, want %q", version, apiVersion)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if got != nil {
+			*got = map[string]interface{}{"path": r.URL.Path}
+			if err := json.Unmarshal(body, got); err != nil {
+				t.Errorf("Request body %q is not JSON: %v", body, err)
+			}
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, reply)
+	}))
+	t.Cleanup(server.Close)
+
+	engine, err := NewClientWithConfig(Config{ModelName: "claude-test", APIKey: "test-key", BaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewClientWithConfig() error = %v", err)
+	}
+	return engine.(*Client)
+}
+
+func TestSendConversation(t *testing.T) {
+	var got map[string]interface{}
+	client := newTestClient(t, http.StatusOK, `{"content":[{"type":"text","text":"Hello, "},{"type":"text","text":"world."}],"stop_reason":"end_turn"}`, &got)
+
+	reply, err := client.SendConversation(context.Background(), []aiEndpoint.Message{
+		{Role: aiEndpoint.RoleUser, Text: "Hi", Attachments: []aiEndpoint.Attachment{
+			{Name: "a.png", MIMEType: "image/png", Data: []byte("png")},
+			{Name: "a.zip", MIMEType: "application/zip", Data: []byte("zip")},
+		}},
+		{Role: aiEndpoint.RoleModel, Text: "Hi!"},
+		{Role: aiEndpoint.RoleUser, Text: "Greet the world."},
+	})
+	if err != nil {
+		t.Fatalf("SendConversation() error = %v", err)
+	}
+	if reply != "Hello, world." {
+		t.Errorf("SendConversation() = %q, want %q", reply, "Hello, world.")
+	}
+
+	if got["path"] != "/v1/messages" || got["model"] != "claude-test" || got["max_tokens"] != float64(defaultMaxTokens) {
+		t.Errorf("request = %v, want model claude-test with the default max_tokens sent to /v1/messages", got)
+	}
+	messages, _ := got["messages"].([]interface{})
+	if len(messages) != 3 {
+		t.Fatalf("request has %d messages, want 3: %v", len(messages), got)
+	}
+	first := messages[0].(map[string]interface{})
+	if content := first["content"].([]interface{}); len(content) != 2 || content[1].(map[string]interface{})["type"] != "image" {
+		t.Errorf("first message content = %v, want the text and only the image attachment", content)
+	}
+	if role := messages[1].(map[string]interface{})["role"]; role != "assistant" {
+		t.Errorf("model message role = %v, want assistant", role)
+	}
+}
+
+func TestSendConversation_StopReasons(t *testing.T) {
+	client := newTestClient(t, http.StatusOK, `{"content":[{"type":"text","text":"partial"}],"stop_reason":"max_tokens"}`, nil)
+	reply, err := client.SendPrompt(context.Background(), "Write a lot.")
+	if !errors.Is(err, aiEndpoint.ErrTruncated) || reply != "partial" {
+		t.Errorf("SendPrompt() = %q, %v; want the partial response and ErrTruncated", reply, err)
+	}
+
+	client = newTestClient(t, http.StatusOK, `{"content":[],"stop_reason":"refusal"}`, nil)
+	if _, err := client.SendPrompt(context.Background(), "Do something bad."); !errors.Is(err, aiEndpoint.ErrBlocked) {
+		t.Errorf("SendPrompt() error = %v, want ErrBlocked", err)
+	}
+}
+
+func TestSendPrompt_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		reply  string
+		want   error // nil means none of the aiEndpoint kinds
+	}{
+		{name: "Unauthorized", status: http.StatusUnauthorized, reply: `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`, want: aiEndpoint.ErrAuth},
+		{name: "Rate limited", status: http.StatusTooManyRequests, reply: `{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`, want: aiEndpoint.ErrQuota},
+		{name: "Overloaded", status: statusOverloaded, reply: `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, want: aiEndpoint.ErrOverloaded},
+		{name: "Unavailable", status: http.StatusServiceUnavailable, reply: "try later", want: aiEndpoint.ErrOverloaded},
+		{name: "Server error", status: http.StatusInternalServerError, reply: "oops"},
+	}
+	kinds := []error{aiEndpoint.ErrAuth, aiEndpoint.ErrQuota, aiEndpoint.ErrOverloaded, aiEndpoint.ErrNetwork}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, tt.status, tt.reply, nil)
+			_, err := client.SendPrompt(context.Background(), "Hi")
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Fatalf("SendPrompt() error = %v, want an APIError with status %d", err, tt.status)
+			}
+			for _, kind := range kinds {
+				if is := errors.Is(err, kind); is != (kind == tt.want) {
+					t.Errorf("errors.Is(err, %v) = %t, want %t", kind, is, kind == tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCountTokens(t *testing.T) {
+	var got map[string]interface{}
+	client := newTestClient(t, http.StatusOK, `{"input_tokens":42}`, &got)
+
+	tokens, err := client.CountTokens(context.Background(), "Count me.")
+	if err != nil {
+		t.Fatalf("CountTokens() error = %v", err)
+	}
+	if tokens != 42 {
+		t.Errorf("CountTokens() = %d, want 42", tokens)
+	}
+	if got["path"] != "/v1/messages/count_tokens" || got["model"] != "claude-test" {
+		t.Errorf("request = %v, want model claude-test sent to /v1/messages/count_tokens", got)
+	}
+}
+
+func TestSendPrompt_Canceled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+	engine, err := NewClientWithConfig(Config{ModelName: "claude-test", APIKey: "test-key", BaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewClientWithConfig() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = engine.SendPrompt(ctx, "Hi")
+	if !errors.Is(err, aiEndpoint.ErrTimeout) || !errors.Is(err, context.Canceled) {
+		t.Errorf("SendPrompt() error = %v, want ErrTimeout wrapping context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("SendPrompt() returned after %v, want it to abort promptly on cancellation", elapsed)
+	}
+}
+
+func TestNewClient_MissingKey(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	t.Setenv("ANTHROPIC_API_KEY_FILE", "")
+	if _, err := NewClient(DefaultModel, ""); !errors.Is(err, aiEndpoint.ErrAuth) {
+		t.Errorf("NewClient() error = %v, want ErrAuth", err)
+	}
+}
\ No newline at end of file
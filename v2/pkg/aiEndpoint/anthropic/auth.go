@@ -0,0 +1,30 @@
+package anthropic
+
+import (
+	"errors"
+	"os"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// GetAPIKey retrieves the Anthropic API key.
+// It checks the ANTHROPIC_API_KEY environment variable first, then reads the key from
+// keyFile or, if that is empty, from the file named by ANTHROPIC_API_KEY_FILE.
+// A key source may also name a Secret Manager secret (see aiEndpoint.ReadAPIKey).
+// Unlike Gemini, there are no default credentials to fall back on, so finding no key
+// is an error, as is an unreadable or empty key file.
+func GetAPIKey(keyFile string) (string, error) {
+	apiKey := os.Getenv("ANTHROPIC_API_KEY")
+	if apiKey != "" {
+		logging.V(1).Info("Using API key from ANTHROPIC_API_KEY environment variable.")
+		return apiKey, nil
+	}
+	if keyFile == "" {
+		keyFile = os.Getenv("ANTHROPIC_API_KEY_FILE")
+	}
+	if keyFile != "" {
+		return aiEndpoint.ReadAPIKey(keyFile)
+	}
+	return "", errors.New("no Anthropic API key: set ANTHROPIC_API_KEY or use --api-key-file")
+}
\ No newline at end of file
@@ -0,0 +1,73 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+// APIError is an error response of the Anthropic API.
+type APIError struct {
+	StatusCode int    // HTTP status code
+	Type       string // Error type, e.g. "authentication_error" or "rate_limit_error"
+	Message    string // Human-readable description
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("HTTP %d %s: %s", e.StatusCode, e.Type, e.Message)
+}
+
+// statusOverloaded is the non-standard HTTP status of the Anthropic API's
+// overloaded_error responses.
+const statusOverloaded = 529
+
+// newAPIError builds an APIError from the status code and body of a failed response.
+// A body that is not the API's JSON error is kept, truncated, as the message.
+func newAPIError(statusCode int, body []byte) *APIError {
+	var parsed struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil || parsed.Error.Type == "" {
+		return &APIError{StatusCode: statusCode, Type: http.StatusText(statusCode), Message: utils.TruncateString(string(body), 200)}
+	}
+	return &APIError{StatusCode: statusCode, Type: parsed.Error.Type, Message: parsed.Error.Message}
+}
+
+// errorKind returns the aiEndpoint error (ErrAuth, ErrQuota, ErrOverloaded or ErrNetwork) that err
+// from the Anthropic API belongs to, or nil if it fits none of them.
+func errorKind(err error) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+			return aiEndpoint.ErrAuth
+		case apiErr.StatusCode == http.StatusTooManyRequests, apiErr.Type == "rate_limit_error":
+			return aiEndpoint.ErrQuota
+		case apiErr.StatusCode == http.StatusServiceUnavailable, apiErr.StatusCode == statusOverloaded, apiErr.Type == "overloaded_error":
+			return aiEndpoint.ErrOverloaded
+		}
+		return nil
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && !netErr.Timeout() {
+		return aiEndpoint.ErrNetwork
+	}
+	return nil
+}
+
+// classifyError wraps err from the Anthropic API with its aiEndpoint error kind (see
+// errorKind), prefixed by what failed, so callers can check it with errors.Is.
+func classifyError(what string, err error) error {
+	if kind := errorKind(err); kind != nil {
+		return fmt.Errorf("%s: %w: %w", what, kind, err)
+	}
+	return fmt.Errorf("%s: %w", what, err)
+}
\ No newline at end of file
@@ -0,0 +1,133 @@
+package aiEndpoint
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/auth/credentials"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// SecretManagerPrefix marks an API key source as a Google Cloud Secret Manager secret
+// rather than a file, e.g. "sm://projects/my-project/secrets/gemini-key/versions/2".
+const SecretManagerPrefix = "sm://"
+
+// secretNamePattern matches a Secret Manager secret or secret version resource name.
+var secretNamePattern = regexp.MustCompile(`^projects/[^/]+/secrets/[^/]+(/versions/[^/]+)?$`)
+
+// secretManagerTimeout bounds the request reading a key from Secret Manager.
+const secretManagerTimeout = 30 * time.Second
+
+// secretManagerURL is the base URL of the Secret Manager API; tests replace it.
+var secretManagerURL = "https://secretmanager.googleapis.com/v1/"
+
+// secretManagerToken returns an access token for the Secret Manager API from the
+// Application Default Credentials; tests replace it.
+var secretManagerToken = func(ctx context.Context) (string, error) {
+	creds, err := credentials.DetectDefault(&credentials.DetectOptions{Scopes: []string{"https://www.googleapis.com/auth/cloud-platform"}})
+	if err != nil {
+		return "", err
+	}
+	token, err := creds.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+	return token.Value, nil
+}
+
+// ReadAPIKey reads an API key from source, ignoring surrounding whitespace. A source
+// starting with SecretManagerPrefix names a Secret Manager secret, read with the
+// Application Default Credentials; without a version, the latest one is read. Any other
+// source is a file path, and a warning is logged if other users can read the file.
+// A key that cannot be read or is empty is an error.
+func ReadAPIKey(source string) (string, error) {
+	var apiKey string
+	var err error
+	if name, ok := strings.CutPrefix(source, SecretManagerPrefix); ok {
+		apiKey, err = readSecret(name)
+	} else {
+		apiKey, err = readAPIKeyFile(source)
+	}
+	if err != nil {
+		return "", err
+	}
+	if apiKey = strings.TrimSpace(apiKey); apiKey == "" {
+		return "", fmt.Errorf("API key %q is empty", source)
+	}
+	logging.V(1).Infof("Using API key from %q.", source)
+	return apiKey, nil
+}
+
+// readAPIKeyFile reads the file at path, warning if it can be read by users other than
+// its owner.
+func readAPIKeyFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read API key file: %w", err)
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		logging.Warningf("API key file %q is accessible by other users (mode %v); consider 'chmod 600 %s'.", path, info.Mode().Perm(), path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read API key file: %w", err)
+	}
+	return string(data), nil
+}
+
+// readSecret reads the payload of the Secret Manager secret version name, a resource
+// name of the form projects/P/secrets/S[/versions/V].
+func readSecret(name string) (string, error) {
+	if !secretNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid Secret Manager secret %q (want %sprojects/PROJECT/secrets/SECRET[/versions/VERSION])", name, SecretManagerPrefix)
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), secretManagerTimeout)
+	defer cancel()
+
+	token, err := secretManagerToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get credentials for Secret Manager: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, secretManagerURL+name+":access", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %q: %w", name, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %q: %w", name, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %q: %w", name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to read secret %q: HTTP %d: %s", name, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var version struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &version); err != nil {
+		return "", fmt.Errorf("invalid response reading secret %q: %w", name, err)
+	}
+	data, err := base64.StdEncoding.DecodeString(version.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("invalid payload of secret %q: %w", name, err)
+	}
+	return string(data), nil
+}
\ No newline at end of file
@@ -0,0 +1,110 @@
+package aiEndpoint
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadAPIKey_File(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "api.key")
+	if err := os.WriteFile(keyPath, []byte("  file-key\n"), 0600); err != nil {
+		t.Fatalf("Failed to write %q: %v", keyPath, err)
+	}
+	emptyPath := filepath.Join(dir, "empty.key")
+	if err := os.WriteFile(emptyPath, []byte("\n"), 0600); err != nil {
+		t.Fatalf("Failed to write %q: %v", emptyPath, err)
+	}
+
+	tests := []struct {
+		name    string
+		source  string
+		want    string
+		wantErr bool
+	}{
+		{name: "Key file", source: keyPath, want: "file-key"},
+		{name: "Empty file", source: emptyPath, wantErr: true},
+		{name: "Missing file", source: filepath.Join(dir, "missing.key"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadAPIKey(tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadAPIKey(%q) error = %v, wantErr %v", tt.source, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ReadAPIKey(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadAPIKey_SecretManager(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer test-token" {
+			http.Error(w, "bad token "+got, http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/projects/p/secrets/key/versions/latest:access":
+			fmt.Fprintf(w, `{"payload": {"data": %q}}`, base64.StdEncoding.EncodeToString([]byte("latest-key\n")))
+		case "/projects/p/secrets/key/versions/2:access":
+			fmt.Fprintf(w, `{"payload": {"data": %q}}`, base64.StdEncoding.EncodeToString([]byte("key-v2")))
+		case "/projects/p/secrets/empty/versions/latest:access":
+			fmt.Fprint(w, `{"payload": {"data": ""}}`)
+		default:
+			http.Error(w, "secret not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	origURL, origToken := secretManagerURL, secretManagerToken
+	defer func() { secretManagerURL, secretManagerToken = origURL, origToken }()
+	secretManagerURL = server.URL + "/"
+	secretManagerToken = func(context.Context) (string, error) { return "test-token", nil }
+
+	tests := []struct {
+		name    string
+		source  string
+		want    string
+		wantErr string
+	}{
+		{name: "Latest version", source: "sm://projects/p/secrets/key", want: "latest-key"},
+		{name: "Explicit version", source: "sm://projects/p/secrets/key/versions/2", want: "key-v2"},
+		{name: "Empty secret", source: "sm://projects/p/secrets/empty", wantErr: "is empty"},
+		{name: "Missing secret", source: "sm://projects/p/secrets/missing", wantErr: "HTTP 404"},
+		{name: "Invalid name", source: "sm://secrets/key", wantErr: "invalid Secret Manager secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadAPIKey(tt.source)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ReadAPIKey(%q) error = %v, want it to contain %q", tt.source, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadAPIKey(%q) failed: %v", tt.source, err)
+			}
+			if got != tt.want {
+				t.Errorf("ReadAPIKey(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("No credentials", func(t *testing.T) {
+		secretManagerToken = func(context.Context) (string, error) { return "", errors.New("no ADC") }
+		if _, err := ReadAPIKey("sm://projects/p/secrets/key"); err == nil || !strings.Contains(err.Error(), "no ADC") {
+			t.Errorf("ReadAPIKey without credentials error = %v, want it to mention the credentials error", err)
+		}
+	})
+}
\ No newline at end of file
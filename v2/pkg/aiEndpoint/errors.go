@@ -0,0 +1,33 @@
+package aiEndpoint
+
+import "errors"
+
+// ErrTruncated reports that the AI stopped generating because it reached its output
+// token limit. SendPrompt and SendConversation return the partial response together
+// with an error wrapping ErrTruncated, so callers can decide whether to use it.
+var ErrTruncated = errors.New("response truncated at the output token limit")
+
+// ErrBlocked reports that the AI endpoint withheld its response, e.g. for safety reasons.
+// The error message names the reason given by the endpoint.
+var ErrBlocked = errors.New("response blocked")
+
+// ErrTimeout reports that a request to the AI endpoint did not complete before its
+// deadline, or that its context was canceled. For auxiliary requests such as token
+// counting, callers can treat it as non-fatal and fall back to an estimate.
+var ErrTimeout = errors.New("AI request timed out")
+
+// ErrAuth reports that the AI endpoint rejected the credentials, or that they lack
+// permission for the request (e.g. HTTP 401 or 403).
+var ErrAuth = errors.New("AI endpoint authentication failed")
+
+// ErrQuota reports that a rate limit or quota of the AI endpoint was exhausted
+// (e.g. HTTP 429). Retrying later may succeed.
+var ErrQuota = errors.New("AI endpoint quota exceeded")
+
+// ErrOverloaded reports that the AI endpoint or model is temporarily overloaded or
+// unavailable (e.g. HTTP 503). Retrying later, or with another model, may succeed.
+var ErrOverloaded = errors.New("AI endpoint overloaded")
+
+// ErrNetwork reports that the AI endpoint could not be reached, e.g. because of a
+// DNS failure or a refused connection.
+var ErrNetwork = errors.New("AI endpoint unreachable")
\ No newline at end of file
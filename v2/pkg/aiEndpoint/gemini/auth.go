@@ -3,19 +3,42 @@ package gemini
 import (
 	"os"
 
-	"github.com/golang/glog"
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
 )
 
 // GetAPIKey retrieves the Gemini API key.
-// It checks the GEMINI_API_KEY environment variable.
-// If set, it returns the value. Otherwise, it returns an empty string,
-// indicating that Application Default Credentials (ADC) should be used.
-func GetAPIKey() string {
+// It checks the GEMINI_API_KEY environment variable first, then reads the key from
+// keyFile or, if that is empty, from the file named by GEMINI_API_KEY_FILE.
+// A key source may also name a Secret Manager secret (see aiEndpoint.ReadAPIKey).
+// If none is set, it returns an empty string, indicating that Application Default
+// Credentials (ADC) should be used. An unreadable or empty key file is an error.
+func GetAPIKey(keyFile string) (string, error) {
 	apiKey := os.Getenv("GEMINI_API_KEY")
 	if apiKey != "" {
-		glog.V(1).Info("Using API key from GEMINI_API_KEY environment variable.")
-		return apiKey
+		logging.V(1).Info("Using API key from GEMINI_API_KEY environment variable.")
+		return apiKey, nil
 	}
-	glog.V(1).Info("GEMINI_API_KEY not set. Attempting to use Application Default Credentials (ADC).")
-	return "" // Empty string signals to use ADC
+	if keyFile == "" {
+		keyFile = os.Getenv("GEMINI_API_KEY_FILE")
+	}
+	if keyFile != "" {
+		return aiEndpoint.ReadAPIKey(keyFile)
+	}
+	logging.V(1).Info("GEMINI_API_KEY not set. Attempting to use Application Default Credentials (ADC).")
+	return "", nil // Empty string signals to use ADC
+}
+
+// GetVertexProjectAndLocation resolves the Google Cloud project and location used for
+// the Vertex AI backend. Explicitly provided values (e.g. from command-line flags) take
+// precedence over the GOOGLE_CLOUD_PROJECT and GOOGLE_CLOUD_LOCATION environment variables.
+// Either return value may be empty if it is not configured anywhere.
+func GetVertexProjectAndLocation(project, location string) (string, string) {
+	if project == "" {
+		project = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	}
+	if location == "" {
+		location = os.Getenv("GOOGLE_CLOUD_LOCATION")
+	}
+	return project, location
 }
\ No newline at end of file
@@ -0,0 +1,54 @@
+package gemini
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetAPIKey_KeyFile(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "gemini.key")
+	if err := os.WriteFile(keyPath, []byte("  file-key\n"), 0600); err != nil {
+		t.Fatalf("Failed to write %q: %v", keyPath, err)
+	}
+	otherPath := filepath.Join(dir, "other.key")
+	if err := os.WriteFile(otherPath, []byte("other-key\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", otherPath, err)
+	}
+	emptyPath := filepath.Join(dir, "empty.key")
+	if err := os.WriteFile(emptyPath, []byte("\n"), 0600); err != nil {
+		t.Fatalf("Failed to write %q: %v", emptyPath, err)
+	}
+
+	tests := []struct {
+		name    string
+		envKey  string
+		envFile string
+		keyFile string
+		want    string
+		wantErr bool
+	}{
+		{name: "Key file", keyFile: keyPath, want: "file-key"},
+		{name: "Environment variable takes precedence", envKey: "env-key", keyFile: keyPath, want: "env-key"},
+		{name: "GEMINI_API_KEY_FILE", envFile: otherPath, want: "other-key"},
+		{name: "Flag takes precedence over GEMINI_API_KEY_FILE", envFile: otherPath, keyFile: keyPath, want: "file-key"},
+		{name: "Nothing set uses ADC", want: ""},
+		{name: "Missing file", keyFile: filepath.Join(dir, "missing.key"), wantErr: true},
+		{name: "Empty file", keyFile: emptyPath, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GEMINI_API_KEY", tt.envKey)
+			t.Setenv("GEMINI_API_KEY_FILE", tt.envFile)
+
+			got, err := GetAPIKey(tt.keyFile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetAPIKey(%q) error = %v, wantErr %t", tt.keyFile, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetAPIKey(%q) = %q, want %q", tt.keyFile, got, tt.want)
+			}
+		})
+	}
+}
\ No newline at end of file
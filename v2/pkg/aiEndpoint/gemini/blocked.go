@@ -0,0 +1,57 @@
+package gemini
+
+import (
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+	"google.golang.org/genai"
+)
+
+// blockingFinishReasons are the candidate finish reasons that mean Gemini withheld
+// the response rather than finishing or running out of tokens.
+var blockingFinishReasons = map[genai.FinishReason]bool{
+	genai.FinishReasonSafety:                 true,
+	genai.FinishReasonRecitation:             true,
+	genai.FinishReasonBlocklist:              true,
+	genai.FinishReasonProhibitedContent:      true,
+	genai.FinishReasonSPII:                   true,
+	genai.FinishReasonImageSafety:            true,
+	genai.FinishReasonImageProhibitedContent: true,
+	genai.FinishReasonImageRecitation:        true,
+}
+
+// blockReason returns why Gemini blocked the response, e.g. "SAFETY", or "" if it did not.
+// A blocked prompt is reported through the prompt feedback; a blocked answer through the
+// first candidate's finish reason.
+func blockReason(resp *genai.GenerateContentResponse) string {
+	if resp == nil {
+		return ""
+	}
+	if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != "" {
+		return string(resp.PromptFeedback.BlockReason)
+	}
+	if len(resp.Candidates) > 0 && blockingFinishReasons[resp.Candidates[0].FinishReason] {
+		return string(resp.Candidates[0].FinishReason)
+	}
+	return ""
+}
+
+// logSafetyRatings logs the finish reason and the safety ratings of the prompt and
+// the first candidate at V(1).
+func logSafetyRatings(resp *genai.GenerateContentResponse) {
+	if resp == nil || !logging.V(1) {
+		return
+	}
+	if resp.PromptFeedback != nil {
+		for _, r := range resp.PromptFeedback.SafetyRatings {
+			logging.V(1).Infof("Prompt safety rating: %s=%s (blocked: %t)", r.Category, r.Probability, r.Blocked)
+		}
+	}
+	if len(resp.Candidates) == 0 {
+		logging.V(1).Info("Gemini returned no candidates.")
+		return
+	}
+	candidate := resp.Candidates[0]
+	logging.V(1).Infof("Gemini finish reason: %s", candidate.FinishReason)
+	for _, r := range candidate.SafetyRatings {
+		logging.V(1).Infof("Response safety rating: %s=%s (blocked: %t)", r.Category, r.Probability, r.Blocked)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,44 @@
+package gemini
+
+import (
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestBlockReason(t *testing.T) {
+	tests := []struct {
+		name string
+		resp *genai.GenerateContentResponse
+		want string
+	}{
+		{name: "Nil response", resp: nil, want: ""},
+		{
+			name: "Finished normally",
+			resp: &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonStop}}},
+			want: "",
+		},
+		{
+			name: "Clipped at the token limit",
+			resp: &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonMaxTokens}}},
+			want: "",
+		},
+		{
+			name: "Response blocked for safety",
+			resp: &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonSafety}}},
+			want: "SAFETY",
+		},
+		{
+			name: "Prompt blocked",
+			resp: &genai.GenerateContentResponse{PromptFeedback: &genai.GenerateContentResponsePromptFeedback{BlockReason: genai.BlockedReasonProhibitedContent}},
+			want: "PROHIBITED_CONTENT",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := blockReason(tt.resp); got != tt.want {
+				t.Errorf("blockReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
\ No newline at end of file
@@ -0,0 +1,43 @@
+package gemini
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"google.golang.org/genai"
+)
+
+// errorKind returns the aiEndpoint error (ErrAuth, ErrQuota, ErrOverloaded or ErrNetwork) that err
+// from the Gemini API belongs to, or nil if it fits none of them.
+func errorKind(err error) error {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusForbidden,
+			apiErr.Status == "UNAUTHENTICATED" || apiErr.Status == "PERMISSION_DENIED":
+			return aiEndpoint.ErrAuth
+		case apiErr.Code == http.StatusTooManyRequests, apiErr.Status == "RESOURCE_EXHAUSTED":
+			return aiEndpoint.ErrQuota
+		case apiErr.Code == http.StatusServiceUnavailable, apiErr.Status == "UNAVAILABLE":
+			return aiEndpoint.ErrOverloaded
+		}
+		return nil
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && !netErr.Timeout() {
+		return aiEndpoint.ErrNetwork
+	}
+	return nil
+}
+
+// classifyError wraps err from the Gemini API with its aiEndpoint error kind (see errorKind),
+// prefixed by what failed, so callers can check it with errors.Is.
+func classifyError(what string, err error) error {
+	if kind := errorKind(err); kind != nil {
+		return fmt.Errorf("%s: %w: %w", what, kind, err)
+	}
+	return fmt.Errorf("%s: %w", what, err)
+}
\ No newline at end of file
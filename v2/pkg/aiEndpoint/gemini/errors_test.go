@@ -0,0 +1,94 @@
+package gemini
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"google.golang.org/genai"
+)
+
+func TestClassifyError(t *testing.T) {
+	dnsErr := &url.Error{Op: "Post", URL: "https://generativelanguage.googleapis.com", Err: &net.DNSError{Err: "no such host", Name: "generativelanguage.googleapis.com"}}
+	tests := []struct {
+		name string
+		err  error
+		want error // nil means none of the aiEndpoint kinds
+	}{
+		{name: "Unauthorized", err: genai.APIError{Code: 401, Status: "UNAUTHENTICATED"}, want: aiEndpoint.ErrAuth},
+		{name: "Forbidden", err: genai.APIError{Code: 403, Message: "API key not valid"}, want: aiEndpoint.ErrAuth},
+		{name: "Permission status", err: genai.APIError{Status: "PERMISSION_DENIED"}, want: aiEndpoint.ErrAuth},
+		{name: "Too many requests", err: genai.APIError{Code: 429}, want: aiEndpoint.ErrQuota},
+		{name: "Resource exhausted", err: fmt.Errorf("wrapped: %w", genai.APIError{Status: "RESOURCE_EXHAUSTED"}), want: aiEndpoint.ErrQuota},
+		{name: "Unavailable", err: genai.APIError{Code: 503, Status: "UNAVAILABLE"}, want: aiEndpoint.ErrOverloaded},
+		{name: "DNS failure", err: dnsErr, want: aiEndpoint.ErrNetwork},
+		{name: "Server error", err: genai.APIError{Code: 500, Status: "INTERNAL"}},
+		{name: "Deadline", err: context.DeadlineExceeded},
+		{name: "Other", err: errors.New("boom")},
+	}
+	kinds := []error{aiEndpoint.ErrAuth, aiEndpoint.ErrQuota, aiEndpoint.ErrOverloaded, aiEndpoint.ErrNetwork}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyError("failed to generate content from Gemini", tt.err)
+			// genai.APIError is not comparable, so check the message rather than errors.Is.
+			if !strings.Contains(got.Error(), tt.err.Error()) {
+				t.Errorf("classifyError() = %v, want it to include the original error", got)
+			}
+			for _, kind := range kinds {
+				if is := errors.Is(got, kind); is != (kind == tt.want) {
+					t.Errorf("errors.Is(classifyError(), %v) = %t, want %t", kind, is, kind == tt.want)
+				}
+			}
+		})
+	}
+}
+
+// newTestClient returns a Client whose requests go to an httptest server replying to
+// every request with status and body.
+func newTestClient(t *testing.T, status int, body string) *Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		APIKey:      "test-key",
+		Backend:     genai.BackendGeminiAPI,
+		HTTPOptions: genai.HTTPOptions{BaseURL: server.URL, APIVersion: "v1beta"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create genai client: %v", err)
+	}
+	return &Client{client: client, modelName: "gemini-test"}
+}
+
+func TestSendConversation_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{name: "Quota", status: http.StatusTooManyRequests, body: `{"error": {"code": 429, "message": "quota exceeded", "status": "RESOURCE_EXHAUSTED"}}`, want: aiEndpoint.ErrQuota},
+		{name: "Unauthorized", status: http.StatusUnauthorized, body: `{"error": {"code": 401, "message": "bad key", "status": "UNAUTHENTICATED"}}`, want: aiEndpoint.ErrAuth},
+		{name: "Overloaded", status: http.StatusServiceUnavailable, body: `{"error": {"code": 503, "message": "overloaded", "status": "UNAVAILABLE"}}`, want: aiEndpoint.ErrOverloaded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, tt.status, tt.body)
+			_, err := client.SendConversation(context.Background(), []aiEndpoint.Message{{Role: aiEndpoint.RoleUser, Text: "Hi"}})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("SendConversation() error = %v, want it to wrap %v", err, tt.want)
+			}
+		})
+	}
+}
\ No newline at end of file
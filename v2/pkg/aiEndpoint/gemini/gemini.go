@@ -4,19 +4,42 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
-	"github.com/golang/glog"
 	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
 	"github.com/zicongmei/ai-coder/v2/pkg/utils"
 	"google.golang.org/genai"
 )
 
-// Client implements the AIEngine interface for the Gemini AI.
+// Client implements the AIEngine and CandidateEngine interfaces for the Gemini AI.
 type Client struct {
 	client    *genai.Client
 	modelName string
-	ctx       context.Context // Context for API calls
 	tools     []string
+
+	maxOutputTokens int32         // Maximum number of tokens to generate; 0 uses the model default
+	timeout         time.Duration // Deadline for each API request; 0 means no deadline
+	candidateCount  int32         // Number of candidate replies to request; 0 or 1 requests one
+}
+
+// Ensure Client satisfies the CandidateEngine interface.
+var _ aiEndpoint.CandidateEngine = (*Client)(nil)
+var _ aiEndpoint.AuthHinter = (*Client)(nil)
+
+// Config holds the settings used to construct a Gemini Client.
+type Config struct {
+	ModelName string // Model to use, e.g. "gemini-2.5-pro"
+	Tools     string // Comma-separated list of tools to enable, or "all"
+	Project   string // Google Cloud project for Vertex AI; falls back to GOOGLE_CLOUD_PROJECT
+	Location  string // Google Cloud location for Vertex AI; falls back to GOOGLE_CLOUD_LOCATION
+
+	APIKeyFile string          // File holding the API key, used if GEMINI_API_KEY is unset; falls back to GEMINI_API_KEY_FILE
+	Context    context.Context // Context for creating the client; requests take their own. Background if nil
+
+	MaxOutputTokens int32         // Maximum number of tokens to generate; 0 uses the model default
+	Timeout         time.Duration // Deadline for each API request; 0 means no deadline
+	CandidateCount  int32         // Number of candidate replies to request (see SendConversationCandidates); 0 or 1 requests one
 }
 
 // NewClient initializes a new Gemini AI client.
@@ -24,123 +47,247 @@ type Client struct {
 // otherwise it attempts to use Application Default Credentials (ADC).
 // The 'toolsCSV' parameter is a comma-separated list of tools to enable.
 func NewClient(modelName string, toolsCSV string) (aiEndpoint.AIEngine, error) {
-	ctx := context.Background()
+	return NewClientWithConfig(Config{ModelName: modelName, Tools: toolsCSV})
+}
+
+// NewClientWithConfig initializes a new Gemini AI client from cfg.
+// Authentication precedence is: the GEMINI_API_KEY environment variable (Gemini API backend),
+// then the API key file (see GetAPIKey), then a Vertex AI project and location (Vertex AI backend with ADC),
+// then plain Application Default Credentials.
+func NewClientWithConfig(clientCfg Config) (aiEndpoint.AIEngine, error) {
+	ctx := clientCfg.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	modelName := clientCfg.ModelName
+
+	// Parse tools before creating the client, so a typo fails fast.
+	tools, err := ParseTools(clientCfg.Tools)
+	if err != nil {
+		logging.Errorf("Invalid tools %q: %v", clientCfg.Tools, err)
+		return nil, err
+	}
 
 	cfg := &genai.ClientConfig{
 		HTTPOptions: genai.HTTPOptions{APIVersion: "v1beta"},
 	}
 
-	apiKey := GetAPIKey() // Use the auth.go function
+	project, location := GetVertexProjectAndLocation(clientCfg.Project, clientCfg.Location)
+	apiKey, err := GetAPIKey(clientCfg.APIKeyFile) // Use the auth.go function
+	if err != nil {
+		logging.Errorf("Failed to get the Gemini API key: %v", err)
+		return nil, fmt.Errorf("%w: %w", aiEndpoint.ErrAuth, err)
+	}
 	if apiKey != "" {
 		cfg.APIKey = apiKey
-		glog.V(1).Info("Gemini client initializing with API key.")
+		logging.V(1).Info("Gemini client initializing with API key.")
+		if project != "" || location != "" {
+			logging.V(1).Infof("API key takes precedence over Vertex AI settings; ignoring project %q and location %q.", project, location)
+		}
+	} else if project != "" && location != "" {
+		cfg.Backend = genai.BackendVertexAI
+		cfg.Project = project
+		cfg.Location = location
+		logging.V(1).Infof("GEMINI_API_KEY not set. Using Vertex AI backend with project %q and location %q (ADC).", project, location)
 	} else {
-		glog.V(1).Info("GEMINI_API_KEY not set. Attempting to use Application Default Credentials (ADC).")
+		if project != "" || location != "" {
+			logging.V(1).Infof("Vertex AI needs both a project and a location (got project %q, location %q); not using the Vertex AI backend.", project, location)
+		}
+		logging.V(1).Info("GEMINI_API_KEY not set. Attempting to use Application Default Credentials (ADC).")
 	}
 
 	client, err := genai.NewClient(ctx, cfg)
 	if err != nil {
-		glog.Errorf("Failed to create Gemini client: %v", err)
+		logging.Errorf("Failed to create Gemini client: %v", err)
 		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
 	}
 	// The underlying genai client should ideally be closed, but the AIEngine interface
 	// doesn't expose a Close method. For long-running applications, the client should
 	// be managed at a higher level (e.g., in `main` function with `defer client.Close()`).
-	glog.V(0).Info("Gemini client successfully created.")
-
-	// Parse tools
-	var tools []string
-	if strings.ToLower(toolsCSV) == "all" {
-		tools = []string{"google-search", "url-context"}
-	} else if toolsCSV != "" {
-		parts := strings.Split(toolsCSV, ",")
-		for _, p := range parts {
-			t := strings.TrimSpace(p)
-			if t != "" {
-				tools = append(tools, t)
-			}
-		}
-	}
+	logging.V(0).Info("Gemini client successfully created.")
 
 	// Disable tools for Gemini 2.5 models
 	if strings.Contains(modelName, "gemini-2.5") {
 		if len(tools) > 0 {
-			glog.Warningf("Tools usage is disabled for model %q. Ignoring tools: %v", modelName, tools)
+			logging.Warningf("Tools usage is disabled for model %q. Ignoring tools: %v", modelName, tools)
 			tools = []string{}
 		}
 	}
 
-	glog.V(0).Infof("Using %q model.", modelName)
+	logging.V(0).Infof("Using %q model.", modelName)
 	if len(tools) > 0 {
-		glog.V(0).Infof("Tools enabled: %v", tools)
+		logging.V(0).Infof("Tools enabled: %v", tools)
+	}
+
+	if clientCfg.MaxOutputTokens > 0 {
+		logging.V(0).Infof("Max output tokens: %d", clientCfg.MaxOutputTokens)
+	}
+	if clientCfg.CandidateCount > 1 {
+		logging.V(0).Infof("Candidates per request: %d", clientCfg.CandidateCount)
 	}
 
 	return &Client{
-		client:    client,
-		modelName: modelName,
-		ctx:       ctx,
-		tools:     tools,
+		client:          client,
+		modelName:       modelName,
+		tools:           tools,
+		maxOutputTokens: clientCfg.MaxOutputTokens,
+		timeout:         clientCfg.Timeout,
+		candidateCount:  clientCfg.CandidateCount,
 	}, nil
 }
 
 // SendPrompt sends a string prompt to the Gemini AI endpoint and returns
 // the AI's response as a string.
-func (c *Client) SendPrompt(prompt string) (string, error) {
-	glog.V(1).Info("Sending prompt to Gemini AI...")
-	glog.V(2).Infof("Prompt content (truncated): %q", utils.TruncateString(prompt, 200))
+func (c *Client) SendPrompt(ctx context.Context, prompt string) (string, error) {
+	return c.SendConversation(ctx, []aiEndpoint.Message{{Role: aiEndpoint.RoleUser, Text: prompt}})
+}
+
+// SendConversation sends the conversation history to the Gemini AI endpoint and returns
+// the AI's reply as a string. If several candidates were requested, the first is returned.
+func (c *Client) SendConversation(ctx context.Context, history []aiEndpoint.Message) (string, error) {
+	candidates, err := c.SendConversationCandidates(ctx, history)
+	if len(candidates) == 0 {
+		return "", err
+	}
+	return candidates[0], err
+}
 
-	contents := []*genai.Content{
-		{
-			Parts: []*genai.Part{
-				{Text: prompt},
-			},
-			Role: "user",
-		},
+// SendConversationCandidates sends the conversation history to the Gemini AI endpoint and
+// returns the text of each candidate reply, Config.CandidateCount of them at most.
+// Candidates cut off at the output token limit are dropped while others remain; if all
+// of them are, they are returned with an error wrapping aiEndpoint.ErrTruncated.
+func (c *Client) SendConversationCandidates(ctx context.Context, history []aiEndpoint.Message) ([]string, error) {
+	logging.V(1).Infof("Sending conversation of %d messages to Gemini AI...", len(history))
+	if len(history) > 0 {
+		logging.V(2).Infof("Latest message content (truncated): %q", utils.TruncateString(history[len(history)-1].Text, 200))
 	}
 
-	var config *genai.GenerateContentConfig
+	contents := make([]*genai.Content, 0, len(history))
+	for _, msg := range history {
+		role := genai.RoleUser
+		if msg.Role == aiEndpoint.RoleModel {
+			role = genai.RoleModel
+		}
+		parts := []*genai.Part{
+			{Text: msg.Text},
+		}
+		for _, a := range msg.Attachments {
+			logging.V(1).Infof("Attaching %q (%s, %d bytes) to the message.", a.Name, a.MIMEType, len(a.Data))
+			parts = append(parts, &genai.Part{InlineData: &genai.Blob{MIMEType: a.MIMEType, Data: a.Data}})
+		}
+		contents = append(contents, &genai.Content{
+			Parts: parts,
+			Role:  role,
+		})
+	}
+
+	config := &genai.GenerateContentConfig{}
+	if c.maxOutputTokens > 0 {
+		config.MaxOutputTokens = c.maxOutputTokens
+	}
+	if c.candidateCount > 1 {
+		config.CandidateCount = c.candidateCount
+	}
 	if len(c.tools) > 0 {
 		tool := &genai.Tool{}
 		configured := false
 		for _, t := range c.tools {
 			switch t {
-			case "google-search":
+			case ToolGoogleSearch:
 				tool.GoogleSearch = &genai.GoogleSearch{}
 				configured = true
-			case "url-context":
+			case ToolURLContext:
 				tool.URLContext = &genai.URLContext{}
 				configured = true
 			default:
-				glog.Warningf("Unknown tool: %q", t)
+				logging.Warningf("Unknown tool: %q", t)
 			}
 		}
 
 		if configured {
-			config = &genai.GenerateContentConfig{
-				Tools: []*genai.Tool{tool},
-			}
+			config.Tools = []*genai.Tool{tool}
 		}
 	}
 
-	resp, err := c.client.Models.GenerateContent(c.ctx, c.modelName, contents, config)
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	resp, err := c.client.Models.GenerateContent(ctx, c.modelName, contents, config)
+	if err != nil && ctx.Err() != nil {
+		logging.Errorf("Gemini request did not complete: %v", ctx.Err())
+		return nil, fmt.Errorf("failed to generate content from Gemini: %w: %w", aiEndpoint.ErrTimeout, ctx.Err())
+	}
 	if err != nil {
-		glog.Errorf("Failed to generate content from Gemini: %v, response: %v", err, resp.Text())
-		return "", fmt.Errorf("failed to generate content from Gemini: %w", err)
+		logging.Errorf("Failed to generate content from Gemini: %v", err) // resp is nil on failure
+		return nil, classifyError("failed to generate content from Gemini", err)
 	}
 
-	result := resp.Text()
-	if result == "" {
-		glog.Warning("Gemini response was empty.")
+	logSafetyRatings(resp)
+	if reason := blockReason(resp); reason != "" {
+		logging.Errorf("Gemini blocked the response: %s", reason)
+		return nil, fmt.Errorf("%w: %s", aiEndpoint.ErrBlocked, reason)
+	}
+
+	var complete, truncated []string
+	for _, candidate := range resp.Candidates {
+		text := candidateText(candidate)
+		if candidate.FinishReason == genai.FinishReasonMaxTokens {
+			truncated = append(truncated, text)
+		} else {
+			complete = append(complete, text)
+		}
+	}
+	if len(complete) == 0 && len(truncated) == 0 {
+		complete = []string{""}
+	}
+	for i, result := range append(complete, truncated...) {
+		if result == "" {
+			logging.Warningf("Gemini response candidate %d was empty.", i+1)
+		}
+		logging.V(1).Infof("Received response candidate %d from Gemini (length: %d).", i+1, len(result))
+		logging.V(2).Infof("Full Gemini response (truncated): %q", utils.TruncateString(result, 200))
 	}
 
-	glog.V(1).Infof("Received response from Gemini (length: %d).", len(result))
-	glog.V(2).Infof("Full Gemini response (truncated): %q", utils.TruncateString(result, 200))
+	if len(complete) == 0 {
+		logging.Warningf("Gemini stopped at the output token limit; the response (length: %d) is incomplete.", len(truncated[0]))
+		return truncated, fmt.Errorf("gemini finish reason %s: %w", genai.FinishReasonMaxTokens, aiEndpoint.ErrTruncated)
+	}
+	if len(truncated) > 0 {
+		logging.Warningf("Dropped %d of %d Gemini candidates that stopped at the output token limit.", len(truncated), len(resp.Candidates))
+	}
+	return complete, nil
+}
 
-	return result, nil
+// candidateText returns the text of candidate, skipping thoughts and non-text parts, as
+// GenerateContentResponse.Text does for the first candidate.
+func candidateText(candidate *genai.Candidate) string {
+	if candidate == nil || candidate.Content == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, part := range candidate.Content.Parts {
+		if !part.Thought {
+			b.WriteString(part.Text)
+		}
+	}
+	return b.String()
 }
 
 // CountTokens estimates the number of tokens in the given prompt string using the Gemini model.
-func (c *Client) CountTokens(prompt string) (int, error) {
-	glog.V(1).Info("Counting tokens for prompt using Gemini model.")
-	return CountTokens(c.ctx, c.client, c.modelName, prompt)
+// The request shares the client's per-request timeout.
+func (c *Client) CountTokens(ctx context.Context, prompt string) (int, error) {
+	logging.V(1).Info("Counting tokens for prompt using Gemini model.")
+	return CountTokens(ctx, c.client, c.modelName, prompt, c.timeout)
+}
+
+// ModelName returns the name of the Gemini model used by this client.
+func (c *Client) ModelName() string {
+	return c.modelName
+}
+
+// AuthHint implements aiEndpoint.AuthHinter.
+func (c *Client) AuthHint() string {
+	return "Check that GEMINI_API_KEY (or --api-key-file) is valid, or run `gcloud auth application-default login`."
 }
\ No newline at end of file
@@ -3,14 +3,24 @@ package gemini
 import (
 	"context"
 	"fmt"
+	"time"
 
-	"github.com/golang/glog"
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
 	"google.golang.org/genai"
 )
 
 // CountTokens estimates the number of tokens in the given text using the provided Gemini model.
-func CountTokens(ctx context.Context, client *genai.Client, modelName string, text string) (int, error) {
-	glog.V(1).Infof("Requesting token count for prompt in model %q.", modelName)
+// The request is bounded by timeout (no bound if timeout <= 0); if it runs out, or ctx is
+// canceled, the returned error wraps aiEndpoint.ErrTimeout.
+func CountTokens(ctx context.Context, client *genai.Client, modelName string, text string, timeout time.Duration) (int, error) {
+	logging.V(1).Infof("Requesting token count for prompt in model %q.", modelName)
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
 	contents := []*genai.Content{
 		{
@@ -22,9 +32,13 @@ func CountTokens(ctx context.Context, client *genai.Client, modelName string, te
 	}
 
 	resp, err := client.Models.CountTokens(ctx, modelName, contents, nil)
+	if err != nil && ctx.Err() != nil {
+		logging.Warningf("Token count request did not complete: %v", ctx.Err())
+		return 0, fmt.Errorf("failed to count tokens: %w: %w", aiEndpoint.ErrTimeout, ctx.Err())
+	}
 	if err != nil {
-		glog.Errorf("Failed to count tokens: %v", err)
-		return 0, fmt.Errorf("failed to count tokens: %w", err)
+		logging.Errorf("Failed to count tokens: %v", err)
+		return 0, classifyError("failed to count tokens", err)
 	}
 	// The log message "Prompt contains %d tokens." will be done in flow.go.
 	return int(resp.TotalTokens), nil
@@ -1,7 +1,13 @@
 package gemini
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"google.golang.org/genai"
 )
 
 // TestCountTokens_Integration performs real API calls to Gemini to count tokens.
@@ -9,7 +15,7 @@ import (
 // connectivity and a valid GEMINI_API_KEY environment variable.
 func TestCountTokens_Integration(t *testing.T) {
 	// Check for API key early and skip if not set, providing a clear message.
-	if GetAPIKey() == "" {
+	if apiKey, _ := GetAPIKey(""); apiKey == "" {
 		t.Skip("GEMINI_API_KEY not set. Skipping integration test for token counting. Please set the environment variable to run this test.")
 	}
 
@@ -61,7 +67,7 @@ func TestCountTokens_Integration(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Call the CountTokens method on the AIEngine interface returned by NewClient
-			gotTokens, err := aiEngine.CountTokens(tt.prompt)
+			gotTokens, err := aiEngine.CountTokens(context.Background(), tt.prompt)
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("CountTokens() error = %v, wantErr %v", err, tt.wantErr)
@@ -77,6 +83,24 @@ func TestCountTokens_Integration(t *testing.T) {
 	}
 }
 
+func TestCountTokens_CanceledContext(t *testing.T) {
+	// A placeholder key is enough: the canceled context stops the request before it is sent.
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{APIKey: "test-key", Backend: genai.BackendGeminiAPI})
+	if err != nil {
+		t.Fatalf("Failed to create genai client: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = CountTokens(ctx, client, "gemini-2.5-flash", "Hello world!", time.Minute)
+	if !errors.Is(err, aiEndpoint.ErrTimeout) {
+		t.Errorf("CountTokens() error = %v, want it to wrap aiEndpoint.ErrTimeout", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("CountTokens() error = %v, want it to wrap context.Canceled", err)
+	}
+}
+
 // TestDummy ensures 'go test' finds at least one test even if the integration test is skipped.
 // This is a common pattern for tests that might be conditionally skipped.
 func TestDummy(t *testing.T) {
@@ -0,0 +1,44 @@
+package gemini
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Names of the Gemini tools that can be enabled with the tools CSV.
+const (
+	ToolGoogleSearch = "google-search"
+	ToolURLContext   = "url-context"
+)
+
+// KnownTools lists every tool name accepted by ParseTools, in the order "all" enables them.
+var KnownTools = []string{ToolGoogleSearch, ToolURLContext}
+
+// ParseTools parses a comma-separated list of tool names. "all" (case-insensitive)
+// enables every known tool, and empty entries are ignored. Unknown names are
+// rejected with an error listing the valid ones.
+func ParseTools(toolsCSV string) ([]string, error) {
+	if strings.EqualFold(strings.TrimSpace(toolsCSV), "all") {
+		return append([]string(nil), KnownTools...), nil
+	}
+
+	var tools []string
+	for _, p := range strings.Split(toolsCSV, ",") {
+		t := strings.TrimSpace(p)
+		if t == "" {
+			continue
+		}
+		known := false
+		for _, k := range KnownTools {
+			if t == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown tool %q (valid tools: %s, or 'all')", t, strings.Join(KnownTools, ", "))
+		}
+		tools = append(tools, t)
+	}
+	return tools, nil
+}
\ No newline at end of file
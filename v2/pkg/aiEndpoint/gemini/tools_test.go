@@ -0,0 +1,56 @@
+package gemini
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTools(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    []string
+		wantErr bool
+	}{
+		{name: "Empty", csv: "", want: nil},
+		{name: "All", csv: "ALL", want: []string{ToolGoogleSearch, ToolURLContext}},
+		{name: "Trimmed list", csv: " url-context , ,google-search", want: []string{ToolURLContext, ToolGoogleSearch}},
+		{name: "Unknown tool", csv: "google-search,code-exec", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTools(tt.csv)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTools(%q) error = %v, wantErr %v", tt.csv, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTools(%q) = %v, want %v", tt.csv, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewClientWithConfig_Tools(t *testing.T) {
+	t.Setenv("GEMINI_API_KEY", "fake-key")
+
+	engine, err := NewClientWithConfig(Config{ModelName: "gemini-3-pro-preview", Tools: "all"})
+	if err != nil {
+		t.Fatalf("NewClientWithConfig() error = %v", err)
+	}
+	c := engine.(*Client)
+	if want := []string{ToolGoogleSearch, ToolURLContext}; !reflect.DeepEqual(c.tools, want) {
+		t.Errorf("client tools = %v, want %v", c.tools, want)
+	}
+
+	engine, err = NewClientWithConfig(Config{ModelName: "gemini-2.5-pro", Tools: "all"})
+	if err != nil {
+		t.Fatalf("NewClientWithConfig() error = %v", err)
+	}
+	if c := engine.(*Client); len(c.tools) != 0 {
+		t.Errorf("client tools for a gemini-2.5 model = %v, want none", c.tools)
+	}
+
+	if _, err := NewClientWithConfig(Config{ModelName: "gemini-3-pro-preview", Tools: "bogus"}); err == nil {
+		t.Error("NewClientWithConfig() with an unknown tool succeeded, want an error")
+	}
+}
\ No newline at end of file
@@ -1,15 +1,66 @@
 package aiEndpoint
 
+import "context"
+
+// Roles of the participants in a conversation.
+const (
+	RoleUser  = "user"  // A message written by the user (or the tool on their behalf)
+	RoleModel = "model" // A message returned by the AI
+)
+
+// Message is a single turn of a conversation with an AI endpoint.
+type Message struct {
+	Role string // RoleUser or RoleModel
+	Text string // The text of the message
+
+	// Attachments are non-text inputs, such as images or PDFs, sent alongside Text.
+	// Engines that do not support them ignore them.
+	Attachments []Attachment
+}
+
+// Attachment is a binary file included inline in a message.
+type Attachment struct {
+	Name     string // File name, for logging
+	MIMEType string // e.g. "image/png" or "application/pdf"
+	Data     []byte // Raw file content
+}
+
 // AIEngine defines the interface for interacting with an AI endpoint.
 // Implementations of this interface will handle the specific communication
 // details (e.g., HTTP requests, authentication) for different AI models
-// or services.
+// or services. Each call is bounded by ctx: canceling it aborts the request in flight.
 type AIEngine interface {
 	// SendPrompt sends a string prompt to the AI endpoint and returns
 	// the AI's response as a string.
 	// It should also return an error if the communication or AI processing fails.
-	SendPrompt(prompt string) (string, error)
+	SendPrompt(ctx context.Context, prompt string) (string, error)
+
+	// SendConversation sends the conversation history, ending with the latest
+	// user message, to the AI endpoint and returns the AI's reply as a string.
+	SendConversation(ctx context.Context, history []Message) (string, error)
 
 	// CountTokens estimates the number of tokens in the given prompt string.
-	CountTokens(prompt string) (int, error)
+	CountTokens(ctx context.Context, prompt string) (int, error)
+
+	// ModelName returns the name of the model the engine sends prompts to.
+	ModelName() string
+}
+
+// AuthHinter is implemented by engines that can tell the user how to fix credentials
+// the endpoint rejected with ErrAuth, e.g. which environment variable holds the key.
+type AuthHinter interface {
+	// AuthHint returns a one-sentence suggestion for fixing the credentials.
+	AuthHint() string
+}
+
+// CandidateEngine is implemented by engines that can generate several alternative
+// replies to one request, e.g. Gemini with a candidate count above one.
+type CandidateEngine interface {
+	AIEngine
+
+	// SendConversationCandidates is like SendConversation, but returns every candidate
+	// reply the endpoint generated, in the endpoint's order. There is at least one
+	// candidate unless an error is returned; with ErrTruncated, the candidates are
+	// returned together with the error.
+	SendConversationCandidates(ctx context.Context, history []Message) ([]string, error)
 }
\ No newline at end of file
@@ -0,0 +1,134 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+// DefaultModelName is the model name reported by a Client with no Model set.
+const DefaultModelName = "mock-model"
+
+// Client implements the AIEngine and CandidateEngine interfaces with canned responses, for deterministic tests.
+// It never touches the network. Each call to SendPrompt returns the next entry of
+// Responses; once those are exhausted (or if none are set), it returns Response.
+// A non-nil Err is returned from every SendPrompt call instead, as is the error of a
+// canceled context, wrapping aiEndpoint.ErrTimeout. SendConversationCandidates
+// returns the entries of Candidates in order, then falls back to SendConversation.
+type Client struct {
+	Response  string   // Response returned once Responses is exhausted
+	Responses []string // Responses returned in order by successive SendPrompt calls
+	Err       error    // Error returned by SendPrompt, if non-nil
+	Model     string   // Model name reported by ModelName; DefaultModelName if empty
+	Truncated bool     // Report every response as clipped, wrapping aiEndpoint.ErrTruncated
+	CountErr  error    // Error returned by CountTokens, if non-nil
+
+	Candidates [][]string // Candidate replies returned in order by successive SendConversationCandidates calls
+
+	mu             sync.Mutex
+	prompts        []string
+	histories      [][]aiEndpoint.Message
+	candidateCalls int
+}
+
+// Ensure Client satisfies the CandidateEngine interface.
+var _ aiEndpoint.CandidateEngine = (*Client)(nil)
+
+// NewClient returns a mock Client that always responds with response.
+func NewClient(response string) *Client {
+	return &Client{Response: response}
+}
+
+// SendConversation records the latest message of the history as a prompt and returns
+// the next canned response or error, like SendPrompt.
+func (c *Client) SendConversation(ctx context.Context, history []aiEndpoint.Message) (string, error) {
+	c.mu.Lock()
+	c.histories = append(c.histories, append([]aiEndpoint.Message(nil), history...))
+	c.mu.Unlock()
+
+	latest := ""
+	if len(history) > 0 {
+		latest = history[len(history)-1].Text
+	}
+	return c.SendPrompt(ctx, latest)
+}
+
+// SendConversationCandidates returns the next entry of Candidates, recording the history
+// and its latest message like SendConversation. Once Candidates is exhausted, the
+// SendConversation response is the only candidate.
+func (c *Client) SendConversationCandidates(ctx context.Context, history []aiEndpoint.Message) ([]string, error) {
+	c.mu.Lock()
+	call := c.candidateCalls
+	c.candidateCalls++
+	c.mu.Unlock()
+
+	response, err := c.SendConversation(ctx, history)
+	if err != nil && !errors.Is(err, aiEndpoint.ErrTruncated) {
+		return nil, err
+	}
+	if call < len(c.Candidates) {
+		return append([]string(nil), c.Candidates[call]...), err
+	}
+	return []string{response}, err
+}
+
+// SendPrompt records the prompt and returns the next canned response or error.
+func (c *Client) SendPrompt(ctx context.Context, prompt string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	call := len(c.prompts)
+	c.prompts = append(c.prompts, prompt)
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("mock: %w: %w", aiEndpoint.ErrTimeout, err)
+	}
+	if c.Err != nil {
+		return "", c.Err
+	}
+	response := c.Response
+	if call < len(c.Responses) {
+		response = c.Responses[call]
+	}
+	if c.Truncated {
+		return response, fmt.Errorf("mock: %w", aiEndpoint.ErrTruncated)
+	}
+	return response, nil
+}
+
+// CountTokens returns a rough estimate of one token per four bytes of the prompt,
+// or CountErr if it is set.
+func (c *Client) CountTokens(ctx context.Context, prompt string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("mock: %w: %w", aiEndpoint.ErrTimeout, err)
+	}
+	if c.CountErr != nil {
+		return 0, c.CountErr
+	}
+	return utils.ApproxTokenCount(prompt), nil
+}
+
+// ModelName returns the configured model name.
+func (c *Client) ModelName() string {
+	if c.Model == "" {
+		return DefaultModelName
+	}
+	return c.Model
+}
+
+// Prompts returns a copy of the prompts received by SendPrompt, in order.
+func (c *Client) Prompts() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.prompts...)
+}
+
+// Histories returns the conversation histories received by SendConversation, in order.
+func (c *Client) Histories() [][]aiEndpoint.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]aiEndpoint.Message(nil), c.histories...)
+}
\ No newline at end of file
@@ -0,0 +1,111 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/anthropic"
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/gemini"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// Names of the built-in providers.
+const (
+	Gemini    = "gemini"    // Google Gemini, through the Gemini API or Vertex AI
+	Anthropic = "anthropic" // Anthropic Claude, through the Messages API
+)
+
+// Config holds the provider-independent settings used to construct an AI engine.
+// Providers ignore the fields that do not apply to them.
+type Config struct {
+	Provider string // Name of the provider, e.g. Gemini; empty selects Gemini
+	Model    string // Model to use
+	Tools    string // Comma-separated list of tools to enable
+	Project  string // Google Cloud project for the Vertex AI backend
+	Location string // Google Cloud location for the Vertex AI backend
+
+	APIKeyFile string          // File holding the API key, used if the provider's API key environment variable is unset
+	Context    context.Context // Context for creating the engine; requests take their own. Background if nil
+
+	MaxOutputTokens int32         // Maximum number of tokens to generate; 0 uses the model default
+	Timeout         time.Duration // Deadline for each request to the AI endpoint; 0 means no deadline
+	CandidateCount  int32         // Number of alternative replies to request per prompt; 0 or 1 requests one
+}
+
+// Factory constructs an AI engine from cfg.
+type Factory func(cfg Config) (aiEndpoint.AIEngine, error)
+
+// registry maps provider names to the factories constructing their engines.
+var registry = map[string]Factory{
+	Gemini:    newGemini,
+	Anthropic: newAnthropic,
+}
+
+// Register makes an engine available under name, replacing any provider of that name.
+// It is meant to be called from init functions, before any engine is constructed.
+func Register(name string, factory Factory) {
+	registry[name] = factory
+}
+
+// Names returns the names of the registered providers in sorted order.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewEngine constructs the AI engine for cfg.Provider with its registered factory.
+// An unknown provider is an error naming the known ones.
+func NewEngine(cfg Config) (aiEndpoint.AIEngine, error) {
+	name := cfg.Provider
+	if name == "" {
+		name = Gemini
+	}
+	logging.V(1).Infof("Constructing AI engine for provider %q.", name)
+	factory, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown AI provider %q (known providers: %s)", cfg.Provider, strings.Join(Names(), ", "))
+	}
+	return factory(cfg)
+}
+
+// newGemini constructs a Gemini engine.
+func newGemini(cfg Config) (aiEndpoint.AIEngine, error) {
+	return gemini.NewClientWithConfig(gemini.Config{
+		ModelName: cfg.Model,
+		Tools:     cfg.Tools,
+		Project:   cfg.Project,
+		Location:  cfg.Location,
+
+		APIKeyFile:      cfg.APIKeyFile,
+		Context:         cfg.Context,
+		MaxOutputTokens: cfg.MaxOutputTokens,
+		Timeout:         cfg.Timeout,
+		CandidateCount:  cfg.CandidateCount,
+	})
+}
+
+// newAnthropic constructs a Claude engine. Tools, the Vertex AI settings and candidate
+// counts do not apply.
+func newAnthropic(cfg Config) (aiEndpoint.AIEngine, error) {
+	if cfg.Tools != "" {
+		logging.Warningf("Tools are not supported by provider %q. Ignoring tools %q.", Anthropic, cfg.Tools)
+	}
+	if cfg.CandidateCount > 1 {
+		logging.Warningf("Multiple candidates are not supported by provider %q. Requesting one.", Anthropic)
+	}
+	return anthropic.NewClientWithConfig(anthropic.Config{
+		ModelName: cfg.Model,
+
+		APIKeyFile:      cfg.APIKeyFile,
+		MaxOutputTokens: cfg.MaxOutputTokens,
+		Timeout:         cfg.Timeout,
+	})
+}
\ No newline at end of file
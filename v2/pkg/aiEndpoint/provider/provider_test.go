@@ -0,0 +1,34 @@
+package provider
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+)
+
+func TestNewEngine_Registry(t *testing.T) {
+	Register("test-mock", func(cfg Config) (aiEndpoint.AIEngine, error) {
+		return &mock.Client{Model: cfg.Model}, nil
+	})
+	t.Cleanup(func() { delete(registry, "test-mock") })
+
+	if got, want := Names(), []string{Anthropic, Gemini, "test-mock"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %q, want %q", got, want)
+	}
+
+	engine, err := NewEngine(Config{Provider: "test-mock", Model: "m1"})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	if engine.ModelName() != "m1" {
+		t.Errorf("NewEngine() model = %q, want %q", engine.ModelName(), "m1")
+	}
+
+	_, err = NewEngine(Config{Provider: "openai"})
+	if err == nil || !strings.Contains(err.Error(), `"openai"`) || !strings.Contains(err.Error(), "anthropic, gemini, test-mock") {
+		t.Errorf("NewEngine() error = %v, want it to name the unknown and the known providers", err)
+	}
+}
\ No newline at end of file
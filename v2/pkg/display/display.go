@@ -9,14 +9,14 @@ import (
 	"runtime"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/yuin/goldmark"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
 )
 
 // SaveAndOpenAsMarkdown saves the provided AI response
 // to a Markdown file in /tmp and attempts to open it in the default web browser.
 func SaveAndOpenAsMarkdown(aiResponse string) error {
-	glog.V(1).Info("Preparing to save AI response as Markdown and open in browser.")
+	logging.V(1).Info("Preparing to save AI response as Markdown and open in browser.")
 
 	// Generate a unique filename using a timestamp
 	timestamp := time.Now().Format("20060102_150405") // YYYYMMDD_HHMMSS
@@ -27,10 +27,10 @@ func SaveAndOpenAsMarkdown(aiResponse string) error {
 	// Write the content to the file
 	err := os.WriteFile(filePath, []byte(markdownContent), 0644)
 	if err != nil {
-		glog.Errorf("Failed to save AI response to Markdown file %q: %v", filePath, err)
+		logging.Errorf("Failed to save AI response to Markdown file %q: %v", filePath, err)
 		return fmt.Errorf("failed to save AI response: %w", err)
 	}
-	glog.V(0).Infof("AI response saved to %q", filePath)
+	logging.V(0).Infof("AI response saved to %q", filePath)
 
 	// Determine the command to open the file based on the operating system
 	var cmd *exec.Cmd
@@ -43,20 +43,20 @@ func SaveAndOpenAsMarkdown(aiResponse string) error {
 		// Use "start" command with "/c" to run it in a new shell and then exit
 		cmd = exec.Command("cmd", "/c", "start", filePath)
 	default:
-		glog.Warningf("Unsupported operating system for opening file in browser: %s. Please open %q manually.", runtime.GOOS, filePath)
+		logging.Warningf("Unsupported operating system for opening file in browser: %s. Please open %q manually.", runtime.GOOS, filePath)
 		return nil // Not considered a critical error, so return nil
 	}
 
-	glog.V(1).Infof("Attempting to open %q in browser using command: %s", filePath, cmd.String())
+	logging.V(1).Infof("Attempting to open %q in browser using command: %s", filePath, cmd.String())
 
 	// Use Start() to open the file asynchronously, so the main program doesn't wait for the browser to close.
 	err = cmd.Start()
 	if err != nil {
-		glog.Errorf("Failed to open file %q in browser: %v", filePath, err)
+		logging.Errorf("Failed to open file %q in browser: %v", filePath, err)
 		return fmt.Errorf("failed to open file in browser: %w", err)
 	}
 
-	glog.V(0).Info("AI response file opened in browser (if supported and successful).")
+	logging.V(0).Info("AI response file opened in browser (if supported and successful).")
 	return nil
 }
 
@@ -65,7 +65,7 @@ func SaveAndOpenAsMarkdown(aiResponse string) error {
 // The content is HTML-escaped and wrapped in <pre> tags for literal display,
 // ensuring whitespace and newlines are preserved.
 func SaveAndOpenAIResponseAsHTML(aiResponse string) error {
-	glog.V(1).Info("Preparing to save raw AI response as HTML and open in browser.")
+	logging.V(1).Info("Preparing to save raw AI response as HTML and open in browser.")
 
 	// Generate a unique filename using a timestamp
 	timestamp := time.Now().Format("20060102_150405") // YYYYMMDD_HHMMSS
@@ -94,10 +94,10 @@ func SaveAndOpenAIResponseAsHTML(aiResponse string) error {
 	// Write the content to the file
 	err := os.WriteFile(filePath, []byte(htmlContent), 0644)
 	if err != nil {
-		glog.Errorf("Failed to save raw AI response to HTML file %q: %v", filePath, err)
+		logging.Errorf("Failed to save raw AI response to HTML file %q: %v", filePath, err)
 		return fmt.Errorf("failed to save AI response: %w", err)
 	}
-	glog.V(0).Infof("Raw AI response saved to %q", filePath)
+	logging.V(0).Infof("Raw AI response saved to %q", filePath)
 
 	// Determine the command to open the file based on the operating system
 	var cmd *exec.Cmd
@@ -109,19 +109,19 @@ func SaveAndOpenAIResponseAsHTML(aiResponse string) error {
 	case "windows": // Windows
 		cmd = exec.Command("cmd", "/c", "start", filePath)
 	default:
-		glog.Warningf("Unsupported operating system for opening file in browser: %s. Please open %q manually.", runtime.GOOS, filePath)
+		logging.Warningf("Unsupported operating system for opening file in browser: %s. Please open %q manually.", runtime.GOOS, filePath)
 		return nil // Not considered a critical error, so return nil
 	}
 
-	glog.V(1).Infof("Attempting to open %q in browser using command: %s", filePath, cmd.String())
+	logging.V(1).Infof("Attempting to open %q in browser using command: %s", filePath, cmd.String())
 
 	// Use Start() to open the file asynchronously, so the main program doesn't wait for the browser to close.
 	err = cmd.Start()
 	if err != nil {
-		glog.Errorf("Failed to open file %q in browser: %v", filePath, err)
+		logging.Errorf("Failed to open file %q in browser: %v", filePath, err)
 		return fmt.Errorf("failed to open file in browser: %w", err)
 	}
 
-	glog.V(0).Info("AI response file opened in browser (if supported and successful).")
+	logging.V(0).Info("AI response file opened in browser (if supported and successful).")
 	return nil
 }
\ No newline at end of file
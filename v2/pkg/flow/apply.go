@@ -0,0 +1,192 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+	"github.com/zicongmei/ai-coder/v2/pkg/modifyFiles"
+	"github.com/zicongmei/ai-coder/v2/pkg/prompt"
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+// applyOptions builds the modifyFiles options for a run from opts, the files sent to
+// the model (requested) and the read-only context files.
+func applyOptions(opts Options, requested, readOnly []string) modifyFiles.Options {
+	return modifyFiles.Options{
+		LineEnding: opts.LineEnding,
+		Requested:  requested,
+		Only:       opts.Only,
+		AllowNew:   opts.AllowNew,
+		ReadOnly:   readOnly,
+
+		AllowedExts:    opts.AllowedExts,
+		Gofmt:          opts.Gofmt,
+		Fuzzy:          opts.Fuzzy,
+		DiffEngine:     opts.DiffEngine,
+		PreserveIndent: opts.PreserveIndent,
+		NormalizeEOL:   opts.NormalizeEOL,
+		TrimTrailing:   opts.TrimTrailing,
+		Markers:        utils.NewMarkers(opts.MarkerNonce),
+		LengthHints:    opts.LengthHints,
+		Base64:         opts.StrictTransport,
+		DryRun:         opts.DryRun,
+		DiffOutput:     opts.DiffOutput,
+		DumpTag:        opts.dumpTag,
+	}
+}
+
+// applyResponse applies an AI response in the given format (prompt.FormatDiff,
+// prompt.FormatSearchReplace or, by default, prompt.FormatFullText) to the files on disk.
+// A response recognizably in another format is applied in that one (see responseFormat).
+// Unless applyOpts.DryRun is set, the previous content of the files it changes is saved
+// in an undo manifest, even if the apply fails part way (see Undo); with shared
+// non-nil, it is added to shared instead, to be saved once with the other applies of
+// the invocation. If ctx is canceled part way instead, no further file is written and
+// the files already written are rolled back, so an interrupted apply changes nothing.
+func applyResponse(ctx context.Context, response, format string, applyOpts modifyFiles.Options, shared *undoRecorder) (modifyFiles.ApplyResult, error) {
+	var undo undoRecorder
+	if !applyOpts.DryRun {
+		applyOpts.Backup = undo.backup
+		applyOpts.Context = ctx
+	}
+	var result modifyFiles.ApplyResult
+	var err error
+	switch responseFormat(response, format, applyOpts.Markers) {
+	case prompt.FormatDiff:
+		result, err = modifyFiles.ApplyChangesToFiles(response, applyOpts) // Applies a unified diff
+	case prompt.FormatSearchReplace:
+		result, err = modifyFiles.ApplySnippetChangesToFiles(response, applyOpts) // Applies search/replace blocks
+	default:
+		result, err = modifyFiles.ApplyFullTextChangesToFiles(response, applyOpts) // Applies full text content
+	}
+	if applyOpts.DryRun {
+		return result, err
+	}
+	if err != nil && ctx.Err() != nil {
+		undo.rollback()
+		return modifyFiles.ApplyResult{}, err
+	}
+	if shared != nil {
+		shared.merge(&undo)
+	} else {
+		undo.save()
+	}
+	return result, err
+}
+
+// Replay applies a raw AI response saved by an earlier run (ai_raw_output_*.txt, or
+// *.txt.gz if compressed) to the current files, without contacting an AI endpoint. The files are collected from opts
+// as in Run, so relative paths and the requested-file checks behave the same, and
+// opts.Format selects the applier. A rawOutputPath of "-" reads the response from
+// opts.Input (os.Stdin if nil). Canceling ctx interrupts the apply as in Run.
+// Returned errors are tagged with ErrConfig or ErrApply.
+func Replay(ctx context.Context, rawOutputPath string, opts Options) error {
+	logging.V(0).Infof("Replaying the AI response saved in %q (format %q).", rawOutputPath, opts.Format)
+	response, err := readInputFile(rawOutputPath, opts.Input)
+	if err != nil {
+		logging.Errorf("Failed to read saved AI response %q: %v", rawOutputPath, err)
+		return categorize(ErrConfig, fmt.Errorf("failed to read saved AI response: %w", err))
+	}
+	fileContents, err := readFiles(ctx, opts)
+	if err != nil {
+		logging.Errorf("Failed to read files (list %q, files %q): %v", opts.FileListPath, opts.Files, err)
+		return categorize(ErrConfig, fmt.Errorf("failed to read files: %w", err))
+	}
+
+	result, err := applyResponse(ctx, string(response), opts.Format, applyOptions(opts, sortedPaths(fileContents), opts.ContextFiles), nil)
+	if len(result.DiffStats) > 0 {
+		logging.V(0).Info(result.DiffSummary())
+	}
+	if err != nil {
+		logging.Errorf("Failed to apply saved AI response %q: %v", rawOutputPath, err)
+		return categorize(ErrApply, fmt.Errorf("failed to apply saved AI response: %w", err))
+	}
+	logging.V(0).Infof("Saved AI response %q applied successfully.", rawOutputPath)
+	return nil
+}
+
+// ApplyPatchFile applies the unified diff saved at patchPath to the files on disk,
+// without contacting an AI endpoint. A patchPath of "-" reads the diff from opts.Input
+// (os.Stdin if nil), e.g. piped from git diff. opts.LineEnding, opts.Only,
+// opts.ContextFiles (as read-only files) and opts.AllowedExts are honored like in Run,
+// and canceling ctx interrupts the apply as in Run.
+// Returned errors are tagged with ErrConfig or ErrApply.
+func ApplyPatchFile(ctx context.Context, patchPath string, opts Options) error {
+	logging.V(0).Infof("Applying the patch %q without contacting the AI.", patchPath)
+	patch, err := readInputFile(patchPath, opts.Input)
+	if err != nil {
+		logging.Errorf("Failed to read patch %q: %v", patchPath, err)
+		return categorize(ErrConfig, fmt.Errorf("failed to read patch: %w", err))
+	}
+
+	result, err := applyResponse(ctx, string(patch), prompt.FormatDiff, applyOptions(opts, nil, opts.ContextFiles), nil)
+	if len(result.DiffStats) > 0 {
+		logging.V(0).Info(result.DiffSummary())
+	}
+	logFuzzy(result)
+	if err != nil {
+		logging.Errorf("Failed to apply patch %q: %v", patchPath, err)
+		return categorize(ErrApply, fmt.Errorf("failed to apply patch %q: %w", patchPath, err))
+	}
+	logging.V(0).Infof("Patch %q applied successfully.", patchPath)
+	return nil
+}
+
+// ApplyFullTextFile applies a full-text response saved at responsePath (BEGIN/END file
+// blocks, such as an ai_raw_output_*.txt file) to the files on disk, without contacting
+// an AI endpoint. Unlike Replay it needs no file list: every block is written, subject to
+// opts.Only, opts.ContextFiles (as read-only files), opts.AllowedExts, opts.Gofmt and
+// opts.MarkerNonce as in Run. A responsePath of "-" reads the response from opts.Input
+// (os.Stdin if nil). Canceling ctx interrupts the apply as in Run.
+// Returned errors are tagged with ErrConfig or ErrApply.
+func ApplyFullTextFile(ctx context.Context, responsePath string, opts Options) error {
+	logging.V(0).Infof("Applying the full-text response %q without contacting the AI.", responsePath)
+	response, err := readInputFile(responsePath, opts.Input)
+	if err != nil {
+		logging.Errorf("Failed to read full-text response %q: %v", responsePath, err)
+		return categorize(ErrConfig, fmt.Errorf("failed to read full-text response: %w", err))
+	}
+
+	result, err := applyResponse(ctx, string(response), prompt.FormatFullText, applyOptions(opts, nil, opts.ContextFiles), nil)
+	for _, gofmtErr := range result.GofmtErrors {
+		logging.Warningf("gofmt failed, file left unformatted: %v", gofmtErr)
+	}
+	if err != nil {
+		logging.Errorf("Failed to apply full-text response %q: %v", responsePath, err)
+		return categorize(ErrApply, fmt.Errorf("failed to apply full-text response %q: %w", responsePath, err))
+	}
+	logging.V(0).Infof("Full-text response %q applied successfully (%d files modified, %d created).", responsePath, len(result.Modified), len(result.Created))
+	return nil
+}
+
+// logFuzzy warns about the files of result that were patched by fuzzy matching (see
+// Options.Fuzzy), since their changes may not land where the diff intended.
+func logFuzzy(result modifyFiles.ApplyResult) {
+	if len(result.Fuzzy) > 0 {
+		logging.Warningf("Patched by fuzzy matching, review these changes: %s", strings.Join(result.Fuzzy, ", "))
+	}
+}
+
+// readInputFile reads the file at path, or all of input (os.Stdin if nil) if path is "-".
+// Gzip-compressed content, such as a dump written with Options.CompressDumps, is
+// decompressed transparently.
+func readInputFile(path string, input io.Reader) ([]byte, error) {
+	var data []byte
+	var err error
+	if path != "-" {
+		data, err = os.ReadFile(path)
+	} else {
+		if input == nil {
+			input = os.Stdin
+		}
+		data, err = io.ReadAll(input)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gunzipIfCompressed(data)
+}
\ No newline at end of file
@@ -0,0 +1,120 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+	"github.com/zicongmei/ai-coder/v2/pkg/prompt"
+)
+
+func TestApplyPatchFile(t *testing.T) {
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(aPath, []byte("one\ntwo\nthree\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", aPath, err)
+	}
+	patchPath := filepath.Join(dir, "changes.diff")
+	patch := "--- a/" + aPath + "\n+++ b/" + aPath + "\n@@ -1,3 +1,3 @@\n one\n-two\n+TWO\n three\n"
+	if err := os.WriteFile(patchPath, []byte(patch), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", patchPath, err)
+	}
+
+	if err := ApplyPatchFile(context.Background(), patchPath, Options{}); err != nil {
+		t.Fatalf("ApplyPatchFile() error = %v", err)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "one\nTWO\nthree\n" {
+		t.Errorf("content of %q = %q, want the patch applied", aPath, got)
+	}
+
+	// Re-applying no longer matches the file.
+	if err := ApplyPatchFile(context.Background(), patchPath, Options{}); !errors.Is(err, ErrApply) {
+		t.Errorf("ApplyPatchFile() on an already patched file error = %v, want an apply error", err)
+	}
+	if err := ApplyPatchFile(context.Background(), filepath.Join(dir, "missing.diff"), Options{}); !errors.Is(err, ErrConfig) {
+		t.Errorf("ApplyPatchFile() on a missing patch error = %v, want a configuration error", err)
+	}
+
+	// "-" reads the patch from the input, as when piping git diff into the tool.
+	revert := "--- a/" + aPath + "\n+++ b/" + aPath + "\n@@ -1,3 +1,3 @@\n one\n-TWO\n+two\n three\n"
+	if err := ApplyPatchFile(context.Background(), "-", Options{Input: strings.NewReader(revert)}); err != nil {
+		t.Fatalf("ApplyPatchFile() from the input error = %v", err)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "one\ntwo\nthree\n" {
+		t.Errorf("content of %q = %q, want the piped patch applied", aPath, got)
+	}
+}
+
+func TestReplay_MatchesFreshRun(t *testing.T) {
+	for _, format := range []string{prompt.FormatFullText, prompt.FormatDiff} {
+		t.Run(format, func(t *testing.T) {
+			freshDir, replayDir := t.TempDir(), t.TempDir()
+			files := map[string]string{"a.txt": "one\ntwo\nthree\n"}
+			freshList := writeFileList(t, freshDir, files)
+			replayList := writeFileList(t, replayDir, files)
+
+			// The response names the files relative to their directory, so it fits both copies.
+			response := fullTextBlock("a.txt", "one\nTWO\nthree\n")
+			if format == prompt.FormatDiff {
+				response = "--- a/a.txt\n+++ b/a.txt\n@@ -1,3 +1,3 @@\n one\n-two\n+TWO\n three\n"
+			}
+			t.Chdir(freshDir)
+			if err := Run(context.Background(), mock.NewClient(response), Options{FileListPath: freshList, Prompt: "Shout.", Inplace: true, Format: format}); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			rawPath := filepath.Join(t.TempDir(), "ai_raw_output.txt")
+			if err := os.WriteFile(rawPath, []byte(response), 0644); err != nil {
+				t.Fatalf("Failed to write %q: %v", rawPath, err)
+			}
+			t.Chdir(replayDir)
+			if err := Replay(context.Background(), rawPath, Options{FileListPath: replayList, Format: format}); err != nil {
+				t.Fatalf("Replay() error = %v", err)
+			}
+
+			fresh, _ := os.ReadFile(filepath.Join(freshDir, "a.txt"))
+			replayed, _ := os.ReadFile(filepath.Join(replayDir, "a.txt"))
+			if string(fresh) != "one\nTWO\nthree\n" || string(replayed) != string(fresh) {
+				t.Errorf("replayed content = %q, fresh run content = %q, want both %q", replayed, fresh, "one\nTWO\nthree\n")
+			}
+		})
+	}
+}
+
+func TestApplyFullTextFile(t *testing.T) {
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.txt")
+	apiPath := filepath.Join(dir, "api.txt")
+	newPath := filepath.Join(dir, "new.txt")
+	for _, path := range []string{aPath, apiPath} {
+		if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+	}
+	responsePath := filepath.Join(dir, "ai_raw_output.txt")
+	response := fullTextBlock(aPath, "new a\n") + fullTextBlock(apiPath, "new api\n") + fullTextBlock(newPath, "created\n")
+	if err := os.WriteFile(responsePath, []byte(response), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", responsePath, err)
+	}
+
+	// No file list is needed: every block is written except the read-only context file.
+	if err := ApplyFullTextFile(context.Background(), responsePath, Options{ContextFiles: []string{apiPath}}); err != nil {
+		t.Fatalf("ApplyFullTextFile() error = %v", err)
+	}
+	for path, want := range map[string]string{aPath: "new a\n", apiPath: "old\n", newPath: "created\n"} {
+		if got, _ := os.ReadFile(path); string(got) != want {
+			t.Errorf("content of %q = %q, want %q", path, got, want)
+		}
+	}
+
+	if err := ApplyFullTextFile(context.Background(), "-", Options{Input: strings.NewReader("no file blocks here")}); !errors.Is(err, ErrApply) {
+		t.Errorf("ApplyFullTextFile() on a malformed response error = %v, want an apply error", err)
+	}
+	if err := ApplyFullTextFile(context.Background(), filepath.Join(dir, "missing.txt"), Options{}); !errors.Is(err, ErrConfig) {
+		t.Errorf("ApplyFullTextFile() on a missing response error = %v, want a configuration error", err)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,53 @@
+package flow
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// MaxAttachmentSize is the largest attachment (in bytes) readAttachments accepts.
+// Attachments are sent inline with the request, which the endpoints limit to about 20MB.
+const MaxAttachmentSize int64 = 20 * 1024 * 1024 // 20MB
+
+// readAttachments reads the files at paths as inline attachments, detecting their MIME
+// type from the file extension, or from the content if the extension is unknown.
+// A file larger than MaxAttachmentSize is an error.
+func readAttachments(paths []string) ([]aiEndpoint.Attachment, error) {
+	var attachments []aiEndpoint.Attachment
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat attachment %q: %w", path, err)
+		}
+		if info.Size() > MaxAttachmentSize {
+			return nil, fmt.Errorf("attachment %q is %d bytes, over the limit of %d bytes", path, info.Size(), MaxAttachmentSize)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read attachment %q: %w", path, err)
+		}
+		mimeType := detectMIMEType(path, data)
+		logging.V(1).Infof("Attaching %q as %s (%d bytes).", path, mimeType, len(data))
+		attachments = append(attachments, aiEndpoint.Attachment{Name: path, MIMEType: mimeType, Data: data})
+	}
+	return attachments, nil
+}
+
+// detectMIMEType returns the MIME type of a file without parameters such as charset.
+func detectMIMEType(path string, data []byte) string {
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(path)))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+		return mediaType
+	}
+	return mimeType
+}
\ No newline at end of file
@@ -0,0 +1,60 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+)
+
+func TestRun_Attachments(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+	aPath := filepath.Join(dir, "a.txt")
+	pngPath := filepath.Join(dir, "screenshot.png")
+	pngData := []byte("\x89PNG\r\n\x1a\nfake image")
+	if err := os.WriteFile(pngPath, pngData, 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", pngPath, err)
+	}
+
+	engine := mock.NewClient(fullTextBlock(aPath, "new\n"))
+	if err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Match the screenshot.", Inplace: true, Attachments: []string{pngPath}}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	histories := engine.Histories()
+	if len(histories) != 1 || len(histories[0]) != 1 {
+		t.Fatalf("engine received conversations %+v, want one single-message conversation", histories)
+	}
+	attachments := histories[0][0].Attachments
+	if len(attachments) != 1 {
+		t.Fatalf("message has %d attachments, want 1", len(attachments))
+	}
+	if attachments[0].MIMEType != "image/png" || string(attachments[0].Data) != string(pngData) {
+		t.Errorf("attachment = %s with %q, want image/png with the file content", attachments[0].MIMEType, attachments[0].Data)
+	}
+}
+
+func TestReadAttachments_TooLarge(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+	path := filepath.Join(dir, "spec.pdf")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", path, err)
+	}
+	if err := os.Truncate(path, MaxAttachmentSize+1); err != nil {
+		t.Fatalf("Failed to grow %q: %v", path, err)
+	}
+
+	_, err := readAttachments([]string{path})
+	if err == nil || !strings.Contains(err.Error(), "over the limit") {
+		t.Errorf("readAttachments() error = %v, want a size limit error", err)
+	}
+	if err := Run(context.Background(), mock.NewClient(""), Options{FileListPath: listPath, Prompt: "Read.", Attachments: []string{path}}); !errors.Is(err, ErrConfig) {
+		t.Errorf("Run() error = %v, want a configuration error", err)
+	}
+}
\ No newline at end of file
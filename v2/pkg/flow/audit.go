@@ -0,0 +1,58 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// AuditRecord is one line of the JSONL audit log appended to Options.AuditLog at the
+// end of each run, for reproducing and debugging interactions with the AI. Unlike the
+// prompt and response dumps in the temporary directory, it keeps no content, only a
+// summary.
+type AuditRecord struct {
+	Time          time.Time `json:"time"`            // When the run started
+	Model         string    `json:"model"`           // Model the prompts were sent to
+	InputTokens   int       `json:"input_tokens"`    // Token count of the first prompt, 0 if unknown
+	ResponseBytes int       `json:"response_bytes"`  // Total length of all AI responses of the run
+	DurationMS    int64     `json:"duration_ms"`     // Wall-clock duration of the run in milliseconds
+	Success       bool      `json:"success"`         // Whether the run completed without error
+	Error         string    `json:"error,omitempty"` // Why the run failed, empty on success
+}
+
+// newAuditRecord builds the audit record of a run that started at start, from its
+// stats and the error it returned.
+func newAuditRecord(start time.Time, model string, stats Stats, err error) AuditRecord {
+	record := AuditRecord{
+		Time:          start.UTC(),
+		Model:         model,
+		InputTokens:   stats.InputTokens,
+		ResponseBytes: stats.ResponseBytes,
+		DurationMS:    stats.Elapsed.Milliseconds(),
+		Success:       err == nil,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	return record
+}
+
+// appendAuditRecord appends record as a single JSON line to the file at path, creating
+// it if needed. The line is written with one call so that concurrent runs sharing the
+// log do not interleave their records.
+func appendAuditRecord(path string, record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode the audit record: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open the audit log: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write the audit log: %w", err)
+	}
+	return f.Close()
+}
\ No newline at end of file
@@ -0,0 +1,48 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+)
+
+func TestRun_AuditLog(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"main.go": "package main\n"})
+	auditPath := filepath.Join(dir, "audit.jsonl")
+	opts := Options{FileListPath: listPath, Prompt: "Explain.", OutPath: filepath.Join(dir, "out.txt"), NoOpen: true, AuditLog: auditPath}
+
+	if err := Run(context.Background(), mock.NewClient("It is empty."), opts); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if err := Run(context.Background(), &mock.Client{Err: errors.New("boom")}, opts); err == nil {
+		t.Fatal("Run() with a failing engine succeeded, want an error")
+	}
+
+	data, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("Failed to read the audit log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("audit log has %d lines, want one per run:\n%s", len(lines), data)
+	}
+	var records [2]AuditRecord
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &records[i]); err != nil {
+			t.Fatalf("audit log line %q is not JSON: %v", line, err)
+		}
+	}
+	if r := records[0]; !r.Success || r.Error != "" || r.Model != mock.DefaultModelName || r.ResponseBytes != len("It is empty.") || r.InputTokens == 0 || r.Time.IsZero() {
+		t.Errorf("first record = %+v, want a successful run with its model, tokens and response length", r)
+	}
+	if r := records[1]; r.Success || !strings.Contains(r.Error, "boom") {
+		t.Errorf("second record = %+v, want a failed run naming the error", r)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,127 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+// BatchResult is the outcome of the run for one batch of files by RunBatches.
+type BatchResult struct {
+	Files []string
+	Stats Stats
+	Err   error // nil if the batch's run succeeded
+}
+
+// RunBatches splits the files of opts (its file list, Files and SinceGit, without the
+// Excludes) into batches and runs Run once per batch, with the files of that batch
+// alone, so that a change to many large files is not lost to a response clipped at the
+// output token limit. A batch holds at most maxFiles files and, by the estimate of
+// utils.ApproxTokenCount, at most maxTokens tokens of file content, in file list order;
+// a limit <= 0 is not applied, and a file over maxTokens forms a batch of its own.
+// The batches run one after another, each applied before the next starts, and a failed
+// batch is reported while the others still run. A summary adding up the statistics of
+// all batches is logged at the end. The returned error is nil if every batch succeeded,
+// and otherwise joins the failures in batch order (keeping their categories).
+// Interactive, AutoSelect and JSONResult are not supported.
+func RunBatches(ctx context.Context, aiEngine aiEndpoint.AIEngine, opts Options, maxFiles, maxTokens int) ([]BatchResult, error) {
+	if opts.Interactive || opts.AutoSelect || opts.JSONResult != nil {
+		return nil, categorize(ErrConfig, errors.New("running the files in batches does not support interactive mode, file auto-selection or a JSON result"))
+	}
+	paths, err := listFiles(opts)
+	if err == nil {
+		paths, err = excludePaths(paths, opts.Excludes)
+	}
+	var contents map[string]string
+	if err == nil {
+		contents, err = readPaths(ctx, paths, opts)
+	}
+	if err != nil {
+		logging.Errorf("Failed to read files (list %q, files %q): %v", opts.FileListPath, opts.Files, err)
+		return nil, categorize(ErrConfig, fmt.Errorf("failed to read files: %w", err))
+	}
+	batches := splitBatches(paths, contents, maxFiles, maxTokens)
+	if len(batches) == 0 {
+		return nil, categorize(ErrConfig, errors.New("no files to process"))
+	}
+	logging.V(0).Infof("Running the prompt on %d files in %d batches.", len(contents), len(batches))
+
+	start := time.Now()
+	var total Stats
+	results := make([]BatchResult, 0, len(batches))
+	var errs []error
+	undo := &undoRecorder{}
+	for i, batch := range batches {
+		logging.V(0).Infof("Running batch %d/%d (%d files).", i+1, len(batches), len(batch))
+		batchOpts := opts
+		batchOpts.FileListPath = ""
+		batchOpts.Files = batch
+		batchOpts.SinceGit = ""
+		batchOpts.dumpTag = fmt.Sprintf("_batch%d", i+1)
+		batchOpts.undo = undo
+
+		stats, err := runWithStats(ctx, aiEngine, batchOpts)
+		results = append(results, BatchResult{Files: batch, Stats: stats, Err: err})
+		total.FilesRead += stats.FilesRead
+		total.InputTokens += stats.InputTokens
+		total.ResponseBytes += stats.ResponseBytes
+		total.FilesModified += stats.FilesModified
+		total.FilesCreated += stats.FilesCreated
+		total.FilesDeleted += stats.FilesDeleted
+		if err != nil {
+			logging.Errorf("Batch %d/%d failed: %v", i+1, len(batches), err)
+			logging.ErrorEvent("batch_failed", err, map[string]interface{}{"batch": i + 1, "files": batch})
+			errs = append(errs, fmt.Errorf("batch %d: %w", i+1, err))
+			if ctx.Err() != nil {
+				break // The remaining batches would only fail the same way
+			}
+			continue
+		}
+		logging.V(0).Infof("Batch %d/%d succeeded.", i+1, len(batches))
+		logging.Event("batch_completed", map[string]interface{}{"batch": i + 1, "files": batch})
+	}
+	total.Elapsed = time.Since(start)
+	undo.save()
+
+	logging.V(0).Infof("%d of %d batches succeeded.", len(results)-len(errs), len(batches))
+	total.log()
+	if len(errs) > 0 {
+		return results, fmt.Errorf("%d of %d batches failed: %w", len(errs), len(batches), errors.Join(errs...))
+	}
+	return results, nil
+}
+
+// splitBatches groups the paths that have contents, in order, into batches of at most
+// maxFiles paths whose contents add up to at most maxTokens estimated tokens. A limit
+// <= 0 is not applied, and a single path over maxTokens forms a batch of its own.
+func splitBatches(paths []string, contents map[string]string, maxFiles, maxTokens int) [][]string {
+	var batches [][]string
+	var batch []string
+	batchTokens := 0
+	for _, path := range paths {
+		content, ok := contents[path]
+		if !ok {
+			continue // Skipped when reading, e.g. oversized or missing
+		}
+		tokens := utils.ApproxTokenCount(content)
+		full := maxFiles > 0 && len(batch) >= maxFiles
+		if maxTokens > 0 && len(batch) > 0 && batchTokens+tokens > maxTokens {
+			full = true
+		}
+		if full {
+			batches = append(batches, batch)
+			batch, batchTokens = nil, 0
+		}
+		batch = append(batch, path)
+		batchTokens += tokens
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
\ No newline at end of file
@@ -0,0 +1,85 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+)
+
+func TestSplitBatches(t *testing.T) {
+	paths := []string{"a", "b", "c", "d", "skipped"}
+	contents := map[string]string{
+		"a": strings.Repeat("x", 40), // 10 tokens
+		"b": strings.Repeat("x", 40),
+		"c": strings.Repeat("x", 200), // 50 tokens
+		"d": strings.Repeat("x", 40),
+	}
+	tests := []struct {
+		name      string
+		maxFiles  int
+		maxTokens int
+		want      [][]string
+	}{
+		{name: "No limits", want: [][]string{{"a", "b", "c", "d"}}},
+		{name: "File limit", maxFiles: 3, want: [][]string{{"a", "b", "c"}, {"d"}}},
+		{name: "Token limit", maxTokens: 25, want: [][]string{{"a", "b"}, {"c"}, {"d"}}},
+		{name: "Both limits", maxFiles: 1, maxTokens: 100, want: [][]string{{"a"}, {"b"}, {"c"}, {"d"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitBatches(paths, contents, tt.maxFiles, tt.maxTokens); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitBatches(%d files, %d tokens) = %q, want %q", tt.maxFiles, tt.maxTokens, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunBatches(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	absContents := make(map[string]string)
+	for _, name := range []string{"a.go", "b.go", "c.go"} {
+		path, content := filepath.Join(dir, name), "package "+strings.TrimSuffix(name, ".go")+"\n"
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+		paths = append(paths, path)
+		absContents[path] = content
+	}
+	cPath := paths[2]
+
+	// The batch holding c.go gets a malformed response; the other one is still applied.
+	engine := &headerClient{Client: &mock.Client{}, contents: absContents, fail: map[string]bool{cPath: true}}
+	results, err := RunBatches(context.Background(), engine, Options{Files: paths, Prompt: "Add a license header.", Inplace: true}, 2, 0)
+	if !errors.Is(err, ErrApply) || !strings.Contains(err.Error(), "1 of 2 batches failed") {
+		t.Fatalf("RunBatches() error = %v, want one failed batch with ErrApply", err)
+	}
+	if len(results) != 2 || len(results[0].Files) != 2 || len(results[1].Files) != 1 || results[1].Files[0] != cPath {
+		t.Fatalf("RunBatches() results = %+v, want batches of 2 files and of %q", results, cPath)
+	}
+	if results[0].Err != nil || results[0].Stats.FilesModified != 2 || results[1].Err == nil {
+		t.Errorf("RunBatches() results = %+v, want the first batch to modify 2 files and the second to fail", results)
+	}
+	for path, content := range absContents {
+		want := "// License\n" + content
+		if path == cPath {
+			want = content
+		}
+		if got, _ := os.ReadFile(path); string(got) != want {
+			t.Errorf("content of %q = %q, want %q", path, got, want)
+		}
+	}
+	if prompts := engine.Prompts(); len(prompts) != 2 {
+		t.Errorf("engine received %d prompts, want one per batch", len(prompts))
+	}
+
+	if _, err := RunBatches(context.Background(), engine, Options{Files: paths, Prompt: "Update.", Interactive: true}, 2, 0); !errors.Is(err, ErrConfig) {
+		t.Errorf("RunBatches() in interactive mode error = %v, want ErrConfig", err)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,34 @@
+package flow
+
+import (
+	"context"
+	"io"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+	"github.com/zicongmei/ai-coder/v2/pkg/modifyFiles"
+)
+
+// pickCandidate returns the first of candidates that applies cleanly in the given format
+// with applyOpts, checked with a dry run whose diffs are discarded, so a malformed
+// candidate does not cost a retry. If none applies, the first is returned and the usual
+// repair and retry handling takes over.
+func pickCandidate(candidates []string, format string, applyOpts modifyFiles.Options) string {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	check := applyOpts
+	check.DryRun = true
+	check.DiffOutput = io.Discard
+	for i, candidate := range candidates {
+		logging.V(0).Infof("Checking response candidate %d of %d with a dry run.", i+1, len(candidates))
+		if _, err := applyResponse(context.Background(), candidate, format, check, nil); err != nil {
+			logging.Warningf("Response candidate %d of %d does not apply: %v", i+1, len(candidates), err)
+			continue
+		}
+		logging.V(0).Infof("Using response candidate %d of %d.", i+1, len(candidates))
+		logging.Event("candidate_selected", map[string]interface{}{"candidate": i + 1, "candidates": len(candidates)})
+		return candidate
+	}
+	logging.Warningf("None of the %d response candidates applies cleanly; using the first.", len(candidates))
+	return candidates[0]
+}
\ No newline at end of file
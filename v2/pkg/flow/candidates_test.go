@@ -0,0 +1,47 @@
+package flow
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+)
+
+func TestRun_Candidates(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+	aPath := filepath.Join(dir, "a.txt")
+
+	engine := mock.NewClient("unused")
+	engine.Candidates = [][]string{{"Sure! Here is the change, without any markers.", fullTextBlock(aPath, "new\n"), fullTextBlock(aPath, "other\n")}}
+	if err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, Candidates: 3}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "new\n" {
+		t.Errorf("content = %q, want the first candidate that applies", got)
+	}
+	if prompts := engine.Prompts(); len(prompts) != 1 {
+		t.Errorf("sent %d prompts, want 1: the malformed candidate must not cause a retry", len(prompts))
+	}
+}
+
+func TestRun_CandidatesNoneApplies(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+	aPath := filepath.Join(dir, "a.txt")
+
+	// Without a usable candidate, the first is used and the parse failure handling applies.
+	engine := mock.NewClient(fullTextBlock(aPath, "retried\n"))
+	engine.Candidates = [][]string{{"garbage", "more garbage"}}
+	if err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, Candidates: 2, RetryOnParseFail: 1}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "retried\n" {
+		t.Errorf("content = %q, want the retried response", got)
+	}
+	if prompts := engine.Prompts(); len(prompts) != 2 {
+		t.Errorf("sent %d prompts, want 2", len(prompts))
+	}
+}
\ No newline at end of file
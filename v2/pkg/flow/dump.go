@@ -0,0 +1,67 @@
+package flow
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+// gzipExt is appended to the name of dumps written with Options.CompressDumps.
+const gzipExt = ".gz"
+
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// dumpExt returns the extension of the prompt, response and transcript dumps.
+func dumpExt(compress bool) string {
+	if compress {
+		return ".txt" + gzipExt
+	}
+	return ".txt"
+}
+
+// dumpPathWithSuffix inserts suffix before the extension of a dump path, e.g.
+// "ai_raw_output_X.txt.gz" becomes "ai_raw_output_X_turn2.txt.gz".
+func dumpPathWithSuffix(path, suffix string) string {
+	base := strings.TrimSuffix(path, gzipExt)
+	compressed := len(base) < len(path)
+	return strings.TrimSuffix(base, ".txt") + suffix + dumpExt(compressed)
+}
+
+// writeDump writes data to path, gzip-compressed if path ends in gzipExt.
+func writeDump(path string, data []byte) error {
+	if strings.HasSuffix(path, gzipExt) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return fmt.Errorf("failed to compress %q: %w", path, err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("failed to compress %q: %w", path, err)
+		}
+		data = buf.Bytes()
+	}
+	return utils.WriteFileAtomic(path, data, 0644)
+}
+
+// gunzipIfCompressed returns data decompressed if it is a gzip stream, such as a dump
+// written with Options.CompressDumps, and unchanged otherwise.
+func gunzipIfCompressed(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, gzipMagic) {
+		return data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress: %w", err)
+	}
+	defer zr.Close()
+	decompressed, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress: %w", err)
+	}
+	return decompressed, nil
+}
\ No newline at end of file
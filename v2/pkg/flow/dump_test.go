@@ -0,0 +1,64 @@
+package flow
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+)
+
+func TestDumpPathWithSuffix(t *testing.T) {
+	tests := map[string]string{
+		"/tmp/ai_raw_output_1.txt":    "/tmp/ai_raw_output_1_turn2.txt",
+		"/tmp/ai_raw_output_1.txt.gz": "/tmp/ai_raw_output_1_turn2.txt.gz",
+	}
+	for path, want := range tests {
+		if got := dumpPathWithSuffix(path, "_turn2"); got != want {
+			t.Errorf("dumpPathWithSuffix(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestRun_CompressDumps(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", filepath.Join(dir, "tmp"))
+	if err := os.Mkdir(os.Getenv("TMPDIR"), 0755); err != nil {
+		t.Fatalf("Failed to create the temp directory: %v", err)
+	}
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+	aPath := filepath.Join(dir, "a.txt")
+
+	response := fullTextBlock(aPath, "new\n")
+	if err := Run(context.Background(), mock.NewClient(response), Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, CompressDumps: true}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	for _, pattern := range []string{"ai_prompt_*.txt.gz", "ai_raw_output_*.txt.gz"} {
+		if matches, _ := filepath.Glob(filepath.Join(os.Getenv("TMPDIR"), pattern)); len(matches) != 1 {
+			t.Errorf("found %d dumps matching %q, want 1", len(matches), pattern)
+		}
+	}
+	dumps, _ := filepath.Glob(filepath.Join(os.Getenv("TMPDIR"), "ai_raw_output_*.txt.gz"))
+	if len(dumps) == 0 {
+		t.Fatal("no compressed response dump was written")
+	}
+
+	// The compressed dump reads back as the response and can be replayed.
+	data, err := readInputFile(dumps[0], nil)
+	if err != nil {
+		t.Fatalf("readInputFile(%q) error = %v", dumps[0], err)
+	}
+	if string(data) != response {
+		t.Errorf("readInputFile(%q) = %q, want %q", dumps[0], data, response)
+	}
+	if err := os.WriteFile(aPath, []byte("old\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", aPath, err)
+	}
+	if err := Replay(context.Background(), dumps[0], Options{FileListPath: listPath, Inplace: true}); err != nil {
+		t.Fatalf("Replay(context.Background(), %q) error = %v", dumps[0], err)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "new\n" {
+		t.Errorf("content of %q = %q, want %q", aPath, got, "new\n")
+	}
+}
\ No newline at end of file
@@ -0,0 +1,36 @@
+package flow
+
+import "errors"
+
+// Error categories of the errors returned by Run, checkable with errors.Is.
+// They let callers such as the CLI react to a failure without parsing messages.
+var (
+	ErrConfig = errors.New("configuration error")     // Invalid input, e.g. an unreadable file list
+	ErrAI     = errors.New("AI endpoint error")       // The AI endpoint failed or could not be reached
+	ErrApply  = errors.New("failed to apply changes") // The response could not be parsed or written
+)
+
+// categorizedError attaches one of the error categories to an error
+// without changing its message.
+type categorizedError struct {
+	category error
+	err      error
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Unwrap() []error {
+	return []error{e.category, e.err}
+}
+
+// categorize marks err as belonging to category.
+func categorize(category, err error) error {
+	return &categorizedError{category: category, err: err}
+}
+
+// ErrTotalDuration reports that a run was cut short by Options.MaxTotalDuration. It is
+// wrapped, together with the error of the step that was cut short, which keeps its
+// category.
+var ErrTotalDuration = errors.New("maximum total duration exceeded")
\ No newline at end of file
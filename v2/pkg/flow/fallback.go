@@ -0,0 +1,122 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// fallbackEngine sends requests to primary until it fails with an error worth retrying
+// on another model (see shouldFallBack), re-sends that request unchanged to fallback,
+// and from then on uses fallback only, so an overloaded model is not asked again for
+// every retry or turn. Token counts are not failed over: they fall back to an estimate.
+type fallbackEngine struct {
+	primary, fallback aiEndpoint.AIEngine
+
+	mu       sync.Mutex
+	switched bool
+}
+
+// Ensure fallbackEngine satisfies the CandidateEngine interface.
+var _ aiEndpoint.CandidateEngine = (*fallbackEngine)(nil)
+
+// withFallback returns aiEngine, failing over to fallback as described for
+// fallbackEngine, or aiEngine itself if fallback is nil.
+func withFallback(aiEngine, fallback aiEndpoint.AIEngine) aiEndpoint.AIEngine {
+	if fallback == nil {
+		return aiEngine
+	}
+	return &fallbackEngine{primary: aiEngine, fallback: fallback}
+}
+
+// shouldFallBack reports whether err means the model is unable to serve requests for
+// now, so another model may do better: an exhausted quota or an overloaded endpoint.
+func shouldFallBack(err error) bool {
+	return errors.Is(err, aiEndpoint.ErrQuota) || errors.Is(err, aiEndpoint.ErrOverloaded)
+}
+
+// current returns the engine requests are sent to.
+func (e *fallbackEngine) current() aiEndpoint.AIEngine {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.switched {
+		return e.fallback
+	}
+	return e.primary
+}
+
+// switchOver makes fallback the engine for all further requests after primary failed
+// with err, and reports whether the failed request should be re-sent to it.
+func (e *fallbackEngine) switchOver(engine aiEndpoint.AIEngine, err error) bool {
+	if engine != e.primary || !shouldFallBack(err) {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.switched {
+		e.switched = true
+		logging.Warningf("Model %q failed (%v); switching to the fallback model %q.", e.primary.ModelName(), err, e.fallback.ModelName())
+		logging.Event("model_fallback", map[string]interface{}{"from": e.primary.ModelName(), "to": e.fallback.ModelName(), "error": err.Error()})
+	}
+	return true
+}
+
+func (e *fallbackEngine) SendPrompt(ctx context.Context, prompt string) (string, error) {
+	engine := e.current()
+	response, err := engine.SendPrompt(ctx, prompt)
+	if err != nil && e.switchOver(engine, err) {
+		return e.fallback.SendPrompt(ctx, prompt)
+	}
+	return response, err
+}
+
+func (e *fallbackEngine) SendConversation(ctx context.Context, history []aiEndpoint.Message) (string, error) {
+	engine := e.current()
+	response, err := engine.SendConversation(ctx, history)
+	if err != nil && e.switchOver(engine, err) {
+		return e.fallback.SendConversation(ctx, history)
+	}
+	return response, err
+}
+
+// SendConversationCandidates requests the candidates of the current engine, or its only
+// reply if it is not an aiEndpoint.CandidateEngine.
+func (e *fallbackEngine) SendConversationCandidates(ctx context.Context, history []aiEndpoint.Message) ([]string, error) {
+	send := func(engine aiEndpoint.AIEngine) ([]string, error) {
+		if candidateEngine, ok := engine.(aiEndpoint.CandidateEngine); ok {
+			return candidateEngine.SendConversationCandidates(ctx, history)
+		}
+		response, err := engine.SendConversation(ctx, history)
+		if err != nil && !errors.Is(err, aiEndpoint.ErrTruncated) {
+			return nil, err
+		}
+		return []string{response}, err
+	}
+	engine := e.current()
+	candidates, err := send(engine)
+	if err != nil && e.switchOver(engine, err) {
+		return send(e.fallback)
+	}
+	return candidates, err
+}
+
+func (e *fallbackEngine) CountTokens(ctx context.Context, prompt string) (int, error) {
+	return e.current().CountTokens(ctx, prompt)
+}
+
+// AuthHint returns the hint of the engine requests are currently sent to, or "" if it
+// has none.
+func (e *fallbackEngine) AuthHint() string {
+	if hinter, ok := e.current().(aiEndpoint.AuthHinter); ok {
+		return hinter.AuthHint()
+	}
+	return ""
+}
+
+// ModelName returns the model name of the engine requests are currently sent to.
+func (e *fallbackEngine) ModelName() string {
+	return e.current().ModelName()
+}
\ No newline at end of file
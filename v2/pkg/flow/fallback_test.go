@@ -0,0 +1,54 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+)
+
+func TestRun_Fallback(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+	aPath := filepath.Join(dir, "a.txt")
+
+	primary := &mock.Client{Model: "primary", Err: fmt.Errorf("failed to generate content: %w: HTTP 503", aiEndpoint.ErrOverloaded)}
+	fallback := &mock.Client{Model: "fallback", Responses: []string{"garbage", fullTextBlock(aPath, "new\n")}}
+	err := Run(context.Background(), primary, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, RetryOnParseFail: 1, Fallback: fallback})
+	if err != nil {
+		t.Fatalf("Run() with a fallback error = %v", err)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "new\n" {
+		t.Errorf("content of %q = %q, want %q", aPath, got, "new\n")
+	}
+
+	// The fallback gets the original prompt, and serves the retry without the primary
+	// being asked again.
+	primaryPrompts, fallbackPrompts := primary.Prompts(), fallback.Prompts()
+	if len(primaryPrompts) != 1 || len(fallbackPrompts) != 2 {
+		t.Fatalf("primary and fallback received %d and %d prompts, want 1 and 2", len(primaryPrompts), len(fallbackPrompts))
+	}
+	if fallbackPrompts[0] != primaryPrompts[0] {
+		t.Error("the fallback did not receive the prompt sent to the primary")
+	}
+}
+
+func TestRun_FallbackOnlyForOverload(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+
+	authErr := fmt.Errorf("failed to generate content: %w: HTTP 401", aiEndpoint.ErrAuth)
+	fallback := mock.NewClient("unused")
+	err := Run(context.Background(), &mock.Client{Err: authErr}, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, Fallback: fallback})
+	if !errors.Is(err, aiEndpoint.ErrAuth) {
+		t.Errorf("Run() error = %v, want it to wrap %v", err, aiEndpoint.ErrAuth)
+	}
+	if prompts := fallback.Prompts(); len(prompts) != 0 {
+		t.Errorf("fallback received %d prompts after an authentication failure, want none", len(prompts))
+	}
+}
\ No newline at end of file
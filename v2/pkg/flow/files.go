@@ -0,0 +1,371 @@
+package flow
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// DefaultMaxFileSize is the default per-file size limit (in bytes) applied by readFiles.
+const DefaultMaxFileSize int64 = 1024 * 1024 // 1MB
+
+// truncatedFileMarker is appended to the content of files cut down to the size limit.
+const truncatedFileMarker = "\n... [truncated by ai-coder: file exceeds %d bytes] ...\n"
+
+// readFiles collects the file paths with listFiles
+// and then reads the content of each file, returning a map of file paths to their content.
+// Entries matching any of opts.Excludes are dropped before reading.
+// Files larger than opts.MaxFileSize bytes are skipped with a warning, or truncated with a
+// marker when opts.TruncateOversized is set. A MaxFileSize <= 0 disables the limit.
+func readFiles(ctx context.Context, opts Options) (map[string]string, error) {
+	filePaths, err := listFiles(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	filePaths, err = excludePaths(filePaths, opts.Excludes)
+	if err != nil {
+		return nil, err
+	}
+
+	return readPaths(ctx, filePaths, opts)
+}
+
+// readContextFiles reads the read-only reference files in opts.ContextFiles,
+// applying the same size limit as readFiles.
+func readContextFiles(ctx context.Context, opts Options) (map[string]string, error) {
+	if len(opts.ContextFiles) == 0 {
+		return nil, nil
+	}
+	return readPaths(ctx, opts.ContextFiles, opts)
+}
+
+// readPaths reads the content of each path, applying opts.MaxFileSize and opts.TruncateOversized.
+// Missing files are skipped with a warning if opts.SkipMissing is set, and files whose
+// read takes longer than opts.FileReadTimeout are skipped with a warning. The skipped
+// files are listed together once all paths are read. Once ctx is canceled, no further
+// file is read and its error is returned.
+func readPaths(ctx context.Context, filePaths []string, opts Options) (map[string]string, error) {
+	maxFileSize := opts.MaxFileSize
+	truncateOversized := opts.TruncateOversized
+
+	// Read content of each file
+	fileContents := make(map[string]string)
+	var skipped []string
+	for _, path := range filePaths {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		logging.V(2).Infof("Reading content of file: %q", path)
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) && opts.SkipMissing {
+			logging.Warningf("Skipping file %q: it does not exist.", path)
+			skipped = append(skipped, path)
+			continue
+		}
+		if err != nil {
+			logging.Errorf("Failed to stat file %q: %v", path, err)
+			return nil, fmt.Errorf("failed to stat file %q: %w", path, err)
+		}
+		if maxFileSize > 0 && info.Size() > maxFileSize && !truncateOversized {
+			logging.Warningf("Skipping file %q: size %d bytes exceeds the limit of %d bytes.", path, info.Size(), maxFileSize)
+			skipped = append(skipped, path)
+			continue
+		}
+
+		var limit int64 // Read only as much of an oversized file as is kept, plus a byte to find the cut
+		if maxFileSize > 0 && info.Size() > maxFileSize {
+			limit = maxFileSize + 1
+		}
+		contentBytes, err := readFileWithTimeout(path, limit, opts.FileReadTimeout)
+		if errors.Is(err, errReadTimeout) {
+			logging.Warningf("Skipping file %q: %v.", path, err)
+			skipped = append(skipped, path)
+			continue
+		}
+		if err != nil {
+			// Log the error but continue if possible, or decide to fail fast.
+			// For now, fail fast as missing files are critical for prompt generation.
+			logging.Errorf("Failed to read content of file %q: %v", path, err)
+			return nil, fmt.Errorf("failed to read file %q: %w", path, err)
+		}
+		if maxFileSize > 0 && int64(len(contentBytes)) > maxFileSize {
+			logging.Warningf("Truncating file %q: size %d bytes exceeds the limit of %d bytes.", path, info.Size(), maxFileSize)
+			cut := maxFileSize
+			for cut > 0 && !utf8.RuneStart(contentBytes[cut]) {
+				cut-- // Do not split a multi-byte UTF-8 character
+			}
+			contentBytes = append(contentBytes[:cut], fmt.Sprintf(truncatedFileMarker, maxFileSize)...)
+		}
+		fileContents[path] = string(contentBytes)
+		logging.V(3).Infof("Read %d bytes from %q.", len(contentBytes), path)
+	}
+
+	if len(skipped) > 0 {
+		logging.Warningf("Skipped %d of %d files: %s", len(skipped), len(filePaths), strings.Join(skipped, ", "))
+		logging.Event("files_skipped", map[string]interface{}{"paths": skipped})
+	}
+	return fileContents, nil
+}
+
+// errReadTimeout reports that reading a file took longer than Options.FileReadTimeout.
+var errReadTimeout = errors.New("reading the file timed out")
+
+// readFile reads a whole file; tests replace it to simulate slow reads.
+var readFile = os.ReadFile
+
+// readFilePrefix reads at most the first n bytes of the file at path.
+func readFilePrefix(path string, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, n))
+}
+
+// readFileWithTimeout reads the file at path, or only its first limit bytes if limit > 0,
+// giving up with an error wrapping errReadTimeout after timeout, e.g. for a file on a
+// hung network mount. A timeout <= 0 waits indefinitely. A read that timed out is left
+// to finish in the background.
+func readFileWithTimeout(path string, limit int64, timeout time.Duration) ([]byte, error) {
+	read := readFile // The read may outlive the call, so do not look readFile up later
+	if limit > 0 {
+		read = func(path string) ([]byte, error) { return readFilePrefix(path, limit) }
+	}
+	if timeout <= 0 {
+		return read(path)
+	}
+	type result struct {
+		content []byte
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		content, err := read(path)
+		done <- result{content, err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.content, r.err
+	case <-timer.C:
+		return nil, fmt.Errorf("%w after %s", errReadTimeout, timeout)
+	}
+}
+
+// excludePaths drops every path matching one of the glob patterns.
+// A pattern is matched against the path relative to the current working directory
+// and against the base name, so "*_test.go" excludes test files in any directory.
+// As in file list globs, "**" matches any number of directories, e.g. "pkg/**/testdata/*".
+func excludePaths(paths []string, patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		return paths, nil
+	}
+	for _, pattern := range patterns {
+		if err := validateGlob(pattern); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	kept := make([]string, 0, len(paths))
+	for _, path := range paths {
+		relPath := path
+		if filepath.IsAbs(path) {
+			if rel, err := filepath.Rel(cwd, path); err == nil {
+				relPath = rel
+			}
+		}
+		excluded := false
+		for _, pattern := range patterns {
+			// Errors were ruled out by the validation above.
+			if matchGlob(pattern, relPath) || matchGlob(pattern, filepath.Base(path)) {
+				logging.V(1).Infof("Excluding %q (matches pattern %q).", path, pattern)
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			kept = append(kept, path)
+		}
+	}
+	return kept, nil
+}
+
+// listFiles returns the paths named in the file list at opts.FileListPath (if set)
+// followed by opts.Files and, with opts.SinceGit set, the files changed since that git
+// ref (see gitChangedFiles) and the files referenced as "@path" in opts.Prompt (see
+// PromptFileRefs; a missing one is an error), skipping empty lines, comments and
+// duplicates (see parseFileListLine for the file list syntax). Unquoted file list entries containing
+// glob metacharacters are expanded with expandGlob; a glob matching nothing is an error
+// unless opts.SkipMissing is set.
+func listFiles(opts Options) ([]string, error) {
+	filePaths := []string{}
+	seen := make(map[string]bool)
+	add := func(path string) {
+		path = strings.TrimSpace(path)
+		if path != "" && !seen[path] { // Ignore empty lines and repeated paths
+			seen[path] = true
+			filePaths = append(filePaths, path)
+		}
+	}
+
+	if opts.FileListPath != "" {
+		fileListPath := opts.FileListPath
+		logging.V(1).Infof("Reading file list from: %q", fileListPath)
+
+		// A directory opens fine but fails obscurely on the first read, so reject it up front.
+		if info, err := os.Stat(fileListPath); err == nil && info.IsDir() {
+			logging.Errorf("File list %q is a directory, not a file containing paths.", fileListPath)
+			return nil, fmt.Errorf("file list %q is a directory: --file-list expects a file with one path per line; list the directory's files in it or pass them with --file", fileListPath)
+		}
+
+		// Open the file list file
+		file, err := os.Open(fileListPath)
+		if err != nil {
+			logging.Errorf("Failed to open file list %q: %v", fileListPath, err)
+			return nil, fmt.Errorf("failed to open file list: %w", err)
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for lineNum := 1; scanner.Scan(); lineNum++ {
+			path, quoted, err := parseFileListLine(scanner.Text())
+			if err != nil {
+				logging.Errorf("Invalid entry in file list %q at line %d: %v", fileListPath, lineNum, err)
+				return nil, fmt.Errorf("file list %q, line %d: %w", fileListPath, lineNum, err)
+			}
+			if quoted || !hasGlobMeta(path) {
+				add(path)
+				continue
+			}
+			matches, err := expandGlob(path)
+			if err != nil {
+				logging.Errorf("Invalid entry in file list %q at line %d: %v", fileListPath, lineNum, err)
+				return nil, fmt.Errorf("file list %q, line %d: %w", fileListPath, lineNum, err)
+			}
+			if len(matches) == 0 {
+				if !opts.SkipMissing {
+					logging.Errorf("Glob %q in file list %q matches no files.", path, fileListPath)
+					return nil, fmt.Errorf("file list %q, line %d: glob %q matches no files (use --skip-missing to ignore it)", fileListPath, lineNum, path)
+				}
+				logging.Warningf("Skipping glob %q in file list %q: it matches no files.", path, fileListPath)
+			}
+			logging.V(1).Infof("Glob %q matched %d files.", path, len(matches))
+			for _, match := range matches {
+				add(match)
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			logging.Errorf("Error reading file list %q: %v", fileListPath, err)
+			return nil, fmt.Errorf("error reading file list: %w", err)
+		}
+		logging.V(1).Infof("Found %d files in the file list.", len(filePaths))
+	}
+
+	for _, path := range opts.Files {
+		add(path)
+	}
+
+	if opts.SinceGit != "" {
+		changed, err := gitChangedFiles(opts.SinceGit)
+		if err != nil {
+			logging.Errorf("Failed to list the files changed since %q: %v", opts.SinceGit, err)
+			return nil, err
+		}
+		if len(changed) == 0 {
+			logging.Warningf("No files changed since git ref %q.", opts.SinceGit)
+		}
+		for _, path := range changed {
+			add(path)
+		}
+	}
+
+	if refs := PromptFileRefs(opts.Prompt); len(refs) > 0 {
+		if err := checkPromptFileRefs(refs); err != nil {
+			logging.Errorf("Invalid file reference in the prompt: %v", err)
+			return nil, err
+		}
+		logging.V(1).Infof("Adding %d files referenced in the prompt: %q", len(refs), refs)
+		listed := make(map[string]bool, len(filePaths))
+		for _, path := range filePaths {
+			if abs, err := filepath.Abs(path); err == nil {
+				listed[abs] = true
+			}
+		}
+		for _, path := range refs {
+			if abs, err := filepath.Abs(path); err == nil && listed[abs] {
+				continue // Already in the file set under another spelling
+			}
+			add(path)
+		}
+	}
+	return filePaths, nil
+}
+
+// parseFileListLine returns the path named by one line of a file list, or "" for a blank
+// or comment line. A "#" starts a comment at the beginning of the line or after whitespace,
+// so "a#b.go" is still a path. A path may be wrapped in double or single quotes to keep
+// surrounding spaces or a " #"; nothing but a comment may follow the closing quote.
+// quoted reports whether the path was quoted, in which case it is not treated as a glob.
+func parseFileListLine(line string) (path string, quoted bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", false, nil
+	}
+	if quote := line[0]; quote == '"' || quote == '\'' {
+		end := strings.IndexByte(line[1:], quote)
+		if end == -1 {
+			return "", false, fmt.Errorf("unterminated quote in %q", line)
+		}
+		rest := strings.TrimSpace(line[end+2:])
+		if rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", false, fmt.Errorf("unexpected text %q after quoted path", rest)
+		}
+		return line[1 : end+1], true, nil
+	}
+	for i := 1; i < len(line); i++ {
+		if line[i] == '#' && (line[i-1] == ' ' || line[i-1] == '\t') {
+			return strings.TrimSpace(line[:i]), false, nil
+		}
+	}
+	return line, false, nil
+}
+
+// sortedPaths returns the paths (keys) of fileContents in sorted order.
+func sortedPaths(fileContents map[string]string) []string {
+	paths := make([]string, 0, len(fileContents))
+	for path := range fileContents {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// dropContextFiles removes from fileContents the files that are also read-only context
+// files, since a file cannot be both editable and read-only.
+func dropContextFiles(fileContents, contextContents map[string]string) {
+	for path := range contextContents {
+		if _, ok := fileContents[path]; ok {
+			logging.Warningf("File %q is both in the file list and a context file; treating it as read-only.", path)
+			delete(fileContents, path)
+		}
+	}
+}
\ No newline at end of file
@@ -0,0 +1,271 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+)
+
+// writeFileList creates the given files in dir and a file list referencing them,
+// returning the path of the file list.
+func writeFileList(t *testing.T, dir string, files map[string]string) string {
+	t.Helper()
+	var list strings.Builder
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory for %q: %v", path, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+		list.WriteString(path + "\n")
+	}
+	listPath := filepath.Join(dir, "file_list.txt")
+	if err := os.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		t.Fatalf("Failed to write file list: %v", err)
+	}
+	return listPath
+}
+
+func TestReadFiles_MaxFileSize(t *testing.T) {
+	const limit = 16
+	dir := t.TempDir()
+	under := strings.Repeat("a", limit)
+	over := strings.Repeat("b", limit+1)
+	listPath := writeFileList(t, dir, map[string]string{
+		"under.txt": under,
+		"over.txt":  over,
+	})
+	underPath := filepath.Join(dir, "under.txt")
+	overPath := filepath.Join(dir, "over.txt")
+
+	t.Run("Skip oversized", func(t *testing.T) {
+		got, err := readFiles(context.Background(), Options{FileListPath: listPath, MaxFileSize: limit})
+		if err != nil {
+			t.Fatalf("readFiles() error = %v", err)
+		}
+		if got[underPath] != under {
+			t.Errorf("readFiles() content of %q = %q, want %q", underPath, got[underPath], under)
+		}
+		if _, ok := got[overPath]; ok {
+			t.Errorf("readFiles() included %q, which exceeds the limit", overPath)
+		}
+	})
+
+	t.Run("Truncate oversized", func(t *testing.T) {
+		got, err := readFiles(context.Background(), Options{FileListPath: listPath, MaxFileSize: limit, TruncateOversized: true})
+		if err != nil {
+			t.Fatalf("readFiles() error = %v", err)
+		}
+		if got[underPath] != under {
+			t.Errorf("readFiles() content of %q = %q, want %q", underPath, got[underPath], under)
+		}
+		content, ok := got[overPath]
+		if !ok {
+			t.Fatalf("readFiles() dropped %q instead of truncating it", overPath)
+		}
+		if !strings.HasPrefix(content, over[:limit]) || strings.HasPrefix(content, over) {
+			t.Errorf("readFiles() content of %q = %q, want the first %d bytes followed by a marker", overPath, content, limit)
+		}
+		if !strings.Contains(content, "truncated by ai-coder") {
+			t.Errorf("readFiles() content of %q = %q, want a truncation marker", overPath, content)
+		}
+	})
+
+	t.Run("No limit", func(t *testing.T) {
+		got, err := readFiles(context.Background(), Options{FileListPath: listPath})
+		if err != nil {
+			t.Fatalf("readFiles() error = %v", err)
+		}
+		if got[overPath] != over {
+			t.Errorf("readFiles() content of %q = %q, want %q", overPath, got[overPath], over)
+		}
+	})
+
+	t.Run("Truncate at a rune boundary", func(t *testing.T) {
+		runesPath := filepath.Join(dir, "runes.txt")
+		if err := os.WriteFile(runesPath, []byte(strings.Repeat("a", limit-1)+"é and more"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readPaths(context.Background(), []string{runesPath}, Options{MaxFileSize: limit, TruncateOversized: true})
+		if err != nil {
+			t.Fatalf("readPaths() error = %v", err)
+		}
+		if content := got[runesPath]; !utf8.ValidString(content) || !strings.HasPrefix(content, strings.Repeat("a", limit-1)+"\n...") {
+			t.Errorf("readPaths() content of %q = %q, want %d bytes cut before the split rune, then a marker", runesPath, content, limit-1)
+		}
+	})
+
+	t.Run("Not with inplace", func(t *testing.T) {
+		err := Run(context.Background(), mock.NewClient(""), Options{FileListPath: listPath, Prompt: "Edit.", Inplace: true, MaxFileSize: limit, TruncateOversized: true})
+		if !errors.Is(err, ErrConfig) {
+			t.Errorf("Run() with inplace and truncation error = %v, want ErrConfig", err)
+		}
+	})
+}
+
+func TestReadFiles_Exclude(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{
+		"main.go":          "package main\n",
+		"main_test.go":     "package main\n",
+		"pkg/util.go":      "package pkg\n",
+		"pkg/util_test.go": "package pkg\n",
+	})
+
+	got, err := readFiles(context.Background(), Options{FileListPath: listPath, Excludes: []string{"*_test.go"}})
+	if err != nil {
+		t.Fatalf("readFiles() error = %v", err)
+	}
+	for _, name := range []string{"main.go", "pkg/util.go"} {
+		if _, ok := got[filepath.Join(dir, name)]; !ok {
+			t.Errorf("readFiles() omitted %q, which does not match the exclude pattern", name)
+		}
+	}
+	for _, name := range []string{"main_test.go", "pkg/util_test.go"} {
+		if _, ok := got[filepath.Join(dir, name)]; ok {
+			t.Errorf("readFiles() included %q, which matches the exclude pattern", name)
+		}
+	}
+
+	if _, err := readFiles(context.Background(), Options{FileListPath: listPath, Excludes: []string{"[invalid"}}); err == nil {
+		t.Error("readFiles() with an invalid exclude pattern returned no error")
+	}
+}
+
+func TestListFiles_MergesFileListAndFiles(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "a\n"})
+	aPath := filepath.Join(dir, "a.txt")
+	bPath := filepath.Join(dir, "b.txt")
+
+	got, err := listFiles(Options{FileListPath: listPath, Files: []string{bPath, aPath, " "}})
+	if err != nil {
+		t.Fatalf("listFiles() error = %v", err)
+	}
+	if want := []string{aPath, bPath}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listFiles() = %q, want %q", got, want)
+	}
+
+	got, err = listFiles(Options{Files: []string{bPath}})
+	if err != nil {
+		t.Fatalf("listFiles() without a file list error = %v", err)
+	}
+	if want := []string{bPath}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listFiles() without a file list = %q, want %q", got, want)
+	}
+}
+
+func TestListFiles_FileListIsDirectory(t *testing.T) {
+	dir := t.TempDir()
+	_, err := listFiles(Options{FileListPath: dir})
+	if err == nil {
+		t.Fatal("listFiles() with a directory as the file list returned no error")
+	}
+	for _, want := range []string{dir, "is a directory", "--file"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestParseFileListLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    string
+		wantErr bool
+	}{
+		{line: "", want: ""},
+		{line: "   \t", want: ""},
+		{line: "# a comment", want: ""},
+		{line: "  # an indented comment", want: ""},
+		{line: "main.go", want: "main.go"},
+		{line: "  main.go  ", want: "main.go"},
+		{line: "main.go # the entry point", want: "main.go"},
+		{line: "main.go\t# tab before the comment", want: "main.go"},
+		{line: "issue#42.go", want: "issue#42.go"},
+		{line: `"my file.go"`, want: "my file.go"},
+		{line: `'my file.go' # quoted, with a comment`, want: "my file.go"},
+		{line: `" padded.go "`, want: " padded.go "},
+		{line: `"odd #name.go"`, want: "odd #name.go"},
+		{line: `"unterminated.go`, wantErr: true},
+		{line: `"a.go" b.go`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, _, err := parseFileListLine(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFileListLine(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseFileListLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestListFiles_CommentsAndQuotes(t *testing.T) {
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.go")
+	spacedPath := filepath.Join(dir, "my file.go")
+	list := "# Files for the refactoring\n\n" +
+		aPath + "  # main logic\n" +
+		"\n   \n" +
+		`"` + spacedPath + `"` + "\n"
+	listPath := filepath.Join(dir, "file_list.txt")
+	if err := os.WriteFile(listPath, []byte(list), 0644); err != nil {
+		t.Fatalf("Failed to write file list: %v", err)
+	}
+
+	got, err := listFiles(Options{FileListPath: listPath})
+	if err != nil {
+		t.Fatalf("listFiles() error = %v", err)
+	}
+	if want := []string{aPath, spacedPath}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listFiles() = %q, want %q", got, want)
+	}
+
+	if err := os.WriteFile(listPath, []byte(aPath+"\n\"broken\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file list: %v", err)
+	}
+	if _, err := listFiles(Options{FileListPath: listPath}); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("listFiles() error = %v, want it to name line 2", err)
+	}
+}
+
+func TestReadFiles_FileReadTimeout(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"fast.txt": "fast\n", "slow.txt": "slow\n"})
+	slowPath := filepath.Join(dir, "slow.txt")
+
+	// Reads of slow.txt hang until the test is over.
+	release := make(chan struct{})
+	original := readFile
+	t.Cleanup(func() {
+		close(release)
+		readFile = original
+	})
+	readFile = func(path string) ([]byte, error) {
+		if path == slowPath {
+			<-release
+		}
+		return original(path)
+	}
+
+	got, err := readFiles(context.Background(), Options{FileListPath: listPath, FileReadTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("readFiles() error = %v", err)
+	}
+	want := map[string]string{filepath.Join(dir, "fast.txt"): "fast\n"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readFiles() = %q, want only the file that reads in time", got)
+	}
+}
\ No newline at end of file
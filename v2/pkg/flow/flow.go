@@ -2,164 +2,585 @@ package flow
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"time" // Import the time package for timestamps
 
-	"github.com/golang/glog"
-	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/gemini" // Assuming Gemini is the chosen AI engine
-	"github.com/zicongmei/ai-coder/v2/pkg/display"           // Import the display package
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/display" // Import the display package
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
 	"github.com/zicongmei/ai-coder/v2/pkg/modifyFiles"
 	"github.com/zicongmei/ai-coder/v2/pkg/prompt"
 	"github.com/zicongmei/ai-coder/v2/pkg/utils" // For TruncateString
 )
 
-// Run executes the main AI coding flow.
+// Options holds the settings for a single run of the AI coding flow.
+type Options struct {
+	FileListPath      string            // Path to a file containing a list of files to process
+	Files             []string          // Additional files to process, merged after the file list entries
+	SinceGit          string            // Git ref; the files changed since it are processed after Files
+	Prompt            string            // The user prompt to send to the AI
+	Inplace           bool              // Whether to modify the files in place
+	MaxFileSize       int64             // Files larger than this (in bytes) are skipped or truncated; <= 0 disables the limit
+	TruncateOversized bool              // Truncate oversized files with a marker instead of skipping them; not with Inplace
+	FileReadTimeout   time.Duration     // Files taking longer than this to read are skipped; <= 0 disables the limit
+	RetryOnParseFail  int               // Number of times to re-send the prompt when the response cannot be parsed
+	MaxTotalDuration  time.Duration     // Wall-clock budget for the whole run, retries and repairs included; <= 0 disables it (see ErrTotalDuration)
+	AutoSelect        bool              // First ask the model which files are relevant, by path only, and send just those (see selectFiles)
+	RequireTokenCount bool              // Fail instead of estimating when the prompt's tokens cannot be counted (see countPromptTokens)
+	AutoRepair        int               // Number of follow-ups asking the model to reformat a response that cannot be parsed
+	Candidates        int               // With Inplace, above 1 the engine's candidate replies are checked and the first that applies is used
+	Excludes          []string          // Glob patterns; matching file list entries are dropped before reading
+	SkipMissing       bool              // Skip missing files and file list globs that match nothing instead of failing
+	Format            string            // prompt.FormatFullText (default), prompt.FormatDiff or prompt.FormatSearchReplace
+	LineEnding        string            // Line ending for files patched in diff format (see modifyFiles.LineEnding*)
+	CheckStale        string            // What to do if files change on disk while waiting for the AI (see CheckStale*)
+	FileNotes         map[string]string // Optional per-file guidance for the prompt, keyed by file path
+	Stats             bool              // Print an end-of-run summary (see Stats)
+	Only              []string          // If non-empty, only these files are written in place (see modifyFiles.Options.Only)
+	AllowNew          bool              // Let a full-text response write files that were not requested (see modifyFiles.Options.AllowNew)
+	PromptPrefix      string            // Text placed before the user prompt (see prompt.Options.Prefix)
+	PromptSuffix      string            // Text placed after the user prompt (see prompt.Options.Suffix)
+	ContextFiles      []string          // Read-only reference files: included in the prompt but never written
+	CompressContext   bool              // Strip comments and blank lines from files the response does not replace (see prompt.Options.Compress)
+	Attachments       []string          // Binary files (images, PDFs) sent inline with the first prompt
+	AllowedExts       []string          // If non-empty, only files with these extensions are written (see modifyFiles.Options.AllowedExts)
+	MarkerNonce       string            // If set, included in the file markers of the prompt and response (see utils.NewMarkers); Run picks one if a file contains the default markers
+	Gofmt             bool              // Format the Go files written from the response (see modifyFiles.Options.Gofmt)
+	Fuzzy             bool              // Let diff hunks that are slightly off apply nearby (see modifyFiles.Options.Fuzzy)
+	DiffEngine        string            // How diff hunks are placed (see modifyFiles.Options.DiffEngine)
+	PreserveIndent    bool              // Restore the indentation style of changed files (see modifyFiles.Options.PreserveIndent)
+	NormalizeEOL      bool              // Convert the line endings of full-text blocks to the file's own (see modifyFiles.Options.NormalizeEOL)
+	TrimTrailing      bool              // Strip trailing whitespace from full-text blocks (see modifyFiles.Options.TrimTrailing)
+	DryRun            bool              // Print a diff of the changes instead of writing them (see modifyFiles.Options.DryRun)
+	DiffOutput        io.Writer         // With DryRun, the diffs are printed here; os.Stdout if nil
+	LengthHints       bool              // State file lengths in the markers and reject blocks that disagree (see modifyFiles.Options.LengthHints)
+	StrictTransport   bool              // Have full-text responses carry each file base64-encoded (see modifyFiles.Options.Base64)
+	OutPath           string            // If set, the latest raw AI response is also written to this file
+	AuditLog          string            // If set, a JSON AuditRecord summarizing the run is appended to this file
+	CompressDumps     bool              // Gzip the prompt, response and transcript dumps in the temp directory (*.txt.gz)
+	NoOpen            bool              // Do not open the response in a browser when not modifying in place
+	JSONResult        io.Writer         // If non-nil, a JSON Result describing the run is written here at the end
+	CountTokens       io.Writer         // If non-nil, only the prompt's token count is written here; nothing is generated
+	Progress          io.Writer         // If non-nil, a spinner with the elapsed time is drawn here while waiting for the AI; should be a terminal
+
+	// Interactive keeps the conversation open after the first turn, reading follow-up
+	// instructions from Input (os.Stdin if nil) and prompting on Output (os.Stderr if nil).
+	Interactive bool
+	Input       io.Reader
+	Output      io.Writer
+
+	// Fallback, if non-nil, takes over from the engine once its quota is exhausted or it
+	// is overloaded, and serves the rest of the run (see fallbackEngine).
+	Fallback aiEndpoint.AIEngine
+
+	// dumpTag is appended to the names of the dumps in the temporary directory, so
+	// concurrent runs (see RunPerFile) do not overwrite each other's.
+	dumpTag string
+	// undo, if non-nil, collects the undo information of the run's applies, so that the
+	// runs of RunPerFile or RunBatches save a single manifest and one --undo reverts them
+	// all.
+	undo *undoRecorder
+}
+
+// malformedResponseNote is appended to the prompt when re-sending it after a response
+// that could not be parsed, so the model knows what went wrong the previous time.
+const malformedResponseNote = "\n\nNOTE: Your previous response was malformed: %v\nPlease respond again, following the required output format exactly.\n"
+
+// repairRequest is the follow-up sent, within the conversation, to ask the model to
+// reformat a response that could not be parsed. It quotes the start of that response.
+const repairRequest = "Your previous response could not be parsed: %v\n\nIt began:\n%s\n\nPlease send the same changes again, reformatted to follow the required output format exactly.\n"
+
+// maxRepairQuote limits how much of a malformed response is quoted back in repairRequest.
+const maxRepairQuote = 2000
+
+// Run executes the main AI coding flow using the given AI engine.
 // It creates a prompt, sends it to the AI, and then either modifies files in-place
 // or prints the AI's response to stdout.
-func Run(fileListPath, userInputPrompt, modelName string, inplace bool, tools string) error {
-	glog.V(0).Info("Starting AI coding flow.")
-	glog.V(1).Infof("File List Path: %q", fileListPath)
-	glog.V(1).Infof("User Prompt (truncated): %q", utils.TruncateString(userInputPrompt, 100))
-	glog.V(1).Infof("Model: %q", modelName)
-	glog.V(1).Infof("In-place: %t", inplace)
-	glog.V(1).Infof("Tools: %q", tools)
+// Canceling ctx aborts the run promptly: the AI request in flight fails, no further
+// file is read, and a response not yet applied is dropped. An apply in progress stops
+// and the files it already wrote are rolled back (see applyResponse). Running longer than
+// opts.MaxTotalDuration, counted from the start of Run, aborts it the same way, whatever
+// retries remain, with an error wrapping ErrTotalDuration. If the engine is overloaded
+// or out of quota and opts.Fallback is set, the request is re-sent to opts.Fallback,
+// which serves the rest of the run. With opts.CountTokens set, Run stops once the
+// prompt's tokens are counted, writing the count (an estimate, with a warning, if the
+// engine cannot count) on a line of its own, and the AI is not asked to generate anything.
+// Returned errors are tagged with ErrConfig, ErrAI or ErrApply.
+func Run(ctx context.Context, aiEngine aiEndpoint.AIEngine, opts Options) error {
+	_, err := runWithStats(ctx, aiEngine, opts)
+	return err
+}
+
+// runWithStats implements Run and also returns the statistics of the run, as reported
+// with opts.Stats, e.g. for RunBatches to add them up.
+func runWithStats(ctx context.Context, aiEngine aiEndpoint.AIEngine, opts Options) (Stats, error) {
+	var stats Stats
+	start := time.Now()
+	aiEngine = withFallback(aiEngine, opts.Fallback)
+	if opts.JSONResult != nil {
+		stats.result = newResult(start, opts.Prompt, aiEngine.ModelName())
+	}
+	if opts.MaxTotalDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadlineCause(ctx, start.Add(opts.MaxTotalDuration), ErrTotalDuration)
+		defer cancel()
+	}
+	err := run(ctx, aiEngine, opts, &stats)
+	if err != nil && errors.Is(context.Cause(ctx), ErrTotalDuration) {
+		err = fmt.Errorf("%w (%s): %w", ErrTotalDuration, opts.MaxTotalDuration, err)
+	}
+	stats.Elapsed = time.Since(start)
+	if opts.Stats {
+		stats.log()
+	}
+	if stats.result != nil {
+		stats.result.InputTokens = stats.InputTokens
+		if err != nil {
+			stats.result.Error = err.Error()
+		}
+		if writeErr := stats.result.write(opts.JSONResult); writeErr != nil {
+			logging.Errorf("Failed to write the JSON result: %v", writeErr)
+			if err == nil {
+				err = categorize(ErrApply, writeErr)
+			}
+		}
+	}
+	if opts.AuditLog != "" {
+		// The audit log is a record of the run, not part of it; failing to write it is not fatal.
+		if auditErr := appendAuditRecord(opts.AuditLog, newAuditRecord(start, aiEngine.ModelName(), stats, err)); auditErr != nil {
+			logging.Errorf("Failed to append to the audit log %q: %v", opts.AuditLog, auditErr)
+		}
+	}
+	return stats, err
+}
+
+// run implements Run, recording what happened in stats as it goes.
+func run(ctx context.Context, aiEngine aiEndpoint.AIEngine, opts Options, stats *Stats) error {
+	fileListPath := opts.FileListPath
+	userInputPrompt := opts.Prompt
+	inplace := opts.Inplace
+
+	logging.V(0).Info("Starting AI coding flow.")
+	logging.V(1).Infof("File List Path: %q", fileListPath)
+	logging.V(1).Infof("Files: %q", opts.Files)
+	logging.V(1).Infof("Since Git Ref: %q", opts.SinceGit)
+	logging.V(1).Infof("User Prompt (truncated): %q", utils.TruncateString(userInputPrompt, 100))
+	logging.V(1).Infof("Model: %q", aiEngine.ModelName())
+	logging.V(1).Infof("In-place: %t", inplace)
+	logging.V(1).Infof("Format: %q", opts.Format)
+	logging.V(1).Infof("Max file size: %d bytes (truncate oversized: %t)", opts.MaxFileSize, opts.TruncateOversized)
+	logging.V(1).Infof("File read timeout: %s", opts.FileReadTimeout)
+	logging.V(1).Infof("Exclude patterns: %q", opts.Excludes)
+	logging.V(1).Infof("Interactive: %t", opts.Interactive)
+	if opts.Input == nil {
+		opts.Input = os.Stdin
+	}
+	if opts.Output == nil {
+		opts.Output = os.Stderr
+	}
+	input := bufio.NewReader(opts.Input)
+
+	if inplace && opts.TruncateOversized {
+		// Writing back a response built on truncated content would lose the file's tail.
+		return categorize(ErrConfig, errors.New("truncating oversized files is not supported with in-place modification"))
+	}
+	if err := checkOutPath(opts.OutPath); err != nil {
+		logging.Errorf("Cannot write the response to %q: %v", opts.OutPath, err)
+		return categorize(ErrConfig, err)
+	}
 
 	// 1. Read files and their contents
-	fileContents, err := readFiles(fileListPath)
+	fileContents, err := readFiles(ctx, opts)
 	if err != nil {
-		glog.Errorf("Failed to read files from list %q: %v", fileListPath, err)
-		return fmt.Errorf("failed to read files: %w", err)
+		logging.Errorf("Failed to read files (list %q, files %q): %v", fileListPath, opts.Files, err)
+		return categorize(ErrConfig, fmt.Errorf("failed to read files: %w", err))
+	}
+	contextContents, err := readContextFiles(ctx, opts)
+	if err != nil {
+		logging.Errorf("Failed to read context files %q: %v", opts.ContextFiles, err)
+		return categorize(ErrConfig, fmt.Errorf("failed to read context files: %w", err))
+	}
+	dropContextFiles(fileContents, contextContents)
+	var selected map[string]bool
+	if opts.AutoSelect {
+		if selected, err = selectFiles(ctx, aiEngine, opts, fileContents); err != nil {
+			return err
+		}
+		keepSelected(fileContents, selected)
+	}
+	readHashes := staleCheckHashes(opts, fileContents)
+	logging.V(1).Infof("Successfully read %d files (and %d read-only context files) for prompt generation.", len(fileContents), len(contextContents))
+	logging.Event("files_read", map[string]interface{}{"count": len(fileContents), "context_count": len(contextContents)})
+	stats.FilesRead = len(fileContents)
+
+	attachments, err := readAttachments(opts.Attachments)
+	if err != nil {
+		logging.Errorf("Failed to read attachments %q: %v", opts.Attachments, err)
+		return categorize(ErrConfig, fmt.Errorf("failed to read attachments: %w", err))
+	}
+
+	if opts.MarkerNonce == "" && markersCollide(fileContents, contextContents) {
+		// The prompt and the response parser must agree on the markers, so pick them here.
+		if opts.MarkerNonce, err = utils.RandomNonce(); err != nil {
+			return categorize(ErrConfig, err)
+		}
+		logging.Warningf("Some files contain the default file marker text; framing files with marker nonce %q instead (use --marker-nonce %s to --replay this run's response).", opts.MarkerNonce, opts.MarkerNonce)
+		logging.Event("marker_nonce", map[string]interface{}{"nonce": opts.MarkerNonce})
 	}
-	glog.V(1).Infof("Successfully read %d files for prompt generation.", len(fileContents))
 
 	// 2. Create the prompt
-	fullPrompt := prompt.GeneratePrompt(userInputPrompt, fileContents, inplace)
-	glog.V(1).Infof("Prompt generated. Total length: %d bytes.", len(fullPrompt))
-	glog.V(2).Infof("Full generated prompt (truncated): %q", utils.TruncateString(fullPrompt, 500))
+	promptOpts := prompt.Options{
+		Inplace:   inplace,
+		Format:    opts.Format,
+		FileNotes: opts.FileNotes,
+		Prefix:    opts.PromptPrefix,
+		Suffix:    opts.PromptSuffix,
+
+		ContextFiles: contextContents,
+		Markers:      utils.NewMarkers(opts.MarkerNonce),
+		Compress:     opts.CompressContext,
+		LengthHints:  opts.LengthHints,
+		Base64:       opts.StrictTransport,
+	}
+	fullPrompt := prompt.GeneratePrompt(userInputPrompt, fileContents, promptOpts)
+	applyOpts := applyOptions(opts, sortedPaths(fileContents), sortedPaths(contextContents))
+	logging.V(1).Infof("Prompt generated. Total length: %d bytes.", len(fullPrompt))
+	logging.V(2).Infof("Full generated prompt (truncated): %q", utils.TruncateString(fullPrompt, 500))
 
 	// Generate dynamic file names based on current timestamp
-	timestamp := time.Now().Format("20060102_150405") // YYYYMMDD_HHMMSS
-	promptDumpFileName := fmt.Sprintf("ai_prompt_%s.txt", timestamp)
-	rawOutputDumpFileName := fmt.Sprintf("ai_raw_output_%s.txt", timestamp)
+	timestamp := time.Now().Format("20060102_150405") + opts.dumpTag // YYYYMMDD_HHMMSS
+	promptDumpFileName := fmt.Sprintf("ai_prompt_%s%s", timestamp, dumpExt(opts.CompressDumps))
+	rawOutputDumpFileName := fmt.Sprintf("ai_raw_output_%s%s", timestamp, dumpExt(opts.CompressDumps))
 
 	promptDumpPath := filepath.Join(os.TempDir(), promptDumpFileName)
 	rawOutputDumpPath := filepath.Join(os.TempDir(), rawOutputDumpFileName)
 
 	// Save the generated prompt to a file in /tmp
-	err = os.WriteFile(promptDumpPath, []byte(fullPrompt), 0644)
+	err = writeDump(promptDumpPath, []byte(fullPrompt))
 	if err != nil {
-		glog.Errorf("Failed to save generated prompt to %q: %v", promptDumpPath, err)
+		logging.Errorf("Failed to save generated prompt to %q: %v", promptDumpPath, err)
 		// Do not return error, proceed with AI call as saving is a secondary feature.
 	} else {
-		glog.V(0).Infof("Generated AI prompt saved to %q", promptDumpPath)
+		logging.V(0).Infof("Generated AI prompt saved to %q", promptDumpPath)
 	}
 
 	// 3. Send the prompt to the AI endpoint
-	aiEngine, err := gemini.NewClient(modelName, tools) // Assuming gemini is the only AI engine for now
-	if err != nil {
-		glog.Errorf("Failed to initialize AI engine: %v", err)
-		return fmt.Errorf("failed to initialize AI engine: %w", err)
-	}
-
 	// Calculate and log token count *before* sending the prompt
-	tokenCount, err := aiEngine.CountTokens(fullPrompt)
+	// A failed count must not hold up the main call unless asked; an estimate is good enough.
+	tokenCount, approximate, err := countPromptTokens(ctx, aiEngine, fullPrompt, opts.RequireTokenCount)
 	if err != nil {
-		glog.Warningf("Could not calculate input token count: %v", err)
-		// Continue even if token count fails, as sending the prompt is still possible.
+		logging.Errorf("Failed to count input tokens: %v", err)
+		return categorize(ErrAI, fmt.Errorf("failed to count input tokens: %w", err))
+	}
+	if approximate {
+		logging.Event("token_count", map[string]interface{}{"tokens": tokenCount, "model": aiEngine.ModelName(), "approximate": true})
 	} else {
-		glog.V(0).Infof("Input prompt token count: %d tokens.", tokenCount)
+		logging.V(0).Infof("Input prompt token count: %d tokens.", tokenCount)
+		logging.Event("token_count", map[string]interface{}{"tokens": tokenCount, "model": aiEngine.ModelName()})
 	}
-
-	aiResponse, err := aiEngine.SendPrompt(fullPrompt)
-	if err != nil {
-		glog.Errorf("Failed to get response from AI: %v", err)
-		return fmt.Errorf("failed to get AI response: %w", err)
+	stats.InputTokens = tokenCount
+	if opts.CountTokens != nil {
+		if _, err := fmt.Fprintln(opts.CountTokens, tokenCount); err != nil {
+			return categorize(ErrApply, fmt.Errorf("failed to write the token count: %w", err))
+		}
+		logging.V(0).Info("Token count written; not sending the prompt.")
+		return nil
 	}
-	glog.V(1).Infof("AI responded. Response length: %d bytes.", len(aiResponse))
-	glog.V(2).Infof("Full AI response (truncated): %q", utils.TruncateString(aiResponse, 500))
 
-	// Save the raw AI output to a file in /tmp
-	err = os.WriteFile(rawOutputDumpPath, []byte(aiResponse), 0644)
-	if err != nil {
-		glog.Errorf("Failed to save raw AI output to %q: %v", rawOutputDumpPath, err)
-		// Do not return error, proceed with modification/display as saving is a secondary feature.
-	} else {
-		glog.V(0).Infof("Raw AI output saved to %q", rawOutputDumpPath)
+	history := []aiEndpoint.Message{}
+	message := aiEndpoint.Message{Role: aiEndpoint.RoleUser, Text: fullPrompt, Attachments: attachments}
+	for turn := 1; ; turn++ {
+		dumpPath := rawOutputDumpPath
+		if turn > 1 {
+			dumpPath = dumpPathWithSuffix(rawOutputDumpPath, fmt.Sprintf("_turn%d", turn))
+		}
+		history, err = runTurn(ctx, aiEngine, opts, applyOpts, stats, history, message, readHashes, dumpPath)
+		if err != nil {
+			return err
+		}
+		if !opts.Interactive {
+			break
+		}
+
+		transcriptPath := filepath.Join(os.TempDir(), fmt.Sprintf("ai_transcript_%s%s", timestamp, dumpExt(opts.CompressDumps)))
+		saveTranscript(transcriptPath, history)
+
+		instruction, ok := readFollowUp(input, opts.Output)
+		if !ok {
+			logging.V(0).Info("No further instructions. Ending interactive session.")
+			break
+		}
+		message = aiEndpoint.Message{Role: aiEndpoint.RoleUser, Text: instruction}
+		if inplace {
+			// Re-read the files so the model sees the changes applied in the previous turns.
+			fileContents, err = readFiles(ctx, opts)
+			if err != nil {
+				logging.Errorf("Failed to re-read files (list %q, files %q): %v", fileListPath, opts.Files, err)
+				return categorize(ErrConfig, fmt.Errorf("failed to read files: %w", err))
+			}
+			dropContextFiles(fileContents, contextContents)
+			keepSelected(fileContents, selected)
+			readHashes = staleCheckHashes(opts, fileContents)
+			message.Text = prompt.GeneratePrompt(instruction, fileContents, promptOpts)
+		}
 	}
 
-	// 4. Modify files or show response
-	if inplace {
-		glog.V(0).Info("In-place modification requested. Applying changes to files.")
-		err = modifyFiles.ApplyFullTextChangesToFiles(aiResponse) // Applies full text content
-		if err != nil {
-			glog.Errorf("Failed to apply changes to files in-place: %v", err)
-			return fmt.Errorf("failed to apply changes: %w", err)
+	logging.V(0).Infof("AI coding flow completed using model %q.", aiEngine.ModelName())
+	return nil
+}
+
+// runTurn sends the user message, following the conversation history, to the AI engine and
+// then either applies the response to the files or displays it. If the response
+// cannot be parsed, the model is first asked to repair it within the conversation up to
+// opts.AutoRepair times, and then the message is re-sent up to opts.RetryOnParseFail times.
+// Before applying, the files are compared with readHashes according to opts.CheckStale.
+// It returns the history extended with the message and the accepted response.
+func runTurn(ctx context.Context, aiEngine aiEndpoint.AIEngine, opts Options, applyOpts modifyFiles.Options, stats *Stats, history []aiEndpoint.Message, message aiEndpoint.Message, readHashes map[string]string, rawOutputDumpPath string) ([]aiEndpoint.Message, error) {
+	currentMessage := message
+	base := history // Conversation that currentMessage follows; grows with each repair exchange
+	retries, repairs := 0, 0
+	for attempt := 0; ; attempt++ {
+		dumpPath := rawOutputDumpPath
+		if attempt > 0 {
+			dumpPath = dumpPathWithSuffix(rawOutputDumpPath, fmt.Sprintf("_retry%d", attempt))
 		}
-		glog.V(0).Info("Files modified successfully in-place.")
-	} else {
-		glog.V(0).Info("In-place modification not requested. Saving and displaying AI response in browser.")
-		// The prompt.GeneratePrompt function does NOT add explicit formatting instructions
-		// for AI output when `inplace` is false. Therefore, the `aiResponse` here is
-		// the raw, unformatted AI output based on the initial prompt.
-		// We use a generic HTML display function for this raw text.
-		err = display.SaveAndOpenAIResponseAsHTML(aiResponse)
+
+		conversation := append(append([]aiEndpoint.Message(nil), base...), currentMessage)
+		var pick func([]string) string
+		if opts.Inplace && opts.Candidates > 1 {
+			pick = func(candidates []string) string { return pickCandidate(candidates, opts.Format, applyOpts) }
+		}
+		aiResponse, err := sendConversation(ctx, aiEngine, conversation, dumpPath, opts.Progress, pick)
 		if err != nil {
-			glog.Errorf("Failed to display AI response in browser: %v", err)
-			// Return error because displaying the result is the primary action when not in-place.
-			return fmt.Errorf("failed to display AI response: %w", err)
+			return nil, err
+		}
+		stats.ResponseBytes += len(aiResponse)
+		if opts.OutPath != "" {
+			if err := utils.WriteFileAtomic(opts.OutPath, []byte(aiResponse), 0644); err != nil {
+				logging.Errorf("Failed to write AI response to %q: %v", opts.OutPath, err)
+				return nil, categorize(ErrApply, fmt.Errorf("failed to write AI response to %q: %w", opts.OutPath, err))
+			}
+			logging.V(0).Infof("AI response written to %q", opts.OutPath)
+		}
+		conversation = append(conversation, aiEndpoint.Message{Role: aiEndpoint.RoleModel, Text: aiResponse})
+
+		// 4. Modify files or show response
+		if !opts.Inplace && opts.NoOpen {
+			logging.V(0).Info("In-place modification not requested and browser display disabled.")
+			return conversation, nil
 		}
-		glog.V(0).Info("AI response saved to file and opened in browser.")
+		if !opts.Inplace {
+			logging.V(0).Info("In-place modification not requested. Saving and displaying AI response in browser.")
+			// The prompt.GeneratePrompt function does NOT add explicit formatting instructions
+			// for AI output when `inplace` is false. Therefore, the `aiResponse` here is
+			// the raw, unformatted AI output based on the initial prompt.
+			// We use a generic HTML display function for this raw text.
+			err = display.SaveAndOpenAIResponseAsHTML(aiResponse)
+			if err != nil {
+				logging.Errorf("Failed to display AI response in browser: %v", err)
+				// Return error because displaying the result is the primary action when not in-place.
+				return nil, categorize(ErrApply, fmt.Errorf("failed to display AI response: %w", err))
+			}
+			logging.V(0).Info("AI response saved to file and opened in browser.")
+			return conversation, nil
+		}
+
+		logging.V(0).Info("In-place modification requested. Applying changes to files.")
+		if err := ctx.Err(); err != nil {
+			logging.Errorf("Not applying the AI response: %v", err)
+			return nil, categorize(ErrApply, fmt.Errorf("not applying the AI response: %w", err))
+		}
+		if err := checkStale(opts.CheckStale, readHashes); err != nil {
+			logging.Errorf("Not applying the AI response: %v", err)
+			return nil, categorize(ErrApply, err)
+		}
+		var before map[string]string
+		if stats.result != nil {
+			before = hashFiles(applyOpts.Requested)
+		}
+		result, err := applyResponse(ctx, aiResponse, opts.Format, applyOpts, opts.undo)
+		stats.addApplyResult(result, before)
+		if len(result.DiffStats) > 0 {
+			logging.V(0).Info(result.DiffSummary())
+		}
+		logFuzzy(result)
+		for _, gofmtErr := range result.GofmtErrors {
+			logging.Warningf("gofmt failed, file left unformatted: %v", gofmtErr)
+		}
+		if err == nil && opts.DryRun {
+			logging.V(0).Info("Dry run complete; no files were written.")
+			return conversation, nil
+		}
+		if err == nil {
+			if written := result.Written(); len(written) > 0 {
+				logging.V(0).Infof("Wrote %d files: %s", len(written), strings.Join(written, ", "))
+			}
+			logging.V(0).Info("Files modified successfully in-place.")
+			return conversation, nil
+		}
+		// Only malformed responses are worth asking for again; I/O failures would just repeat.
+		if !modifyFiles.IsParseError(err) || (repairs >= opts.AutoRepair && retries >= opts.RetryOnParseFail) {
+			logging.Errorf("Failed to apply changes to files in-place: %v", err)
+			return nil, categorize(ErrApply, fmt.Errorf("failed to apply changes: %w", err))
+		}
+		if repairs < opts.AutoRepair {
+			// Keep the malformed response in the conversation so the model remembers the task.
+			repairs++
+			logging.Warningf("AI response could not be parsed (%v). Asking the AI to repair it (%d/%d).", err, repairs, opts.AutoRepair)
+			logging.Event("auto_repair", map[string]interface{}{"attempt": repairs, "error": err.Error()})
+			base = conversation
+			currentMessage = aiEndpoint.Message{Role: aiEndpoint.RoleUser,
+				Text: fmt.Sprintf(repairRequest, err, utils.TruncateString(aiResponse, maxRepairQuote))}
+			continue
+		}
+		retries++
+		logging.Warningf("AI response could not be parsed (%v). Retrying (%d/%d).", err, retries, opts.RetryOnParseFail)
+		base = history
+		currentMessage = message
+		currentMessage.Text = message.Text + fmt.Sprintf(malformedResponseNote, err)
 	}
+}
 
-	glog.V(0).Info("AI coding flow completed.")
-	return nil
+// markersCollide reports whether any of the contents contains the default file marker
+// text, e.g. because one of the files is this tool's own source.
+func markersCollide(contents ...map[string]string) bool {
+	for _, m := range contents {
+		for _, content := range m {
+			if utils.DefaultMarkers.FoundIn(content) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-// readFiles reads the file paths from the given file list path
-// and then reads the content of each file, returning a map of file paths to their content.
-func readFiles(fileListPath string) (map[string]string, error) {
-	glog.V(1).Infof("Reading file list from: %q", fileListPath)
-	filePaths := []string{}
+// aiErrorHint suggests a fix for a failure of aiEngine of a known kind, or returns "".
+// The fix for rejected credentials comes from aiEngine if it is an aiEndpoint.AuthHinter.
+func aiErrorHint(err error, aiEngine aiEndpoint.AIEngine) string {
+	switch {
+	case errors.Is(err, aiEndpoint.ErrAuth):
+		if hinter, ok := aiEngine.(aiEndpoint.AuthHinter); ok && hinter.AuthHint() != "" {
+			return hinter.AuthHint()
+		}
+		return "Check the API key or credentials configured for the AI provider."
+	case errors.Is(err, aiEndpoint.ErrQuota):
+		return "The API quota or rate limit is exhausted; wait and retry, or use a different model (e.g. --flash)."
+	case errors.Is(err, aiEndpoint.ErrOverloaded):
+		return "The model is overloaded; retry later, or name a model to switch to with --model-fallback."
+	case errors.Is(err, aiEndpoint.ErrNetwork):
+		return "Check the network connection and any proxy settings."
+	case errors.Is(err, aiEndpoint.ErrTimeout) && !errors.Is(err, context.Canceled):
+		return "Consider raising --timeout."
+	}
+	return ""
+}
 
-	// Open the file list file
-	file, err := os.Open(fileListPath)
+// sendConversation sends the conversation to the AI engine and saves the raw response to dumpPath
+// (gzip-compressed if it ends in gzipExt).
+// While waiting, a progress indicator is drawn on progress, if it is non-nil (see startProgress).
+// If pick is non-nil and aiEngine is an aiEndpoint.CandidateEngine, all the candidate
+// replies are requested and pick chooses the response among them.
+func sendConversation(ctx context.Context, aiEngine aiEndpoint.AIEngine, conversation []aiEndpoint.Message, dumpPath string, progress io.Writer, pick func([]string) string) (string, error) {
+	stopProgress := startProgress(progress, aiEngine.ModelName())
+	var aiResponse string
+	var err error
+	if candidateEngine, ok := aiEngine.(aiEndpoint.CandidateEngine); ok && pick != nil {
+		var candidates []string
+		candidates, err = candidateEngine.SendConversationCandidates(ctx, conversation)
+		stopProgress()
+		if len(candidates) > 0 {
+			aiResponse = pick(candidates)
+		}
+	} else {
+		aiResponse, err = aiEngine.SendConversation(ctx, conversation)
+		stopProgress()
+	}
+	if errors.Is(err, aiEndpoint.ErrTruncated) {
+		// Keep the partial response: complete file blocks before the cut can still be used,
+		// and the appliers refuse to write a block that is missing its end.
+		logging.Warningf("The AI response was clipped at the output token limit (%v). Consider raising --max-output-tokens.", err)
+		logging.Event("response_truncated", map[string]interface{}{"bytes": len(aiResponse), "model": aiEngine.ModelName()})
+		err = nil
+	}
 	if err != nil {
-		glog.Errorf("Failed to open file list %q: %v", fileListPath, err)
-		return nil, fmt.Errorf("failed to open file list: %w", err)
+		logging.Errorf("Failed to get response from AI: %v", err)
+		hint := aiErrorHint(err, aiEngine)
+		if errors.Is(context.Cause(ctx), ErrTotalDuration) {
+			hint = "The run used up its --max-total-duration; consider raising it."
+		}
+		if hint != "" {
+			logging.Error(hint)
+		}
+		return "", categorize(ErrAI, fmt.Errorf("failed to get AI response: %w", err))
 	}
-	defer file.Close()
+	logging.V(1).Infof("AI responded. Response length: %d bytes.", len(aiResponse))
+	logging.Event("ai_response", map[string]interface{}{"bytes": len(aiResponse), "model": aiEngine.ModelName()})
+	logging.V(2).Infof("Full AI response (truncated): %q", utils.TruncateString(aiResponse, 500))
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line != "" { // Ignore empty lines
-			filePaths = append(filePaths, line)
-		}
+	// Save the raw AI output to a file in /tmp
+	err = writeDump(dumpPath, []byte(aiResponse))
+	if err != nil {
+		logging.Errorf("Failed to save raw AI output to %q: %v", dumpPath, err)
+		// Do not return error, proceed with modification/display as saving is a secondary feature.
+	} else {
+		logging.V(0).Infof("Raw AI output saved to %q", dumpPath)
 	}
+	return aiResponse, nil
+}
 
-	if err := scanner.Err(); err != nil {
-		glog.Errorf("Error reading file list %q: %v", fileListPath, err)
-		return nil, fmt.Errorf("error reading file list: %w", err)
+// checkOutPath returns an error if the response cannot be written to path:
+// its directory must exist and path must not be a directory. An empty path is valid.
+func checkOutPath(path string) error {
+	if path == "" {
+		return nil
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return fmt.Errorf("output path %q is a directory", path)
+	}
+	dir := filepath.Dir(path)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("output directory %q is not accessible: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("output directory %q is not a directory", dir)
 	}
-	glog.V(1).Infof("Found %d files in the file list.", len(filePaths))
+	return nil
+}
 
-	// Read content of each file
-	fileContents := make(map[string]string)
-	for _, path := range filePaths {
-		glog.V(2).Infof("Reading content of file: %q", path)
-		contentBytes, err := os.ReadFile(path)
-		if err != nil {
-			// Log the error but continue if possible, or decide to fail fast.
-			// For now, fail fast as missing files are critical for prompt generation.
-			glog.Errorf("Failed to read content of file %q: %v", path, err)
-			return nil, fmt.Errorf("failed to read file %q: %w", path, err)
+// readFollowUp prompts on output and reads the next instruction from input.
+// It returns false when the user enters an empty line or input is exhausted.
+func readFollowUp(input *bufio.Reader, output io.Writer) (string, bool) {
+	fmt.Fprint(output, "\nEnter a follow-up instruction (empty line or EOF to finish): ")
+	line, err := input.ReadString('\n')
+	line = strings.TrimSpace(line)
+	if line == "" {
+		if err != nil && err != io.EOF {
+			logging.Warningf("Failed to read follow-up instruction: %v", err)
 		}
-		fileContents[path] = string(contentBytes)
-		glog.V(3).Infof("Read %d bytes from %q.", len(contentBytes), path)
+		return "", false
 	}
+	return line, true
+}
 
-	return fileContents, nil
+// saveTranscript writes the conversation history to path, overwriting earlier versions.
+// Failing to save is logged but not fatal, as the transcript is a secondary feature.
+func saveTranscript(path string, history []aiEndpoint.Message) {
+	var builder strings.Builder
+	for i, msg := range history {
+		builder.WriteString(fmt.Sprintf("===== Message %d (%s) =====\n", i+1, msg.Role))
+		builder.WriteString(msg.Text)
+		builder.WriteString("\n")
+	}
+	if err := writeDump(path, []byte(builder.String())); err != nil {
+		logging.Errorf("Failed to save conversation transcript to %q: %v", path, err)
+		return
+	}
+	logging.V(0).Infof("Conversation transcript saved to %q", path)
 }
\ No newline at end of file
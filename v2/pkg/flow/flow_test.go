@@ -0,0 +1,391 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+// fullTextBlock formats content as a BEGIN/END file block, as the AI is asked to respond.
+func fullTextBlock(path, content string) string {
+	return utils.BeginMarkerPrefix + path + utils.BeginMarkerSuffix + content + utils.EndMarkerPrefix + path + utils.EndMarkerSuffix
+}
+
+func TestRun_InplaceWithMockEngine(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"main.go": "package main\n"})
+	mainPath := filepath.Join(dir, "main.go")
+
+	engine := mock.NewClient(fullTextBlock(mainPath, "package main\n\nfunc main() {}\n"))
+	err := Run(context.Background(), engine, Options{
+		FileListPath: listPath,
+		Prompt:       "Add a main function.",
+		Inplace:      true,
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	got, err := os.ReadFile(mainPath)
+	if err != nil {
+		t.Fatalf("Failed to read %q: %v", mainPath, err)
+	}
+	if want := "package main\n\nfunc main() {}\n"; string(got) != want {
+		t.Errorf("content of %q = %q, want %q", mainPath, got, want)
+	}
+
+	prompts := engine.Prompts()
+	if len(prompts) != 1 {
+		t.Fatalf("engine received %d prompts, want 1", len(prompts))
+	}
+	if !strings.Contains(prompts[0], "Add a main function.") || !strings.Contains(prompts[0], mainPath) {
+		t.Errorf("prompt %q does not contain the user input and file path", prompts[0])
+	}
+}
+
+func TestRun_RetryOnParseFail(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+	aPath := filepath.Join(dir, "a.txt")
+
+	engine := &mock.Client{Responses: []string{"garbage", fullTextBlock(aPath, "new\n")}}
+	opts := Options{FileListPath: listPath, Prompt: "Update.", Inplace: true}
+
+	if err := Run(context.Background(), engine, opts); err == nil {
+		t.Fatal("Run() without retries succeeded on a malformed response, want an error")
+	}
+
+	engine = &mock.Client{Responses: []string{"garbage", fullTextBlock(aPath, "new\n")}}
+	opts.RetryOnParseFail = 1
+	if err := Run(context.Background(), engine, opts); err != nil {
+		t.Fatalf("Run() with one retry error = %v", err)
+	}
+	prompts := engine.Prompts()
+	if len(prompts) != 2 {
+		t.Fatalf("engine received %d prompts, want 2", len(prompts))
+	}
+	if !strings.Contains(prompts[1], "previous response was malformed") {
+		t.Errorf("retry prompt does not mention the malformed response: %q", utils.TruncateString(prompts[1], 200))
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "new\n" {
+		t.Errorf("content of %q = %q, want %q", aPath, got, "new\n")
+	}
+}
+
+func TestRun_MaxTotalDuration(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir) // Keep the dumps of the retries out of the real temp directory
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+
+	// Every response is malformed, so only the time budget can end the retries.
+	engine := &slowClient{Client: mock.NewClient("garbage"), delay: 10 * time.Millisecond}
+	opts := Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, RetryOnParseFail: 1000, AutoRepair: 1000, MaxTotalDuration: 100 * time.Millisecond}
+	start := time.Now()
+	err := Run(context.Background(), engine, opts)
+	if !errors.Is(err, ErrTotalDuration) {
+		t.Fatalf("Run() error = %v, want it to wrap ErrTotalDuration", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run() took %s with a budget of %s", elapsed, opts.MaxTotalDuration)
+	}
+	if calls := len(engine.Prompts()); calls < 2 || calls >= 1000 {
+		t.Errorf("engine received %d prompts, want a few retries cut short by the budget", calls)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(got) != "old\n" {
+		t.Errorf("content of a.txt = %q, want it unchanged", got)
+	}
+}
+
+func TestRun_AutoRepair(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+	aPath := filepath.Join(dir, "a.txt")
+
+	engine := &mock.Client{Responses: []string{"garbage", fullTextBlock(aPath, "new\n")}}
+	err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, AutoRepair: 1})
+	if err != nil {
+		t.Fatalf("Run() with auto-repair error = %v", err)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "new\n" {
+		t.Errorf("content of %q = %q, want %q", aPath, got, "new\n")
+	}
+
+	// The repair request follows the malformed response in the same conversation.
+	histories := engine.Histories()
+	if len(histories) != 2 {
+		t.Fatalf("engine received %d conversations, want 2", len(histories))
+	}
+	repair := histories[1]
+	if len(repair) != 3 || repair[1].Role != aiEndpoint.RoleModel || repair[1].Text != "garbage" {
+		t.Fatalf("repair conversation = %+v, want the prompt, the malformed response and a repair request", repair)
+	}
+	if repair[0].Text != histories[0][0].Text {
+		t.Error("repair conversation does not start with the original prompt")
+	}
+	if !strings.Contains(repair[2].Text, "could not be parsed") || !strings.Contains(repair[2].Text, "garbage") {
+		t.Errorf("repair request %q does not explain the failure and quote the response", repair[2].Text)
+	}
+
+	// Once the repairs are used up, the run fails.
+	engine = &mock.Client{Response: "garbage"}
+	if err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, AutoRepair: 2}); err == nil {
+		t.Fatal("Run() succeeded although every response was malformed")
+	}
+	if got := len(engine.Prompts()); got != 3 {
+		t.Errorf("engine received %d prompts, want 3", got)
+	}
+}
+
+func TestRun_MarkerNonce(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+	aPath := filepath.Join(dir, "a.txt")
+	markers := utils.NewMarkers("nonce42")
+
+	// The response quotes a block framed with the default markers inside a.txt's content.
+	engine := mock.NewClient(markers.Begin(aPath) + fullTextBlock("/b.txt", "quoted\n") + markers.End(aPath))
+	err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, MarkerNonce: "nonce42"})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if prompts := engine.Prompts(); !strings.Contains(prompts[0], markers.Begin(aPath)) {
+		t.Errorf("prompt does not frame %q with the nonce markers", aPath)
+	}
+	want := fullTextBlock("/b.txt", "quoted\n")
+	if got, _ := os.ReadFile(aPath); string(got) != want {
+		t.Errorf("content of %q = %q, want %q", aPath, got, want)
+	}
+}
+
+// echoClient is a mock engine that answers with the given file contents, framed with
+// the markers it finds in the prompt, as a model following the instructions would.
+type echoClient struct {
+	*mock.Client
+	files map[string]string
+}
+
+func (c *echoClient) SendConversation(ctx context.Context, history []aiEndpoint.Message) (string, error) {
+	markers := utils.DefaultMarkers
+	if m := regexp.MustCompile(`--- Start of File \[([0-9a-f]+)\]: `).FindStringSubmatch(history[0].Text); m != nil {
+		markers = utils.NewMarkers(m[1])
+	}
+	var response strings.Builder
+	for path, content := range c.files {
+		response.WriteString(markers.Begin(path) + content + markers.End(path))
+	}
+	c.Client.Response = response.String()
+	return c.Client.SendConversation(ctx, history)
+}
+
+func TestRun_ContentContainingMarkers(t *testing.T) {
+	dir := t.TempDir()
+	selfPath := filepath.Join(dir, "self.go")
+	// The file quotes its own end marker, as this tool's tests do.
+	quote := func(version string) string {
+		return "package self\n\n// " + version + "\nconst end = `" + utils.DefaultMarkers.End(selfPath) + "`\n"
+	}
+	listPath := writeFileList(t, dir, map[string]string{"self.go": quote("v1")})
+
+	engine := &echoClient{Client: &mock.Client{}, files: map[string]string{selfPath: quote("v2")}}
+	if err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Bump the version.", Inplace: true}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if prompts := engine.Prompts(); strings.Contains(prompts[0], utils.DefaultMarkers.Begin(selfPath)) {
+		t.Error("prompt frames the file with the default markers, which its content contains")
+	}
+	if got, _ := os.ReadFile(selfPath); string(got) != quote("v2") {
+		t.Errorf("content of %q = %q, want %q", selfPath, got, quote("v2"))
+	}
+
+	// Without a collision the default markers are kept.
+	plainPath := filepath.Join(dir, "plain.go")
+	listPath = writeFileList(t, dir, map[string]string{"plain.go": "package plain\n"})
+	engine = &echoClient{Client: &mock.Client{}, files: map[string]string{plainPath: "package plain\n\nvar x int\n"}}
+	if err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Add x.", Inplace: true}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if prompts := engine.Prompts(); !strings.Contains(prompts[0], utils.DefaultMarkers.Begin(plainPath)) {
+		t.Error("prompt does not use the default markers although no file contains them")
+	}
+}
+
+func TestRun_EngineError(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+	engineErr := errors.New("boom")
+
+	err := Run(context.Background(), &mock.Client{Err: engineErr}, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true})
+	if !errors.Is(err, engineErr) {
+		t.Errorf("Run() error = %v, want it to wrap %v", err, engineErr)
+	}
+}
+
+func TestRun_EngineErrorKinds(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+
+	for _, kind := range []error{aiEndpoint.ErrAuth, aiEndpoint.ErrQuota, aiEndpoint.ErrOverloaded, aiEndpoint.ErrNetwork, aiEndpoint.ErrTimeout} {
+		engine := &mock.Client{Err: fmt.Errorf("failed to generate content: %w: status 4xx", kind)}
+		err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true})
+		if !errors.Is(err, kind) || !errors.Is(err, ErrAI) {
+			t.Errorf("Run() error = %v, want it to wrap %v and ErrAI", err, kind)
+		}
+		if aiErrorHint(err, engine) == "" {
+			t.Errorf("aiErrorHint(%v) is empty", err)
+		}
+	}
+	if hint := aiErrorHint(errors.New("boom"), &mock.Client{}); hint != "" {
+		t.Errorf("aiErrorHint() for an unclassified error = %q, want none", hint)
+	}
+}
+
+// authHintClient is a mock engine with a provider-specific hint for rejected credentials.
+type authHintClient struct {
+	*mock.Client
+	hint string
+}
+
+func (c *authHintClient) AuthHint() string { return c.hint }
+
+func TestAIErrorHint_Auth(t *testing.T) {
+	err := fmt.Errorf("failed: %w", aiEndpoint.ErrAuth)
+	engine := &authHintClient{Client: &mock.Client{}, hint: "Check ANTHROPIC_API_KEY."}
+	if got := aiErrorHint(err, engine); got != engine.hint {
+		t.Errorf("aiErrorHint() = %q, want the engine's hint %q", got, engine.hint)
+	}
+	if got := aiErrorHint(err, withFallback(&mock.Client{}, engine)); strings.Contains(got, "GEMINI") {
+		t.Errorf("aiErrorHint() for an engine without a hint = %q, want a provider-neutral hint", got)
+	}
+}
+
+func TestRun_Interactive(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "v1\n"})
+	aPath := filepath.Join(dir, "a.txt")
+
+	engine := &mock.Client{Responses: []string{fullTextBlock(aPath, "v2\n"), fullTextBlock(aPath, "v3\n")}}
+	err := Run(context.Background(), engine, Options{
+		FileListPath: listPath,
+		Prompt:       "Bump the version.",
+		Inplace:      true,
+		Interactive:  true,
+		Input:        strings.NewReader("Bump it again.\n\n"),
+		Output:       io.Discard,
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	histories := engine.Histories()
+	if len(histories) != 2 {
+		t.Fatalf("engine received %d conversations, want 2", len(histories))
+	}
+	second := histories[1]
+	if len(second) != 3 {
+		t.Fatalf("second conversation has %d messages, want 3", len(second))
+	}
+	if second[1].Role != aiEndpoint.RoleModel || second[1].Text != fullTextBlock(aPath, "v2\n") {
+		t.Errorf("second conversation does not include the first response: %+v", second[1])
+	}
+	if !strings.Contains(second[2].Text, "Bump it again.") || !strings.Contains(second[2].Text, "v2\n") {
+		t.Errorf("follow-up message %q does not contain the instruction and the updated file", second[2].Text)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "v3\n" {
+		t.Errorf("content of %q = %q, want %q", aPath, got, "v3\n")
+	}
+}
+
+func TestRun_TruncatedResponse(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old a\n", "b.txt": "old b\n"})
+	aPath := filepath.Join(dir, "a.txt")
+	bPath := filepath.Join(dir, "b.txt")
+
+	// The response is cut off in the middle of b.txt's block.
+	clipped := fullTextBlock(aPath, "new a\n") + utils.BeginMarkerPrefix + bPath + utils.BeginMarkerSuffix + "new"
+	engine := &mock.Client{Response: clipped, Truncated: true}
+	if err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true}); err != nil {
+		t.Logf("Run() error = %v", err)
+	}
+
+	if got, _ := os.ReadFile(aPath); string(got) != "new a\n" {
+		t.Errorf("content of %q = %q, want the complete block to be applied", aPath, got)
+	}
+	if got, _ := os.ReadFile(bPath); string(got) != "old b\n" {
+		t.Errorf("content of %q = %q, want the clipped block to be left unwritten", bPath, got)
+	}
+}
+
+func TestRun_OutPath(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+	outPath := filepath.Join(dir, "changes.diff")
+
+	engine := mock.NewClient("--- a/a.txt\n+++ b/a.txt\n")
+	if err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Explain.", OutPath: outPath, NoOpen: true}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got, _ := os.ReadFile(outPath); string(got) != engine.Response {
+		t.Errorf("content of %q = %q, want the raw response %q", outPath, got, engine.Response)
+	}
+
+	// An unwritable location is reported before the AI is called.
+	engine = mock.NewClient("unused")
+	err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Explain.", OutPath: filepath.Join(dir, "missing", "out.txt"), NoOpen: true})
+	if !errors.Is(err, ErrConfig) {
+		t.Errorf("Run() error = %v, want a configuration error", err)
+	}
+	if len(engine.Prompts()) != 0 {
+		t.Errorf("engine received %d prompts, want none", len(engine.Prompts()))
+	}
+}
+
+func TestRun_DryRun(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"main.go": "package main\n"})
+	mainPath := filepath.Join(dir, "main.go")
+
+	engine := mock.NewClient(fullTextBlock(mainPath, "package main\n\nfunc main() {}\n"))
+	var diff bytes.Buffer
+	err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Add a main function.", Inplace: true, DryRun: true, DiffOutput: &diff})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got, _ := os.ReadFile(mainPath); string(got) != "package main\n" {
+		t.Errorf("content of %q = %q, want it untouched", mainPath, got)
+	}
+	if want := "+++ b/" + mainPath + "\n@@ -1,1 +1,3 @@\n package main\n+\n+func main() {}\n"; !strings.Contains(diff.String(), want) {
+		t.Errorf("dry-run diff = %q, want it to contain %q", diff.String(), want)
+	}
+}
+
+func TestRun_CompressContext(t *testing.T) {
+	dir := t.TempDir()
+	source := "package main\n\n// main does nothing.\nfunc main() {}\n"
+	listPath := writeFileList(t, dir, map[string]string{"main.go": source})
+	mainPath := filepath.Join(dir, "main.go")
+
+	engine := mock.NewClient("It does nothing.")
+	err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Explain.", CompressContext: true, OutPath: filepath.Join(dir, "out.txt"), NoOpen: true})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if prompts := engine.Prompts(); strings.Contains(prompts[0], "main does nothing") || !strings.Contains(prompts[0], "func main() {}") {
+		t.Errorf("prompt %q still contains the comment or lost the code", prompts[0])
+	}
+	if got, _ := os.ReadFile(mainPath); string(got) != source {
+		t.Errorf("content of %q = %q, want it untouched", mainPath, got)
+	}
+}
\ No newline at end of file
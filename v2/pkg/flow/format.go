@@ -0,0 +1,52 @@
+package flow
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+	"github.com/zicongmei/ai-coder/v2/pkg/prompt"
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+// hunkHeaderPattern matches the "@@ -a,b +c,d @@" line that starts a unified diff hunk.
+var hunkHeaderPattern = regexp.MustCompile(`(?m)^@@ -\d+(,\d+)? \+\d+(,\d+)? @@`)
+
+// detectFormat guesses the format of an AI response from its shape: prompt.FormatFullText
+// if it has file blocks framed with markers (or the default markers, which a model may
+// fall back to), prompt.FormatSearchReplace if it has search/replace blocks and
+// prompt.FormatDiff if it has unified diff hunks. When several appear, e.g. a full-text
+// file that itself contains a diff, the one that comes first wins. It returns "" if the
+// response has none of them.
+func detectFormat(response string, markers utils.Markers) string {
+	detected, first := "", -1
+	consider := func(format string, index int) {
+		if index >= 0 && (first < 0 || index < first) {
+			detected, first = format, index
+		}
+	}
+	consider(prompt.FormatFullText, strings.Index(response, markers.OrDefault().BeginPrefix))
+	consider(prompt.FormatFullText, strings.Index(response, utils.BeginMarkerPrefix))
+	consider(prompt.FormatSearchReplace, strings.Index(response, utils.SearchMarkerPrefix))
+	if loc := hunkHeaderPattern.FindStringIndex(response); loc != nil {
+		consider(prompt.FormatDiff, loc[0])
+	}
+	return detected
+}
+
+// responseFormat returns the format to apply response in: the requested format (an empty
+// one meaning prompt.FormatFullText), unless the response is recognizably in another one
+// (see detectFormat), as happens when the model ignores the instructions. A mismatch is
+// logged, and the response is routed to the applier for the format it is in.
+func responseFormat(response, requested string, markers utils.Markers) string {
+	if requested == "" {
+		requested = prompt.FormatFullText
+	}
+	detected := detectFormat(response, markers)
+	if detected == "" || detected == requested {
+		return requested
+	}
+	logging.Warningf("The AI response is in the %q format instead of the requested %q; applying it as %q.", detected, requested, detected)
+	logging.Event("format_mismatch", map[string]interface{}{"requested": requested, "detected": detected})
+	return detected
+}
\ No newline at end of file
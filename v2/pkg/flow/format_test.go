@@ -0,0 +1,68 @@
+package flow
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+	"github.com/zicongmei/ai-coder/v2/pkg/prompt"
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+func TestDetectFormat(t *testing.T) {
+	diff := "--- a/a.txt\n+++ b/a.txt\n@@ -1,2 +1,2 @@\n one\n-two\n+TWO\n"
+	tests := []struct {
+		name     string
+		response string
+		markers  utils.Markers
+		want     string
+	}{
+		{"full text", fullTextBlock("a.txt", "one\n"), utils.Markers{}, prompt.FormatFullText},
+		{"nonce markers", utils.NewMarkers("abc").Begin("a.txt") + "one\n" + utils.NewMarkers("abc").End("a.txt"), utils.NewMarkers("abc"), prompt.FormatFullText},
+		{"default markers despite a nonce", fullTextBlock("a.txt", "one\n"), utils.NewMarkers("abc"), prompt.FormatFullText},
+		{"diff", diff, utils.Markers{}, prompt.FormatDiff},
+		{"fenced diff", "Here is the change:\n```diff\n" + diff + "```\n", utils.Markers{}, prompt.FormatDiff},
+		{"search/replace", utils.SearchMarkerPrefix + "a.txt\ntwo\n" + utils.SnippetDivider + "\nTWO\n" + utils.ReplaceMarker + "\n", utils.Markers{}, prompt.FormatSearchReplace},
+		{"full text containing a diff", fullTextBlock("fix.patch", diff), utils.Markers{}, prompt.FormatFullText},
+		{"prose", "I cannot make this change.", utils.Markers{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectFormat(tt.response, tt.markers); got != tt.want {
+				t.Errorf("detectFormat(%q) = %q, want %q", tt.response, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun_FormatMismatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		response func(aPath string) string
+	}{
+		{"diff in full-text mode", prompt.FormatFullText, func(aPath string) string {
+			return "--- a/" + aPath + "\n+++ b/" + aPath + "\n@@ -1,3 +1,3 @@\n one\n-two\n+TWO\n three\n"
+		}},
+		{"full text in diff mode", prompt.FormatDiff, func(aPath string) string {
+			return fullTextBlock(aPath, "one\nTWO\nthree\n")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			listPath := writeFileList(t, dir, map[string]string{"a.txt": "one\ntwo\nthree\n"})
+			aPath := filepath.Join(dir, "a.txt")
+
+			err := Run(context.Background(), mock.NewClient(tt.response(aPath)), Options{FileListPath: listPath, Prompt: "Capitalize two.", Inplace: true, Format: tt.format})
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if got, _ := os.ReadFile(aPath); string(got) != "one\nTWO\nthree\n" {
+				t.Errorf("content of %q = %q, want the response applied in its own format", aPath, got)
+			}
+		})
+	}
+}
\ No newline at end of file
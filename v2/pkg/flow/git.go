@@ -0,0 +1,58 @@
+package flow
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// gitCommand runs git with args in the current directory and returns its standard
+// output. Tests replace it to stub git out.
+var gitCommand = func(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// gitChangedFiles returns the files that differ between ref and the working tree, as
+// listed by `git diff --name-only`, joined to the root of the repository. Deleted files
+// are left out, since there is nothing to read. It is an error if the current directory
+// is not in a git repository or ref does not name a commit.
+func gitChangedFiles(ref string) ([]string, error) {
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("invalid git ref %q", ref)
+	}
+	out, err := gitCommand("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, fmt.Errorf("not in a git repository: %w", err)
+	}
+	root := strings.TrimSpace(string(out))
+	if _, err := gitCommand("rev-parse", "--verify", "--quiet", ref+"^{commit}"); err != nil {
+		return nil, fmt.Errorf("git ref %q does not name a commit: %w", ref, err)
+	}
+	out, err = gitCommand("diff", "--name-only", "--diff-filter=d", ref, "--")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the files changed since %q: %w", ref, err)
+	}
+
+	var paths []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			paths = append(paths, filepath.Join(root, filepath.FromSlash(line)))
+		}
+	}
+	logging.V(1).Infof("Found %d files changed since git ref %q.", len(paths), ref)
+	return paths, nil
+}
\ No newline at end of file
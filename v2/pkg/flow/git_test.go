@@ -0,0 +1,74 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stubGit replaces gitCommand for the duration of the test with one answering from
+// outputs, keyed by the space-separated arguments. Other commands fail.
+func stubGit(t *testing.T, outputs map[string]string) {
+	t.Helper()
+	original := gitCommand
+	t.Cleanup(func() { gitCommand = original })
+	gitCommand = func(args ...string) ([]byte, error) {
+		if out, ok := outputs[strings.Join(args, " ")]; ok {
+			return []byte(out), nil
+		}
+		return nil, errors.New("exit status 128")
+	}
+}
+
+func TestReadFiles_SinceGit(t *testing.T) {
+	dir := t.TempDir()
+	writeFileList(t, dir, map[string]string{"listed.txt": "listed\n", "pkg/changed.go": "changed\n"})
+	stubGit(t, map[string]string{
+		"rev-parse --show-toplevel":                dir + "\n",
+		"rev-parse --verify --quiet main^{commit}": "0123abcd\n",
+		"diff --name-only --diff-filter=d main --": "pkg/changed.go\nlisted.txt\n",
+	})
+
+	got, err := readFiles(context.Background(), Options{Files: []string{filepath.Join(dir, "listed.txt")}, SinceGit: "main"})
+	if err != nil {
+		t.Fatalf("readFiles() error = %v", err)
+	}
+	want := map[string]string{
+		filepath.Join(dir, "listed.txt"):     "listed\n",
+		filepath.Join(dir, "pkg/changed.go"): "changed\n",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readFiles() = %q, want %q", got, want)
+	}
+}
+
+func TestReadFiles_SinceGitErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		ref     string
+		outputs map[string]string
+		wantErr string
+	}{
+		{name: "Not a repository", ref: "main", outputs: map[string]string{}, wantErr: "not in a git repository"},
+		{
+			name:    "Unknown ref",
+			ref:     "nope",
+			outputs: map[string]string{"rev-parse --show-toplevel": dir + "\n"},
+			wantErr: `git ref "nope" does not name a commit`,
+		},
+		{name: "Option as ref", ref: "--output=x", outputs: map[string]string{"rev-parse --show-toplevel": dir + "\n"}, wantErr: "invalid git ref"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubGit(t, tt.outputs)
+			_, err := readFiles(context.Background(), Options{SinceGit: tt.ref})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("readFiles() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
\ No newline at end of file
@@ -0,0 +1,104 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// hasGlobMeta reports whether p contains glob metacharacters.
+func hasGlobMeta(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// expandGlob returns the regular files matching pattern, in sorted order.
+// Each path segment is matched with path.Match, and a "**" segment matches zero or more
+// directories, so "pkg/**/*.go" matches Go files at any depth below pkg.
+// A pattern whose directory prefix does not exist matches nothing.
+func expandGlob(pattern string) ([]string, error) {
+	if err := validateGlob(pattern); err != nil {
+		return nil, err
+	}
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+
+	// Walk only below the longest prefix without metacharacters.
+	fixed := 0
+	for fixed < len(segments)-1 && !hasGlobMeta(segments[fixed]) {
+		fixed++
+	}
+	root := strings.Join(segments[:fixed], "/")
+	if root == "" && fixed > 0 {
+		root = "/" // Absolute pattern such as "/*.go"
+	} else if root == "" {
+		root = "."
+	}
+	root = filepath.FromSlash(root)
+	rest := segments[fixed:]
+
+	var matches []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if matchSegments(rest, strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand glob %q: %w", pattern, err)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// validateGlob reports a malformed segment of pattern.
+func validateGlob(pattern string) error {
+	for _, segment := range strings.Split(filepath.ToSlash(pattern), "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether name matches pattern, with "**" matching any number of
+// directories as in expandGlob. The pattern must have passed validateGlob.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(filepath.ToSlash(pattern), "/"), strings.Split(filepath.ToSlash(name), "/"))
+}
+
+// matchSegments reports whether the path segments in name match the pattern segments.
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	// Errors were ruled out when the pattern was validated.
+	ok, _ := path.Match(pattern[0], name[0])
+	return ok && matchSegments(pattern[1:], name[1:])
+}
\ No newline at end of file
@@ -0,0 +1,140 @@
+package flow
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTree creates empty files at the given slash-separated paths below dir.
+func writeTree(t *testing.T, dir string, paths ...string) {
+	t.Helper()
+	for _, p := range paths {
+		path := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory for %q: %v", path, err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+	}
+}
+
+func TestExpandGlob(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "main.go", "README.md", "pkg/a.go", "pkg/a_test.go", "pkg/sub/b.go", "pkg/sub/c.txt")
+	t.Chdir(dir)
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{pattern: "*.go", want: []string{"main.go"}},
+		{pattern: "pkg/*.go", want: []string{"pkg/a.go", "pkg/a_test.go"}},
+		{pattern: "**/*.go", want: []string{"main.go", "pkg/a.go", "pkg/a_test.go", "pkg/sub/b.go"}},
+		{pattern: "pkg/**/*.go", want: []string{"pkg/a.go", "pkg/a_test.go", "pkg/sub/b.go"}},
+		{pattern: "pkg/**", want: []string{"pkg/a.go", "pkg/a_test.go", "pkg/sub/b.go", "pkg/sub/c.txt"}},
+		{pattern: "*.rs", want: nil},
+		{pattern: "missing/**/*.go", want: nil},
+	}
+	for _, tt := range tests {
+		got, err := expandGlob(tt.pattern)
+		if err != nil {
+			t.Errorf("expandGlob(%q) error = %v", tt.pattern, err)
+			continue
+		}
+		var want []string
+		for _, p := range tt.want {
+			want = append(want, filepath.FromSlash(p))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expandGlob(%q) = %q, want %q", tt.pattern, got, want)
+		}
+	}
+
+	// Absolute patterns return absolute paths.
+	got, err := expandGlob(filepath.Join(dir, "pkg", "sub", "*.go"))
+	if err != nil {
+		t.Fatalf("expandGlob() with an absolute pattern error = %v", err)
+	}
+	if want := []string{filepath.Join(dir, "pkg", "sub", "b.go")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expandGlob() with an absolute pattern = %q, want %q", got, want)
+	}
+
+	if _, err := expandGlob("pkg/[a.go"); err == nil {
+		t.Error("expandGlob() with a malformed pattern returned no error")
+	}
+}
+
+func TestListFiles_Globs(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "main.go", "pkg/a.go", "pkg/sub/b.go", "[literal].go")
+	t.Chdir(dir)
+	list := "main.go\n**/*.go\n\"[literal].go\"\n"
+	if err := os.WriteFile("file_list.txt", []byte(list), 0644); err != nil {
+		t.Fatalf("Failed to write file list: %v", err)
+	}
+
+	// Duplicates between literal entries and glob matches are dropped; quoted entries are literal.
+	got, err := listFiles(Options{FileListPath: "file_list.txt"})
+	if err != nil {
+		t.Fatalf("listFiles() error = %v", err)
+	}
+	want := []string{"main.go", "[literal].go", filepath.Join("pkg", "a.go"), filepath.Join("pkg", "sub", "b.go")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("listFiles() = %q, want %q", got, want)
+	}
+
+	// A glob that matches nothing is an error unless SkipMissing is set.
+	if err := os.WriteFile("file_list.txt", []byte("main.go\n*.rs\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file list: %v", err)
+	}
+	if _, err := listFiles(Options{FileListPath: "file_list.txt"}); err == nil || !strings.Contains(err.Error(), "*.rs") {
+		t.Errorf("listFiles() error = %v, want it to name the unmatched glob", err)
+	}
+	got, err = listFiles(Options{FileListPath: "file_list.txt", SkipMissing: true})
+	if err != nil {
+		t.Fatalf("listFiles() with SkipMissing error = %v", err)
+	}
+	if want := []string{"main.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listFiles() with SkipMissing = %q, want %q", got, want)
+	}
+}
+
+func TestReadFiles_GlobsWithExcludes(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "main.go", "main_test.go", "pkg/a.go", "pkg/a_test.go", "pkg/testdata/fixture.go", "pkg/sub/b.go")
+	t.Chdir(dir)
+	if err := os.WriteFile("file_list.txt", []byte("**/*.go\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file list: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		excludes []string
+		want     []string
+	}{
+		{name: "No excludes", want: []string{"main.go", "main_test.go", "pkg/a.go", "pkg/a_test.go", "pkg/sub/b.go", "pkg/testdata/fixture.go"}},
+		{name: "Test files by base name", excludes: []string{"*_test.go"}, want: []string{"main.go", "pkg/a.go", "pkg/sub/b.go", "pkg/testdata/fixture.go"}},
+		{name: "Recursive exclude", excludes: []string{"pkg/**/testdata/**", "*_test.go"}, want: []string{"main.go", "pkg/a.go", "pkg/sub/b.go"}},
+		{name: "Whole directory", excludes: []string{"pkg/**"}, want: []string{"main.go", "main_test.go"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readFiles(context.Background(), Options{FileListPath: "file_list.txt", Excludes: tt.excludes})
+			if err != nil {
+				t.Fatalf("readFiles() error = %v", err)
+			}
+			var want []string
+			for _, p := range tt.want {
+				want = append(want, filepath.FromSlash(p))
+			}
+			if paths := sortedPaths(got); !reflect.DeepEqual(paths, want) {
+				t.Errorf("readFiles() read %q, want %q", paths, want)
+			}
+		})
+	}
+}
\ No newline at end of file
@@ -0,0 +1,89 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// FileResult is the outcome of the run for one file by RunPerFile.
+type FileResult struct {
+	Path string
+	Err  error // nil if the file's run succeeded
+}
+
+// RunPerFile runs Run once for each file of opts (its file list, Files and SinceGit,
+// without the Excludes), with that file alone, so an instruction that applies to every
+// file independently, such as adding a license header, gets one small prompt per file.
+// Up to concurrency runs (at least one) are in flight at once. A failed file is reported
+// and the other files still run. The returned error is nil if every file succeeded, and
+// otherwise joins the failures in file order (keeping their categories). Interactive,
+// AutoSelect and JSONResult are not supported, and no progress indicator is drawn.
+func RunPerFile(ctx context.Context, aiEngine aiEndpoint.AIEngine, opts Options, concurrency int) ([]FileResult, error) {
+	if opts.Interactive || opts.AutoSelect || opts.JSONResult != nil {
+		return nil, categorize(ErrConfig, errors.New("running each file separately does not support interactive mode, file auto-selection or a JSON result"))
+	}
+	paths, err := listFiles(opts)
+	if err == nil {
+		paths, err = excludePaths(paths, opts.Excludes)
+	}
+	if err != nil {
+		logging.Errorf("Failed to list files (list %q, files %q): %v", opts.FileListPath, opts.Files, err)
+		return nil, categorize(ErrConfig, fmt.Errorf("failed to list files: %w", err))
+	}
+	if len(paths) == 0 {
+		return nil, categorize(ErrConfig, errors.New("no files to process"))
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	logging.V(0).Infof("Running the prompt on %d files separately, %d at a time.", len(paths), concurrency)
+
+	results := make([]FileResult, len(paths))
+	undo := &undoRecorder{}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		fileOpts := opts
+		fileOpts.FileListPath = ""
+		fileOpts.Files = []string{path}
+		fileOpts.SinceGit = ""
+		fileOpts.Progress = nil // Concurrent spinners would overwrite each other
+		fileOpts.dumpTag = fmt.Sprintf("_file%d", i+1)
+		fileOpts.undo = undo
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := Run(ctx, aiEngine, fileOpts)
+			results[i] = FileResult{Path: path, Err: err}
+			if err != nil {
+				logging.Errorf("File %d/%d (%q) failed: %v", i+1, len(paths), path, err)
+				logging.ErrorEvent("file_run_failed", err, map[string]interface{}{"path": path})
+				return
+			}
+			logging.V(0).Infof("File %d/%d (%q) succeeded.", i+1, len(paths), path)
+			logging.Event("file_run_completed", map[string]interface{}{"path": path})
+		}()
+	}
+	wg.Wait()
+	undo.save()
+
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", result.Path, result.Err))
+		}
+	}
+	logging.V(0).Infof("%d of %d files succeeded.", len(paths)-len(errs), len(paths))
+	if len(errs) > 0 {
+		return results, fmt.Errorf("%d of %d files failed: %w", len(errs), len(paths), errors.Join(errs...))
+	}
+	return results, nil
+}
\ No newline at end of file
@@ -0,0 +1,139 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+)
+
+// headerClient is a mock engine that answers a prompt with the file it contains,
+// prefixed with a header, or with garbage for the files in fail.
+type headerClient struct {
+	*mock.Client
+	contents map[string]string
+	fail     map[string]bool
+}
+
+func (c *headerClient) SendConversation(ctx context.Context, history []aiEndpoint.Message) (string, error) {
+	if _, err := c.Client.SendConversation(ctx, history); err != nil {
+		return "", err
+	}
+	var response strings.Builder
+	for path, content := range c.contents {
+		if !strings.Contains(history[0].Text, path) {
+			continue
+		}
+		if c.fail[path] {
+			return "garbage", nil
+		}
+		response.WriteString(fullTextBlock(path, "// License\n"+content))
+	}
+	return response.String(), nil
+}
+
+func TestRunPerFile(t *testing.T) {
+	dir := t.TempDir()
+	contents := map[string]string{"a.go": "package a\n", "b.go": "package b\n", "c.go": "package c\n"}
+	listPath := writeFileList(t, dir, contents)
+	absContents := make(map[string]string)
+	for name, content := range contents {
+		absContents[filepath.Join(dir, name)] = content
+	}
+
+	engine := &headerClient{Client: &mock.Client{}, contents: absContents}
+	results, err := RunPerFile(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Add a license header.", Inplace: true}, 2)
+	if err != nil {
+		t.Fatalf("RunPerFile() error = %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("RunPerFile() returned %d results, want 3", len(results))
+	}
+	for path, content := range absContents {
+		if got, _ := os.ReadFile(path); string(got) != "// License\n"+content {
+			t.Errorf("content of %q = %q, want the header added", path, got)
+		}
+	}
+	// Each prompt holds exactly one of the files.
+	prompts := engine.Prompts()
+	if len(prompts) != 3 {
+		t.Fatalf("engine received %d prompts, want 3", len(prompts))
+	}
+	for _, prompt := range prompts {
+		count := 0
+		for path := range absContents {
+			if strings.Contains(prompt, path) {
+				count++
+			}
+		}
+		if count != 1 {
+			t.Errorf("prompt contains %d of the files, want 1:\n%s", count, prompt)
+		}
+	}
+}
+
+func TestRunPerFile_AggregatesErrors(t *testing.T) {
+	dir := t.TempDir()
+	contents := map[string]string{"a.go": "package a\n", "b.go": "package b\n", "c.go": "package c\n"}
+	listPath := writeFileList(t, dir, contents)
+	absContents := make(map[string]string)
+	for name, content := range contents {
+		absContents[filepath.Join(dir, name)] = content
+	}
+	aPath, cPath := filepath.Join(dir, "a.go"), filepath.Join(dir, "c.go")
+
+	engine := &headerClient{Client: &mock.Client{}, contents: absContents, fail: map[string]bool{aPath: true, cPath: true}}
+	results, err := RunPerFile(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Add a license header.", Inplace: true}, 3)
+	if !errors.Is(err, ErrApply) {
+		t.Fatalf("RunPerFile() error = %v, want ErrApply", err)
+	}
+	if !strings.Contains(err.Error(), "2 of 3 files failed") || !strings.Contains(err.Error(), aPath) || !strings.Contains(err.Error(), cPath) {
+		t.Errorf("RunPerFile() error = %v, want both failed files named", err)
+	}
+	for _, result := range results {
+		if failed := result.Path == aPath || result.Path == cPath; failed != (result.Err != nil) {
+			t.Errorf("result for %q has error %v, want failed = %t", result.Path, result.Err, failed)
+		}
+	}
+	// The other file is still changed.
+	bPath := filepath.Join(dir, "b.go")
+	if got, _ := os.ReadFile(bPath); string(got) != "// License\npackage b\n" {
+		t.Errorf("content of %q = %q, want the header added", bPath, got)
+	}
+}
+
+func TestRunPerFile_UndoneTogether(t *testing.T) {
+	manifests := t.TempDir()
+	defer func(orig func() string) { undoDir = orig }(undoDir)
+	undoDir = func() string { return manifests }
+
+	dir := t.TempDir()
+	contents := map[string]string{"a.go": "package a\n", "b.go": "package b\n", "c.go": "package c\n"}
+	listPath := writeFileList(t, dir, contents)
+	absContents := make(map[string]string)
+	for name, content := range contents {
+		absContents[filepath.Join(dir, name)] = content
+	}
+
+	engine := &headerClient{Client: &mock.Client{}, contents: absContents}
+	if _, err := RunPerFile(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Add a license header.", Inplace: true}, 2); err != nil {
+		t.Fatalf("RunPerFile() error = %v", err)
+	}
+	if saved, _ := filepath.Glob(filepath.Join(manifests, undoManifestPattern)); len(saved) != 1 {
+		t.Fatalf("RunPerFile() saved undo manifests %q, want one for the invocation", saved)
+	}
+
+	if err := Undo(); err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	for path, content := range absContents {
+		if got, _ := os.ReadFile(path); string(got) != content {
+			t.Errorf("content of %q after Undo = %q, want %q", path, got, content)
+		}
+	}
+}
\ No newline at end of file
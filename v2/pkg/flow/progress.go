@@ -0,0 +1,48 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// progressInterval is how often the progress indicator is redrawn.
+const progressInterval = 100 * time.Millisecond
+
+// spinnerFrames are drawn in turn by the progress indicator.
+var spinnerFrames = []rune{'|', '/', '-', '\\'}
+
+// clearLine returns the cursor to the start of the line and erases it.
+const clearLine = "\r\033[K"
+
+// startProgress draws a spinner with the elapsed time on w until the returned stop
+// function is called, which erases the indicator and waits for the drawing goroutine
+// to exit. A nil w disables the indicator. w should be a terminal.
+func startProgress(w io.Writer, model string) (stop func()) {
+	if w == nil {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for frame := 0; ; frame++ {
+			elapsed := time.Since(start).Truncate(time.Second)
+			fmt.Fprintf(w, "%s%c Waiting for %s... %s", clearLine, spinnerFrames[frame%len(spinnerFrames)], model, elapsed)
+			select {
+			case <-ctx.Done():
+				fmt.Fprint(w, clearLine)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
\ No newline at end of file
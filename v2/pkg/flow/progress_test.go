@@ -0,0 +1,97 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+)
+
+func TestStartProgress(t *testing.T) {
+	var out bytes.Buffer
+	stop := startProgress(&out, "test-model")
+	time.Sleep(3 * progressInterval)
+	stop()
+
+	got := out.String()
+	if !strings.Contains(got, "Waiting for test-model... 0s") {
+		t.Errorf("progress output %q does not show the model and elapsed time", got)
+	}
+	if strings.Count(got, "Waiting for") < 2 {
+		t.Errorf("progress output %q was not redrawn while waiting", got)
+	}
+	if !strings.HasSuffix(got, clearLine) {
+		t.Errorf("progress output %q does not end by clearing the line", got)
+	}
+
+	// A nil writer disables the indicator.
+	startProgress(nil, "test-model")()
+}
+
+// slowClient is a mock engine that takes a while to respond, unless ctx is canceled first.
+type slowClient struct {
+	*mock.Client
+	delay time.Duration
+}
+
+func (c *slowClient) SendConversation(ctx context.Context, history []aiEndpoint.Message) (string, error) {
+	select {
+	case <-time.After(c.delay):
+	case <-ctx.Done():
+		return "", fmt.Errorf("slow: %w: %w", aiEndpoint.ErrTimeout, ctx.Err())
+	}
+	return c.Client.SendConversation(ctx, history)
+}
+
+func TestRun_Progress(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+	aPath := filepath.Join(dir, "a.txt")
+
+	var progress bytes.Buffer
+	engine := &slowClient{Client: mock.NewClient(fullTextBlock(aPath, "new\n")), delay: 2 * progressInterval}
+	if err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, Progress: &progress}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := progress.String(); !strings.Contains(got, "Waiting for "+mock.DefaultModelName) || !strings.HasSuffix(got, clearLine) {
+		t.Errorf("progress output = %q, want a cleared indicator for %q", got, mock.DefaultModelName)
+	}
+}
+
+func TestRun_Canceled(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+	aPath := filepath.Join(dir, "a.txt")
+	engine := &slowClient{Client: mock.NewClient(fullTextBlock(aPath, "new\n")), delay: time.Minute}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	err := Run(ctx, engine, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true})
+	if !errors.Is(err, ErrAI) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error = %v, want an ErrAI wrapping context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run() returned after %v, want it to abort promptly on cancellation", elapsed)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "old\n" {
+		t.Errorf("a.txt = %q after a canceled run, want it unchanged", got)
+	}
+
+	// A run whose context is already canceled reads no files and sends nothing.
+	canceled := mock.NewClient(fullTextBlock(aPath, "new\n"))
+	if err := Run(ctx, canceled, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() with a canceled context error = %v, want context.Canceled", err)
+	}
+	if prompts := canceled.Prompts(); len(prompts) != 0 {
+		t.Errorf("Run() with a canceled context sent %d prompts, want none", len(prompts))
+	}
+}
\ No newline at end of file
@@ -0,0 +1,47 @@
+package flow
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// promptRefPattern matches an "@path" token at the start of the prompt or after
+// whitespace or an opening bracket, so e-mail addresses are not taken for references.
+var promptRefPattern = regexp.MustCompile(`(?:^|[\s(\[{"'])@([^\s@]+)`)
+
+// PromptFileRefs returns the files referenced in prompt as "@path", e.g. "@main.go" or
+// "@pkg/flow/flow.go", in order and without duplicates. Trailing punctuation is not part
+// of the path, and a token with neither a "." nor a "/" (such as "@deprecated") is not a
+// file reference. The references are left in the prompt, where they still read naturally.
+func PromptFileRefs(prompt string) []string {
+	var refs []string
+	seen := make(map[string]bool)
+	for _, m := range promptRefPattern.FindAllStringSubmatch(prompt, -1) {
+		path := strings.TrimRight(m[1], ".,;:!?)]}\"'`")
+		if !strings.ContainsAny(path, "./") || seen[path] {
+			continue
+		}
+		seen[path] = true
+		refs = append(refs, path)
+	}
+	return refs
+}
+
+// checkPromptFileRefs returns an error naming the first of refs that is not an existing
+// regular file.
+func checkPromptFileRefs(refs []string) error {
+	for _, ref := range refs {
+		info, err := os.Stat(ref)
+		switch {
+		case os.IsNotExist(err):
+			return fmt.Errorf("file %q referenced as @%s in the prompt does not exist", ref, ref)
+		case err != nil:
+			return fmt.Errorf("file %q referenced as @%s in the prompt: %w", ref, ref, err)
+		case info.IsDir():
+			return fmt.Errorf("@%s in the prompt is a directory; reference files only", ref)
+		}
+	}
+	return nil
+}
\ No newline at end of file
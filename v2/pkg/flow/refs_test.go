@@ -0,0 +1,62 @@
+package flow
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+)
+
+func TestPromptFileRefs(t *testing.T) {
+	tests := []struct {
+		prompt string
+		want   []string
+	}{
+		{"Refactor @main.go to use @config.go.", []string{"main.go", "config.go"}},
+		{"@pkg/flow/flow.go: rename Run (see @pkg/flow/flow.go)", []string{"pkg/flow/flow.go"}},
+		{"Mark it @deprecated and mail me@example.com.", nil},
+		{"No references here.", nil},
+	}
+	for _, tt := range tests {
+		if got := PromptFileRefs(tt.prompt); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("PromptFileRefs(%q) = %q, want %q", tt.prompt, got, tt.want)
+		}
+	}
+}
+
+func TestRun_PromptFileRefs(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"main.go": "package main\n", "config.go": "package main\n\nvar port = 80\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", name, err)
+		}
+	}
+	t.Chdir(dir)
+
+	engine := mock.NewClient(fullTextBlock("main.go", "package main\n\nfunc main() { println(port) }\n"))
+	err := Run(context.Background(), engine, Options{Prompt: "Refactor @main.go to use @config.go.", Inplace: true})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	prompts := engine.Prompts()
+	if len(prompts) != 1 {
+		t.Fatalf("engine received %d prompts, want 1", len(prompts))
+	}
+	for _, want := range []string{"Refactor @main.go to use @config.go.", "var port = 80"} {
+		if !strings.Contains(prompts[0], want) {
+			t.Errorf("prompt does not contain %q:\n%s", want, prompts[0])
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "main.go")); string(got) != "package main\n\nfunc main() { println(port) }\n" {
+		t.Errorf("main.go = %q, want the response written", got)
+	}
+
+	err = Run(context.Background(), engine, Options{Prompt: "Update @missing.go.", Inplace: true})
+	if err == nil || !strings.Contains(err.Error(), `"missing.go" referenced as @missing.go`) {
+		t.Errorf("Run() with a missing reference error = %v, want it named", err)
+	}
+}
\ No newline at end of file
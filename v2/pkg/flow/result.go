@@ -0,0 +1,102 @@
+package flow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/modifyFiles"
+)
+
+// Result is the machine-readable description of a run written to Options.JSONResult.
+type Result struct {
+	Time        time.Time    `json:"time"`            // When the run started
+	Prompt      string       `json:"prompt"`          // The user prompt
+	PromptHash  string       `json:"prompt_sha256"`   // Hex SHA-256 digest of the prompt, to match runs without comparing text
+	Model       string       `json:"model"`           // Model the prompt was sent to
+	InputTokens int          `json:"input_tokens"`    // Token count of the first prompt, 0 if unknown
+	Changes     []FileChange `json:"changes"`         // Files written, created or deleted in place
+	Error       string       `json:"error,omitempty"` // Why the run failed, empty on success
+}
+
+// FileChange describes one file touched by a run. Hashes are hex SHA-256 digests
+// of the file content before and after the change; a missing side is omitted.
+type FileChange struct {
+	Path    string `json:"path"`
+	Action  string `json:"action"` // "modified", "created" or "deleted"
+	OldHash string `json:"old_sha256,omitempty"`
+	NewHash string `json:"new_sha256,omitempty"`
+	Fuzzy   bool   `json:"fuzzy,omitempty"` // Some of the diff was placed by fuzzy matching
+}
+
+// newResult starts the Result of a run of prompt against model that started at start.
+func newResult(start time.Time, prompt, model string) *Result {
+	sum := sha256.Sum256([]byte(prompt))
+	return &Result{Time: start.UTC(), Prompt: prompt, PromptHash: hex.EncodeToString(sum[:]), Model: model}
+}
+
+// hashFiles returns the SHA-256 digest of each readable file in paths, keyed by absolute path.
+func hashFiles(paths []string) map[string]string {
+	hashes := make(map[string]string, len(paths))
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		if hash, ok := hashFile(abs); ok {
+			hashes[abs] = hash
+		}
+	}
+	return hashes
+}
+
+// hashFile returns the SHA-256 digest of the file at path, or false if it cannot be read.
+func hashFile(path string) (string, bool) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), true
+}
+
+// addApplyResult records the files touched by one applied response, using before
+// (see hashFiles) for the hashes of the files as they were prior to the apply.
+func (r *Result) addApplyResult(applied modifyFiles.ApplyResult, before map[string]string) {
+	add := func(action string, paths []string) {
+		for _, path := range paths {
+			change := FileChange{Path: path, Action: action, Fuzzy: slices.Contains(applied.Fuzzy, path)}
+			if abs, err := filepath.Abs(path); err == nil {
+				change.OldHash = before[abs]
+			}
+			if action != "deleted" {
+				change.NewHash, _ = hashFile(path)
+			}
+			r.Changes = append(r.Changes, change)
+		}
+	}
+	add("modified", applied.Modified)
+	add("created", applied.Created)
+	add("deleted", applied.Deleted)
+}
+
+// write marshals the result as indented JSON to w.
+func (r *Result) write(w io.Writer) error {
+	if r.Changes == nil {
+		r.Changes = []FileChange{}
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal run result: %w", err)
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write run result: %w", err)
+	}
+	return nil
+}
\ No newline at end of file
@@ -0,0 +1,80 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+	"github.com/zicongmei/ai-coder/v2/pkg/prompt"
+)
+
+func TestRun_JSONResult(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+	aPath := filepath.Join(dir, "a.txt")
+
+	var out bytes.Buffer
+	engine := mock.NewClient(fullTextBlock(aPath, "new\n"))
+	if err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, JSONResult: &out}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	var got Result
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("JSON result %q does not parse: %v", out.String(), err)
+	}
+	if got.Prompt != "Update." || got.PromptHash != sha256Hex("Update.") || got.Model != mock.DefaultModelName || got.InputTokens == 0 || got.Error != "" {
+		t.Errorf("result = %+v, want the prompt, its hash, model and token count of a successful run", got)
+	}
+	if got.Time.IsZero() || time.Since(got.Time) > time.Minute {
+		t.Errorf("result time = %v, want the start of the run", got.Time)
+	}
+	want := []FileChange{{Path: aPath, Action: "modified", OldHash: sha256Hex("old\n"), NewHash: sha256Hex("new\n")}}
+	if len(got.Changes) != 1 || got.Changes[0] != want[0] {
+		t.Errorf("changes = %+v, want %+v", got.Changes, want)
+	}
+
+	// A failed run still produces a document, naming the error.
+	out.Reset()
+	if err := Run(context.Background(), mock.NewClient("garbage"), Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, JSONResult: &out}); err == nil {
+		t.Fatal("Run() on a malformed response succeeded, want an error")
+	}
+	got = Result{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("JSON result %q does not parse: %v", out.String(), err)
+	}
+	if got.Error == "" || got.Changes == nil || len(got.Changes) != 0 {
+		t.Errorf("result = %+v, want an error and an empty change list", got)
+	}
+}
+
+func TestRun_JSONResultFuzzy(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "one\n\ntwo\n"})
+	aPath := filepath.Join(dir, "a.txt")
+
+	var out bytes.Buffer
+	diff := "--- a/" + aPath + "\n+++ b/" + aPath + "\n@@ -1,2 +1,2 @@\n one\n-two\n+2\n"
+	opts := Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, Format: prompt.FormatDiff, Fuzzy: true, JSONResult: &out}
+	if err := Run(context.Background(), mock.NewClient(diff), opts); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	var got Result
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("JSON result %q does not parse: %v", out.String(), err)
+	}
+	if len(got.Changes) != 1 || !got.Changes[0].Fuzzy {
+		t.Errorf("changes = %+v, want one change marked fuzzy", got.Changes)
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
\ No newline at end of file
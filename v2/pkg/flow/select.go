@@ -0,0 +1,114 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+	"github.com/zicongmei/ai-coder/v2/pkg/prompt"
+)
+
+// selectFiles asks the model which of the files in fileContents are relevant to
+// opts.Prompt, sending only their paths (see prompt.GenerateFileSelectionPrompt). It
+// returns the selected paths, or nil to keep every file: when there is nothing to
+// choose from or when the response names none of the files. Returned errors are tagged
+// with ErrAI.
+func selectFiles(ctx context.Context, aiEngine aiEndpoint.AIEngine, opts Options, fileContents map[string]string) (map[string]bool, error) {
+	paths := sortedPaths(fileContents)
+	if len(paths) < 2 {
+		return nil, nil
+	}
+	logging.V(0).Infof("Asking the AI which of the %d files are relevant to the task.", len(paths))
+	selectionPrompt := prompt.GenerateFileSelectionPrompt(opts.Prompt, paths)
+	dumpPath := filepath.Join(os.TempDir(), fmt.Sprintf("ai_file_selection_%s%s", time.Now().Format("20060102_150405"), dumpExt(opts.CompressDumps)))
+	conversation := []aiEndpoint.Message{{Role: aiEndpoint.RoleUser, Text: selectionPrompt}}
+	response, err := sendConversation(ctx, aiEngine, conversation, dumpPath, opts.Progress, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := parseSelectedFiles(response, paths)
+	if len(selected) == 0 {
+		logging.Warningf("The AI selected none of the %d files; sending all of them.", len(paths))
+		return nil, nil
+	}
+	logging.V(0).Infof("The AI selected %d of %d files.", len(selected), len(paths))
+	logging.Event("files_selected", map[string]interface{}{"count": len(selected), "total": len(paths)})
+	return selected, nil
+}
+
+// parseSelectedFiles reads the paths listed one per line in response, as requested by
+// prompt.GenerateFileSelectionPrompt, and returns those that are among candidates.
+// Markdown list bullets, numbering, quotes and code fences around the paths are
+// ignored. A line that is not a candidate but is the trailing part of exactly one
+// candidate, e.g. a relative path, selects that candidate; other lines are skipped.
+func parseSelectedFiles(response string, candidates []string) map[string]bool {
+	known := make(map[string]bool, len(candidates))
+	for _, path := range candidates {
+		known[path] = true
+	}
+	selected := make(map[string]bool)
+	for _, line := range strings.Split(response, "\n") {
+		path := trimListItem(line)
+		if path == "" || strings.HasPrefix(path, "```") {
+			continue
+		}
+		if known[path] {
+			selected[path] = true
+			continue
+		}
+		if match, ok := uniqueSuffixMatch(path, candidates); ok {
+			selected[match] = true
+			continue
+		}
+		logging.V(1).Infof("Ignoring line %q of the file selection: it is not one of the files.", line)
+	}
+	return selected
+}
+
+// trimListItem strips surrounding whitespace, a markdown bullet or number and quotes
+// from a line of the file selection response.
+func trimListItem(line string) string {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "```") {
+		return line
+	}
+	line = strings.TrimLeft(line, "-*+ \t")
+	if i := strings.IndexAny(line, ".)"); i > 0 && strings.HasPrefix(line[i+1:], " ") && strings.Trim(line[:i], "0123456789") == "" {
+		line = strings.TrimSpace(line[i+1:])
+	}
+	return strings.Trim(line, "`'\" \t")
+}
+
+// uniqueSuffixMatch returns the only candidate ending in path at a directory boundary.
+func uniqueSuffixMatch(path string, candidates []string) (string, bool) {
+	path = strings.TrimPrefix(filepath.ToSlash(path), "./")
+	match := ""
+	for _, candidate := range candidates {
+		if strings.HasSuffix(filepath.ToSlash(candidate), "/"+path) {
+			if match != "" {
+				return "", false
+			}
+			match = candidate
+		}
+	}
+	return match, match != ""
+}
+
+// keepSelected removes from fileContents the files that are not in selected. A nil
+// selected keeps every file.
+func keepSelected(fileContents map[string]string, selected map[string]bool) {
+	if selected == nil {
+		return
+	}
+	for path := range fileContents {
+		if !selected[path] {
+			delete(fileContents, path)
+		}
+	}
+}
\ No newline at end of file
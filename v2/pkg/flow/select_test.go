@@ -0,0 +1,84 @@
+package flow
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+)
+
+func TestParseSelectedFiles(t *testing.T) {
+	candidates := []string{"/src/a.go", "/src/pkg/b.go", "/src/pkg/10.go", "/other/pkg/b.go", "/src/c.go"}
+	tests := []struct {
+		name     string
+		response string
+		want     map[string]bool
+	}{
+		{name: "Plain lines", response: "/src/a.go\n/src/c.go\n", want: map[string]bool{"/src/a.go": true, "/src/c.go": true}},
+		{name: "Markdown list in a fence", response: "```\n- `/src/a.go`\n* /src/pkg/b.go\n```\n", want: map[string]bool{"/src/a.go": true, "/src/pkg/b.go": true}},
+		{name: "Numbered", response: "1. /src/c.go\n2) \"/src/pkg/10.go\"\n", want: map[string]bool{"/src/c.go": true, "/src/pkg/10.go": true}},
+		{name: "Unique relative path", response: "a.go\n./src/c.go\n", want: map[string]bool{"/src/a.go": true, "/src/c.go": true}},
+		{name: "Ambiguous relative path", response: "pkg/b.go\n", want: map[string]bool{}},
+		{name: "Unknown files and chatter", response: "Here you go:\n/src/missing.go\n", want: map[string]bool{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSelectedFiles(tt.response, candidates); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSelectedFiles(%q) = %v, want %v", tt.response, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun_AutoSelect(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{
+		"main.go":   "package main\n",
+		"util.go":   "package main\n\nfunc util() {}\n",
+		"readme.md": "# Notes\n",
+	})
+	mainPath := filepath.Join(dir, "main.go")
+	utilPath := filepath.Join(dir, "util.go")
+
+	engine := &mock.Client{Responses: []string{
+		"- main.go\n",
+		fullTextBlock(mainPath, "package main\n\nfunc main() {}\n"),
+	}}
+	err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Add a main function.", Inplace: true, AutoSelect: true})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	prompts := engine.Prompts()
+	if len(prompts) != 2 {
+		t.Fatalf("engine received %d prompts, want 2 (selection and main)", len(prompts))
+	}
+	if !strings.Contains(prompts[0], utilPath) || strings.Contains(prompts[0], "func util()") {
+		t.Errorf("selection prompt %q should list the paths without the contents", prompts[0])
+	}
+	if !strings.Contains(prompts[1], mainPath) || strings.Contains(prompts[1], utilPath) || strings.Contains(prompts[1], "# Notes") {
+		t.Errorf("main prompt %q should include only the selected file", prompts[1])
+	}
+	if got, _ := os.ReadFile(mainPath); string(got) != "package main\n\nfunc main() {}\n" {
+		t.Errorf("content of %q = %q, want the AI's version", mainPath, got)
+	}
+}
+
+func TestRun_AutoSelectNoneSendsAll(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.go": "package a\n", "b.go": "package b\n"})
+
+	engine := &mock.Client{Responses: []string{"I am not sure.", "Nothing to do."}}
+	err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Explain.", AutoSelect: true, OutPath: filepath.Join(dir, "out.txt"), NoOpen: true})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	prompts := engine.Prompts()
+	if len(prompts) != 2 || !strings.Contains(prompts[1], "package a") || !strings.Contains(prompts[1], "package b") {
+		t.Errorf("prompts = %q, want the main prompt to include every file", prompts)
+	}
+}
\ No newline at end of file
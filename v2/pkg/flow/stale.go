@@ -0,0 +1,72 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// Modes accepted by Options.CheckStale.
+const (
+	CheckStaleOff   = "off"   // Do not check whether files changed while waiting for the AI
+	CheckStaleWarn  = "warn"  // Log changed files and apply the response anyway
+	CheckStaleAbort = "abort" // Refuse to apply the response if any file changed
+)
+
+// ErrStale is returned, together with ErrApply, when Options.CheckStale is CheckStaleAbort
+// and a file changed on disk between reading it for the prompt and applying the response.
+var ErrStale = errors.New("files changed on disk since the prompt was generated")
+
+// ValidateCheckStale returns an error if mode is not a known stale check mode.
+// An empty mode is treated as CheckStaleOff.
+func ValidateCheckStale(mode string) error {
+	switch mode {
+	case "", CheckStaleOff, CheckStaleWarn, CheckStaleAbort:
+		return nil
+	}
+	return fmt.Errorf("unknown stale check mode %q (want %q, %q or %q)", mode, CheckStaleOff, CheckStaleWarn, CheckStaleAbort)
+}
+
+// staleCheckHashes records the hashes of the files sent to the model, or returns nil
+// when opts.CheckStale disables the check or the files are not modified in place.
+func staleCheckHashes(opts Options, fileContents map[string]string) map[string]string {
+	if !opts.Inplace || opts.CheckStale == "" || opts.CheckStale == CheckStaleOff {
+		return nil
+	}
+	return hashFiles(sortedPaths(fileContents))
+}
+
+// staleFiles returns, in sorted order, the files whose current content no longer matches
+// the hashes recorded when they were read (see hashFiles). Deleted files are stale too.
+func staleFiles(readHashes map[string]string) []string {
+	var stale []string
+	for path, hash := range readHashes {
+		if current, ok := hashFile(path); !ok || current != hash {
+			stale = append(stale, path)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// checkStale compares the files against readHashes according to mode, logging every
+// changed file. It returns an error wrapping ErrStale only in CheckStaleAbort mode.
+func checkStale(mode string, readHashes map[string]string) error {
+	if mode == "" || mode == CheckStaleOff {
+		return nil
+	}
+	stale := staleFiles(readHashes)
+	if len(stale) == 0 {
+		return nil
+	}
+	for _, path := range stale {
+		logging.Warningf("File %q changed on disk after it was sent to the AI; the response may be based on stale content.", path)
+	}
+	logging.Event("files_stale", map[string]interface{}{"paths": stale, "mode": mode})
+	if mode == CheckStaleAbort {
+		return fmt.Errorf("%w: %q", ErrStale, stale)
+	}
+	return nil
+}
\ No newline at end of file
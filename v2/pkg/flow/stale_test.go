@@ -0,0 +1,68 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+)
+
+// editingClient is a mock engine that rewrites a file while "thinking", as another
+// process or a git checkout might between building the prompt and applying the response.
+type editingClient struct {
+	*mock.Client
+	path    string
+	content string
+}
+
+func (c *editingClient) SendConversation(ctx context.Context, history []aiEndpoint.Message) (string, error) {
+	if err := os.WriteFile(c.path, []byte(c.content), 0644); err != nil {
+		return "", err
+	}
+	return c.Client.SendConversation(ctx, history)
+}
+
+func TestRun_CheckStale(t *testing.T) {
+	tests := []struct {
+		mode    string
+		wantErr bool
+		want    string // Content of the file after the run
+	}{
+		{mode: CheckStaleAbort, wantErr: true, want: "edited\n"},
+		{mode: CheckStaleWarn, want: "new\n"},
+		{mode: CheckStaleOff, want: "new\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			dir := t.TempDir()
+			listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+			aPath := filepath.Join(dir, "a.txt")
+
+			engine := &editingClient{Client: mock.NewClient(fullTextBlock(aPath, "new\n")), path: aPath, content: "edited\n"}
+			err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, CheckStale: tt.mode})
+			if tt.wantErr {
+				if !errors.Is(err, ErrStale) || !errors.Is(err, ErrApply) {
+					t.Fatalf("Run() error = %v, want ErrStale and ErrApply", err)
+				}
+			} else if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if got, _ := os.ReadFile(aPath); string(got) != tt.want {
+				t.Errorf("content of %q = %q, want %q", aPath, got, tt.want)
+			}
+		})
+	}
+
+	// An unchanged file passes the check.
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+	aPath := filepath.Join(dir, "a.txt")
+	err := Run(context.Background(), mock.NewClient(fullTextBlock(aPath, "new\n")), Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, CheckStale: CheckStaleAbort})
+	if err != nil {
+		t.Fatalf("Run() on unchanged files error = %v", err)
+	}
+}
\ No newline at end of file
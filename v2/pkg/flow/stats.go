@@ -0,0 +1,48 @@
+package flow
+
+import (
+	"time"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+	"github.com/zicongmei/ai-coder/v2/pkg/modifyFiles"
+)
+
+// Stats summarizes a run of the AI coding flow.
+type Stats struct {
+	FilesRead     int           // Number of files included in the first prompt
+	InputTokens   int           // Token count of the first prompt, if it could be counted
+	ResponseBytes int           // Total length of all AI responses, including retries and follow-ups
+	FilesModified int           // Existing files rewritten in place
+	FilesCreated  int           // Files created in place
+	FilesDeleted  int           // Files deleted in place
+	Elapsed       time.Duration // Wall-clock duration of the run
+
+	// result, if non-nil, also collects the files touched for Options.JSONResult.
+	result *Result
+}
+
+// addApplyResult counts the files touched by one applied response.
+// before holds the file hashes taken before the apply, for the JSON result.
+func (s *Stats) addApplyResult(result modifyFiles.ApplyResult, before map[string]string) {
+	s.FilesModified += len(result.Modified)
+	s.FilesCreated += len(result.Created)
+	s.FilesDeleted += len(result.Deleted)
+	if s.result != nil {
+		s.result.addApplyResult(result, before)
+	}
+}
+
+// log prints the end-of-run summary at V(0) and emits it as a run_stats event.
+func (s *Stats) log() {
+	logging.V(0).Infof("Run summary: %d files read, %d input tokens, %d response bytes, %d modified, %d created, %d deleted, elapsed %s.",
+		s.FilesRead, s.InputTokens, s.ResponseBytes, s.FilesModified, s.FilesCreated, s.FilesDeleted, s.Elapsed.Round(time.Millisecond))
+	logging.Event("run_stats", map[string]interface{}{
+		"files_read":     s.FilesRead,
+		"input_tokens":   s.InputTokens,
+		"response_bytes": s.ResponseBytes,
+		"files_modified": s.FilesModified,
+		"files_created":  s.FilesCreated,
+		"files_deleted":  s.FilesDeleted,
+		"elapsed_ms":     s.Elapsed.Milliseconds(),
+	})
+}
\ No newline at end of file
@@ -0,0 +1,61 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+func TestRun_Stats(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old a\n", "b.txt": "old b\n"})
+	aPath := filepath.Join(dir, "a.txt")
+	newPath := filepath.Join(dir, "new.txt")
+
+	response := fullTextBlock(aPath, "new a\n") + fullTextBlock(newPath, "created\n")
+	engine := mock.NewClient(response)
+	var stats Stats
+	if err := run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, AllowNew: true}, &stats); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	prompts := engine.Prompts()
+	if len(prompts) != 1 {
+		t.Fatalf("engine received %d prompts, want 1", len(prompts))
+	}
+	want := Stats{
+		FilesRead:     2,
+		InputTokens:   (len(prompts[0]) + 3) / 4, // The mock engine's token estimate
+		ResponseBytes: len(response),
+		FilesModified: 1,
+		FilesCreated:  1,
+	}
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestRun_TokenCountTimeoutFallsBack(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old a\n"})
+	aPath := filepath.Join(dir, "a.txt")
+
+	engine := mock.NewClient(fullTextBlock(aPath, "new a\n"))
+	engine.CountErr = fmt.Errorf("count: %w", aiEndpoint.ErrTimeout)
+	var stats Stats
+	if err := run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true}, &stats); err != nil {
+		t.Fatalf("run() error = %v, want the token count timeout to be non-fatal", err)
+	}
+	prompts := engine.Prompts()
+	if len(prompts) != 1 {
+		t.Fatalf("engine received %d prompts, want 1", len(prompts))
+	}
+	if want := utils.ApproxTokenCount(prompts[0]); stats.InputTokens != want {
+		t.Errorf("InputTokens = %d, want the estimate %d", stats.InputTokens, want)
+	}
+}
\ No newline at end of file
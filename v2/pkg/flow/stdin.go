@@ -0,0 +1,53 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+	"github.com/zicongmei/ai-coder/v2/pkg/modifyFiles"
+	"github.com/zicongmei/ai-coder/v2/pkg/prompt"
+)
+
+// RunStdin edits a single unnamed file: it reads the content from in, sends it to the
+// AI with opts.Prompt (see prompt.GenerateSingleFilePrompt) and writes the modified
+// content to out, e.g. for `cat foo.go | coder --stdin-content --prompt "..." > bar.go`.
+// No file list, markers or diff are involved; besides opts.Prompt, only
+// opts.PromptPrefix, opts.PromptSuffix, opts.Fallback, opts.Progress and opts.CompressDumps
+// are used.
+// Returned errors are tagged with ErrConfig or ErrAI.
+func RunStdin(ctx context.Context, aiEngine aiEndpoint.AIEngine, opts Options, in io.Reader, out io.Writer) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		logging.Errorf("Failed to read the content from stdin: %v", err)
+		return categorize(ErrConfig, fmt.Errorf("failed to read stdin: %w", err))
+	}
+	if len(data) == 0 {
+		return categorize(ErrConfig, errors.New("no content on stdin"))
+	}
+	content := string(data)
+	logging.V(1).Infof("Read %d bytes from stdin.", len(content))
+
+	fullPrompt := prompt.GenerateSingleFilePrompt(opts.Prompt, content, prompt.Options{Prefix: opts.PromptPrefix, Suffix: opts.PromptSuffix})
+	dumpPath := filepath.Join(os.TempDir(), fmt.Sprintf("ai_raw_output_%s%s", time.Now().Format("20060102_150405"), dumpExt(opts.CompressDumps)))
+	conversation := []aiEndpoint.Message{{Role: aiEndpoint.RoleUser, Text: fullPrompt}}
+	aiResponse, err := sendConversation(ctx, withFallback(aiEngine, opts.Fallback), conversation, dumpPath, opts.Progress, nil)
+	if err != nil {
+		return err
+	}
+
+	modified := modifyFiles.SingleFileContent(aiResponse, content)
+	if _, err := io.WriteString(out, modified); err != nil {
+		logging.Errorf("Failed to write the modified content: %v", err)
+		return fmt.Errorf("failed to write the modified content: %w", err)
+	}
+	logging.Event("stdin_completed", map[string]interface{}{"bytes_in": len(content), "bytes_out": len(modified)})
+	logging.V(0).Infof("Wrote %d bytes of modified content.", len(modified))
+	return nil
+}
\ No newline at end of file
@@ -0,0 +1,38 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+)
+
+func TestRunStdin(t *testing.T) {
+	engine := mock.NewClient("```go\npackage foo\n\nfunc F() {}\n```")
+	var out bytes.Buffer
+	if err := RunStdin(context.Background(), engine, Options{Prompt: "Add F."}, strings.NewReader("package foo\n"), &out); err != nil {
+		t.Fatalf("RunStdin() error = %v", err)
+	}
+	if want := "package foo\n\nfunc F() {}\n"; out.String() != want {
+		t.Errorf("RunStdin() wrote %q, want %q", out.String(), want)
+	}
+	prompts := engine.Prompts()
+	if len(prompts) != 1 || !strings.Contains(prompts[0], "Add F.") || !strings.Contains(prompts[0], "package foo\n") {
+		t.Errorf("prompts = %q, want one prompt with the instruction and the content", prompts)
+	}
+
+	// Empty input is a configuration error and the AI is not called.
+	engine = mock.NewClient("unused")
+	err := RunStdin(context.Background(), engine, Options{Prompt: "Add F."}, strings.NewReader(""), &out)
+	if !errors.Is(err, ErrConfig) || len(engine.Prompts()) != 0 {
+		t.Errorf("RunStdin() with empty input error = %v after %d prompts, want a configuration error and no prompt", err, len(engine.Prompts()))
+	}
+
+	err = RunStdin(context.Background(), &mock.Client{Err: errors.New("boom")}, Options{Prompt: "Add F."}, strings.NewReader("package foo\n"), &out)
+	if !errors.Is(err, ErrAI) {
+		t.Errorf("RunStdin() with a failing engine error = %v, want an AI error", err)
+	}
+}
\ No newline at end of file
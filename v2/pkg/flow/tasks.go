@@ -0,0 +1,121 @@
+package flow
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// Task is one entry of a tasks file: a prompt and, optionally, the files it works on.
+// A task without FileList and Files uses the files of the Options passed to RunTasks.
+type Task struct {
+	Prompt   string   `json:"prompt"`
+	FileList string   `json:"file_list,omitempty"`
+	Files    []string `json:"files,omitempty"`
+}
+
+// TaskResult is the outcome of one task run by RunTasks.
+type TaskResult struct {
+	Task Task
+	Err  error // nil if the task succeeded
+}
+
+// ReadTasks parses the tasks file at path. Blank lines and lines starting with "#" are
+// ignored. A line starting with "{" is a JSON Task, e.g.
+//
+//	{"prompt": "Rename Foo to Bar.", "files": ["foo.go", "bar.go"]}
+//
+// and any other line is a prompt that uses the files given on the command line.
+// Returned errors are tagged with ErrConfig.
+func ReadTasks(path string) ([]Task, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, categorize(ErrConfig, fmt.Errorf("failed to open tasks file: %w", err))
+	}
+	defer file.Close()
+
+	var tasks []Task
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024) // Prompts can be long lines
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		task := Task{Prompt: line}
+		if strings.HasPrefix(line, "{") {
+			task = Task{}
+			if err := json.Unmarshal([]byte(line), &task); err != nil {
+				return nil, categorize(ErrConfig, fmt.Errorf("tasks file %q, line %d: %w", path, lineNum, err))
+			}
+		}
+		if strings.TrimSpace(task.Prompt) == "" {
+			return nil, categorize(ErrConfig, fmt.Errorf("tasks file %q, line %d: task has no prompt", path, lineNum))
+		}
+		tasks = append(tasks, task)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, categorize(ErrConfig, fmt.Errorf("error reading tasks file %q: %w", path, err))
+	}
+	if len(tasks) == 0 {
+		return nil, categorize(ErrConfig, fmt.Errorf("tasks file %q contains no tasks", path))
+	}
+	return tasks, nil
+}
+
+// RunTasks runs each task with Run, in order, using opts with the task's prompt and files.
+// Each task is applied before the next one starts, so later tasks see earlier edits.
+// A failed task is reported and the remaining tasks still run. The returned error is nil
+// if every task succeeded, and otherwise wraps the first failure (keeping its category).
+func RunTasks(ctx context.Context, aiEngine aiEndpoint.AIEngine, tasks []Task, opts Options) ([]TaskResult, error) {
+	if opts.FileListPath == "" && len(opts.Files) == 0 && opts.SinceGit == "" {
+		for i, task := range tasks {
+			if task.FileList == "" && len(task.Files) == 0 && len(PromptFileRefs(task.Prompt)) == 0 {
+				return nil, categorize(ErrConfig, fmt.Errorf("task %d has no files and none were given for all tasks", i+1))
+			}
+		}
+	}
+
+	results := make([]TaskResult, 0, len(tasks))
+	var firstErr error
+	for i, task := range tasks {
+		logging.V(0).Infof("Running task %d/%d: %q", i+1, len(tasks), task.Prompt)
+		taskOpts := opts
+		taskOpts.Prompt = task.Prompt
+		if task.FileList != "" || len(task.Files) > 0 {
+			taskOpts.FileListPath = task.FileList
+			taskOpts.Files = task.Files
+		}
+
+		err := Run(ctx, aiEngine, taskOpts)
+		results = append(results, TaskResult{Task: task, Err: err})
+		if err != nil {
+			logging.Errorf("Task %d/%d failed: %v", i+1, len(tasks), err)
+			logging.ErrorEvent("task_failed", err, map[string]interface{}{"task": i + 1})
+			if firstErr == nil {
+				firstErr = fmt.Errorf("task %d: %w", i+1, err)
+			}
+			continue
+		}
+		logging.V(0).Infof("Task %d/%d succeeded.", i+1, len(tasks))
+		logging.Event("task_completed", map[string]interface{}{"task": i + 1})
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	logging.V(0).Infof("%d of %d tasks succeeded.", len(tasks)-failed, len(tasks))
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d tasks failed, first %w", failed, len(tasks), firstErr)
+	}
+	return results, nil
+}
\ No newline at end of file
@@ -0,0 +1,103 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+)
+
+func TestReadTasks(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tasks.txt")
+	content := "# Refactoring plan\n\n" +
+		"Rename Foo to Bar.\n" +
+		`{"prompt": "Add docs.", "files": ["a.go", "b.go"]}` + "\n" +
+		`{"prompt": "Fix tests.", "file_list": "tests.txt"}` + "\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", path, err)
+	}
+
+	got, err := ReadTasks(path)
+	if err != nil {
+		t.Fatalf("ReadTasks() error = %v", err)
+	}
+	want := []Task{
+		{Prompt: "Rename Foo to Bar."},
+		{Prompt: "Add docs.", Files: []string{"a.go", "b.go"}},
+		{Prompt: "Fix tests.", FileList: "tests.txt"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadTasks() = %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []string{"", "# only a comment\n", `{"prompt": "unterminated` + "\n", `{"files": ["a.go"]}` + "\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+		if _, err := ReadTasks(path); !errors.Is(err, ErrConfig) {
+			t.Errorf("ReadTasks() on %q error = %v, want a configuration error", bad, err)
+		}
+	}
+}
+
+func TestRunTasks_Sequential(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"version.txt": "v1\n"})
+	versionPath := filepath.Join(dir, "version.txt")
+
+	engine := &mock.Client{Responses: []string{fullTextBlock(versionPath, "v2\n"), fullTextBlock(versionPath, "v3\n")}}
+	tasks := []Task{{Prompt: "Bump the version."}, {Prompt: "Bump it again."}}
+	results, err := RunTasks(context.Background(), engine, tasks, Options{FileListPath: listPath, Inplace: true})
+	if err != nil {
+		t.Fatalf("RunTasks() error = %v", err)
+	}
+	if len(results) != 2 || results[0].Err != nil || results[1].Err != nil {
+		t.Errorf("RunTasks() results = %+v, want two successes", results)
+	}
+
+	// The second task's prompt was built from the first task's output.
+	prompts := engine.Prompts()
+	if len(prompts) != 2 {
+		t.Fatalf("engine received %d prompts, want 2", len(prompts))
+	}
+	if !strings.Contains(prompts[1], "Bump it again.") || !strings.Contains(prompts[1], "v2\n") {
+		t.Errorf("second prompt does not contain the first task's edit: %q", prompts[1])
+	}
+	if got, _ := os.ReadFile(versionPath); string(got) != "v3\n" {
+		t.Errorf("content of %q = %q, want %q", versionPath, got, "v3\n")
+	}
+}
+
+func TestRunTasks_ReportsFailures(t *testing.T) {
+	dir := t.TempDir()
+	aList := writeFileList(t, filepath.Join(dir, "a"), map[string]string{"a.txt": "a\n"})
+	bPath := filepath.Join(dir, "b.txt")
+	if err := os.WriteFile(bPath, []byte("b\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", bPath, err)
+	}
+
+	// The first task's response is malformed; the second task still runs.
+	engine := &mock.Client{Responses: []string{"garbage", fullTextBlock(bPath, "B\n")}}
+	tasks := []Task{{Prompt: "Change a."}, {Prompt: "Change b.", Files: []string{bPath}}}
+	results, err := RunTasks(context.Background(), engine, tasks, Options{FileListPath: aList, Inplace: true})
+	if !errors.Is(err, ErrApply) || !strings.Contains(err.Error(), "1 of 2 tasks failed") {
+		t.Fatalf("RunTasks() error = %v, want an apply error reporting 1 of 2 failures", err)
+	}
+	if len(results) != 2 || results[0].Err == nil || results[1].Err != nil {
+		t.Errorf("RunTasks() results = %+v, want the first task failed and the second succeeded", results)
+	}
+	if got, _ := os.ReadFile(bPath); string(got) != "B\n" {
+		t.Errorf("content of %q = %q, want %q", bPath, got, "B\n")
+	}
+
+	// Tasks without files need files for all tasks.
+	if _, err := RunTasks(context.Background(), engine, []Task{{Prompt: "Change something."}}, Options{Inplace: true}); !errors.Is(err, ErrConfig) {
+		t.Errorf("RunTasks() without any files error = %v, want a configuration error", err)
+	}
+}
\ No newline at end of file
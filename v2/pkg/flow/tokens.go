@@ -0,0 +1,152 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+	"github.com/zicongmei/ai-coder/v2/pkg/prompt"
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+// fileTokens is one row of a token report.
+type fileTokens struct {
+	path        string
+	tokens      int
+	bytes       int
+	approximate bool // Counted with utils.ApproxTokenCount because the engine could not count
+	context     bool // A read-only context file
+}
+
+// TokenReport counts the tokens of every file that Run would put in the prompt and writes
+// a table to w, largest first, so the files that dominate an oversized prompt stand out.
+// Tokens are counted with aiEngine.CountTokens, falling back to utils.ApproxTokenCount
+// (marked with "~") when that fails. If opts.Prompt is set, the size of the complete
+// prompt is reported too. Nothing is sent to the AI. Returned errors are tagged with ErrConfig.
+func TokenReport(ctx context.Context, aiEngine aiEndpoint.AIEngine, opts Options, w io.Writer) error {
+	fileContents, err := readFiles(ctx, opts)
+	if err != nil {
+		logging.Errorf("Failed to read files (list %q, files %q): %v", opts.FileListPath, opts.Files, err)
+		return categorize(ErrConfig, fmt.Errorf("failed to read files: %w", err))
+	}
+	contextContents, err := readContextFiles(ctx, opts)
+	if err != nil {
+		logging.Errorf("Failed to read context files %q: %v", opts.ContextFiles, err)
+		return categorize(ErrConfig, fmt.Errorf("failed to read context files: %w", err))
+	}
+	dropContextFiles(fileContents, contextContents)
+
+	var rows []fileTokens
+	add := func(contents map[string]string, context bool) {
+		for _, path := range sortedPaths(contents) {
+			row := countTokens(ctx, aiEngine, contents[path])
+			row.path = path
+			row.context = context
+			rows = append(rows, row)
+		}
+	}
+	add(fileContents, false)
+	add(contextContents, true)
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].tokens > rows[j].tokens })
+
+	total := 0
+	for _, row := range rows {
+		total += row.tokens
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "TOKENS\tBYTES\tSHARE\t FILE")
+	for _, row := range rows {
+		tokens := fmt.Sprint(row.tokens)
+		if row.approximate {
+			tokens = "~" + tokens
+		}
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(row.tokens) / float64(total)
+		}
+		path := row.path
+		if row.context {
+			path += " (context)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t %s\n", tokens, row.bytes, share, path)
+	}
+	fmt.Fprintf(tw, "%d\t\t\t %s\n", total, "total of files")
+	if opts.Prompt != "" {
+		fullPrompt := prompt.GeneratePrompt(opts.Prompt, fileContents, prompt.Options{
+			Inplace:      opts.Inplace,
+			Format:       opts.Format,
+			FileNotes:    opts.FileNotes,
+			Prefix:       opts.PromptPrefix,
+			Suffix:       opts.PromptSuffix,
+			ContextFiles: contextContents,
+			Markers:      utils.NewMarkers(opts.MarkerNonce),
+			Compress:     opts.CompressContext,
+			LengthHints:  opts.LengthHints,
+			Base64:       opts.StrictTransport,
+		})
+		row := countTokens(ctx, aiEngine, fullPrompt)
+		tokens := fmt.Sprint(row.tokens)
+		if row.approximate {
+			tokens = "~" + tokens
+		}
+		fmt.Fprintf(tw, "%s\t%d\t\t %s\n", tokens, row.bytes, "full prompt, with instructions")
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("failed to write token report: %w", err)
+	}
+	return nil
+}
+
+// countTokens counts the tokens of content with aiEngine, falling back to an estimate.
+func countTokens(ctx context.Context, aiEngine aiEndpoint.AIEngine, content string) fileTokens {
+	row := fileTokens{bytes: len(content)}
+	tokens, err := aiEngine.CountTokens(ctx, content)
+	if err != nil {
+		logging.V(1).Infof("Token count failed (%v); using an estimate.", err)
+		tokens = utils.ApproxTokenCount(content)
+		row.approximate = true
+	}
+	row.tokens = tokens
+	return row
+}
+
+// tokenCountAttempts is how often Run asks the engine to count the prompt's tokens
+// before it falls back to an estimate.
+const tokenCountAttempts = 3
+
+// tokenCountRetryDelay is the pause before the second attempt; it doubles for each
+// further attempt. A variable so tests can shorten it.
+var tokenCountRetryDelay = time.Second
+
+// countPromptTokens counts the tokens of prompt with aiEngine, retrying failures up to
+// tokenCountAttempts times in total. Timeouts and authentication failures are not
+// retried, since another attempt would only fail the same way. If counting fails, the
+// error is returned when require is set; otherwise an estimate from
+// utils.ApproxTokenCount is returned with approximate set.
+func countPromptTokens(ctx context.Context, aiEngine aiEndpoint.AIEngine, prompt string, require bool) (tokens int, approximate bool, err error) {
+	delay := tokenCountRetryDelay
+	for attempt := 1; ; attempt++ {
+		tokens, err = aiEngine.CountTokens(ctx, prompt)
+		if err == nil {
+			return tokens, false, nil
+		}
+		if attempt == tokenCountAttempts || errors.Is(err, aiEndpoint.ErrTimeout) || errors.Is(err, aiEndpoint.ErrAuth) {
+			break
+		}
+		logging.V(1).Infof("Token count attempt %d of %d failed (%v); retrying in %s.", attempt, tokenCountAttempts, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+	if require {
+		return 0, false, err
+	}
+	tokens = utils.ApproxTokenCount(prompt)
+	logging.Warningf("Could not count input tokens (%v); using an estimate of %d tokens.", err, tokens)
+	return tokens, true, nil
+}
\ No newline at end of file
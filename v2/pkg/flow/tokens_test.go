@@ -0,0 +1,174 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+func TestTokenReport(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{
+		"small.go": "package a\n",
+		"big.go":   strings.Repeat("x", 400),
+	})
+	apiPath := filepath.Join(dir, "api.go")
+	writeTree(t, dir, "api.go")
+
+	engine := mock.NewClient("unused")
+	var out bytes.Buffer
+	err := TokenReport(context.Background(), engine, Options{FileListPath: listPath, ContextFiles: []string{apiPath}, Prompt: "Refactor."}, &out)
+	if err != nil {
+		t.Fatalf("TokenReport() error = %v", err)
+	}
+	if prompts := engine.Prompts(); len(prompts) != 0 {
+		t.Errorf("TokenReport() sent %d prompts, want none", len(prompts))
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("report has %d lines, want a header, 3 files, the total and the full prompt:\n%s", len(lines), out.String())
+	}
+	// Largest first, with the share of the files' total; the mock counts a token per four bytes.
+	for i, want := range []string{
+		"100 400 97.1% " + filepath.Join(dir, "big.go"),
+		"3 10 2.9% " + filepath.Join(dir, "small.go"),
+		"0 0 0.0% " + apiPath + " (context)",
+		"103 total of files",
+	} {
+		if got := strings.Join(strings.Fields(lines[i+1]), " "); got != want {
+			t.Errorf("report line %d = %q, want %q", i+1, got, want)
+		}
+	}
+	if !strings.HasSuffix(lines[5], "full prompt, with instructions") {
+		t.Errorf("last report line = %q, want the full prompt size", lines[5])
+	}
+}
+
+func TestTokenReport_Approximate(t *testing.T) {
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.go": "package a\n"})
+
+	var out bytes.Buffer
+	engine := &mock.Client{CountErr: errors.New("quota exceeded")}
+	if err := TokenReport(context.Background(), engine, Options{FileListPath: listPath}, &out); err != nil {
+		t.Fatalf("TokenReport() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "~3") {
+		t.Errorf("report does not mark the estimated count:\n%s", out.String())
+	}
+
+	if err := TokenReport(context.Background(), engine, Options{FileListPath: filepath.Join(dir, "missing.txt")}, &out); !errors.Is(err, ErrConfig) {
+		t.Errorf("TokenReport() with a missing file list error = %v, want ErrConfig", err)
+	}
+}
+
+// flakyCounter is a mock engine whose first failures calls to CountTokens fail with err.
+type flakyCounter struct {
+	*mock.Client
+	failures int
+	err      error
+	calls    int
+}
+
+func (c *flakyCounter) CountTokens(ctx context.Context, prompt string) (int, error) {
+	c.calls++
+	if c.calls <= c.failures {
+		return 0, c.err
+	}
+	return c.Client.CountTokens(ctx, prompt)
+}
+
+func TestCountPromptTokens(t *testing.T) {
+	defer func(delay time.Duration) { tokenCountRetryDelay = delay }(tokenCountRetryDelay)
+	tokenCountRetryDelay = 0
+	const prompt = "Count these tokens, please."
+	unavailable := errors.New("service unavailable")
+
+	tests := []struct {
+		name       string
+		failures   int
+		err        error
+		require    bool
+		wantCalls  int
+		wantApprox bool
+		wantErr    bool
+	}{
+		{name: "Transient failure is retried", failures: 2, err: unavailable, wantCalls: 3},
+		{name: "Persistent failure falls back to an estimate", failures: 5, err: unavailable, wantCalls: tokenCountAttempts, wantApprox: true},
+		{name: "Persistent failure with require", failures: 5, err: unavailable, require: true, wantCalls: tokenCountAttempts, wantErr: true},
+		{name: "Timeout is not retried", failures: 5, err: fmt.Errorf("count: %w", aiEndpoint.ErrTimeout), wantCalls: 1, wantApprox: true},
+		{name: "Authentication failure is not retried", failures: 5, err: aiEndpoint.ErrAuth, require: true, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := &flakyCounter{Client: &mock.Client{}, failures: tt.failures, err: tt.err}
+			tokens, approximate, err := countPromptTokens(context.Background(), engine, prompt, tt.require)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("countPromptTokens() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if engine.calls != tt.wantCalls {
+				t.Errorf("CountTokens was called %d times, want %d", engine.calls, tt.wantCalls)
+			}
+			if approximate != tt.wantApprox {
+				t.Errorf("countPromptTokens() approximate = %t, want %t", approximate, tt.wantApprox)
+			}
+			if !tt.wantErr && tokens != utils.ApproxTokenCount(prompt) {
+				t.Errorf("countPromptTokens() = %d, want %d", tokens, utils.ApproxTokenCount(prompt))
+			}
+		})
+	}
+}
+
+func TestRun_RequireTokenCount(t *testing.T) {
+	defer func(delay time.Duration) { tokenCountRetryDelay = delay }(tokenCountRetryDelay)
+	tokenCountRetryDelay = 0
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "old\n"})
+	aPath := filepath.Join(dir, "a.txt")
+
+	engine := &mock.Client{Response: fullTextBlock(aPath, "new\n"), CountErr: errors.New("service unavailable")}
+	err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, RequireTokenCount: true})
+	if !errors.Is(err, ErrAI) {
+		t.Errorf("Run() error = %v, want an AI error", err)
+	}
+	if len(engine.Prompts()) != 0 {
+		t.Errorf("engine received %d prompts, want none", len(engine.Prompts()))
+	}
+}
+
+func TestRun_CountTokens(t *testing.T) {
+	defer func(delay time.Duration) { tokenCountRetryDelay = delay }(tokenCountRetryDelay)
+	tokenCountRetryDelay = 0
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": strings.Repeat("x", 400)})
+	aPath := filepath.Join(dir, "a.txt")
+
+	for _, countErr := range []error{nil, errors.New("service unavailable")} {
+		engine := &mock.Client{Response: fullTextBlock(aPath, "new\n"), CountErr: countErr}
+		var out bytes.Buffer
+		if err := Run(context.Background(), engine, Options{FileListPath: listPath, Prompt: "Update.", Inplace: true, CountTokens: &out}); err != nil {
+			t.Fatalf("Run() counting tokens (count error %v) error = %v", countErr, err)
+		}
+		var tokens int
+		if _, err := fmt.Sscanf(out.String(), "%d\n", &tokens); err != nil || tokens < 100 {
+			t.Errorf("Run() wrote token count %q (count error %v), want a count covering the file", out.String(), countErr)
+		}
+		if prompts := engine.Prompts(); len(prompts) != 0 {
+			t.Errorf("engine received %d prompts while counting tokens, want none", len(prompts))
+		}
+		if got, _ := os.ReadFile(aPath); string(got) != strings.Repeat("x", 400) {
+			t.Errorf("content of %q changed while counting tokens", aPath)
+		}
+	}
+}
\ No newline at end of file
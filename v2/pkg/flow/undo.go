@@ -0,0 +1,237 @@
+package flow
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+// undoManifestPattern matches the undo manifests in undoDir. Their names embed a key of
+// the workspace (see undoManifestPrefix) followed by the time of the apply, so those of
+// a workspace sort chronologically.
+const undoManifestPattern = "ai_undo_*.json"
+
+// undoDir returns the directory holding the undo manifests; tests replace it.
+var undoDir = os.TempDir
+
+// undoWorkspace returns the workspace an apply is recorded for and Undo reverts the
+// applies of: the root of the git repository holding the current directory or, outside
+// one, the current directory. The temporary directory is shared by every project and
+// user, so Undo must not pick up the manifest of an apply made elsewhere.
+var undoWorkspace = func() (string, error) {
+	if out, err := gitCommand("rev-parse", "--show-toplevel"); err == nil {
+		return strings.TrimSpace(string(out)), nil
+	}
+	return os.Getwd()
+}
+
+// undoManifestPrefix returns the start of the names of the undo manifests of
+// workspace, which includes a short hash of its path.
+func undoManifestPrefix(workspace string) string {
+	sum := sha256.Sum256([]byte(workspace))
+	return fmt.Sprintf("ai_undo_%x_", sum[:4])
+}
+
+// undoManifest records the content of the files an apply changed, as it was before the
+// apply, so Undo can restore it.
+type undoManifest struct {
+	Time      time.Time   `json:"time"`
+	Workspace string      `json:"workspace"` // See undoWorkspace
+	Files     []undoEntry `json:"files"`     // In the order the files were changed
+}
+
+// undoEntry is the state of one file before an apply changed it.
+type undoEntry struct {
+	Path    string      `json:"path"`              // Absolute path of the file
+	Existed bool        `json:"existed"`           // Whether the file existed; if not, Undo deletes it
+	Content []byte      `json:"content,omitempty"` // Content of the file
+	Mode    os.FileMode `json:"mode,omitempty"`    // Permission bits of the file
+}
+
+// undoRecorder collects an undoManifest through its backup method, which is used as
+// modifyFiles.Options.Backup.
+type undoRecorder struct {
+	mu       sync.Mutex // Guards the fields below for a recorder shared by concurrent runs
+	manifest undoManifest
+	seen     map[string]bool
+}
+
+// backup records the current state of the file at path, unless it was already recorded.
+func (r *undoRecorder) backup(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.seen[abs] {
+		return nil
+	}
+	entry := undoEntry{Path: abs}
+	info, err := os.Stat(abs)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		if entry.Content, err = os.ReadFile(abs); err != nil {
+			return err
+		}
+		entry.Existed = true
+		entry.Mode = info.Mode().Perm()
+	}
+	r.record(entry)
+	return nil
+}
+
+// record adds entry to the manifest, unless its file was already recorded. The caller
+// holds r.mu.
+func (r *undoRecorder) record(entry undoEntry) {
+	if r.seen[entry.Path] {
+		return
+	}
+	if r.seen == nil {
+		r.seen = make(map[string]bool)
+	}
+	r.seen[entry.Path] = true
+	r.manifest.Files = append(r.manifest.Files, entry)
+}
+
+// merge adds the files recorded by other to r, so the applies of several runs of one
+// invocation (see RunPerFile and RunBatches) are undone together. A file r recorded
+// already keeps its earlier state.
+func (r *undoRecorder) merge(other *undoRecorder) {
+	other.mu.Lock()
+	files := append([]undoEntry(nil), other.manifest.Files...)
+	other.mu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, entry := range files {
+		r.record(entry)
+	}
+}
+
+// save writes the recorded manifest to undoDir, if any file was recorded. A failure is
+// only logged: the changes are already on disk.
+func (r *undoRecorder) save() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.manifest.Files) == 0 {
+		return
+	}
+	r.manifest.Time = time.Now()
+	workspace, err := undoWorkspace()
+	if err != nil {
+		logging.Errorf("Failed to save undo information: cannot determine the workspace: %v", err)
+		return
+	}
+	r.manifest.Workspace = workspace
+	// The process ID keeps the names of manifests saved at the same moment apart.
+	name := fmt.Sprintf("%s%s_%d.json", undoManifestPrefix(workspace), r.manifest.Time.Format("20060102_150405.000000"), os.Getpid())
+	path := filepath.Join(undoDir(), name)
+	data, err := json.Marshal(r.manifest)
+	if err == nil {
+		// The manifest holds file contents, so keep it private.
+		err = utils.WriteFileAtomic(path, data, 0600)
+	}
+	if err != nil {
+		logging.Errorf("Failed to save undo information to %q: %v", path, err)
+		return
+	}
+	logging.V(0).Infof("Undo information for %d files saved to %q; run with --undo to revert the changes.", len(r.manifest.Files), path)
+}
+
+// rollback restores the files recorded so far, for an apply that was interrupted. If
+// that fails, the manifest is saved instead, so --undo can finish the job. It is only
+// used on the recorder of a single apply, never on a shared one.
+func (r *undoRecorder) rollback() {
+	if len(r.manifest.Files) == 0 {
+		return
+	}
+	logging.Warningf("Rolling back the %d files of the interrupted apply.", len(r.manifest.Files))
+	if err := r.manifest.restore(); err != nil {
+		r.save()
+		return
+	}
+	logging.V(0).Info("Rolled back the interrupted apply; no files were changed.")
+}
+
+// restore gives the files of the manifest their recorded state, in reverse order:
+// changed and deleted files get their previous content back and created files are
+// deleted.
+func (m undoManifest) restore() error {
+	for i := len(m.Files) - 1; i >= 0; i-- {
+		entry := m.Files[i]
+		if !entry.Existed {
+			if err := os.Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+				logging.Errorf("Failed to delete %q: %v", entry.Path, err)
+				return fmt.Errorf("failed to delete %q: %w", entry.Path, err)
+			}
+			logging.V(0).Infof("Deleted created file %q.", entry.Path)
+			logging.Event("file_undone", map[string]interface{}{"path": entry.Path, "action": "deleted"})
+			continue
+		}
+		if err := utils.WriteFileAtomic(entry.Path, entry.Content, entry.Mode); err != nil {
+			logging.Errorf("Failed to restore %q: %v", entry.Path, err)
+			return fmt.Errorf("failed to restore %q: %w", entry.Path, err)
+		}
+		logging.V(0).Infof("Restored %q.", entry.Path)
+		logging.Event("file_undone", map[string]interface{}{"path": entry.Path, "action": "restored"})
+	}
+	return nil
+}
+
+// Undo reverts the most recent apply in the current workspace (see undoWorkspace) that
+// has not been undone yet, using the undo manifest saved for it: changed and deleted
+// files get their previous content back and created files are deleted. The manifest is
+// removed afterwards, so repeated calls undo earlier applies in turn. Returned errors
+// are tagged with ErrConfig or ErrApply.
+func Undo() error {
+	workspace, err := undoWorkspace()
+	if err != nil {
+		logging.Errorf("Cannot determine the workspace to undo: %v", err)
+		return categorize(ErrConfig, fmt.Errorf("cannot determine the workspace to undo: %w", err))
+	}
+	paths, err := filepath.Glob(filepath.Join(undoDir(), undoManifestPrefix(workspace)+"*.json"))
+	if err != nil || len(paths) == 0 {
+		logging.Errorf("No undo information for %q found in %q.", workspace, undoDir())
+		return categorize(ErrConfig, fmt.Errorf("nothing to undo: no undo information for %q found in %q", workspace, undoDir()))
+	}
+	sort.Strings(paths)
+	manifestPath := paths[len(paths)-1]
+
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		logging.Errorf("Failed to read undo information %q: %v", manifestPath, err)
+		return categorize(ErrConfig, fmt.Errorf("failed to read undo information: %w", err))
+	}
+	var manifest undoManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		logging.Errorf("Undo information %q is corrupt: %v", manifestPath, err)
+		return categorize(ErrConfig, fmt.Errorf("undo information %q is corrupt: %w", manifestPath, err))
+	}
+	if manifest.Workspace != workspace {
+		logging.Errorf("Undo information %q belongs to %q, not %q.", manifestPath, manifest.Workspace, workspace)
+		return categorize(ErrConfig, fmt.Errorf("undo information %q belongs to another workspace, %q", manifestPath, manifest.Workspace))
+	}
+
+	logging.V(0).Infof("Undoing the changes of %s to %d files (from %q).", manifest.Time.Format(time.DateTime), len(manifest.Files), manifestPath)
+	if err := manifest.restore(); err != nil {
+		return categorize(ErrApply, err)
+	}
+
+	if err := os.Remove(manifestPath); err != nil {
+		logging.Warningf("Failed to remove undo information %q; a repeated --undo would restore the same files again: %v", manifestPath, err)
+	}
+	logging.V(0).Info("Undo complete.")
+	return nil
+}
\ No newline at end of file
@@ -0,0 +1,179 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/aiEndpoint/mock"
+	"github.com/zicongmei/ai-coder/v2/pkg/modifyFiles"
+	"github.com/zicongmei/ai-coder/v2/pkg/prompt"
+)
+
+func TestUndo_RestoresLastApply(t *testing.T) {
+	manifests := t.TempDir()
+	defer func(orig func() string) { undoDir = orig }(undoDir)
+	undoDir = func() string { return manifests }
+
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "original\n"})
+	aPath := filepath.Join(dir, "a.txt")
+	newPath := filepath.Join(dir, "new.txt")
+	if err := os.Chmod(aPath, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := mock.NewClient(fullTextBlock(aPath, "changed\n") + fullTextBlock(newPath, "created\n"))
+	err := Run(context.Background(), engine, Options{
+		FileListPath: listPath,
+		Prompt:       "Change a.txt and add new.txt.",
+		Inplace:      true,
+		AllowNew:     true,
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "changed\n" {
+		t.Fatalf("content of %q after Run = %q, want %q", aPath, got, "changed\n")
+	}
+
+	if err := Undo(); err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	got, err := os.ReadFile(aPath)
+	if err != nil {
+		t.Fatalf("Failed to read %q: %v", aPath, err)
+	}
+	if string(got) != "original\n" {
+		t.Errorf("content of %q after Undo = %q, want %q", aPath, got, "original\n")
+	}
+	if info, err := os.Stat(aPath); err == nil && info.Mode().Perm() != 0640 {
+		t.Errorf("mode of %q after Undo = %v, want %v", aPath, info.Mode().Perm(), os.FileMode(0640))
+	}
+	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+		t.Errorf("created file %q still exists after Undo (stat error %v)", newPath, err)
+	}
+
+	// The manifest is consumed, so there is nothing left to undo.
+	if err := Undo(); !errors.Is(err, ErrConfig) {
+		t.Errorf("second Undo() error = %v, want ErrConfig", err)
+	}
+}
+
+func TestUndo_OnlyCurrentWorkspace(t *testing.T) {
+	manifests := t.TempDir()
+	defer func(orig func() string) { undoDir = orig }(undoDir)
+	undoDir = func() string { return manifests }
+	defer func(orig func() (string, error)) { undoWorkspace = orig }(undoWorkspace)
+	workspace := "/work/a"
+	undoWorkspace = func() (string, error) { return workspace, nil }
+
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "original\n"})
+	aPath := filepath.Join(dir, "a.txt")
+	err := Run(context.Background(), mock.NewClient(fullTextBlock(aPath, "changed\n")), Options{
+		FileListPath: listPath,
+		Prompt:       "Change a.txt.",
+		Inplace:      true,
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// An apply made in another workspace is not undone from here.
+	workspace = "/work/b"
+	if err := Undo(); !errors.Is(err, ErrConfig) {
+		t.Errorf("Undo() in another workspace error = %v, want ErrConfig", err)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "changed\n" {
+		t.Errorf("content of %q = %q, want the change kept", aPath, got)
+	}
+
+	workspace = "/work/a"
+	if err := Undo(); err != nil {
+		t.Fatalf("Undo() in the workspace of the apply error = %v", err)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "original\n" {
+		t.Errorf("content of %q after Undo = %q, want %q", aPath, got, "original\n")
+	}
+}
+
+func TestUndo_DryRunSavesNothing(t *testing.T) {
+	manifests := t.TempDir()
+	defer func(orig func() string) { undoDir = orig }(undoDir)
+	undoDir = func() string { return manifests }
+
+	dir := t.TempDir()
+	listPath := writeFileList(t, dir, map[string]string{"a.txt": "original\n"})
+	aPath := filepath.Join(dir, "a.txt")
+
+	err := Run(context.Background(), mock.NewClient(fullTextBlock(aPath, "changed\n")), Options{
+		FileListPath: listPath,
+		Prompt:       "Change a.txt.",
+		Inplace:      true,
+		DryRun:       true,
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if entries, _ := os.ReadDir(manifests); len(entries) != 0 {
+		t.Errorf("dry run saved %d undo manifests, want none", len(entries))
+	}
+}
+
+// cancelAfter is a context reported as canceled from its checks'th Err call on, so an
+// apply can be interrupted at a chosen file.
+type cancelAfter struct {
+	context.Context
+	checks atomic.Int32
+}
+
+func (c *cancelAfter) Err() error {
+	if c.checks.Add(-1) > 0 {
+		return nil
+	}
+	return context.Canceled
+}
+
+func TestApplyResponse_CanceledRollsBack(t *testing.T) {
+	manifests := t.TempDir()
+	defer func(orig func() string) { undoDir = orig }(undoDir)
+	undoDir = func() string { return manifests }
+
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.txt")
+	bPath := filepath.Join(dir, "b.txt")
+	newPath := filepath.Join(dir, "new.txt")
+	for _, path := range []string{aPath, bPath} {
+		if err := os.WriteFile(path, []byte("original\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	response := fullTextBlock(aPath, "changed\n") + fullTextBlock(newPath, "created\n") + fullTextBlock(bPath, "changed\n")
+
+	// a.txt and new.txt are written before the interrupt is noticed at b.txt.
+	ctx := &cancelAfter{Context: context.Background()}
+	ctx.checks.Store(3)
+	result, err := applyResponse(ctx, response, prompt.FormatFullText, modifyFiles.Options{AllowNew: true}, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("applyResponse() error = %v, want context.Canceled", err)
+	}
+	if len(result.Written()) != 0 {
+		t.Errorf("Written() = %q after a rolled back apply, want none", result.Written())
+	}
+	for _, path := range []string{aPath, bPath} {
+		if got, _ := os.ReadFile(path); string(got) != "original\n" {
+			t.Errorf("content of %q = %q, want it rolled back to %q", path, got, "original\n")
+		}
+	}
+	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+		t.Errorf("created file %q still exists after the rollback (stat error %v)", newPath, err)
+	}
+	// Nothing was changed, so there is nothing to undo.
+	if saved, _ := filepath.Glob(filepath.Join(manifests, undoManifestPattern)); len(saved) != 0 {
+		t.Errorf("undo manifests %q saved for a rolled back apply, want none", saved)
+	}
+}
\ No newline at end of file
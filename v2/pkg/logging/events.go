@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Log formats accepted by the --log-format flag.
+const (
+	FormatText = "text" // glog's text format only (default)
+	FormatJSON = "json" // Key events as JSON lines, in addition to glog's log files
+)
+
+var (
+	mu         sync.Mutex
+	jsonOutput io.Writer // Destination of JSON events; nil in text mode
+)
+
+// SetJSONOutput enables structured JSON events, written one per line to w.
+// Passing nil disables them again.
+func SetJSONOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	jsonOutput = w
+}
+
+// Event records a key event of the run, such as files being read or modified.
+// In text mode this is a no-op, since the callers already log the event through glog;
+// in JSON mode it writes an object with "time", "level", "event" and the given fields.
+func Event(event string, fields map[string]interface{}) {
+	emit("info", event, fields)
+}
+
+// ErrorEvent records a failure of the run as a JSON event with an "error" field.
+func ErrorEvent(event string, err error, fields map[string]interface{}) {
+	record := map[string]interface{}{"error": err.Error()}
+	for k, v := range fields {
+		record[k] = v
+	}
+	emit("error", event, record)
+}
+
+func emit(level, event string, fields map[string]interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	if jsonOutput == nil {
+		return
+	}
+
+	record := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		record[k] = v
+	}
+	record["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	record["level"] = level
+	record["event"] = event
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		Warningf("Failed to encode %q event as JSON: %v", event, err)
+		return
+	}
+	if _, err := jsonOutput.Write(append(line, '\n')); err != nil {
+		Warningf("Failed to write %q event: %v", event, err)
+	}
+}
\ No newline at end of file
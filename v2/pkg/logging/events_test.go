@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEvent_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	SetJSONOutput(&buf)
+	defer SetJSONOutput(nil)
+
+	Event("files_read", map[string]interface{}{"count": 3})
+	ErrorEvent("flow_failed", errors.New("boom"), nil)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d JSON lines, want 2: %q", len(lines), buf.String())
+	}
+
+	var first map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("first line is not valid JSON: %v", err)
+	}
+	if first["event"] != "files_read" || first["level"] != "info" || first["count"] != float64(3) || first["time"] == nil {
+		t.Errorf("first event = %v, want files_read at info level with count 3 and a time", first)
+	}
+
+	var second map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatalf("second line is not valid JSON: %v", err)
+	}
+	if second["event"] != "flow_failed" || second["level"] != "error" || second["error"] != "boom" {
+		t.Errorf("second event = %v, want flow_failed at error level with error boom", second)
+	}
+}
+
+func TestEvent_TextModeIsSilent(t *testing.T) {
+	var buf bytes.Buffer
+	SetJSONOutput(&buf)
+	SetJSONOutput(nil)
+
+	Event("files_read", map[string]interface{}{"count": 3})
+	if buf.Len() != 0 {
+		t.Errorf("text mode wrote %q, want nothing", buf.String())
+	}
+}
\ No newline at end of file
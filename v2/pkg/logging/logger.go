@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/golang/glog"
+)
+
+// Logger receives the log messages of the library packages. The default logs through
+// glog, as the coder command does; a program embedding the packages can install its own
+// with SetLogger. Implementations must be safe for concurrent use.
+type Logger interface {
+	// Enabled reports whether informational messages of the given verbosity (0 being
+	// the most important) are logged.
+	Enabled(level int) bool
+	Info(msg string)
+	Warning(msg string)
+	Error(msg string)
+}
+
+// loggerHolder lets an interface value be stored in an atomic.Pointer.
+type loggerHolder struct{ Logger }
+
+var current atomic.Pointer[loggerHolder]
+
+// SetLogger makes the library packages log through l. Passing nil restores the glog logger.
+func SetLogger(l Logger) {
+	if l == nil {
+		current.Store(nil)
+		return
+	}
+	current.Store(&loggerHolder{l})
+}
+
+func logger() Logger {
+	if h := current.Load(); h != nil {
+		return h.Logger
+	}
+	return glogLogger{}
+}
+
+// Verbose is returned by V; its methods log only if the verbosity level was enabled,
+// like glog.Verbose.
+type Verbose bool
+
+// V reports whether informational messages of the given verbosity are logged, e.g.
+// logging.V(1).Infof(...) logs only with -v=1 or higher under glog.
+func V(level int) Verbose {
+	return Verbose(logger().Enabled(level))
+}
+
+// Info logs its arguments, formatted as by fmt.Sprint, if v is enabled.
+func (v Verbose) Info(args ...interface{}) {
+	if v {
+		logger().Info(fmt.Sprint(args...))
+	}
+}
+
+// Infof logs a message formatted as by fmt.Sprintf, if v is enabled.
+func (v Verbose) Infof(format string, args ...interface{}) {
+	if v {
+		logger().Info(fmt.Sprintf(format, args...))
+	}
+}
+
+// Warning logs its arguments, formatted as by fmt.Sprint, as a warning.
+func Warning(args ...interface{}) {
+	logger().Warning(fmt.Sprint(args...))
+}
+
+// Warningf logs a message formatted as by fmt.Sprintf as a warning.
+func Warningf(format string, args ...interface{}) {
+	logger().Warning(fmt.Sprintf(format, args...))
+}
+
+// Error logs its arguments, formatted as by fmt.Sprint, as an error.
+func Error(args ...interface{}) {
+	logger().Error(fmt.Sprint(args...))
+}
+
+// Errorf logs a message formatted as by fmt.Sprintf as an error.
+func Errorf(format string, args ...interface{}) {
+	logger().Error(fmt.Sprintf(format, args...))
+}
+
+// glogDepth is the number of stack frames between a glogLogger method and the code that
+// called V, Warningf and so on, so glog reports that code's file and line, and applies
+// -vmodule to it, as if it had called glog directly.
+const glogDepth = 2
+
+// glogLogger is the default Logger, logging through glog.
+type glogLogger struct{}
+
+func (glogLogger) Enabled(level int) bool { return bool(glog.VDepth(glogDepth, glog.Level(level))) }
+func (glogLogger) Info(msg string)        { glog.InfoDepth(glogDepth, msg) }
+func (glogLogger) Warning(msg string)     { glog.WarningDepth(glogDepth, msg) }
+func (glogLogger) Error(msg string)       { glog.ErrorDepth(glogDepth, msg) }
\ No newline at end of file
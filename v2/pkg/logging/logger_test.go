@@ -0,0 +1,64 @@
+package logging_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+	"github.com/zicongmei/ai-coder/v2/pkg/prompt"
+)
+
+// captureLogger records the messages logged up to its verbosity.
+type captureLogger struct {
+	verbosity int
+
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *captureLogger) Enabled(level int) bool { return level <= l.verbosity }
+func (l *captureLogger) Info(msg string)        { l.add("I " + msg) }
+func (l *captureLogger) Warning(msg string)     { l.add("W " + msg) }
+func (l *captureLogger) Error(msg string)       { l.add("E " + msg) }
+
+func (l *captureLogger) add(msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, msg)
+}
+
+func TestSetLogger(t *testing.T) {
+	capture := &captureLogger{verbosity: 1}
+	logging.SetLogger(capture)
+	defer logging.SetLogger(nil)
+
+	logging.V(0).Infof("read %d files", 3)
+	logging.V(1).Info("details", 1)
+	logging.V(2).Info("too verbose")
+	logging.Warningf("slow %s", "read")
+	logging.Error("failed: ", fmt.Errorf("boom"))
+
+	want := []string{"I read 3 files", "I details1", "W slow read", "E failed: boom"}
+	if !reflect.DeepEqual(capture.messages, want) {
+		t.Errorf("logged %q, want %q", capture.messages, want)
+	}
+}
+
+func TestSetLogger_LibraryPackages(t *testing.T) {
+	capture := &captureLogger{verbosity: 1}
+	logging.SetLogger(capture)
+	defer logging.SetLogger(nil)
+
+	prompt.GeneratePrompt("Fix the bug.", map[string]string{"/src/a.go": "package a\n"}, prompt.Options{})
+
+	found := false
+	for _, msg := range capture.messages {
+		found = found || strings.Contains(msg, "Prompt generation complete")
+	}
+	if !found {
+		t.Errorf("GeneratePrompt() did not log through the installed logger; got %q", capture.messages)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,196 @@
+package modifyFiles
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// Diff engines selectable with Options.DiffEngine.
+const (
+	DiffEngineLines = "lines" // Apply hunks in order, each after the previous one (like `patch`)
+	DiffEngineText  = "text"  // Apply each hunk on its own wherever its text matches best
+	DiffEngineAuto  = "auto"  // DiffEngineLines, falling back to DiffEngineText for a file it cannot patch
+)
+
+// unavailableDiffEngines names diff engines that are asked for but not implemented, with
+// the reason, so ValidateDiffEngine can say more than that they are unknown.
+var unavailableDiffEngines = map[string]string{
+	"gitdiff": "the go-gitdiff library is not a dependency; use \"lines\", which applies hunks in order like git apply",
+	"dmp":     "the diff-match-patch library is not a dependency; use \"text\" or \"auto\" to place hunks by their content",
+}
+
+// diffApplier applies the hunks of a file diff to original, the content of the old file
+// with "\n" line endings. It returns the patched content and the number of hunks placed
+// by fuzzy matching, which is only tried with fuzzy set.
+type diffApplier interface {
+	applyHunks(original string, hunks []hunk, fuzzy bool) (string, int, error)
+}
+
+// linesApplier is the diffApplier of DiffEngineLines (see applyHunks).
+type linesApplier struct{}
+
+func (linesApplier) applyHunks(original string, hunks []hunk, fuzzy bool) (string, int, error) {
+	return applyHunks(original, hunks, fuzzy)
+}
+
+// textApplier is the diffApplier of DiffEngineText (see applyHunksByText).
+type textApplier struct{}
+
+func (textApplier) applyHunks(original string, hunks []hunk, fuzzy bool) (string, int, error) {
+	return applyHunksByText(original, hunks, fuzzy)
+}
+
+// fallbackApplier applies hunks with primary, retrying a file primary cannot patch with
+// fallback. If both fail, the error joins both failures.
+type fallbackApplier struct {
+	primary, fallback         diffApplier
+	primaryName, fallbackName string // Engine names, for messages
+}
+
+func (a fallbackApplier) applyHunks(original string, hunks []hunk, fuzzy bool) (string, int, error) {
+	newContent, fuzzyHunks, err := a.primary.applyHunks(original, hunks, fuzzy)
+	if err == nil {
+		return newContent, fuzzyHunks, nil
+	}
+	logging.Warningf("The %s diff engine failed (%v); retrying with the %s engine.", a.primaryName, err, a.fallbackName)
+	newContent, fuzzyHunks, fallbackErr := a.fallback.applyHunks(original, hunks, fuzzy)
+	if fallbackErr == nil {
+		return newContent, fuzzyHunks, nil
+	}
+	logging.Warningf("The %s diff engine failed too: %v", a.fallbackName, fallbackErr)
+	return "", 0, errors.Join(fmt.Errorf("%s engine: %w", a.primaryName, err), fmt.Errorf("%s engine: %w", a.fallbackName, fallbackErr))
+}
+
+// diffAppliers maps the diff engines to their appliers.
+var diffAppliers = map[string]diffApplier{
+	DiffEngineLines: linesApplier{},
+	DiffEngineText:  textApplier{},
+	DiffEngineAuto:  fallbackApplier{primary: linesApplier{}, fallback: textApplier{}, primaryName: DiffEngineLines, fallbackName: DiffEngineText},
+}
+
+// ValidateDiffEngine returns an error if engine is not a known diff engine.
+// An empty engine is treated as DiffEngineLines.
+func ValidateDiffEngine(engine string) error {
+	if _, ok := diffAppliers[engine]; ok || engine == "" {
+		return nil
+	}
+	if reason, ok := unavailableDiffEngines[engine]; ok {
+		return fmt.Errorf("diff engine %q is not available: %s", engine, reason)
+	}
+	return fmt.Errorf("unknown diff engine %q (want %q, %q or %q)", engine, DiffEngineLines, DiffEngineText, DiffEngineAuto)
+}
+
+// applyDiffHunks applies hunks to original with the applier of engine, or that of
+// DiffEngineLines if engine is empty or unknown.
+func applyDiffHunks(original string, hunks []hunk, engine string, fuzzy bool) (string, int, error) {
+	applier, ok := diffAppliers[engine]
+	if !ok {
+		applier = linesApplier{}
+	}
+	return applier.applyHunks(original, hunks, fuzzy)
+}
+
+// applyHunksByText applies each hunk to the content patched by the hunks before it,
+// wherever its context and removed lines appear, choosing the match closest to the line
+// given in its header (shifted by the lines earlier hunks added or removed above it).
+// Unlike applyHunks, it accepts hunks out of file order and never skips text between
+// them, at the cost of possibly patching a repeated block other than the intended one.
+// With fuzzy set, a hunk that matches nowhere exactly is placed with fuzzyMatchAt at the
+// closest position where it matches. The second result counts the hunks placed that way.
+func applyHunksByText(original string, hunks []hunk, fuzzy bool) (string, int, error) {
+	lines, finalNewline := splitLines(original)
+	if original == "" {
+		finalNewline = true
+	}
+
+	type shift struct{ at, delta int } // Lines added (or removed, if negative) at a line of the original
+	var shifts []shift
+	fuzzyHunks := 0
+	for _, h := range hunks {
+		var oldLines, newLines []string
+		for _, l := range h.lines {
+			if l.op != '+' {
+				oldLines = append(oldLines, l.text)
+			}
+			if l.op != '-' {
+				newLines = append(newLines, l.text)
+			}
+		}
+
+		stated := h.oldStart - 1
+		if len(oldLines) == 0 {
+			stated = h.oldStart // A pure insertion's start line is the line it follows
+		}
+		expected := stated
+		for _, s := range shifts {
+			if s.at <= stated {
+				expected += s.delta
+			}
+		}
+		expected = min(max(expected, 0), len(lines))
+
+		start, end, replacement := -1, -1, newLines
+		if len(oldLines) == 0 {
+			start, end = expected, expected
+		} else if start = closestMatch(lines, oldLines, expected); start != -1 {
+			end = start + len(oldLines)
+		}
+		if start == -1 && fuzzy {
+			for offset := 0; offset <= len(lines) && start == -1; offset++ {
+				for _, i := range []int{expected - offset, expected + offset} {
+					if i < 0 || i > len(lines) {
+						continue
+					}
+					if r, e, ok := fuzzyMatchAt(lines, h, i); ok {
+						replacement, start, end = r, i, e
+						logging.Warningf("Hunk %s does not match the file exactly; applied it at line %d by fuzzy matching.", h.header, start+1)
+						fuzzyHunks++
+						break
+					}
+				}
+			}
+		}
+		if start == -1 {
+			return "", 0, fmt.Errorf("hunk %s does not match the file content anywhere: %s", h.header, describeMismatch(lines, oldLines, min(expected, max(len(lines)-len(oldLines), 0))))
+		}
+
+		// A hunk reaching the end of the file decides whether the file ends with a newline.
+		if end == len(lines) {
+			finalNewline = true
+			for i := len(h.lines) - 1; i >= 0; i-- {
+				if h.lines[i].op != '-' {
+					finalNewline = !h.lines[i].noNewline
+					break
+				}
+			}
+		}
+		patched := append(append(append([]string(nil), lines[:start]...), replacement...), lines[end:]...)
+		shifts = append(shifts, shift{at: stated, delta: len(replacement) - (end - start)})
+		lines = patched
+	}
+
+	if len(lines) == 0 {
+		return "", fuzzyHunks, nil
+	}
+	newContent := strings.Join(lines, "\n")
+	if finalNewline {
+		newContent += "\n"
+	}
+	return newContent, fuzzyHunks, nil
+}
+
+// closestMatch returns the index in lines where want matches that is closest to
+// expected, preferring the earlier of two equally close matches, or -1 if it matches
+// nowhere.
+func closestMatch(lines, want []string, expected int) int {
+	best := -1
+	for i := 0; i+len(want) <= len(lines); i++ {
+		if linesMatchAt(lines, want, i) && (best == -1 || abs(i-expected) < abs(best-expected)) {
+			best = i
+		}
+	}
+	return best
+}
\ No newline at end of file
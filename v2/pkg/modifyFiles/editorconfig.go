@@ -0,0 +1,184 @@
+package modifyFiles
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// editorConfigName is the name of the files holding EditorConfig rules.
+const editorConfigName = ".editorconfig"
+
+// indentStyle describes how a file is indented.
+type indentStyle struct {
+	tabs bool // Indent with tabs rather than spaces
+	size int  // Columns per indentation level; 0 if unknown
+}
+
+// editorConfigIndent returns the indentation that the .editorconfig files in the
+// directories containing filePath, up to the one marked root, prescribe for it. Only the
+// indent_style, indent_size and tab_width properties are read. The second result is
+// false if no rule sets indent_style for the file.
+func editorConfigIndent(filePath string) (indentStyle, bool) {
+	abs, err := filepath.Abs(filePath)
+	if err != nil {
+		return indentStyle{}, false
+	}
+	// Collect the files from the root down, so that closer files override farther ones.
+	var configs []string
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		config := filepath.Join(dir, editorConfigName)
+		if root, ok := readEditorConfigRoot(config); ok {
+			configs = append([]string{config}, configs...)
+			if root {
+				break
+			}
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+
+	props := make(map[string]string)
+	for _, config := range configs {
+		applyEditorConfig(config, abs, props)
+	}
+	var style indentStyle
+	switch props["indent_style"] {
+	case "tab":
+		style.tabs = true
+	case "space":
+	default:
+		return indentStyle{}, false
+	}
+	size := props["indent_size"]
+	if size == "tab" || size == "" {
+		size = props["tab_width"]
+	}
+	style.size, _ = strconv.Atoi(size)
+	logging.V(2).Infof("EditorConfig indentation for %q: tabs %t, size %d.", filePath, style.tabs, style.size)
+	return style, true
+}
+
+// readEditorConfigRoot reports whether the EditorConfig file at configPath exists
+// (second result) and declares root = true in its preamble (first result).
+func readEditorConfigRoot(configPath string) (bool, bool) {
+	f, err := os.Open(configPath)
+	if err != nil {
+		return false, false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			break // The preamble ends at the first section
+		}
+		if key, value, ok := parseEditorConfigProperty(line); ok && key == "root" {
+			return value == "true", true
+		}
+	}
+	return false, true
+}
+
+// applyEditorConfig sets in props the properties of the sections of the EditorConfig
+// file at configPath whose glob matches the file at filePath. Later sections win.
+func applyEditorConfig(configPath, filePath string, props map[string]string) {
+	f, err := os.Open(configPath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	rel, err := filepath.Rel(filepath.Dir(configPath), filePath)
+	if err != nil {
+		return
+	}
+	rel = filepath.ToSlash(rel)
+
+	matches := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			matches = editorConfigGlobMatch(line[1:len(line)-1], rel)
+			continue
+		}
+		if key, value, ok := parseEditorConfigProperty(line); ok && matches {
+			props[key] = value
+		}
+	}
+}
+
+// parseEditorConfigProperty splits a "key = value" line, lowercasing both. Comments and
+// blank lines are not properties.
+func parseEditorConfigProperty(line string) (string, string, bool) {
+	if line == "" || line[0] == '#' || line[0] == ';' {
+		return "", "", false
+	}
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return "", "", false
+	}
+	return strings.ToLower(strings.TrimSpace(key)), strings.ToLower(strings.TrimSpace(value)), true
+}
+
+// editorConfigGlobMatch reports whether the section glob matches rel, the slash-separated
+// path of the file relative to the .editorconfig file. A glob without a slash matches the
+// base name in any directory. "*" matches within a path segment, a "**" segment any
+// number of directories and "{a,b}" either alternative; numeric ranges are not supported.
+func editorConfigGlobMatch(glob, rel string) bool {
+	for _, alternative := range expandBraces(glob) {
+		pattern, target := alternative, rel
+		if !strings.Contains(pattern, "/") {
+			target = filepath.Base(rel)
+		} else {
+			pattern = strings.TrimPrefix(pattern, "/")
+		}
+		if matchGlobSegments(strings.Split(pattern, "/"), strings.Split(target, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandBraces expands the first "{a,b}" group in glob, recursively, into one pattern
+// per alternative.
+func expandBraces(glob string) []string {
+	open := strings.IndexByte(glob, '{')
+	end := strings.IndexByte(glob, '}')
+	if open < 0 || end < open {
+		return []string{glob}
+	}
+	var patterns []string
+	for _, alternative := range strings.Split(glob[open+1:end], ",") {
+		patterns = append(patterns, expandBraces(glob[:open]+alternative+glob[end+1:])...)
+	}
+	return patterns
+}
+
+// matchGlobSegments reports whether the path segments in name match the pattern
+// segments, matching each with path.Match and a "**" segment with any number of
+// directories.
+func matchGlobSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchGlobSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	ok, err := path.Match(pattern[0], name[0])
+	return err == nil && ok && matchGlobSegments(pattern[1:], name[1:])
+}
\ No newline at end of file
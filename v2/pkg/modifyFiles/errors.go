@@ -0,0 +1,25 @@
+package modifyFiles
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ParseError reports that an AI response could not be parsed into file changes,
+// or that the parsed changes (e.g. diff hunks) do not match the files on disk.
+// Unlike I/O failures while writing files, a ParseError is caused by the shape of
+// the response itself, so asking the model again is usually worthwhile.
+type ParseError struct {
+	Reason string // Human-readable description of what was malformed
+}
+
+// Error implements the error interface.
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("malformed AI response: %s", e.Reason)
+}
+
+// IsParseError reports whether err (or any error it wraps) is a *ParseError.
+func IsParseError(err error) bool {
+	var parseErr *ParseError
+	return errors.As(err, &parseErr)
+}
\ No newline at end of file
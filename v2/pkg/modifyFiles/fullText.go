@@ -1,65 +1,88 @@
 package modifyFiles
 
 import (
+	"encoding/base64"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
-	"github.com/golang/glog"
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
 	"github.com/zicongmei/ai-coder/v2/pkg/utils"
 )
 
 // ApplyFullTextChangesToFiles parses the AI response containing full text of modified files
 // and writes the content to the respective files on disk.
 // The AI response is expected to be formatted with explicit BEGIN_OF_FILE and END_OF_FILE
-// markers, matching the constants defined in prompt/generate.go.
+// markers, matching those the prompt was generated with (Options.Markers, by default
+// the constants in utils/marker.go).
 // Example format:
-// --- BEGIN_OF_FILE: /path/to/file1 ---
+// --- Start of File: /path/to/file1 ---
 // {content for /path/to/file1}
-// --- END_OF_FILE: /path/to/file1 ---
-func ApplyFullTextChangesToFiles(fullTextResponse string) error {
+// --- End of File: /path/to/file1 ---
+// Relative paths are resolved against the requested files (see Options.Requested and Options.Root).
+// Blocks for files outside Options.Requested are not written unless Options.AllowNew is set,
+// and blocks for Options.ReadOnly files or for extensions outside Options.AllowedExts are never written.
+// With Options.PreserveIndent set, existing files keep their indentation style, and with
+// Options.Gofmt set, Go files are formatted before they are written. With
+// Options.DryRun set, nothing is written and a diff of each file is printed instead.
+// The returned ApplyResult lists the files written, including those written before an error.
+func ApplyFullTextChangesToFiles(fullTextResponse string, opts Options) (ApplyResult, error) {
+	var result ApplyResult
 	fullTextResponse = cleanAIMarkdown(fullTextResponse) // Use common markdown cleaner
 
 	// Trim leading/trailing whitespace (including newlines) from the entire response.
 	// This helps in handling potential preamble/postamble from the LLM that isn't part of the structured file content.
 	fullTextResponse = strings.TrimSpace(fullTextResponse)
 
-	fullTextPath := "/tmp/fullTextChanges.txt"
-	err := os.WriteFile(fullTextPath, []byte(fullTextResponse), 0644)
+	dumpResponse("fullTextChanges", opts.DumpTag, fullTextResponse)
+
+	only, err := newOnlyFilter(opts.Only)
+	if err != nil {
+		return result, err
+	}
+	requested, err := newPathSet(opts.Requested)
 	if err != nil {
-		glog.Errorf("Failed to write full text response to %s: %v", fullTextPath, err)
-		return fmt.Errorf("failed to write %s: %w", fullTextPath, err)
+		return result, err
 	}
-	glog.V(2).Infof("Full text response written to %s", fullTextPath)
+	readOnly, err := newPathSet(opts.ReadOnly)
+	if err != nil {
+		return result, err
+	}
+	allowedExts := newExtAllowlist(opts.AllowedExts)
+	markers := opts.Markers.OrDefault()
 
 	remainingResponse := fullTextResponse
 	foundAnyFile := false
 
 	for {
 		// Find the start of the next file block
-		beginIndex := strings.Index(remainingResponse, utils.BeginMarkerPrefix)
+		beginIndex := strings.Index(remainingResponse, markers.BeginPrefix)
 		if beginIndex == -1 {
 			break // No more begin markers found
 		}
 
 		// The path starts immediately after `beginMarkerPrefix`
-		pathStartInRemaining := beginIndex + len(utils.BeginMarkerPrefix)
+		pathStartInRemaining := beginIndex + len(markers.BeginPrefix)
 
 		// The path ends before `beginMarkerSuffix`
-		pathEndInSegment := strings.Index(remainingResponse[pathStartInRemaining:], utils.BeginMarkerSuffix)
+		pathEndInSegment := strings.Index(remainingResponse[pathStartInRemaining:], markers.BeginSuffix)
 		if pathEndInSegment == -1 {
-			glog.Warningf("Malformed BEGIN_OF_FILE marker: missing suffix %q near %q. Skipping remaining response.",
-				utils.BeginMarkerSuffix, utils.TruncateString(remainingResponse[beginIndex:], 100))
-			break // Malformed marker, cannot parse further
+			// A BEGIN marker cut off before its suffix means the response was truncated.
+			logging.Errorf("Malformed BEGIN_OF_FILE marker: missing suffix %q near %q. The response appears to be truncated.",
+				markers.BeginSuffix, utils.TruncateString(remainingResponse[beginIndex:], 100))
+			return result, &ParseError{Reason: fmt.Sprintf("truncated response: BEGIN_OF_FILE marker near %q has no closing %q",
+				utils.TruncateString(remainingResponse[beginIndex:], 100), markers.BeginSuffix)}
 		}
 
-		filePath := strings.TrimSpace(remainingResponse[pathStartInRemaining : pathStartInRemaining+pathEndInSegment])
+		filePath, hintedLength := utils.SplitLengthHint(strings.TrimSpace(remainingResponse[pathStartInRemaining : pathStartInRemaining+pathEndInSegment]))
+		filePath = strings.TrimSpace(filePath)
 
 		// Content starts immediately after the full begin marker
-		contentStartIndex := pathStartInRemaining + pathEndInSegment + len(utils.BeginMarkerSuffix)
+		contentStartIndex := pathStartInRemaining + pathEndInSegment + len(markers.BeginSuffix)
 
 		// Construct the full end marker string for this specific file
-		fullEndMarker := fmt.Sprintf("%s%s%s", utils.EndMarkerPrefix, filePath, utils.EndMarkerSuffix)
+		fullEndMarker := markers.End(filePath)
 
 		// Search for the end marker in the portion of the response *after* the content started
 		endIndexInContentSegment := strings.Index(remainingResponse[contentStartIndex:], fullEndMarker)
@@ -67,7 +90,7 @@ func ApplyFullTextChangesToFiles(fullTextResponse string) error {
 		// Robustness: try matching the end marker without the final trailing newline, as LLMs can sometimes omit it.
 		// This must be a distinct marker string to ensure correct length calculation later.
 		if endIndexInContentSegment == -1 {
-			fullEndMarkerNoTrailingNewline := fmt.Sprintf("%s%s ---", utils.EndMarkerPrefix, filePath)
+			fullEndMarkerNoTrailingNewline := strings.TrimSuffix(fullEndMarker, "\n")
 			endIndexInContentSegment = strings.Index(remainingResponse[contentStartIndex:], fullEndMarkerNoTrailingNewline)
 			// If found, update `fullEndMarker` so its length is correct for advancing `remainingResponse`
 			if endIndexInContentSegment != -1 {
@@ -76,52 +99,149 @@ func ApplyFullTextChangesToFiles(fullTextResponse string) error {
 		}
 
 		if endIndexInContentSegment == -1 {
-			glog.Warningf("Malformed or missing END_OF_FILE marker for %q. Expected %q or %q near %q. Skipping this file and remainder.",
+			// A BEGIN marker without a matching END marker means the response was truncated
+			// (or malformed); the file is left unwritten rather than overwritten with partial content.
+			logging.Errorf("Missing END_OF_FILE marker for %q. Expected %q or %q near %q. The response appears to be truncated; the file was not written.",
 				filePath,
-				fmt.Sprintf("%s%s%s", utils.EndMarkerPrefix, filePath, utils.EndMarkerSuffix),
-				fmt.Sprintf("%s%s ---", utils.EndMarkerPrefix, filePath),
+				markers.End(filePath),
+				strings.TrimSuffix(markers.End(filePath), "\n"),
 				utils.TruncateString(remainingResponse[contentStartIndex:], 100))
-			break // Cannot find end marker, break from loop
+			return result, &ParseError{Reason: fmt.Sprintf("truncated response: no END_OF_FILE marker for %q", filePath)}
 		}
 
 		// Extract the file content
 		fileContent := remainingResponse[contentStartIndex : contentStartIndex+endIndexInContentSegment]
+		if opts.Base64 {
+			decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(fileContent), ""))
+			if err != nil {
+				logging.Errorf("The block for %q is not valid base64: %v; the file was not written.", filePath, err)
+				return result, &ParseError{Reason: fmt.Sprintf("content of %q is not valid base64: %v", filePath, err)}
+			}
+			fileContent = string(decoded)
+		}
+		if hintedLength >= 0 && !lengthMatches(fileContent, hintedLength) {
+			if opts.LengthHints {
+				logging.Errorf("The block for %q has %d bytes but its marker says %d; the file was not written.", filePath, len(fileContent), hintedLength)
+				return result, &ParseError{Reason: fmt.Sprintf("length mismatch for %q: the marker says %d bytes, the block has %d", filePath, hintedLength, len(fileContent))}
+			}
+			logging.Warningf("The block for %q has %d bytes but its marker says %d; it may be truncated.", filePath, len(fileContent), hintedLength)
+		}
+
+		targetPath, err := resolveResponsePath(filePath, opts)
+		if err != nil {
+			logging.Errorf("Cannot write the block for %q: %v", filePath, err)
+			return result, err
+		}
+		// Advance `remainingResponse` past the current file's block for the next iteration
+		remainingResponse = remainingResponse[contentStartIndex+endIndexInContentSegment+len(fullEndMarker):]
+		foundAnyFile = true
+
+		if readOnly.contains(targetPath) {
+			logging.Warningf("Refusing to write %q: it was provided as a read-only context file.", targetPath)
+			logging.Event("file_read_only", map[string]interface{}{"path": targetPath})
+			result.ReadOnly = append(result.ReadOnly, targetPath)
+			continue
+		}
+		if !allowedExts.allows(targetPath) {
+			logging.Warningf("Refusing to write %q: its extension is not in the allowlist %q.", targetPath, opts.AllowedExts)
+			logging.Event("file_disallowed", map[string]interface{}{"path": targetPath})
+			result.Disallowed = append(result.Disallowed, targetPath)
+			continue
+		}
+		if requested != nil && !opts.AllowNew && !requested.contains(targetPath) {
+			logging.Warningf("Refusing to write %q: the AI response changes a file that was not requested (use --allow-new to permit this).", targetPath)
+			logging.Event("file_unrequested", map[string]interface{}{"path": targetPath})
+			result.Unrequested = append(result.Unrequested, targetPath)
+			continue
+		}
+		if !only.allows(targetPath) {
+			logging.V(0).Infof("Skipping changes to %q: not selected for writing.", targetPath)
+			result.Skipped = append(result.Skipped, targetPath)
+			continue
+		}
 
 		// The prompt generator adds newlines around content (e.g., `\n---BEGIN---\ncontent\n---END---\n`).
-		// `os.WriteFile` will write exactly the extracted content. No `TrimSpace` here to preserve
-		// legitimate leading/trailing blank lines or newlines within the actual file content.
+		// No `TrimSpace` here to preserve legitimate leading/trailing blank lines within the
+		// actual file content; only the final newline is normalized by applyFinalNewlineRule.
 
-		glog.V(2).Infof("Attempting to write %d bytes to file: %q", len(fileContent), filePath)
-		glog.V(3).Infof("File content for %q (truncated): %q", filePath, utils.TruncateString(fileContent, 200))
+		logging.V(2).Infof("Attempting to write %d bytes to file: %q", len(fileContent), targetPath)
+		logging.V(3).Infof("File content for %q (truncated): %q", targetPath, utils.TruncateString(fileContent, 200))
 
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
-			glog.Warningf("File %q specified in AI response does not exist on disk. Creating it.", filePath)
+		originalBytes, err := os.ReadFile(targetPath)
+		created := os.IsNotExist(err)
+		if created {
+			logging.Warningf("File %q specified in AI response does not exist on disk. Creating it.", targetPath)
 			// For new files, 0644 permission is fine.
+			if !opts.Base64 {
+				fileContent = applyFinalNewlineRule(fileContent, true)
+			}
 		} else if err != nil {
-			glog.Errorf("Error checking file %q before writing: %v", filePath, err)
-			return fmt.Errorf("error checking file %q: %w", filePath, err)
+			logging.Errorf("Error checking file %q before writing: %v", targetPath, err)
+			return result, fmt.Errorf("error checking file %q: %w", targetPath, err)
+		} else {
+			if !opts.Base64 {
+				fileContent = applyFinalNewlineRule(fileContent, len(originalBytes) == 0 || strings.HasSuffix(string(originalBytes), "\n"))
+			}
+			if opts.PreserveIndent {
+				fileContent = preserveIndent(targetPath, string(originalBytes), fileContent)
+			}
+		}
+		if opts.TrimTrailing {
+			fileContent = trimTrailingWhitespace(fileContent)
+		}
+		if opts.NormalizeEOL {
+			fileContent = convertLineEndings(normalizeLineEndings(fileContent), resolveLineEnding(opts.LineEnding, string(originalBytes)))
 		}
 
-		err = os.WriteFile(filePath, []byte(fileContent), 0644)
-		if err != nil {
-			glog.Errorf("Failed to write content to file %q: %v", filePath, err)
-			return fmt.Errorf("failed to write content to file %q: %w", filePath, err)
+		if opts.Gofmt {
+			fileContent = gofmtContent(targetPath, fileContent, &result)
 		}
-		glog.V(0).Infof("Successfully updated file: %q", filePath)
-		foundAnyFile = true
 
-		// Advance `remainingResponse` past the current file's block for the next iteration
-		remainingResponse = remainingResponse[contentStartIndex+endIndexInContentSegment+len(fullEndMarker):]
+		if opts.DryRun {
+			oldPath := targetPath
+			if created {
+				oldPath = devNull
+			}
+			if err := writeDryRunDiff(opts, oldPath, targetPath, string(originalBytes), fileContent); err != nil {
+				return result, err
+			}
+			continue
+		}
+
+		if err := canceled(opts); err != nil {
+			return result, err
+		}
+		if err := backup(opts, targetPath); err != nil {
+			return result, err
+		}
+		err = writeFile(targetPath, fileContent)
+		if err != nil {
+			logging.Errorf("Failed to write content to file %q: %v", targetPath, err)
+			return result, fmt.Errorf("failed to write content to file %q: %w", targetPath, err)
+		}
+		if created {
+			logging.V(0).Infof("Successfully created file: %q", targetPath)
+			logging.Event("file_created", map[string]interface{}{"path": targetPath, "bytes": len(fileContent)})
+			result.Created = append(result.Created, targetPath)
+		} else {
+			logging.V(0).Infof("Successfully updated file: %q", targetPath)
+			logging.Event("file_modified", map[string]interface{}{"path": targetPath, "bytes": len(fileContent)})
+			result.Modified = append(result.Modified, targetPath)
+		}
 	}
 
 	if !foundAnyFile {
-		glog.Warning("AI response for full text changes did not contain any correctly formatted file blocks.")
+		logging.Warning("AI response for full text changes did not contain any correctly formatted file blocks.")
 		// Consider if a hard error is necessary here depending on expected behavior.
 		// For now, a warning is kept to allow partial success in case of malformed output.
-		return fmt.Errorf("no valid file blocks found in AI response")
+		return result, &ParseError{Reason: "no valid file blocks found in AI response"}
+	}
+	if err := only.check(); err != nil {
+		logging.Errorf("%v", err)
+		return result, err
 	}
 
-	return nil
+	return result, nil
 }
 
 // cleanAIMarkdown removes markdown code block fences (```) from the beginning and end of a string.
@@ -144,4 +264,54 @@ func cleanAIMarkdown(response string) string {
 		return strings.TrimSpace(processedResponse) // Trim again in case content also has leading/trailing newlines
 	}
 	return response
+}
+
+// dumpResponse saves response as name+tag+".txt" in the temporary directory for
+// debugging. A failure to write it is only logged.
+func dumpResponse(name, tag, response string) {
+	path := filepath.Join(os.TempDir(), name+tag+".txt")
+	if err := os.WriteFile(path, []byte(response), 0644); err != nil {
+		logging.Warningf("Failed to save the response to %q: %v", path, err)
+		return
+	}
+	logging.V(2).Infof("Response saved to %s", path)
+}
+
+// SingleFileContent returns the new content of a file from a response that holds only
+// that content, without BEGIN/END markers. A surrounding markdown code fence and
+// surrounding whitespace are removed, and a final newline is added if the original
+// content had one (or was empty).
+func SingleFileContent(response, original string) string {
+	content := cleanAIMarkdown(response)
+	if content != "" && (original == "" || strings.HasSuffix(original, "\n")) {
+		content += "\n"
+	}
+	return content
+}
+
+// applyFinalNewlineRule makes the presence of a final newline in content deterministic.
+// The newline before an END marker belongs to the framing, so whether the model kept a
+// file's final newline is ambiguous. The rule is:
+//   - If the file should end with a newline (the original did, or the file is new or empty),
+//     a missing final newline is added.
+//   - If the original did not end with a newline, a single trailing newline is removed.
+//   - Content ending in a blank line ("\n\n") explicitly changes the ending and is kept as is.
+func applyFinalNewlineRule(content string, wantFinalNewline bool) string {
+	if content == "" || strings.HasSuffix(content, "\n\n") {
+		return content
+	}
+	if wantFinalNewline && !strings.HasSuffix(content, "\n") {
+		return content + "\n"
+	}
+	if !wantFinalNewline && strings.HasSuffix(content, "\n") {
+		return strings.TrimSuffix(content, "\n")
+	}
+	return content
+}
+
+// lengthMatches reports whether content agrees with the length hint of its block. A
+// difference of one byte is tolerated, since models often count the final newline differently.
+func lengthMatches(content string, hinted int) bool {
+	diff := len(content) - hinted
+	return diff >= -1 && diff <= 1
 }
\ No newline at end of file
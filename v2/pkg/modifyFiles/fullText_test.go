@@ -0,0 +1,553 @@
+package modifyFiles
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+// fullTextBlock frames content the same way prompt.GeneratePrompt does.
+func fullTextBlock(path, content string) string {
+	return utils.BeginMarkerPrefix + path + utils.BeginMarkerSuffix + content + utils.EndMarkerPrefix + path + utils.EndMarkerSuffix
+}
+
+func TestApplyFullTextChangesToFiles_FinalNewline(t *testing.T) {
+	tests := []struct {
+		name     string
+		original *string // nil means the file does not exist yet
+		returned string  // Content the model put between the markers
+		want     string
+	}{
+		{name: "Newline kept", original: ptr("old\n"), returned: "new\n", want: "new\n"},
+		{name: "Newline dropped by model is restored", original: ptr("old\n"), returned: "new", want: "new\n"},
+		{name: "No newline kept", original: ptr("old"), returned: "new", want: "new"},
+		{name: "Newline added by model is stripped", original: ptr("old"), returned: "new\n", want: "new"},
+		{name: "Explicit trailing blank line is kept", original: ptr("old"), returned: "new\n\n", want: "new\n\n"},
+		{name: "New file gets a newline", original: nil, returned: "new", want: "new\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file.txt")
+			if tt.original != nil {
+				if err := os.WriteFile(path, []byte(*tt.original), 0644); err != nil {
+					t.Fatalf("Failed to write %q: %v", path, err)
+				}
+			}
+			if _, err := ApplyFullTextChangesToFiles(fullTextBlock(path, tt.returned), Options{}); err != nil {
+				t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+			}
+			got, _ := os.ReadFile(path)
+			if string(got) != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyFullTextChangesToFiles_RoundTrip(t *testing.T) {
+	// Unchanged content echoed back in the prompt's framing must not alter the file.
+	for _, original := range []string{"line1\nline2\n", "line1\nline2", "line1\n\n"} {
+		path := filepath.Join(t.TempDir(), "file.txt")
+		if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+		if _, err := ApplyFullTextChangesToFiles(fullTextBlock(path, original), Options{}); err != nil {
+			t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+		}
+		if got, _ := os.ReadFile(path); string(got) != original {
+			t.Errorf("round trip of %q produced %q", original, got)
+		}
+	}
+}
+
+func TestApplyFullTextChangesToFiles_Base64(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tricky.txt")
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", path, err)
+	}
+	// Marker text, trailing whitespace, no final newline, non-ASCII and invalid UTF-8 bytes.
+	content := fullTextBlock("/other.go", "x\n") + "caf\u00e9 \t\n\xff\xfe\x00 end"
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+	// Models wrap long base64 text, so the decoder must ignore line breaks.
+	wrapped := encoded[:20] + "\n" + encoded[20:] + "\n"
+
+	if _, err := ApplyFullTextChangesToFiles(fullTextBlock(path, wrapped), Options{Base64: true}); err != nil {
+		t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != content {
+		t.Errorf("content of %q = %q, want %q", path, got, content)
+	}
+
+	_, err := ApplyFullTextChangesToFiles(fullTextBlock(path, "not base64!\n"), Options{Base64: true})
+	if !IsParseError(err) {
+		t.Errorf("ApplyFullTextChangesToFiles() on invalid base64 error = %v, want a ParseError", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != content {
+		t.Errorf("content of %q after invalid base64 = %q, want it unchanged", path, got)
+	}
+}
+
+func TestApplyFullTextChangesToFiles_Truncated(t *testing.T) {
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.txt")
+	bPath := filepath.Join(dir, "b.txt")
+	for _, path := range []string{aPath, bPath} {
+		if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+	}
+
+	// The response is cut off in the middle of b.txt's content.
+	truncated := fullTextBlock(aPath, "new a\n") + utils.BeginMarkerPrefix + bPath + utils.BeginMarkerSuffix + "new"
+	_, err := ApplyFullTextChangesToFiles(truncated, Options{})
+	if !IsParseError(err) {
+		t.Fatalf("ApplyFullTextChangesToFiles() error = %v, want a ParseError", err)
+	}
+	if !strings.Contains(err.Error(), bPath) {
+		t.Errorf("error %q does not name the truncated file %q", err, bPath)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "new a\n" {
+		t.Errorf("content of %q = %q, want the complete block to be applied", aPath, got)
+	}
+	if got, _ := os.ReadFile(bPath); string(got) != "old\n" {
+		t.Errorf("content of %q = %q, want it unchanged", bPath, got)
+	}
+
+	// A response cut off inside the BEGIN marker itself is also reported.
+	if _, err := ApplyFullTextChangesToFiles(utils.BeginMarkerPrefix+aPath, Options{}); !IsParseError(err) {
+		t.Errorf("ApplyFullTextChangesToFiles() on a clipped BEGIN marker error = %v, want a ParseError", err)
+	}
+}
+
+func TestApplyFullTextChangesToFiles_RelativePaths(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "pkg")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("Failed to create %q: %v", sub, err)
+	}
+	fooPath := filepath.Join(sub, "foo.go")
+	barPath := filepath.Join(sub, "bar.go")
+	for _, path := range []string{fooPath, barPath} {
+		if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+	}
+	opts := Options{Requested: []string{fooPath, barPath}, Root: dir}
+
+	// Relative to the workspace root, and relative to the requested files' directory.
+	response := fullTextBlock("pkg/foo.go", "new foo\n") + fullTextBlock("bar.go", "new bar\n")
+	result, err := ApplyFullTextChangesToFiles(response, opts)
+	if err != nil {
+		t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+	}
+	if want := []string{fooPath, barPath}; !reflect.DeepEqual(result.Modified, want) {
+		t.Errorf("modified files = %q, want %q", result.Modified, want)
+	}
+	for path, want := range map[string]string{fooPath: "new foo\n", barPath: "new bar\n"} {
+		if got, _ := os.ReadFile(path); string(got) != want {
+			t.Errorf("content of %q = %q, want %q", path, got, want)
+		}
+	}
+
+	// A relative path that matches no requested file must not create a stray file.
+	_, err = ApplyFullTextChangesToFiles(fullTextBlock("stray.go", "package stray\n"), opts)
+	if !IsParseError(err) {
+		t.Fatalf("ApplyFullTextChangesToFiles() error = %v, want a ParseError", err)
+	}
+	for _, path := range []string{filepath.Join(dir, "stray.go"), filepath.Join(sub, "stray.go"), "stray.go"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("stray file %q was created", path)
+		}
+	}
+}
+
+func TestApplyFullTextChangesToFiles_Written(t *testing.T) {
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.txt")
+	bPath := filepath.Join(dir, "b.txt")
+	newPath := filepath.Join(dir, "new.txt")
+	for _, path := range []string{aPath, bPath} {
+		if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+	}
+
+	response := fullTextBlock(bPath, "new b\n") + fullTextBlock(newPath, "created\n") + fullTextBlock(aPath, "new a\n")
+	result, err := ApplyFullTextChangesToFiles(response, Options{Requested: []string{aPath, bPath}, AllowNew: true})
+	if err != nil {
+		t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+	}
+	// Every block of the response is written, existing files first.
+	if want := []string{bPath, aPath, newPath}; !reflect.DeepEqual(result.Written(), want) {
+		t.Errorf("Written() = %q, want %q", result.Written(), want)
+	}
+	for path, want := range map[string]string{aPath: "new a\n", bPath: "new b\n", newPath: "created\n"} {
+		if got, _ := os.ReadFile(path); string(got) != want {
+			t.Errorf("content of %q = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestApplyFullTextChangesToFiles_NormalizeEOL(t *testing.T) {
+	dir := t.TempDir()
+	crlfPath := filepath.Join(dir, "crlf.txt")
+	lfPath := filepath.Join(dir, "lf.txt")
+	newPath := filepath.Join(dir, "new.txt")
+	for path, content := range map[string]string{crlfPath: "one\r\ntwo\r\n", lfPath: "one\ntwo\n"} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+	}
+
+	const mixed = "a  \r\nb\nc\t\r\n"
+	tests := []struct {
+		name string
+		opts Options
+		want map[string]string
+	}{
+		{"off", Options{}, map[string]string{crlfPath: mixed, lfPath: mixed, newPath: mixed}},
+		{"normalize", Options{NormalizeEOL: true}, map[string]string{crlfPath: "a  \r\nb\r\nc\t\r\n", lfPath: "a  \nb\nc\t\n", newPath: "a  \nb\nc\t\n"}},
+		{"trim", Options{TrimTrailing: true}, map[string]string{crlfPath: "a\r\nb\nc\r\n", lfPath: "a\r\nb\nc\r\n", newPath: "a\r\nb\nc\r\n"}},
+		{"both", Options{NormalizeEOL: true, TrimTrailing: true}, map[string]string{crlfPath: "a\r\nb\r\nc\r\n", lfPath: "a\nb\nc\n", newPath: "a\nb\nc\n"}},
+		{"forced crlf", Options{NormalizeEOL: true, LineEnding: LineEndingCRLF}, map[string]string{crlfPath: "a  \r\nb\r\nc\t\r\n", lfPath: "a  \r\nb\r\nc\t\r\n", newPath: "a  \r\nb\r\nc\t\r\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, path := range []string{crlfPath, lfPath, newPath} {
+				// Write each file separately so the originals stay in place across cases.
+				original, _ := os.ReadFile(path)
+				if _, err := ApplyFullTextChangesToFiles(fullTextBlock(path, mixed), tt.opts); err != nil {
+					t.Fatalf("ApplyFullTextChangesToFiles(%q) error = %v", path, err)
+				}
+				got, _ := os.ReadFile(path)
+				if string(got) != tt.want[path] {
+					t.Errorf("content of %q = %q, want %q", path, got, tt.want[path])
+				}
+				if original == nil {
+					os.Remove(path)
+				} else if err := os.WriteFile(path, original, 0644); err != nil {
+					t.Fatalf("Failed to restore %q: %v", path, err)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyFullTextChangesToFiles_Only(t *testing.T) {
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.txt")
+	bPath := filepath.Join(dir, "b.txt")
+	for _, path := range []string{aPath, bPath} {
+		if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+	}
+	response := fullTextBlock(aPath, "new a\n") + fullTextBlock(bPath, "new b\n")
+
+	result, err := ApplyFullTextChangesToFiles(response, Options{Only: []string{bPath}})
+	if err != nil {
+		t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Modified, []string{bPath}) || !reflect.DeepEqual(result.Skipped, []string{aPath}) {
+		t.Errorf("result = %+v, want %q modified and %q skipped", result, bPath, aPath)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "old\n" {
+		t.Errorf("content of skipped %q = %q, want it unchanged", aPath, got)
+	}
+
+	missing := filepath.Join(dir, "missing.txt")
+	if _, err := ApplyFullTextChangesToFiles(response, Options{Only: []string{aPath, missing}}); err == nil || !strings.Contains(err.Error(), missing) {
+		t.Errorf("ApplyFullTextChangesToFiles() error = %v, want it to name %q", err, missing)
+	}
+}
+
+func TestApplyFullTextChangesToFiles_Unrequested(t *testing.T) {
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.txt")
+	extraPath := filepath.Join(dir, "extra.txt")
+	if err := os.WriteFile(aPath, []byte("old\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", aPath, err)
+	}
+	response := fullTextBlock(aPath, "new a\n") + fullTextBlock(extraPath, "extra\n")
+
+	result, err := ApplyFullTextChangesToFiles(response, Options{Requested: []string{aPath}})
+	if err != nil {
+		t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Modified, []string{aPath}) || !reflect.DeepEqual(result.Unrequested, []string{extraPath}) {
+		t.Errorf("result = %+v, want %q modified and %q unrequested", result, aPath, extraPath)
+	}
+	if _, err := os.Stat(extraPath); !os.IsNotExist(err) {
+		t.Errorf("unrequested file %q was created", extraPath)
+	}
+
+	result, err = ApplyFullTextChangesToFiles(response, Options{Requested: []string{aPath}, AllowNew: true})
+	if err != nil {
+		t.Fatalf("ApplyFullTextChangesToFiles() with AllowNew error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Created, []string{extraPath}) || len(result.Unrequested) != 0 {
+		t.Errorf("result = %+v, want %q created", result, extraPath)
+	}
+	if got, _ := os.ReadFile(extraPath); string(got) != "extra\n" {
+		t.Errorf("content of %q = %q, want %q", extraPath, got, "extra\n")
+	}
+}
+
+func TestApplyFullTextChangesToFiles_ReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.go")
+	apiPath := filepath.Join(dir, "api.go")
+	for _, path := range []string{aPath, apiPath} {
+		if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+	}
+	response := fullTextBlock(aPath, "new a\n") + fullTextBlock(apiPath, "new api\n")
+
+	// Even AllowNew does not let the response change a context file.
+	opts := Options{Requested: []string{aPath}, ReadOnly: []string{apiPath}, AllowNew: true}
+	result, err := ApplyFullTextChangesToFiles(response, opts)
+	if err != nil {
+		t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Modified, []string{aPath}) || !reflect.DeepEqual(result.ReadOnly, []string{apiPath}) {
+		t.Errorf("result = %+v, want %q modified and %q rejected as read-only", result, aPath, apiPath)
+	}
+	if got, _ := os.ReadFile(apiPath); string(got) != "old\n" {
+		t.Errorf("content of read-only %q = %q, want it unchanged", apiPath, got)
+	}
+}
+
+func TestApplyFullTextChangesToFiles_AllowedExts(t *testing.T) {
+	dir := t.TempDir()
+	goPath := filepath.Join(dir, "main.go")
+	shPath := filepath.Join(dir, "build.sh")
+	for _, path := range []string{goPath, shPath} {
+		if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+	}
+	response := fullTextBlock(goPath, "new go\n") + fullTextBlock(shPath, "rm -rf /\n")
+
+	result, err := ApplyFullTextChangesToFiles(response, Options{AllowedExts: []string{".go"}})
+	if err != nil {
+		t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Modified, []string{goPath}) || !reflect.DeepEqual(result.Disallowed, []string{shPath}) {
+		t.Errorf("result = %+v, want %q modified and %q disallowed", result, goPath, shPath)
+	}
+	if got, _ := os.ReadFile(shPath); string(got) != "old\n" {
+		t.Errorf("content of disallowed %q = %q, want it unchanged", shPath, got)
+	}
+}
+
+func TestApplyFullTextChangesToFiles_Markers(t *testing.T) {
+	dir := t.TempDir()
+	docPath := filepath.Join(dir, "doc.md")
+	if err := os.WriteFile(docPath, []byte("old\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", docPath, err)
+	}
+	// Documentation of the prompt format legitimately contains the default markers.
+	content := "Files are framed like this:\n" + fullTextBlock("/x.go", "package x\n") + "End of example.\n"
+	markers := utils.NewMarkers("3f9a0c1d")
+	response := markers.Begin(docPath) + content + markers.End(docPath)
+
+	result, err := ApplyFullTextChangesToFiles(response, Options{Markers: markers})
+	if err != nil {
+		t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Modified, []string{docPath}) || len(result.Created) != 0 {
+		t.Errorf("result = %+v, want only %q modified", result, docPath)
+	}
+	if got, _ := os.ReadFile(docPath); string(got) != content {
+		t.Errorf("content of %q = %q, want %q", docPath, got, content)
+	}
+	if _, err := os.Stat("/x.go"); !os.IsNotExist(err) {
+		t.Error("the example block inside the content was written as a file")
+	}
+}
+
+func TestApplyFullTextChangesToFiles_DashesInContent(t *testing.T) {
+	// YAML front matter and Markdown rules use "---" lines, like the default markers.
+	content := "---\ntitle: Notes\n---\n\n# Notes\n\n---\n\n--- Start of something ---\nbody\n---\n"
+	tests := []struct {
+		name    string
+		markers utils.Markers
+	}{
+		{name: "Default markers", markers: utils.Markers{}},
+		{name: "Custom markers", markers: utils.Markers{BeginPrefix: "<<<BEGIN ", BeginSuffix: ">>>\n", EndPrefix: "\n<<<END ", EndSuffix: ">>>\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docPath := filepath.Join(t.TempDir(), "notes.md")
+			if err := os.WriteFile(docPath, []byte("old\n"), 0644); err != nil {
+				t.Fatalf("Failed to write %q: %v", docPath, err)
+			}
+			markers := tt.markers.OrDefault()
+			response := markers.Begin(docPath) + content + markers.End(docPath)
+
+			if _, err := ApplyFullTextChangesToFiles(response, Options{Markers: tt.markers}); err != nil {
+				t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+			}
+			if got, _ := os.ReadFile(docPath); string(got) != content {
+				t.Errorf("content of %q = %q, want %q", docPath, got, content)
+			}
+		})
+	}
+}
+
+func TestApplyFullTextChangesToFiles_LengthHints(t *testing.T) {
+	markers := utils.DefaultMarkers
+	tests := []struct {
+		name    string
+		begin   func(path string) string
+		strict  bool
+		wantErr bool
+	}{
+		{name: "Matching hint", begin: func(p string) string { return markers.BeginWithLength(p, 4) }, strict: true},
+		{name: "Off by the final newline", begin: func(p string) string { return markers.BeginWithLength(p, 3) }, strict: true},
+		{name: "Mismatch", begin: func(p string) string { return markers.BeginWithLength(p, 40) }, strict: true, wantErr: true},
+		{name: "Advisory mismatch", begin: func(p string) string { return markers.BeginWithLength(p, 40) }},
+		{name: "No hint", begin: markers.Begin, strict: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "a.txt")
+			if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+				t.Fatalf("Failed to write %q: %v", path, err)
+			}
+			response := tt.begin(path) + "new\n" + markers.End(path)
+
+			_, err := ApplyFullTextChangesToFiles(response, Options{LengthHints: tt.strict})
+			want := "new\n"
+			if tt.wantErr {
+				if !IsParseError(err) {
+					t.Fatalf("ApplyFullTextChangesToFiles() error = %v, want a ParseError", err)
+				}
+				want = "old\n"
+			} else if err != nil {
+				t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+			}
+			if got, _ := os.ReadFile(path); string(got) != want {
+				t.Errorf("content of %q = %q, want %q", path, got, want)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
+func TestApplyFullTextChangesToFiles_Gofmt(t *testing.T) {
+	dir := t.TempDir()
+	goodPath := filepath.Join(dir, "good.go")
+	badPath := filepath.Join(dir, "bad.go")
+	txtPath := filepath.Join(dir, "notes.txt")
+	unformatted := "package main\nfunc main(){\nx:=1\n_ = x}\n"
+	broken := "package main\nfunc main() {\n"
+	response := fullTextBlock(goodPath, unformatted) + fullTextBlock(badPath, broken) + fullTextBlock(txtPath, "a  =  b\n")
+
+	result, err := ApplyFullTextChangesToFiles(response, Options{Gofmt: true})
+	if err != nil {
+		t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+	}
+	want := map[string]string{
+		goodPath: "package main\n\nfunc main() {\n\tx := 1\n\t_ = x\n}\n",
+		badPath:  broken,
+		txtPath:  "a  =  b\n",
+	}
+	for path, want := range want {
+		if got, _ := os.ReadFile(path); string(got) != want {
+			t.Errorf("content of %q = %q, want %q", path, got, want)
+		}
+	}
+	if len(result.GofmtErrors) != 1 || result.GofmtErrors[0].Path != badPath {
+		t.Errorf("GofmtErrors = %v, want one error for %q", result.GofmtErrors, badPath)
+	}
+
+	// Without Gofmt the content is written as returned.
+	if _, err := ApplyFullTextChangesToFiles(fullTextBlock(goodPath, unformatted), Options{}); err != nil {
+		t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+	}
+	if got, _ := os.ReadFile(goodPath); string(got) != unformatted {
+		t.Errorf("content of %q = %q, want it unformatted", goodPath, got)
+	}
+}
+
+func TestApplyFullTextChangesToFiles_Canceled(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	var response string
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+		response += fullTextBlock(path, "new\n")
+	}
+
+	// Cancel while the second file is about to be written, as a signal arriving mid-apply would.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backups := 0
+	backup := func(path string) error {
+		if backups++; backups == 2 {
+			cancel()
+		}
+		return nil
+	}
+	result, err := ApplyFullTextChangesToFiles(response, Options{Context: ctx, Backup: backup})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ApplyFullTextChangesToFiles() error = %v, want context.Canceled", err)
+	}
+	// The file being written when the context was canceled is finished; the rest are untouched.
+	for i, want := range []string{"new\n", "new\n", "old\n"} {
+		if got, _ := os.ReadFile(paths[i]); string(got) != want {
+			t.Errorf("content of %q = %q, want %q", paths[i], got, want)
+		}
+	}
+	if !reflect.DeepEqual(result.Modified, paths[:2]) {
+		t.Errorf("Modified = %q, want %q", result.Modified, paths[:2])
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, ".*.tmp-*")); len(leftovers) != 0 {
+		t.Errorf("temporary files left behind: %q", leftovers)
+	}
+}
+
+func TestApplyFullTextChangesToFiles_KeepsModeAndSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	scriptPath := filepath.Join(dir, "run.sh")
+	if err := os.WriteFile(scriptPath, []byte("echo old\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	linkPath := filepath.Join(dir, "link.sh")
+	if err := os.Symlink(scriptPath, linkPath); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	if _, err := ApplyFullTextChangesToFiles(fullTextBlock(linkPath, "echo new\n"), Options{}); err != nil {
+		t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+	}
+	if info, err := os.Lstat(linkPath); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%q is no longer a symlink (stat error %v)", linkPath, err)
+	}
+	info, err := os.Stat(scriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("mode of %q = %v, want %v", scriptPath, info.Mode().Perm(), os.FileMode(0755))
+	}
+	if got, _ := os.ReadFile(scriptPath); string(got) != "echo new\n" {
+		t.Errorf("content of %q = %q, want %q", scriptPath, got, "echo new\n")
+	}
+}
\ No newline at end of file
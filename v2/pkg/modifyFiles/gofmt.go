@@ -0,0 +1,37 @@
+package modifyFiles
+
+import (
+	"go/format"
+	"path/filepath"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// gofmtContent returns content, the new content of the file at path, formatted with
+// go/format if path is a .go file (see Options.Gofmt). Content that does not parse is
+// returned unchanged and recorded in result.GofmtErrors, so the change is not lost.
+func gofmtContent(path, content string, result *ApplyResult) string {
+	formatted, err := formatGo(path, content)
+	if err != nil {
+		logging.Errorf("File %q is not valid Go and was written unformatted: %v", path, err)
+		logging.Event("file_gofmt_error", map[string]interface{}{"path": path, "error": err.Error()})
+		result.GofmtErrors = append(result.GofmtErrors, GofmtError{Path: path, Err: err})
+	}
+	return formatted
+}
+
+// formatGo returns content formatted with go/format if path is a .go file. Content that
+// does not parse is returned unchanged, together with the parse error.
+func formatGo(path, content string) (string, error) {
+	if filepath.Ext(path) != ".go" {
+		return content, nil
+	}
+	formatted, err := format.Source([]byte(content))
+	if err != nil {
+		return content, err
+	}
+	if string(formatted) != content {
+		logging.V(0).Infof("Reformatted %q with gofmt.", path)
+	}
+	return string(formatted), nil
+}
\ No newline at end of file
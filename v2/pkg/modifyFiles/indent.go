@@ -0,0 +1,110 @@
+package modifyFiles
+
+import (
+	"strings"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// defaultIndentSize is the number of columns per indentation level assumed when
+// neither .editorconfig nor the file itself says otherwise.
+const defaultIndentSize = 4
+
+// preserveIndent re-indents content, the new content of the file at path, with the
+// indentation its .editorconfig prescribes or, without a rule, the one detected in
+// original, e.g. turning the spaces of a model that re-indented a tab-indented file
+// back into tabs. Only the leading whitespace of each line is changed; spaces left over
+// after whole indentation levels are kept for alignment. content is returned unchanged
+// if the indentation cannot be determined, e.g. for a new file.
+func preserveIndent(path, original, content string) string {
+	style, ok := editorConfigIndent(path)
+	if !ok {
+		if style, ok = detectIndent(original); !ok {
+			return content
+		}
+	}
+	if style.size <= 0 {
+		// The width of a level is needed to convert between tabs and spaces; take it from
+		// whichever version of the file is indented with spaces.
+		spaces := original
+		if style.tabs {
+			spaces = content
+		}
+		style.size = spaceIndentSize(spaces)
+	}
+	reindented := reindent(content, style)
+	if reindented != content {
+		logging.V(0).Infof("Restored the indentation of %q (tabs %t, size %d).", path, style.tabs, style.size)
+	}
+	return reindented
+}
+
+// detectIndent reports whether more lines of content are indented with tabs or with
+// spaces. Lines starting with " *", the continuation of block comments, are not counted.
+// The second result is false if no line is indented.
+func detectIndent(content string) (indentStyle, bool) {
+	tabs, spaces := 0, 0
+	for _, line := range strings.Split(content, "\n") {
+		switch {
+		case strings.HasPrefix(line, "\t"):
+			tabs++
+		case strings.HasPrefix(line, " ") && !strings.HasPrefix(strings.TrimLeft(line, " "), "*"):
+			spaces++
+		}
+	}
+	if tabs == 0 && spaces == 0 {
+		return indentStyle{}, false
+	}
+	return indentStyle{tabs: tabs > spaces}, true
+}
+
+// spaceIndentSize returns the indentation level width of content: the greatest common
+// divisor of the numbers of leading spaces of its lines, between 2 and 8, or
+// defaultIndentSize if that cannot be determined.
+func spaceIndentSize(content string) int {
+	size := 0
+	for _, line := range strings.Split(content, "\n") {
+		n := len(line) - len(strings.TrimLeft(line, " "))
+		if n == 0 || n == len(line) || strings.HasPrefix(line[n:], "*") {
+			continue
+		}
+		size = gcd(size, n)
+	}
+	if size < 2 || size > 8 {
+		return defaultIndentSize
+	}
+	return size
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// reindent rewrites the leading whitespace of each line of content in style: a run of
+// tabs and spaces spanning n columns becomes n/size tabs and n%size spaces, or n spaces.
+func reindent(content string, style indentStyle) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		body := strings.TrimLeft(line, " \t")
+		if body == "" || len(body) == len(line) {
+			continue // Blank lines keep their whitespace; unindented lines have none
+		}
+		columns := 0
+		for _, c := range line[:len(line)-len(body)] {
+			if c == '\t' {
+				columns += style.size - columns%style.size
+			} else {
+				columns++
+			}
+		}
+		if style.tabs {
+			lines[i] = strings.Repeat("\t", columns/style.size) + strings.Repeat(" ", columns%style.size) + body
+		} else {
+			lines[i] = strings.Repeat(" ", columns) + body
+		}
+	}
+	return strings.Join(lines, "\n")
+}
\ No newline at end of file
@@ -0,0 +1,107 @@
+package modifyFiles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyFullTextChangesToFiles_PreserveIndent(t *testing.T) {
+	original := "package a\n\nfunc f() int {\n\tif true {\n\t\treturn 1\n\t}\n\treturn 0\n}\n"
+	returned := "package a\n\nfunc f() int {\n    if true {\n        return 2\n    }\n    return 0\n}\n"
+	want := "package a\n\nfunc f() int {\n\tif true {\n\t\treturn 2\n\t}\n\treturn 0\n}\n"
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.go")
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", path, err)
+	}
+	config := "root = true\n\n[*]\nindent_style = space\nindent_size = 2\n\n[*.go]\nindent_style = tab\nindent_size = 4\n"
+	if err := os.WriteFile(filepath.Join(dir, editorConfigName), []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write .editorconfig: %v", err)
+	}
+
+	if _, err := ApplyFullTextChangesToFiles(fullTextBlock(path, returned), Options{PreserveIndent: true}); err != nil {
+		t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Errorf("content = %q, want %q", got, want)
+	}
+}
+
+func TestEditorConfigIndent(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("Failed to create %q: %v", sub, err)
+	}
+	configs := map[string]string{
+		root: "root = true\n\n[*]\nindent_style = space\nindent_size = 2\n\n[{Makefile,*.mk}]\nindent_style = tab\n\n[docs/**/*.md]\nindent_size = 3\n",
+		sub:  "[*.go]\nindent_style = tab\ntab_width = 8\n",
+	}
+	for dir, config := range configs {
+		if err := os.WriteFile(filepath.Join(dir, editorConfigName), []byte(config), 0644); err != nil {
+			t.Fatalf("Failed to write .editorconfig: %v", err)
+		}
+	}
+
+	tests := []struct {
+		path string
+		want indentStyle
+	}{
+		{path: filepath.Join(root, "a.py"), want: indentStyle{size: 2}},
+		{path: filepath.Join(root, "Makefile"), want: indentStyle{tabs: true, size: 2}},
+		{path: filepath.Join(root, "x", "rules.mk"), want: indentStyle{tabs: true, size: 2}},
+		{path: filepath.Join(root, "docs", "a", "b.md"), want: indentStyle{size: 3}},
+		{path: filepath.Join(sub, "a.go"), want: indentStyle{tabs: true, size: 2}},
+		{path: filepath.Join(sub, "a.txt"), want: indentStyle{size: 2}},
+	}
+	for _, tt := range tests {
+		got, ok := editorConfigIndent(tt.path)
+		if !ok || got != tt.want {
+			t.Errorf("editorConfigIndent(%q) = %+v, %t, want %+v, true", tt.path, got, ok, tt.want)
+		}
+	}
+}
+
+func TestPreserveIndent_Detected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.py") // No .editorconfig rule applies
+	tests := []struct {
+		name     string
+		original string
+		content  string
+		want     string
+	}{
+		{
+			name:     "Tabs restored with alignment kept",
+			original: "def f():\n\treturn 1\n",
+			content:  "def f():\n  x = (1,\n        2)\n  return x\n",
+			want:     "def f():\n\tx = (1,\n\t\t\t\t2)\n\treturn x\n",
+		},
+		{
+			name:     "Spaces restored at the original width",
+			original: "def f():\n  if x:\n    return 1\n",
+			content:  "def f():\n\tif x:\n\t\treturn 2\n",
+			want:     "def f():\n  if x:\n    return 2\n",
+		},
+		{
+			name:     "Unindented original leaves content alone",
+			original: "a\nb\n",
+			content:  "a\n\tb\n",
+			want:     "a\n\tb\n",
+		},
+		{
+			name:     "Blank lines keep their whitespace",
+			original: "a\n\tb\n",
+			content:  "a\n    b\n    \n",
+			want:     "a\n\tb\n    \n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := preserveIndent(path, tt.original, tt.content); got != tt.want {
+				t.Errorf("preserveIndent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
\ No newline at end of file
@@ -0,0 +1,70 @@
+package modifyFiles
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Line ending modes accepted by Options.LineEnding.
+const (
+	LineEndingAuto = "auto" // Keep the original file's dominant line ending
+	LineEndingLF   = "lf"   // Always write "\n"
+	LineEndingCRLF = "crlf" // Always write "\r\n"
+)
+
+// ValidateLineEnding returns an error if mode is not a known line ending mode.
+// An empty mode is treated as LineEndingAuto.
+func ValidateLineEnding(mode string) error {
+	switch mode {
+	case "", LineEndingAuto, LineEndingLF, LineEndingCRLF:
+		return nil
+	}
+	return fmt.Errorf("unknown line ending %q (want %q, %q or %q)", mode, LineEndingAuto, LineEndingLF, LineEndingCRLF)
+}
+
+// detectLineEnding returns LineEndingCRLF if most lines of content end with "\r\n",
+// and LineEndingLF otherwise (including for content without any newline).
+func detectLineEnding(content string) string {
+	crlf := strings.Count(content, "\r\n")
+	lf := strings.Count(content, "\n") - crlf
+	if crlf > lf {
+		return LineEndingCRLF
+	}
+	return LineEndingLF
+}
+
+// normalizeLineEndings converts all "\r\n" line endings in content to "\n".
+func normalizeLineEndings(content string) string {
+	return strings.ReplaceAll(content, "\r\n", "\n")
+}
+
+// resolveLineEnding returns the concrete line ending to write for a file whose
+// original content is original, given the requested mode.
+func resolveLineEnding(mode, original string) string {
+	if mode == LineEndingLF || mode == LineEndingCRLF {
+		return mode
+	}
+	return detectLineEnding(original)
+}
+
+// trimTrailingWhitespace removes the spaces and tabs at the end of every line of
+// content, keeping its line endings.
+func trimTrailingWhitespace(content string) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimRight(strings.TrimSuffix(line, "\r"), " \t")
+		if strings.HasSuffix(line, "\r") {
+			trimmed += "\r"
+		}
+		lines[i] = trimmed
+	}
+	return strings.Join(lines, "\n")
+}
+
+// convertLineEndings converts "\n"-terminated content to the given line ending.
+func convertLineEndings(content, ending string) string {
+	if ending == LineEndingCRLF {
+		return strings.ReplaceAll(normalizeLineEndings(content), "\n", "\r\n")
+	}
+	return content
+}
\ No newline at end of file
@@ -0,0 +1,229 @@
+package modifyFiles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// resolveResponsePath maps a file path named in an AI response to the path to write.
+// Absolute paths are used as is. Models sometimes answer with a relative path even
+// though the prompt used absolute ones; such a path is joined with opts.Root and with
+// the requested files' common directory, and the first result that names a requested
+// file is used. A relative path matching no requested file is a ParseError, so that a
+// stray file is never created relative to the current directory.
+func resolveResponsePath(path string, opts Options) (string, error) {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path), nil
+	}
+
+	requested := make(map[string]bool, len(opts.Requested))
+	absRequested := make([]string, 0, len(opts.Requested))
+	for _, p := range opts.Requested {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve requested path %q: %w", p, err)
+		}
+		requested[abs] = true
+		absRequested = append(absRequested, abs)
+	}
+
+	root := opts.Root
+	if root == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return "", fmt.Errorf("failed to get the current directory: %w", err)
+		}
+		root = cwd
+	}
+	for _, dir := range []string{root, commonDir(absRequested)} {
+		if dir == "" {
+			continue
+		}
+		candidate := filepath.Join(dir, path)
+		if requested[candidate] {
+			logging.Warningf("AI response used the relative path %q; resolved it to the requested file %q.", path, candidate)
+			return candidate, nil
+		}
+	}
+	return "", &ParseError{Reason: fmt.Sprintf("relative path %q in AI response does not match any requested file", path)}
+}
+
+// resolveDiffPaths resolves the old and new paths of fileDiffs in place. With
+// Options.Requested set, as for a diff from the model, they are resolved like full-text
+// paths with resolveResponsePath. Without it, as for a patch the user supplied (e.g.
+// from git diff), a relative path is joined with Options.Root or the current directory,
+// like git apply does, but may not leave that directory.
+func resolveDiffPaths(fileDiffs []fileDiff, opts Options) error {
+	resolve := func(path string) (string, error) {
+		if path == devNull {
+			return path, nil
+		}
+		if len(opts.Requested) > 0 {
+			return resolveResponsePath(path, opts)
+		}
+		if filepath.IsAbs(path) {
+			return filepath.Clean(path), nil
+		}
+		if rel := filepath.Clean(path); rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", &ParseError{Reason: fmt.Sprintf("relative path %q in the diff leaves the workspace", path)}
+		}
+		root := opts.Root
+		if root == "" {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return "", fmt.Errorf("failed to get the current directory: %w", err)
+			}
+			root = cwd
+		}
+		return filepath.Join(root, path), nil
+	}
+	for i := range fileDiffs {
+		fd := &fileDiffs[i]
+		var err error
+		if fd.oldPath, err = resolve(fd.oldPath); err == nil {
+			fd.newPath, err = resolve(fd.newPath)
+		}
+		if err != nil {
+			logging.Errorf("Cannot apply the diff for %q: %v", fd.path(), err)
+			return err
+		}
+	}
+	return nil
+}
+
+// commonDir returns the deepest directory containing all of the given absolute paths,
+// or "" if paths is empty.
+func commonDir(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+	dir := filepath.Dir(paths[0])
+	for _, p := range paths[1:] {
+		for dir != filepath.Dir(dir) && p != dir && !strings.HasPrefix(p, dir+string(filepath.Separator)) {
+			dir = filepath.Dir(dir)
+		}
+	}
+	return dir
+}
+
+// pathSet is a set of absolute paths, such as Options.Requested or Options.ReadOnly.
+// A nil pathSet is empty.
+type pathSet map[string]bool
+
+// newPathSet returns the set of the given paths, or nil if paths is empty.
+func newPathSet(paths []string) (pathSet, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	s := make(pathSet, len(paths))
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve path %q: %w", p, err)
+		}
+		s[abs] = true
+	}
+	return s, nil
+}
+
+// contains reports whether path is in the set.
+func (s pathSet) contains(path string) bool {
+	if s == nil {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	return s[abs]
+}
+
+// extAllowlist holds Options.AllowedExts as lower-case extensions with a leading dot.
+// A nil extAllowlist allows every path.
+type extAllowlist map[string]bool
+
+// newExtAllowlist returns the allowlist for exts, given with or without the leading
+// dot, or nil if exts is empty.
+func newExtAllowlist(exts []string) extAllowlist {
+	if len(exts) == 0 {
+		return nil
+	}
+	a := make(extAllowlist, len(exts))
+	for _, ext := range exts {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext != "" && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		a[ext] = true
+	}
+	return a
+}
+
+// allows reports whether the extension of path is in the allowlist.
+func (a extAllowlist) allows(path string) bool {
+	if a == nil {
+		return true
+	}
+	return a[strings.ToLower(filepath.Ext(path))]
+}
+
+// onlyFilter applies Options.Only and records which of its files a response named.
+// A nil *onlyFilter allows every file.
+type onlyFilter struct {
+	seen map[string]bool // Absolute path -> whether the response named it
+}
+
+// newOnlyFilter returns a filter for the given paths, or nil if only is empty.
+func newOnlyFilter(only []string) (*onlyFilter, error) {
+	if len(only) == 0 {
+		return nil, nil
+	}
+	f := &onlyFilter{seen: make(map[string]bool, len(only))}
+	for _, p := range only {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve path %q: %w", p, err)
+		}
+		f.seen[abs] = false
+	}
+	return f, nil
+}
+
+// allows reports whether path may be written, marking it as seen.
+func (f *onlyFilter) allows(path string) bool {
+	if f == nil {
+		return true
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	if _, ok := f.seen[abs]; !ok {
+		return false
+	}
+	f.seen[abs] = true
+	return true
+}
+
+// check returns an error naming the filtered files that the response did not contain.
+func (f *onlyFilter) check() error {
+	if f == nil {
+		return nil
+	}
+	var missing []string
+	for p, seen := range f.seen {
+		if !seen {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("files selected for writing are not changed by the AI response: %s", strings.Join(missing, ", "))
+}
\ No newline at end of file
@@ -0,0 +1,103 @@
+package modifyFiles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+// ApplyResult lists the files touched while applying an AI response.
+type ApplyResult struct {
+	Modified []string // Existing files whose content was rewritten
+	Created  []string // Files that did not exist before
+	Deleted  []string // Files that were removed
+	Skipped  []string // Files changed by the response but not written because of Options.Only
+
+	Unrequested []string // Files named by the response but not in Options.Requested, left unwritten
+	ReadOnly    []string // Files named by the response but listed in Options.ReadOnly, left unwritten
+	Disallowed  []string // Files named by the response whose extension is not in Options.AllowedExts, left unwritten
+
+	DiffStats []DiffStat // Per-file hunk and line counts of the diffs written by ApplyChangesToFiles
+	Fuzzy     []string   // Files written by ApplyChangesToFiles with hunks placed by fuzzy matching (see Options.Fuzzy)
+
+	GofmtErrors []GofmtError // Go files written as returned because go/format could not parse them (see Options.Gofmt)
+}
+
+// Written returns the files whose content was written: Modified followed by Created.
+func (r ApplyResult) Written() []string {
+	return append(append([]string(nil), r.Modified...), r.Created...)
+}
+
+// GofmtError records a written Go file that go/format rejected.
+type GofmtError struct {
+	Path string // File that was written unformatted
+	Err  error  // Error returned by go/format
+}
+
+func (e GofmtError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+// DiffStat counts the changes a diff made to one file.
+type DiffStat struct {
+	Path    string // File the diff applied to
+	Hunks   int    // Number of hunks applied
+	Added   int    // Lines added
+	Removed int    // Lines removed
+}
+
+// DiffSummary returns a git-style summary of DiffStats, e.g. "3 files changed, +42 -17".
+func (r ApplyResult) DiffSummary() string {
+	added, removed := 0, 0
+	for _, s := range r.DiffStats {
+		added += s.Added
+		removed += s.Removed
+	}
+	noun := "files"
+	if len(r.DiffStats) == 1 {
+		noun = "file"
+	}
+	return fmt.Sprintf("%d %s changed, +%d -%d", len(r.DiffStats), noun, added, removed)
+}
+
+// backup calls opts.Backup, if set, for the file at path before it is changed.
+func backup(opts Options, path string) error {
+	if opts.Backup == nil {
+		return nil
+	}
+	if err := opts.Backup(path); err != nil {
+		logging.Errorf("Not changing %q: failed to back it up: %v", path, err)
+		return fmt.Errorf("failed to back up %q: %w", path, err)
+	}
+	return nil
+}
+
+// canceled returns an error wrapping the error of opts.Context if it is done, so the
+// apply stops before changing the next file.
+func canceled(opts Options) error {
+	if opts.Context == nil {
+		return nil
+	}
+	if err := opts.Context.Err(); err != nil {
+		logging.Warningf("Apply interrupted: %v", err)
+		return fmt.Errorf("apply interrupted: %w", err)
+	}
+	return nil
+}
+
+// writeFile writes content to path atomically (see utils.WriteFileAtomic), so an
+// interrupted run never leaves it half-written. An existing file keeps its permission
+// bits, and a symlink is written through to its target rather than replaced.
+func writeFile(path, content string) error {
+	perm := os.FileMode(0644)
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	return utils.WriteFileAtomic(path, []byte(content), perm)
+}
\ No newline at end of file
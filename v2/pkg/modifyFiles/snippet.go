@@ -0,0 +1,221 @@
+package modifyFiles
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+// Errors returned by ReplaceSnippet.
+var (
+	ErrSnippetNotFound  = errors.New("snippet not found")
+	ErrSnippetNotUnique = errors.New("snippet is not unique")
+)
+
+// snippetEdit is one search/replace block of a response.
+type snippetEdit struct {
+	path    string // File named on the SEARCH line
+	search  string // Exact text to find, with "\n" line endings
+	replace string // Text to put in its place
+}
+
+// ReplaceSnippet replaces the only occurrence of search in content with replace.
+// It returns an error wrapping ErrSnippetNotFound if search does not occur, and one
+// wrapping ErrSnippetNotUnique if it occurs more than once, since the edit would be
+// ambiguous. An empty search is never unique.
+func ReplaceSnippet(content, search, replace string) (string, error) {
+	switch count := strings.Count(content, search); {
+	case search == "":
+		return "", fmt.Errorf("%w: the search text is empty", ErrSnippetNotUnique)
+	case count == 0:
+		return "", fmt.Errorf("%w: %q", ErrSnippetNotFound, utils.TruncateString(search, 80))
+	case count > 1:
+		return "", fmt.Errorf("%w: %q occurs %d times", ErrSnippetNotUnique, utils.TruncateString(search, 80), count)
+	}
+	return strings.Replace(content, search, replace, 1), nil
+}
+
+// ApplySnippetChangesToFiles parses an AI response made of search/replace blocks (see
+// utils.SearchMarkerPrefix) and applies each to the file it names with ReplaceSnippet,
+// so that large files can be edited without returning their full text. Blocks for the
+// same file are applied in order. As with ApplyChangesToFiles, every edit is applied in
+// memory first and nothing is written unless all of them apply; a snippet that is
+// missing or not unique is a ParseError. Options.ReadOnly, Options.AllowedExts and
+// Options.Only are honored, and relative paths are resolved against Options.Requested.
+// Files are matched with their line endings normalized to "\n"; the line ending
+// selected by opts.LineEnding is restored on write. Options.Gofmt and Options.DryRun are
+// honored as in ApplyChangesToFiles.
+func ApplySnippetChangesToFiles(response string, opts Options) (ApplyResult, error) {
+	var result ApplyResult
+	edits, err := parseSnippetEdits(cleanAIMarkdown(response))
+	if err != nil {
+		return result, err
+	}
+
+	only, err := newOnlyFilter(opts.Only)
+	if err != nil {
+		return result, err
+	}
+	readOnly, err := newPathSet(opts.ReadOnly)
+	if err != nil {
+		return result, err
+	}
+	allowedExts := newExtAllowlist(opts.AllowedExts)
+
+	// Compute the new content of every file before touching the disk.
+	var order []string
+	originals := make(map[string]string)
+	addedNewline := make(map[string]bool) // Files given a final newline so that blocks can match their last line
+	newContents := make(map[string]string)
+	stats := make(map[string]*DiffStat)
+	for _, edit := range edits {
+		path, err := resolveResponsePath(edit.path, opts)
+		if err != nil {
+			return result, err
+		}
+		if _, seen := stats[path]; !seen {
+			stats[path] = &DiffStat{Path: path}
+			order = append(order, path)
+			if readOnly.contains(path) || !allowedExts.allows(path) || !only.allows(path) {
+				continue
+			}
+			contentBytes, err := os.ReadFile(path)
+			if err != nil {
+				logging.Errorf("Failed to read file %q for editing: %v", path, err)
+				return result, fmt.Errorf("failed to read file %q: %w", path, err)
+			}
+			originals[path] = string(contentBytes)
+			newContents[path] = normalizeLineEndings(string(contentBytes))
+			if newContents[path] != "" && !strings.HasSuffix(newContents[path], "\n") {
+				newContents[path] += "\n"
+				addedNewline[path] = true
+			}
+		}
+		content, ok := newContents[path]
+		if !ok {
+			continue // Rejected or skipped; reported below
+		}
+		if newContents[path], err = ReplaceSnippet(content, edit.search, edit.replace); err != nil {
+			logging.Errorf("Failed to apply search/replace block to %q: %v", path, err)
+			return result, &ParseError{Reason: fmt.Sprintf("failed to apply search/replace block to %q: %v", path, err)}
+		}
+		stats[path].Hunks++
+		stats[path].Removed += countLines(edit.search)
+		stats[path].Added += countLines(edit.replace)
+	}
+
+	if err := only.check(); err != nil {
+		return result, err
+	}
+
+	for _, path := range order {
+		switch {
+		case readOnly.contains(path):
+			logging.Warningf("Refusing to change %q: it was provided as a read-only context file.", path)
+			logging.Event("file_read_only", map[string]interface{}{"path": path})
+			result.ReadOnly = append(result.ReadOnly, path)
+			continue
+		case !allowedExts.allows(path):
+			logging.Warningf("Refusing to change %q: its extension is not in the allowlist %q.", path, opts.AllowedExts)
+			logging.Event("file_disallowed", map[string]interface{}{"path": path})
+			result.Disallowed = append(result.Disallowed, path)
+			continue
+		case !only.allows(path):
+			logging.V(0).Infof("Skipping changes to %q: not selected for writing.", path)
+			result.Skipped = append(result.Skipped, path)
+			continue
+		}
+		lineEnding := resolveLineEnding(opts.LineEnding, originals[path])
+		logging.V(2).Infof("Writing %q with %s line endings.", path, lineEnding)
+		content := newContents[path]
+		if addedNewline[path] {
+			content = strings.TrimSuffix(content, "\n")
+		}
+		if opts.PreserveIndent {
+			content = preserveIndent(path, normalizeLineEndings(originals[path]), content)
+		}
+		if opts.Gofmt {
+			content = gofmtContent(path, content, &result)
+		}
+		content = convertLineEndings(content, lineEnding)
+		if opts.DryRun {
+			if err := writeDryRunDiff(opts, path, path, originals[path], content); err != nil {
+				return result, err
+			}
+			continue
+		}
+		if err := canceled(opts); err != nil {
+			return result, err
+		}
+		if err := backup(opts, path); err != nil {
+			return result, err
+		}
+		if err := writeFile(path, content); err != nil {
+			logging.Errorf("Failed to write content to file %q: %v", path, err)
+			return result, fmt.Errorf("failed to write content to file %q: %w", path, err)
+		}
+		stat := *stats[path]
+		logging.V(0).Infof("Successfully updated file: %q", path)
+		logging.Event("file_modified", map[string]interface{}{"path": path, "bytes": len(content)})
+		logging.V(0).Infof("Applied %d search/replace blocks to %q (+%d -%d).", stat.Hunks, path, stat.Added, stat.Removed)
+		result.Modified = append(result.Modified, path)
+		result.DiffStats = append(result.DiffStats, stat)
+	}
+	return result, nil
+}
+
+// parseSnippetEdits splits a response into its search/replace blocks. Text outside the
+// blocks is ignored. A block that is not terminated, or a response without any block,
+// is a ParseError.
+func parseSnippetEdits(response string) ([]snippetEdit, error) {
+	lines := strings.Split(strings.ReplaceAll(response, "\r\n", "\n"), "\n")
+	var edits []snippetEdit
+	for i := 0; i < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], utils.SearchMarkerPrefix) {
+			continue
+		}
+		edit := snippetEdit{path: strings.TrimSpace(strings.TrimPrefix(lines[i], utils.SearchMarkerPrefix))}
+		if edit.path == "" {
+			return nil, &ParseError{Reason: fmt.Sprintf("search/replace block on line %d does not name a file", i+1)}
+		}
+		start := i + 1
+		divider, end := -1, -1
+		for j := start; j < len(lines) && end < 0; j++ {
+			switch {
+			case lines[j] == utils.SnippetDivider && divider < 0:
+				divider = j
+			case lines[j] == utils.ReplaceMarker && divider >= 0:
+				end = j
+			}
+		}
+		if end < 0 {
+			return nil, &ParseError{Reason: fmt.Sprintf("search/replace block for %q on line %d is not terminated by %q", edit.path, i+1, utils.ReplaceMarker)}
+		}
+		edit.search = joinBlockLines(lines[start:divider])
+		edit.replace = joinBlockLines(lines[divider+1 : end])
+		edits = append(edits, edit)
+		i = end
+	}
+	if len(edits) == 0 {
+		return nil, &ParseError{Reason: "no search/replace blocks found"}
+	}
+	return edits, nil
+}
+
+// joinBlockLines joins the lines of one side of a block, each ending in "\n", so that
+// an edit replaces whole lines.
+func joinBlockLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// countLines returns the number of lines in s, which ends in "\n" unless empty.
+func countLines(s string) int {
+	return strings.Count(s, "\n")
+}
\ No newline at end of file
@@ -0,0 +1,105 @@
+package modifyFiles
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplaceSnippet(t *testing.T) {
+	content := "package a\n\nfunc f() int {\n\treturn 1\n}\n\nfunc g() int {\n\treturn 1\n}\n"
+	tests := []struct {
+		name    string
+		search  string
+		replace string
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "Unique match",
+			search:  "func f() int {\n\treturn 1\n",
+			replace: "func f() int {\n\treturn 2\n",
+			want:    "package a\n\nfunc f() int {\n\treturn 2\n}\n\nfunc g() int {\n\treturn 1\n}\n",
+		},
+		{name: "No match", search: "func h() int {\n", wantErr: ErrSnippetNotFound},
+		{name: "Ambiguous match", search: "\treturn 1\n", wantErr: ErrSnippetNotUnique},
+		{name: "Empty search", search: "", wantErr: ErrSnippetNotUnique},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReplaceSnippet(content, tt.search, tt.replace)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReplaceSnippet() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ReplaceSnippet() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplySnippetChangesToFiles(t *testing.T) {
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.go")
+	bPath := filepath.Join(dir, "b.txt")
+	if err := os.WriteFile(aPath, []byte("package a\r\n\r\nvar x = 1\r\nvar y = 1\r\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", aPath, err)
+	}
+	if err := os.WriteFile(bPath, []byte("first\nlast"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", bPath, err)
+	}
+
+	response := "Here are the edits:\n\n" +
+		"<<<<<<< SEARCH " + aPath + "\nvar x = 1\n=======\nvar x = 2\n>>>>>>> REPLACE\n" +
+		"<<<<<<< SEARCH " + aPath + "\nvar y = 1\n=======\n>>>>>>> REPLACE\n" +
+		"<<<<<<< SEARCH b.txt\nlast\n=======\nend\n>>>>>>> REPLACE\n"
+	result, err := ApplySnippetChangesToFiles(response, Options{Requested: []string{aPath, bPath}, Root: dir})
+	if err != nil {
+		t.Fatalf("ApplySnippetChangesToFiles() error = %v", err)
+	}
+	if len(result.Modified) != 2 || result.Modified[0] != aPath || result.Modified[1] != bPath {
+		t.Errorf("Modified = %q, want [%q %q]", result.Modified, aPath, bPath)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "package a\r\n\r\nvar x = 2\r\n" {
+		t.Errorf("content of %q = %q, want the edits with CRLF line endings kept", aPath, got)
+	}
+	if got, _ := os.ReadFile(bPath); string(got) != "first\nend" {
+		t.Errorf("content of %q = %q, want the last line replaced without a final newline", bPath, got)
+	}
+	if stat := result.DiffStats[0]; stat.Hunks != 2 || stat.Added != 1 || stat.Removed != 2 {
+		t.Errorf("DiffStats[0] = %+v, want 2 blocks, +1 -2", stat)
+	}
+}
+
+func TestApplySnippetChangesToFiles_NothingWrittenOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.go")
+	bPath := filepath.Join(dir, "b.go")
+	for _, path := range []string{aPath, bPath} {
+		if err := os.WriteFile(path, []byte("package a\n\nvar x = 1\nvar x = 1\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{name: "Ambiguous", response: "<<<<<<< SEARCH " + aPath + "\npackage a\n=======\npackage b\n>>>>>>> REPLACE\n<<<<<<< SEARCH " + bPath + "\nvar x = 1\n=======\nvar x = 2\n>>>>>>> REPLACE\n"},
+		{name: "Not found", response: "<<<<<<< SEARCH " + aPath + "\npackage a\n=======\npackage b\n>>>>>>> REPLACE\n<<<<<<< SEARCH " + bPath + "\nvar z = 1\n=======\nvar z = 2\n>>>>>>> REPLACE\n"},
+		{name: "Unterminated", response: "<<<<<<< SEARCH " + aPath + "\npackage a\n=======\npackage b\n"},
+		{name: "No blocks", response: "I could not find anything to change."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ApplySnippetChangesToFiles(tt.response, Options{})
+			if !IsParseError(err) {
+				t.Fatalf("ApplySnippetChangesToFiles() error = %v, want a ParseError", err)
+			}
+			if got, _ := os.ReadFile(aPath); string(got) != "package a\n\nvar x = 1\nvar x = 1\n" {
+				t.Errorf("content of %q = %q, want it untouched", aPath, got)
+			}
+		})
+	}
+}
\ No newline at end of file
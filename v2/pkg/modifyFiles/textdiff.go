@@ -0,0 +1,252 @@
+package modifyFiles
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// diffContext is the number of unchanged lines shown around each change by UnifiedDiff.
+const diffContext = 3
+
+// maxDiffCells bounds the memory used by diffLines; beyond it the files are shown as
+// entirely replaced rather than diffed line by line.
+const maxDiffCells = 1 << 24
+
+// diffLine is one line of an edit script: an unchanged, removed or added line.
+type diffLine struct {
+	op   byte   // ' ', '-' or '+', as in a hunk body
+	text string // Line including its "\n", if it has one
+}
+
+// UnifiedDiff returns a git-style unified diff turning before, the content of oldPath,
+// into after, the content of newPath, with three lines of context. Pass "/dev/null" as
+// oldPath for a created file or as newPath for a deleted one. The headers use the same
+// "a/" and "b/" prefixes as the diffs requested from the model, so the output can be
+// applied with ApplyChangesToFiles. It returns "" if the contents are equal.
+func UnifiedDiff(oldPath, newPath, before, after string) string {
+	if before == after {
+		return ""
+	}
+	script := diffLines(splitLinesKeepEnds(before), splitLinesKeepEnds(after))
+
+	var b strings.Builder
+	b.WriteString(diffHeader("---", "a/", oldPath))
+	b.WriteString(diffHeader("+++", "b/", newPath))
+	oldLine, newLine := 0, 0 // Lines of before and after consumed so far
+	for i := 0; i < len(script); {
+		if script[i].op == ' ' {
+			i++
+			oldLine++
+			newLine++
+			continue
+		}
+		// Start the hunk up to diffContext lines before the change, and extend it while
+		// the next change is close enough for their context to touch.
+		start := i
+		for start > 0 && i-start < diffContext && script[start-1].op == ' ' {
+			start--
+		}
+		end, unchanged := i, 0
+		for end < len(script) && unchanged <= 2*diffContext {
+			if script[end].op == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			end++
+		}
+		end -= max(unchanged-diffContext, 0)
+
+		hunkOld, hunkNew := oldLine-(i-start), newLine-(i-start)
+		oldCount, newCount := 0, 0
+		for _, l := range script[start:end] {
+			if l.op != '+' {
+				oldCount++
+			}
+			if l.op != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(hunkOld, oldCount), hunkRange(hunkNew, newCount))
+		for _, l := range script[start:end] {
+			b.WriteByte(l.op)
+			b.WriteString(l.text)
+			if !strings.HasSuffix(l.text, "\n") {
+				b.WriteString("\n" + noNewlineMarker + "\n")
+			}
+		}
+		for _, l := range script[i:end] {
+			if l.op != '+' {
+				oldLine++
+			}
+			if l.op != '-' {
+				newLine++
+			}
+		}
+		i = end
+	}
+	return b.String()
+}
+
+// writeDryRunDiff prints the diff of a change that Options.DryRun keeps from being
+// written to opts.DiffOutput (os.Stdout if nil).
+func writeDryRunDiff(opts Options, oldPath, newPath, before, after string) error {
+	path := newPath
+	if path == devNull {
+		path = oldPath
+	}
+	diff := UnifiedDiff(oldPath, newPath, before, after)
+	if diff == "" {
+		logging.V(0).Infof("Dry run: %q would be unchanged.", path)
+		return nil
+	}
+	logging.V(0).Infof("Dry run: not writing %q.", path)
+	logging.Event("file_dry_run", map[string]interface{}{"path": path})
+	out := opts.DiffOutput
+	if out == nil {
+		out = os.Stdout
+	}
+	if _, err := io.WriteString(out, diff); err != nil {
+		return fmt.Errorf("failed to print the diff of %q: %w", path, err)
+	}
+	return nil
+}
+
+// diffHeader returns a "---" or "+++" header line for path.
+func diffHeader(prefix, gitPrefix, path string) string {
+	if path == devNull {
+		return prefix + " " + devNull + "\n"
+	}
+	return prefix + " " + gitPrefix + path + "\n"
+}
+
+// hunkRange formats the range of a hunk header for a hunk covering count lines after
+// the first skipped lines. An empty range names the line before it, as diff does.
+func hunkRange(skipped, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", skipped)
+	}
+	return fmt.Sprintf("%d,%d", skipped+1, count)
+}
+
+// splitLinesKeepEnds splits content into lines that keep their "\n", so that a last
+// line without one differs from the same line with one.
+func splitLinesKeepEnds(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns a shortest edit script turning a into b, using Myers' algorithm.
+// If that would need more than maxDiffCells of memory, all of a is removed and all of b
+// added instead.
+func diffLines(a, b []string) []diffLine {
+	// Common prefixes and suffixes are cheap to find and usually most of the file.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var script []diffLine
+	for _, line := range a[:prefix] {
+		script = append(script, diffLine{' ', line})
+	}
+	script = append(script, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		script = append(script, diffLine{' ', line})
+	}
+	return script
+}
+
+// myers implements diffLines for inputs without a common prefix or suffix.
+func myers(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return replaceAll(a, b)
+	}
+	limit := n + m
+	offset := limit + 1
+	v := make([]int, 2*limit+2)
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		if (d+1)*len(v) > maxDiffCells {
+			return replaceAll(a, b)
+		}
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // Step down: insert a line of b
+			} else {
+				x = v[offset+k-1] + 1 // Step right: remove a line of a
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, offset)
+			}
+		}
+	}
+	return replaceAll(a, b)
+}
+
+// backtrack walks the trace of myers back from the end of a and b and returns the edit
+// script in order.
+func backtrack(trace [][]int, a, b []string, offset int) []diffLine {
+	var reversed []diffLine
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			reversed = append(reversed, diffLine{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, diffLine{'+', b[y-1]})
+			} else {
+				reversed = append(reversed, diffLine{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	script := make([]diffLine, len(reversed))
+	for i, l := range reversed {
+		script[len(reversed)-1-i] = l
+	}
+	return script
+}
+
+// replaceAll returns the edit script removing all of a and adding all of b.
+func replaceAll(a, b []string) []diffLine {
+	script := make([]diffLine, 0, len(a)+len(b))
+	for _, line := range a {
+		script = append(script, diffLine{'-', line})
+	}
+	for _, line := range b {
+		script = append(script, diffLine{'+', line})
+	}
+	return script
+}
\ No newline at end of file
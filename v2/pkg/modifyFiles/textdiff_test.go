@@ -0,0 +1,102 @@
+package modifyFiles
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	before := "package a\n\nimport \"fmt\"\n\nfunc f() {\n\tfmt.Println(1)\n}\n\nfunc g() {}\n\nfunc h() {}\n\nfunc i() {}\n"
+	after := "package a\n\nimport \"fmt\"\n\nfunc f() {\n\tfmt.Println(2)\n}\n\nfunc g() {}\n\nfunc h() {}\n\nfunc i() {}\n\nfunc j() {}\n"
+	want := `--- a//src/a.go
++++ b//src/a.go
+@@ -3,7 +3,7 @@
+ import "fmt"
+ 
+ func f() {
+-	fmt.Println(1)
++	fmt.Println(2)
+ }
+ 
+ func g() {}
+@@ -11,3 +11,5 @@
+ func h() {}
+ 
+ func i() {}
++
++func j() {}
+`
+	if got := UnifiedDiff("/src/a.go", "/src/a.go", before, after); got != want {
+		t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, want)
+	}
+	if got := UnifiedDiff("/src/a.go", "/src/a.go", before, before); got != "" {
+		t.Errorf("UnifiedDiff() of equal contents = %q, want \"\"", got)
+	}
+}
+
+func TestUnifiedDiff_NewFileAndFinalNewline(t *testing.T) {
+	if got, want := UnifiedDiff(devNull, "/src/new.go", "", "package a\n"), "--- /dev/null\n+++ b//src/new.go\n@@ -0,0 +1,1 @@\n+package a\n"; got != want {
+		t.Errorf("UnifiedDiff() of a new file = %q, want %q", got, want)
+	}
+	want := "--- a//src/a.txt\n+++ b//src/a.txt\n@@ -1,2 +1,2 @@\n one\n-two\n\\ No newline at end of file\n+two\n"
+	if got := UnifiedDiff("/src/a.txt", "/src/a.txt", "one\ntwo", "one\ntwo\n"); got != want {
+		t.Errorf("UnifiedDiff() adding a final newline = %q, want %q", got, want)
+	}
+}
+
+func TestUnifiedDiff_RoundTrip(t *testing.T) {
+	tests := []struct{ before, after string }{
+		{"a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n", "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nK\nl\nm\n"},
+		{"x\ny\nz\n", "z\ny\nx\n"},
+		{"one\n", ""},
+		{"keep\nno newline", "keep\nchanged"},
+	}
+	for _, tt := range tests {
+		diff := UnifiedDiff("/src/f", "/src/f", tt.before, tt.after)
+		fileDiffs, err := parseUnifiedDiffString(diff)
+		if err != nil || len(fileDiffs) != 1 {
+			t.Fatalf("parseUnifiedDiffString(%q) = %v, %v; want one file diff", diff, fileDiffs, err)
+		}
+		got, _, err := applyHunks(tt.before, fileDiffs[0].hunks, false)
+		if err != nil {
+			t.Fatalf("applyHunks(%q) error = %v", diff, err)
+		}
+		if got != tt.after {
+			t.Errorf("applying %q to %q = %q, want %q", diff, tt.before, got, tt.after)
+		}
+	}
+}
+
+func TestApplyFullTextChangesToFiles_DryRun(t *testing.T) {
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.txt")
+	newPath := filepath.Join(dir, "new.txt")
+	if err := os.WriteFile(aPath, []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", aPath, err)
+	}
+
+	response := "--- Start of File: " + aPath + " ---\none\n2\n--- End of File: " + aPath + " ---\n" +
+		"--- Start of File: " + newPath + " ---\nhello\n--- End of File: " + newPath + " ---\n"
+	var out bytes.Buffer
+	result, err := ApplyFullTextChangesToFiles(response, Options{DryRun: true, DiffOutput: &out})
+	if err != nil {
+		t.Fatalf("ApplyFullTextChangesToFiles() error = %v", err)
+	}
+	if len(result.Modified) != 0 || len(result.Created) != 0 {
+		t.Errorf("result = %+v, want no files written", result)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "one\ntwo\n" {
+		t.Errorf("content of %q = %q, want it untouched", aPath, got)
+	}
+	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%q) error = %v, want the file not to be created", newPath, err)
+	}
+	for _, want := range []string{"-two\n+2\n", "--- /dev/null\n+++ b/" + newPath + "\n@@ -0,0 +1,1 @@\n+hello\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("dry-run output %q does not contain %q", out.String(), want)
+		}
+	}
+}
\ No newline at end of file
@@ -0,0 +1,861 @@
+package modifyFiles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+// devNull is the path used in unified diff headers for a file that does not exist
+// on one side of the diff (i.e. a created or deleted file).
+const devNull = "/dev/null"
+
+// noNewlineMarker follows a diff line that is not terminated by a newline.
+const noNewlineMarker = `\ No newline at end of file`
+
+// fileDiff holds the parsed changes for a single file in a unified diff.
+type fileDiff struct {
+	oldPath string // Path from the "--- " header, or devNull for a new file
+	newPath string // Path from the "+++ " header, or devNull for a deleted file
+	hunks   []hunk
+}
+
+// path returns the path of the file the diff applies to.
+func (fd fileDiff) path() string {
+	if fd.newPath == devNull {
+		return fd.oldPath
+	}
+	return fd.newPath
+}
+
+// stat counts the hunks and the added and removed lines of the file diff.
+func (fd fileDiff) stat() DiffStat {
+	stat := DiffStat{Path: fd.path(), Hunks: len(fd.hunks)}
+	for _, h := range fd.hunks {
+		for _, l := range h.lines {
+			switch l.op {
+			case '+':
+				stat.Added++
+			case '-':
+				stat.Removed++
+			}
+		}
+	}
+	return stat
+}
+
+// Options controls how ApplyChangesToFiles and ApplyFullTextChangesToFiles write files.
+type Options struct {
+	// LineEnding selects the line ending of written files: LineEndingAuto (the default)
+	// keeps each file's dominant line ending, while LineEndingLF and LineEndingCRLF force one.
+	LineEnding string
+
+	// Requested lists the files that were sent to the model. A relative path in a
+	// full-text response must resolve to one of them, or the response is rejected.
+	Requested []string
+	// Root is the workspace directory that relative paths are resolved against
+	// (the current directory if empty). The requested files' common directory is tried next.
+	Root string
+
+	// Only, if non-empty, limits writing to these files; other files in the response are
+	// reported as skipped. Every listed file must appear in the response.
+	Only []string
+
+	// AllowNew lets a full-text response write files that are not in Requested.
+	// By default such blocks are reported as unrequested and left unwritten.
+	// It has no effect when Requested is empty.
+	AllowNew bool
+
+	// ReadOnly lists files that were sent to the model for reference only.
+	// Changes to them are rejected: they are reported in ApplyResult.ReadOnly and never written.
+	ReadOnly []string
+
+	// AllowedExts, if non-empty, lists the file extensions (e.g. ".go", "md") that may be
+	// written. Changes to other files are rejected and reported in ApplyResult.Disallowed.
+	AllowedExts []string
+
+	// Gofmt runs go/format on every .go file a response writes, whatever its format, so
+	// the file lands gofmt-clean. Files that do not parse are written as returned and reported in
+	// ApplyResult.GofmtErrors.
+	Gofmt bool
+
+	// Fuzzy lets a diff hunk whose lines do not appear exactly in the file apply within
+	// a few lines of its stated position if it matches there once trailing whitespace is
+	// ignored and blank lines missing from either the hunk or the file are skipped.
+	// Files patched this way are reported in ApplyResult.Fuzzy.
+	Fuzzy bool
+
+	// DiffEngine selects how diff hunks are placed in a file: DiffEngineLines (the
+	// default if empty), DiffEngineText or DiffEngineAuto (see ValidateDiffEngine).
+	DiffEngine string
+
+	// PreserveIndent re-indents the new content of every existing file with the
+	// indentation its .editorconfig prescribes or, without a rule, the tabs or spaces
+	// detected in its original content, undoing a model's re-indentation. New files are
+	// written as returned.
+	PreserveIndent bool
+
+	// NormalizeEOL converts the line endings of each full-text block to the convention
+	// selected by LineEnding (by default the dominant one of the file being replaced, or
+	// "\n" for a new file), so a block with "\r\n" or mixed line endings does not change
+	// them all.
+	NormalizeEOL bool
+	// TrimTrailing removes trailing spaces and tabs from every line of each full-text
+	// block.
+	TrimTrailing bool
+
+	// Markers frame each file in a full-text response; the zero value means
+	// utils.DefaultMarkers. They must match the markers used to generate the prompt.
+	Markers utils.Markers
+
+	// LengthHints makes a mismatch between a block's content and the length hint in its
+	// begin marker (see utils.LengthHintFormat) a ParseError, so a silently truncated
+	// block is not written. Without it, a mismatch is only logged. Blocks without a hint
+	// are never checked.
+	LengthHints bool
+
+	// Base64 decodes the content of each full-text block as base64 (whitespace, such as
+	// line wrapping, is ignored), for responses to a prompt generated with
+	// prompt.Options.Base64. Content that is not valid base64 is a ParseError. The decoded
+	// content is written exactly, without the final newline rule, and a length hint is
+	// checked against it.
+	Base64 bool
+
+	// DryRun computes the changes without creating, writing or deleting any file, and
+	// prints a unified diff of each file that would change to DiffOutput instead (see
+	// UnifiedDiff). Files are not listed as modified, created or deleted in ApplyResult.
+	DryRun bool
+	// DiffOutput receives the diffs printed with DryRun; os.Stdout if nil.
+	DiffOutput io.Writer
+
+	// DumpTag is appended to the names of the copies of the response saved in the
+	// temporary directory, such as unifiedDiff.txt, so concurrent applies do not
+	// overwrite each other's.
+	DumpTag string
+
+	// Backup, if set, is called with the path of each file before it is written, created
+	// or deleted, so its current content can be saved; an error stops the apply before
+	// the file is touched. It is not called with DryRun.
+	Backup func(path string) error
+
+	// Context, if set, stops the apply once it is canceled: no further file is written
+	// and the error wraps the context's error. Each file is written atomically, so the
+	// file being written when it is canceled is either finished or left untouched.
+	Context context.Context
+}
+
+// hunk is a single "@@ -a,b +c,d @@" section of a file diff.
+type hunk struct {
+	header   string // The full "@@ ... @@" line, for error messages
+	oldStart int    // 1-based start line in the original file
+	lines    []hunkLine
+}
+
+// hunkLine is one line of a hunk body.
+type hunkLine struct {
+	op        byte   // ' ' for context, '-' for a removed line, '+' for an added line
+	text      string // Line content without the op prefix or newline
+	noNewline bool   // The line is followed by "\ No newline at end of file"
+}
+
+// ApplyChangesToFiles parses the AI response containing a unified diff
+// (as produced by `git diff`) and applies it to the respective files on disk.
+// It is ParseDiff followed by ApplyFileChanges: all file diffs are applied in memory
+// first, so nothing is written unless every file's hunks apply cleanly. Diffs for
+// Options.ReadOnly files, and for files whose extension is not in Options.AllowedExts,
+// are rejected.
+// Files are matched with their line endings normalized to "\n", so an LF diff applies
+// to a CRLF file; the line ending selected by opts.LineEnding is restored on write.
+// With Options.Gofmt set, Go files are formatted before they are written.
+// With Options.Fuzzy set, hunks that are slightly off may be applied by fuzzy matching.
+// With Options.DryRun set, the diff of each file's resulting content is printed instead.
+// The returned ApplyResult lists the files written or deleted, even when an error
+// stops the run part way.
+// Example format:
+// --- a//path/to/file1
+// +++ b//path/to/file1
+// @@ -10,3 +10,3 @@
+// -removed line
+// +added line
+func ApplyChangesToFiles(diffResponse string, opts Options) (ApplyResult, error) {
+	changes, err := ParseDiff(diffResponse, opts)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	return ApplyFileChanges(changes, opts)
+}
+
+// FileChange is the change a diff makes to one file, computed in memory by ParseDiff
+// and written by ApplyFileChanges.
+type FileChange struct {
+	OldPath  string // File the change starts from; "/dev/null" if it creates the file
+	NewPath  string // File the change writes; "/dev/null" if it deletes the file
+	Original string // Content of OldPath before the change; "" for a created file
+	Content  string // Content to write to NewPath, with its final line endings; "" for a deleted file
+
+	Stat     DiffStat // Hunk and line counts of the diff
+	Fuzzy    bool     // Some hunks were placed by fuzzy matching (see Options.Fuzzy)
+	GofmtErr error    // Why go/format rejected Content, with Options.Gofmt set
+}
+
+// Path returns the path of the file the change applies to.
+func (c FileChange) Path() string {
+	if c.NewPath == devNull {
+		return c.OldPath
+	}
+	return c.NewPath
+}
+
+// ParseDiff parses diffResponse, an AI response containing a unified diff, and computes
+// the new content of every file it changes without writing anything, so the changes can
+// be validated, shown or confirmed before ApplyFileChanges writes them. Relative paths
+// in the diff headers are resolved as described for resolveDiffPaths, and the original
+// files are read from disk. Options.LineEnding, Options.Fuzzy, Options.DiffEngine,
+// Options.PreserveIndent and Options.Gofmt shape the computed content; the options
+// deciding which files may be written are left to ApplyFileChanges. A diff that does
+// not parse, changes a file that does not exist (rather than creating it from
+// /dev/null), changes a file more than once or has a hunk that does not apply is a
+// ParseError. Files are patched concurrently; if several fail, the error of the first
+// in the diff is returned.
+func ParseDiff(diffResponse string, opts Options) ([]FileChange, error) {
+	diffResponse = cleanAIMarkdown(diffResponse) // Use common markdown cleaner
+
+	dumpResponse("unifiedDiff", opts.DumpTag, diffResponse)
+
+	fileDiffs, err := parseUnifiedDiffString(diffResponse)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolveDiffPaths(fileDiffs, opts); err != nil {
+		return nil, err
+	}
+	if err := checkTargetsExist(fileDiffs); err != nil {
+		return nil, err
+	}
+	if err := checkDuplicateTargets(fileDiffs); err != nil {
+		return nil, err
+	}
+
+	changes := make([]FileChange, len(fileDiffs))
+	err = forEachFile(len(fileDiffs), func(i int) error {
+		fd := fileDiffs[i]
+		change := FileChange{OldPath: fd.oldPath, NewPath: fd.newPath, Stat: fd.stat()}
+		if fd.oldPath != devNull {
+			contentBytes, err := os.ReadFile(fd.oldPath)
+			if err != nil {
+				logging.Errorf("Failed to read file %q for patching: %v", fd.oldPath, err)
+				return fmt.Errorf("failed to read file %q: %w", fd.oldPath, err)
+			}
+			change.Original = string(contentBytes)
+		}
+		if fd.newPath == devNull {
+			changes[i] = change // Deleted file, nothing to compute
+			return nil
+		}
+		newContent, fuzzyHunks, err := patchContent(change.Original, fd, opts.DiffEngine, opts.Fuzzy)
+		if err != nil {
+			logging.Errorf("Cannot patch %q: %v", fd.path(), err)
+			return err
+		}
+		if fuzzyHunks > 0 {
+			logging.Warningf("%d of %d hunks for %q were applied by fuzzy matching; review the change.", fuzzyHunks, len(fd.hunks), fd.path())
+			logging.Event("file_fuzzy_match", map[string]interface{}{"path": fd.path(), "hunks": fuzzyHunks})
+			change.Fuzzy = true
+		}
+		if opts.PreserveIndent && fd.oldPath != devNull {
+			newContent = preserveIndent(fd.newPath, normalizeLineEndings(change.Original), newContent)
+		}
+		if opts.Gofmt {
+			newContent, change.GofmtErr = formatGo(fd.newPath, newContent)
+		}
+		lineEnding := resolveLineEnding(opts.LineEnding, change.Original)
+		logging.V(2).Infof("Writing %q with %s line endings.", fd.path(), lineEnding)
+		change.Content = convertLineEndings(newContent, lineEnding)
+		changes[i] = change
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// ApplyFileChanges writes changes, as computed by ParseDiff, to disk in order. Changes
+// to Options.ReadOnly files, to files whose extension is not in Options.AllowedExts
+// and, unless Options.AllowNew is set, to files outside a non-empty Options.Requested
+// are rejected, and changes outside Options.Only are skipped; a file selected by
+// Options.Only that no change touches is an error, reported before anything is
+// written. With Options.DryRun set, the diff of each change is printed instead. Every
+// file is backed up (see Options.Backup) before any is written, and files are then
+// written concurrently; if several writes fail, the error of the first change is
+// returned. A failed write rolls back the files already written, restoring their
+// original content, so the changes are applied all or nothing; an apply stopped by
+// Options.Context is left to its caller to roll back. The returned ApplyResult lists
+// the files written or deleted, in the order of changes, even when an error stops the
+// run part way.
+func ApplyFileChanges(changes []FileChange, opts Options) (ApplyResult, error) {
+	var result ApplyResult
+	only, err := newOnlyFilter(opts.Only)
+	if err != nil {
+		return result, err
+	}
+	readOnly, err := newPathSet(opts.ReadOnly)
+	if err != nil {
+		return result, err
+	}
+	requested, err := newPathSet(opts.Requested)
+	if err != nil {
+		return result, err
+	}
+	allowedExts := newExtAllowlist(opts.AllowedExts)
+
+	// Decide which changes may be written before touching the disk.
+	skip := make([]bool, len(changes))
+	rejected := make([]bool, len(changes))
+	disallowed := make([]bool, len(changes))
+	unrequested := make([]bool, len(changes))
+	for i, change := range changes {
+		switch {
+		case readOnly.contains(change.OldPath) || readOnly.contains(change.NewPath):
+			logging.Warningf("Refusing to change %q: it was provided as a read-only context file.", change.Path())
+			logging.Event("file_read_only", map[string]interface{}{"path": change.Path()})
+			rejected[i] = true
+		case !allowedExts.allows(change.Path()):
+			logging.Warningf("Refusing to change %q: its extension is not in the allowlist %q.", change.Path(), opts.AllowedExts)
+			logging.Event("file_disallowed", map[string]interface{}{"path": change.Path()})
+			disallowed[i] = true
+		case requested != nil && !opts.AllowNew && !requested.contains(change.Path()):
+			logging.Warningf("Refusing to change %q: the AI response changes a file that was not requested (use --allow-new to permit this).", change.Path())
+			logging.Event("file_unrequested", map[string]interface{}{"path": change.Path()})
+			unrequested[i] = true
+		case !only.allows(change.Path()):
+			logging.V(0).Infof("Skipping changes to %q: not selected for writing.", change.Path())
+			skip[i] = true
+		}
+	}
+
+	if err := only.check(); err != nil {
+		return result, err
+	}
+
+	// Back the files up in order, then write them concurrently.
+	var writes []int
+	for i, change := range changes {
+		if rejected[i] {
+			result.ReadOnly = append(result.ReadOnly, change.Path())
+			continue
+		}
+		if disallowed[i] {
+			result.Disallowed = append(result.Disallowed, change.Path())
+			continue
+		}
+		if unrequested[i] {
+			result.Unrequested = append(result.Unrequested, change.Path())
+			continue
+		}
+		if skip[i] {
+			result.Skipped = append(result.Skipped, change.Path())
+			continue
+		}
+		if change.GofmtErr != nil {
+			logging.Errorf("File %q is not valid Go and was written unformatted: %v", change.NewPath, change.GofmtErr)
+			logging.Event("file_gofmt_error", map[string]interface{}{"path": change.NewPath, "error": change.GofmtErr.Error()})
+			result.GofmtErrors = append(result.GofmtErrors, GofmtError{Path: change.NewPath, Err: change.GofmtErr})
+		}
+		if opts.DryRun {
+			if err := writeDryRunDiff(opts, change.OldPath, change.NewPath, change.Original, change.Content); err != nil {
+				return result, err
+			}
+			continue
+		}
+		if err := backup(opts, change.Path()); err != nil {
+			return result, err
+		}
+		writes = append(writes, i)
+	}
+
+	written := make([]bool, len(writes))
+	writeErr := forEachFile(len(writes), func(w int) error {
+		change := changes[writes[w]]
+		if err := canceled(opts); err != nil {
+			return err
+		}
+		if change.NewPath == devNull {
+			if err := os.Remove(change.OldPath); err != nil {
+				logging.Errorf("Failed to delete file %q: %v", change.OldPath, err)
+				return fmt.Errorf("failed to delete file %q: %w", change.OldPath, err)
+			}
+			written[w] = true
+			return nil
+		}
+		logging.V(2).Infof("Attempting to write %d bytes to file: %q", len(change.Content), change.NewPath)
+		logging.V(3).Infof("File content for %q (truncated): %q", change.NewPath, utils.TruncateString(change.Content, 200))
+		if err := writeFile(change.NewPath, change.Content); err != nil {
+			logging.Errorf("Failed to write content to file %q: %v", change.NewPath, err)
+			return fmt.Errorf("failed to write content to file %q: %w", change.NewPath, err)
+		}
+		written[w] = true
+		return nil
+	})
+
+	if writeErr != nil && (opts.Context == nil || opts.Context.Err() == nil) {
+		rollbackWrites(changes, writes, written)
+		return result, writeErr
+	}
+
+	// Report the files written in the order of the changes, whatever order they were written in.
+	for w, i := range writes {
+		if !written[w] {
+			continue
+		}
+		change := changes[i]
+		stat := change.Stat
+		switch {
+		case change.NewPath == devNull:
+			logging.V(0).Infof("Successfully deleted file: %q", change.OldPath)
+			logging.Event("file_deleted", map[string]interface{}{"path": change.OldPath})
+			result.Deleted = append(result.Deleted, change.OldPath)
+			result.DiffStats = append(result.DiffStats, stat)
+			continue
+		case change.OldPath == devNull:
+			logging.V(0).Infof("Successfully created file: %q", change.NewPath)
+			logging.Event("file_created", map[string]interface{}{"path": change.NewPath, "bytes": len(change.Content)})
+			result.Created = append(result.Created, change.NewPath)
+		default:
+			logging.V(0).Infof("Successfully updated file: %q", change.NewPath)
+			logging.Event("file_modified", map[string]interface{}{"path": change.NewPath, "bytes": len(change.Content)})
+			result.Modified = append(result.Modified, change.NewPath)
+			if change.Fuzzy {
+				result.Fuzzy = append(result.Fuzzy, change.NewPath)
+			}
+		}
+		logging.V(0).Infof("Applied %d hunks to %q (+%d -%d).", stat.Hunks, stat.Path, stat.Added, stat.Removed)
+		result.DiffStats = append(result.DiffStats, stat)
+	}
+	if writeErr != nil {
+		return result, writeErr
+	}
+
+	return result, nil
+}
+
+// rollbackWrites restores the files written for changes[writes[w]] where written[w] is
+// set, after a later write failed: modified and deleted files get their original
+// content back and created files are removed. Failures are logged, as the write error
+// is the one reported.
+func rollbackWrites(changes []FileChange, writes []int, written []bool) {
+	for w, i := range writes {
+		if !written[w] {
+			continue
+		}
+		change := changes[i]
+		var err error
+		switch {
+		case change.NewPath == devNull:
+			err = writeFile(change.OldPath, change.Original)
+		case change.OldPath == devNull || filepath.Clean(change.OldPath) != filepath.Clean(change.NewPath):
+			err = os.Remove(change.NewPath)
+		default:
+			err = writeFile(change.NewPath, change.Original)
+		}
+		if err != nil {
+			logging.Errorf("Failed to roll back %q: %v", change.Path(), err)
+			continue
+		}
+		logging.Warningf("Rolled back %q after a failed write.", change.Path())
+		logging.Event("file_rolled_back", map[string]interface{}{"path": change.Path()})
+	}
+}
+
+// ApplyUnifiedDiff applies unifiedDiff, a unified diff of a single file, to original and
+// returns the patched content, without reading or writing any file; the paths in the
+// diff headers are ignored. Hunks are placed as by ApplyChangesToFiles, and the result
+// keeps the dominant line ending of original. A diff deleting the file yields "". A diff
+// that does not parse, covers several files or does not apply is a ParseError.
+func ApplyUnifiedDiff(original, unifiedDiff string) (string, error) {
+	fileDiffs, err := parseUnifiedDiffString(cleanAIMarkdown(unifiedDiff))
+	if err != nil {
+		return "", err
+	}
+	if len(fileDiffs) != 1 {
+		return "", &ParseError{Reason: fmt.Sprintf("expected a diff of one file, found %d", len(fileDiffs))}
+	}
+	fd := fileDiffs[0]
+	if fd.newPath == devNull {
+		return "", nil
+	}
+	newContent, _, err := patchContent(original, fd, DiffEngineLines, false)
+	if err != nil {
+		return "", err
+	}
+	return convertLineEndings(newContent, detectLineEnding(original)), nil
+}
+
+// checkTargetsExist returns a ParseError naming the files that the diffs change or delete
+// but that do not exist, so a diff against a wrong path fails up front with a clear
+// message instead of being patched as if the file were empty.
+func checkTargetsExist(fileDiffs []fileDiff) error {
+	var missing []string
+	for _, fd := range fileDiffs {
+		if fd.oldPath == devNull {
+			continue
+		}
+		if _, err := os.Stat(fd.oldPath); errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, strconv.Quote(fd.oldPath))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	logging.Errorf("The diff changes files that do not exist: %s", strings.Join(missing, ", "))
+	return &ParseError{Reason: fmt.Sprintf("target file not found: %s (a diff creating a file must start with \"--- %s\")", strings.Join(missing, ", "), devNull)}
+}
+
+// checkDuplicateTargets returns a ParseError if several file diffs change the same file,
+// since their changes, each computed from the file on disk, could not all be written.
+func checkDuplicateTargets(fileDiffs []fileDiff) error {
+	seen := make(map[string]bool, len(fileDiffs))
+	for _, fd := range fileDiffs {
+		path := filepath.Clean(fd.path())
+		if seen[path] {
+			logging.Errorf("The diff changes %q more than once.", fd.path())
+			return &ParseError{Reason: fmt.Sprintf("the diff changes %q in several sections; a file's hunks must all be in one section", fd.path())}
+		}
+		seen[path] = true
+	}
+	return nil
+}
+
+// patchContent applies the hunks of fd to original, the content of its old file, with
+// line endings normalized to "\n", using the diff engine named engine. It returns the
+// patched content with "\n" line endings and the number of hunks placed by fuzzy
+// matching. A hunk that does not apply is a ParseError naming the file.
+func patchContent(original string, fd fileDiff, engine string, fuzzy bool) (string, int, error) {
+	newContent, fuzzyHunks, err := applyDiffHunks(normalizeLineEndings(original), fd.hunks, engine, fuzzy)
+	if err != nil {
+		return "", 0, &ParseError{Reason: fmt.Sprintf("failed to apply diff to %q: %v", fd.path(), err)}
+	}
+	return newContent, fuzzyHunks, nil
+}
+
+// parseUnifiedDiffString splits a unified diff into per-file diffs and parses their hunks.
+// Git extended headers ("diff --git", "index", mode lines) are accepted and ignored.
+// Line counts in hunk headers are not trusted, since models often get them wrong;
+// a hunk body extends until the next hunk or file header.
+func parseUnifiedDiffString(diff string) ([]fileDiff, error) {
+	lines := strings.Split(strings.ReplaceAll(diff, "\r\n", "\n"), "\n")
+	var fileDiffs []fileDiff
+	var current *fileDiff
+	var currentHunk *hunk
+
+	flushFile := func() {
+		if current != nil {
+			fileDiffs = append(fileDiffs, *current)
+		}
+		current = nil
+		currentHunk = nil
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			flushFile()
+			current = &fileDiff{
+				oldPath: parseDiffHeaderPath(strings.TrimPrefix(line, "--- "), "a/"),
+				newPath: parseDiffHeaderPath(strings.TrimPrefix(lines[i+1], "+++ "), "b/"),
+			}
+			if current.oldPath == devNull && current.newPath == devNull {
+				return nil, &ParseError{Reason: fmt.Sprintf("diff header at line %d has no file path", i+1)}
+			}
+			i++ // Skip the "+++ " line
+		case strings.HasPrefix(line, "@@"):
+			if current == nil {
+				return nil, &ParseError{Reason: fmt.Sprintf("hunk header %q at line %d is not preceded by a ---/+++ file header", line, i+1)}
+			}
+			oldStart, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, &ParseError{Reason: fmt.Sprintf("line %d: %v", i+1, err)}
+			}
+			current.hunks = append(current.hunks, hunk{header: line, oldStart: oldStart})
+			currentHunk = &current.hunks[len(current.hunks)-1]
+		case currentHunk != nil && line == noNewlineMarker:
+			if len(currentHunk.lines) > 0 {
+				currentHunk.lines[len(currentHunk.lines)-1].noNewline = true
+			}
+		case currentHunk != nil && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "-") || strings.HasPrefix(line, "+")):
+			currentHunk.lines = append(currentHunk.lines, hunkLine{op: line[0], text: line[1:]})
+		case currentHunk != nil && line == "":
+			// Models frequently drop the leading space of blank context lines.
+			currentHunk.lines = append(currentHunk.lines, hunkLine{op: ' '})
+		default:
+			// "diff --git", "index", mode lines and any surrounding prose end the current hunk.
+			currentHunk = nil
+		}
+	}
+	flushFile()
+
+	// Trailing blank lines at the end of the response were parsed as empty context lines.
+	for i := range fileDiffs {
+		for j := range fileDiffs[i].hunks {
+			h := &fileDiffs[i].hunks[j]
+			for len(h.lines) > 0 && h.lines[len(h.lines)-1] == (hunkLine{op: ' '}) {
+				h.lines = h.lines[:len(h.lines)-1]
+			}
+		}
+	}
+
+	if len(fileDiffs) == 0 {
+		return nil, &ParseError{Reason: "no file diffs found in AI response"}
+	}
+	for _, fd := range fileDiffs {
+		if len(fd.hunks) == 0 && fd.newPath != devNull {
+			return nil, &ParseError{Reason: fmt.Sprintf("diff for %q contains no hunks", fd.path())}
+		}
+	}
+	logging.V(1).Infof("Parsed unified diff for %d files.", len(fileDiffs))
+	return fileDiffs, nil
+}
+
+// parseDiffHeaderPath extracts the file path from a "---" or "+++" header value,
+// stripping the git "a/" or "b/" prefix and any trailing timestamp.
+func parseDiffHeaderPath(value, gitPrefix string) string {
+	if tab := strings.Index(value, "\t"); tab != -1 {
+		value = value[:tab]
+	}
+	value = strings.TrimSpace(value)
+	if value == devNull {
+		return devNull
+	}
+	return strings.TrimPrefix(value, gitPrefix)
+}
+
+// parseHunkHeader returns the original-file start line from a "@@ -a,b +c,d @@" header.
+// Both ranges must be well formed, even though only the start of the first is used.
+func parseHunkHeader(header string) (int, error) {
+	fields := strings.Fields(header)
+	if len(fields) < 3 || fields[0] != "@@" || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return 0, fmt.Errorf("malformed hunk header %q", header)
+	}
+	oldStart, err := parseHunkRange(strings.TrimPrefix(fields[1], "-"))
+	if err != nil {
+		return 0, fmt.Errorf("malformed hunk header %q: %w", header, err)
+	}
+	if _, err := parseHunkRange(strings.TrimPrefix(fields[2], "+")); err != nil {
+		return 0, fmt.Errorf("malformed hunk header %q: %w", header, err)
+	}
+	return oldStart, nil
+}
+
+// parseHunkRange returns the start line of a hunk header range, "start" or "start,count",
+// both of which must be non-negative integers.
+func parseHunkRange(r string) (int, error) {
+	startField, countField, hasCount := strings.Cut(r, ",")
+	start, err := strconv.Atoi(startField)
+	if err == nil && start < 0 {
+		err = fmt.Errorf("negative start line %d", start)
+	}
+	if err == nil && hasCount {
+		var count int
+		if count, err = strconv.Atoi(countField); err == nil && count < 0 {
+			err = fmt.Errorf("negative line count %d", count)
+		}
+	}
+	return start, err
+}
+
+// applyHunks applies the hunks, in order, to the original content and returns the result.
+// Each hunk is first tried at the line given in its header; if the context does not match
+// there, the nearest exact match after the previous hunk is used instead, like `patch` does.
+// With fuzzy set, a hunk that matches nowhere exactly is then looked for nearby with
+// fuzzyApplyHunk. The second result counts the hunks placed that way.
+func applyHunks(original string, hunks []hunk, fuzzy bool) (string, int, error) {
+	lines, finalNewline := splitLines(original)
+	if original == "" {
+		finalNewline = true
+	}
+
+	var result []string
+	pos := 0        // Next unconsumed line of the original
+	fuzzyHunks := 0 // Hunks placed by fuzzyApplyHunk
+	for _, h := range hunks {
+		var oldLines, newLines []string
+		for _, l := range h.lines {
+			if l.op != '+' {
+				oldLines = append(oldLines, l.text)
+			}
+			if l.op != '-' {
+				newLines = append(newLines, l.text)
+			}
+		}
+
+		expected := h.oldStart - 1
+		if len(oldLines) == 0 {
+			expected = h.oldStart // A pure insertion's start line is the line it follows
+		}
+		start := findHunk(lines, oldLines, expected, pos)
+		replacement, end := newLines, start+len(oldLines)
+		if start == -1 && fuzzy {
+			var ok bool
+			if replacement, start, end, ok = fuzzyApplyHunk(lines, h, expected, pos); ok {
+				logging.Warningf("Hunk %s does not match the file exactly; applied it at line %d by fuzzy matching.", h.header, start+1)
+				fuzzyHunks++
+			}
+		}
+		if start == -1 {
+			return "", 0, fmt.Errorf("hunk %s does not match the file content: %s", h.header, describeMismatch(lines, oldLines, max(expected, pos)))
+		}
+		result = append(result, lines[pos:start]...)
+		result = append(result, replacement...)
+		pos = end
+
+		// A hunk reaching the end of the file decides whether the file ends with a newline.
+		if pos == len(lines) {
+			finalNewline = true
+			for i := len(h.lines) - 1; i >= 0; i-- {
+				if h.lines[i].op != '-' {
+					finalNewline = !h.lines[i].noNewline
+					break
+				}
+			}
+		}
+	}
+	result = append(result, lines[pos:]...)
+
+	if len(result) == 0 {
+		return "", fuzzyHunks, nil
+	}
+	newContent := strings.Join(result, "\n")
+	if finalNewline {
+		newContent += "\n"
+	}
+	return newContent, fuzzyHunks, nil
+}
+
+// findHunk returns the index in lines where oldLines match, preferring the expected index
+// and otherwise the closest match at or after minIndex. It returns -1 if there is no match.
+func findHunk(lines, oldLines []string, expected, minIndex int) int {
+	if expected < minIndex {
+		expected = minIndex
+	}
+	best := -1
+	for i := minIndex; i+len(oldLines) <= len(lines); i++ {
+		if !linesMatchAt(lines, oldLines, i) {
+			continue
+		}
+		if best == -1 || abs(i-expected) < abs(best-expected) {
+			best = i
+		}
+		if i >= expected {
+			break // Later matches are only further away
+		}
+	}
+	return best
+}
+
+// fuzzyWindow is how many lines before or after its stated position a hunk that does not
+// match exactly anywhere is looked for with Options.Fuzzy.
+const fuzzyWindow = 10
+
+// fuzzyApplyHunk looks for h within fuzzyWindow lines of expected, at or after minIndex,
+// with the loose comparison of fuzzyMatchAt, trying the closest positions first. It
+// returns the lines replacing lines[start:end]; ok is false if h matches nowhere nearby.
+func fuzzyApplyHunk(lines []string, h hunk, expected, minIndex int) (replacement []string, start, end int, ok bool) {
+	for offset := 0; offset <= fuzzyWindow; offset++ {
+		for _, i := range []int{expected - offset, expected + offset} {
+			if i < minIndex || i > len(lines) {
+				continue
+			}
+			if replacement, end, ok := fuzzyMatchAt(lines, h, i); ok {
+				return replacement, i, end, true
+			}
+		}
+	}
+	return nil, -1, -1, false
+}
+
+// fuzzyMatchAt reports whether the context and removed lines of h match lines from index
+// i, ignoring trailing whitespace and skipping blank lines that only one side has. It
+// returns the replacement for lines[i:end], which keeps the file's own version of the
+// context lines and the blank lines the hunk left out. A hunk made only of blank lines
+// never matches.
+func fuzzyMatchAt(lines []string, h hunk, i int) ([]string, int, bool) {
+	var replacement []string
+	matched := false
+	for _, l := range h.lines {
+		if l.op == '+' {
+			replacement = append(replacement, l.text)
+			continue
+		}
+		want := strings.TrimRight(l.text, " \t")
+		for want != "" && i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+			replacement = append(replacement, lines[i]) // Blank line missing from the hunk
+			i++
+		}
+		switch {
+		case i < len(lines) && strings.TrimRight(lines[i], " \t") == want:
+			if l.op == ' ' {
+				replacement = append(replacement, lines[i])
+			}
+			matched = matched || want != ""
+			i++
+		case want == "":
+			// Blank line missing from the file
+		default:
+			return nil, 0, false
+		}
+	}
+	return replacement, i, matched
+}
+
+// describeMismatch explains why want does not appear in lines at index i,
+// naming the first line (1-based) whose content differs from what the hunk expects.
+func describeMismatch(lines, want []string, i int) string {
+	for j, w := range want {
+		n := i + j
+		if n >= len(lines) {
+			return fmt.Sprintf("expected %q at line %d, but the file has only %d lines", w, n+1, len(lines))
+		}
+		if lines[n] != w {
+			return fmt.Sprintf("line %d: expected %q, found %q", n+1, w, lines[n])
+		}
+	}
+	return "context not found"
+}
+
+// linesMatchAt reports whether want appears in lines starting at index i.
+func linesMatchAt(lines, want []string, i int) bool {
+	for j, w := range want {
+		if lines[i+j] != w {
+			return false
+		}
+	}
+	return true
+}
+
+// splitLines splits content into lines without their newline terminators,
+// reporting whether the final line was terminated by a newline.
+func splitLines(content string) ([]string, bool) {
+	if content == "" {
+		return nil, false
+	}
+	finalNewline := strings.HasSuffix(content, "\n")
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	return lines, finalNewline
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
\ No newline at end of file
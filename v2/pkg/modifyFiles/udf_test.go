@@ -0,0 +1,785 @@
+package modifyFiles
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseUnifiedDiffString(t *testing.T) {
+	diff := `diff --git a//src/a.go b//src/a.go
+index 123..456 100644
+--- a//src/a.go
++++ b//src/a.go
+@@ -1,3 +1,3 @@
+ package a
+-var x = 1
++var x = 2
+
+--- /dev/null
++++ b//src/new.go
+@@ -0,0 +1,1 @@
++package a
+`
+	got, err := parseUnifiedDiffString(diff)
+	if err != nil {
+		t.Fatalf("parseUnifiedDiffString() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("parseUnifiedDiffString() returned %d file diffs, want 2", len(got))
+	}
+	if got[0].oldPath != "/src/a.go" || got[0].newPath != "/src/a.go" {
+		t.Errorf("first file paths = (%q, %q), want (%q, %q)", got[0].oldPath, got[0].newPath, "/src/a.go", "/src/a.go")
+	}
+	if len(got[0].hunks) != 1 || got[0].hunks[0].oldStart != 1 {
+		t.Errorf("first file hunks = %+v, want one hunk starting at line 1", got[0].hunks)
+	}
+	if got[1].oldPath != devNull || got[1].newPath != "/src/new.go" {
+		t.Errorf("second file paths = (%q, %q), want (%q, %q)", got[1].oldPath, got[1].newPath, devNull, "/src/new.go")
+	}
+
+	for _, malformed := range []string{"", "just some prose", "@@ -1 +1 @@\n-a\n+b\n", "--- a/x\n+++ b/x\n@@ bogus @@\n", "--- a/x\n+++ b/x\n@@ -1,-2 +1 @@\n-a\n"} {
+		if _, err := parseUnifiedDiffString(malformed); !IsParseError(err) {
+			t.Errorf("parseUnifiedDiffString(%q) error = %v, want a ParseError", malformed, err)
+		}
+	}
+}
+
+func TestApplyHunks(t *testing.T) {
+	original := "line1\nline2\nline3\nline4\nline5\n"
+	tests := []struct {
+		name    string
+		diff    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "Replace a line",
+			diff: "--- a/f\n+++ b/f\n@@ -2,3 +2,3 @@\n line2\n-line3\n+LINE3\n line4\n",
+			want: "line1\nline2\nLINE3\nline4\nline5\n",
+		},
+		{
+			name: "Wrong line number is relocated",
+			diff: "--- a/f\n+++ b/f\n@@ -1,2 +1,2 @@\n line4\n-line5\n+LINE5\n",
+			want: "line1\nline2\nline3\nline4\nLINE5\n",
+		},
+		{
+			name: "Pure insertion",
+			diff: "--- a/f\n+++ b/f\n@@ -1,0 +2,1 @@\n+inserted\n",
+			want: "line1\ninserted\nline2\nline3\nline4\nline5\n",
+		},
+		{
+			name: "Remove final newline",
+			diff: "--- a/f\n+++ b/f\n@@ -5,1 +5,1 @@\n-line5\n+line5\n\\ No newline at end of file\n",
+			want: "line1\nline2\nline3\nline4\nline5",
+		},
+		{
+			name:    "Context mismatch",
+			diff:    "--- a/f\n+++ b/f\n@@ -2,2 +2,2 @@\n line2\n-nope\n+yes\n",
+			wantErr: true,
+		},
+	}
+	// Every diff engine must agree on diffs with hunks in file order.
+	for engine, applier := range diffAppliers {
+		for _, tt := range tests {
+			t.Run(engine+"/"+tt.name, func(t *testing.T) {
+				fileDiffs, err := parseUnifiedDiffString(tt.diff)
+				if err != nil {
+					t.Fatalf("parseUnifiedDiffString() error = %v", err)
+				}
+				got, _, err := applier.applyHunks(original, fileDiffs[0].hunks, false)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("applyHunks() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if !tt.wantErr && got != tt.want {
+					t.Errorf("applyHunks() = %q, want %q", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestApplyChangesToFiles_DiffEngines(t *testing.T) {
+	original := "func a() {\n\treturn 1\n}\n\nfunc b() {\n\treturn 2\n}\n"
+	inOrder := "@@ -1,3 +1,3 @@\n func a() {\n-\treturn 1\n+\treturn 10\n }\n@@ -5,3 +5,3 @@\n func b() {\n-\treturn 2\n+\treturn 20\n }\n"
+	// Models sometimes emit hunks out of file order, which `patch` rejects.
+	outOfOrder := "@@ -5,3 +5,3 @@\n func b() {\n-\treturn 2\n+\treturn 20\n }\n@@ -1,3 +1,3 @@\n func a() {\n-\treturn 1\n+\treturn 10\n }\n"
+	want := "func a() {\n\treturn 10\n}\n\nfunc b() {\n\treturn 20\n}\n"
+
+	tests := []struct {
+		engine  string
+		hunks   string
+		wantErr bool
+	}{
+		{engine: "", hunks: inOrder},
+		{engine: DiffEngineLines, hunks: inOrder},
+		{engine: DiffEngineText, hunks: inOrder},
+		{engine: DiffEngineAuto, hunks: inOrder},
+		{engine: DiffEngineLines, hunks: outOfOrder, wantErr: true},
+		{engine: DiffEngineText, hunks: outOfOrder},
+		{engine: DiffEngineAuto, hunks: outOfOrder},
+	}
+	for _, tt := range tests {
+		name := tt.engine
+		if tt.hunks == outOfOrder {
+			name += " out of order"
+		}
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "f.go")
+			if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+				t.Fatal(err)
+			}
+			diff := "--- a/" + path + "\n+++ b/" + path + "\n" + tt.hunks
+			_, err := ApplyChangesToFiles(diff, Options{DiffEngine: tt.engine})
+			if tt.wantErr {
+				if !IsParseError(err) {
+					t.Errorf("ApplyChangesToFiles() error = %v, want a ParseError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyChangesToFiles() error = %v", err)
+			}
+			if got, _ := os.ReadFile(path); string(got) != want {
+				t.Errorf("content = %q, want %q", got, want)
+			}
+		})
+	}
+
+	if err := ValidateDiffEngine("patch"); err == nil {
+		t.Errorf("ValidateDiffEngine(%q) succeeded, want an error", "patch")
+	}
+}
+
+func TestValidateDiffEngine_Unavailable(t *testing.T) {
+	for _, engine := range []string{"gitdiff", "dmp"} {
+		if err := ValidateDiffEngine(engine); err == nil || !strings.Contains(err.Error(), "not available") {
+			t.Errorf("ValidateDiffEngine(%q) error = %v, want it to say the engine is not available", engine, err)
+		}
+	}
+}
+
+func TestFallbackApplier_JoinsErrors(t *testing.T) {
+	fileDiffs, err := parseUnifiedDiffString("--- a/f\n+++ b/f\n@@ -1,1 +1,1 @@\n-missing\n+new\n")
+	if err != nil {
+		t.Fatalf("parseUnifiedDiffString() error = %v", err)
+	}
+	_, _, err = diffAppliers[DiffEngineAuto].applyHunks("a\n", fileDiffs[0].hunks, false)
+	if err == nil || !strings.Contains(err.Error(), DiffEngineLines+" engine") || !strings.Contains(err.Error(), DiffEngineText+" engine") {
+		t.Errorf("applyHunks() error = %v, want the failures of both engines", err)
+	}
+}
+
+func TestApplyUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		diff     string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "Modify",
+			original: "a\nb\nc\n",
+			diff:     "```diff\n--- a/x.txt\n+++ b/x.txt\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n```\n",
+			want:     "a\nB\nc\n",
+		},
+		{
+			name:     "CRLF kept",
+			original: "a\r\nb\r\n",
+			diff:     "--- a/x.txt\n+++ b/x.txt\n@@ -1,2 +1,3 @@\n a\n+new\n b\n",
+			want:     "a\r\nnew\r\nb\r\n",
+		},
+		{
+			name:     "Create",
+			original: "",
+			diff:     "--- /dev/null\n+++ b/x.txt\n@@ -0,0 +1,1 @@\n+new\n",
+			want:     "new\n",
+		},
+		{
+			name:     "Delete",
+			original: "a\n",
+			diff:     "--- a/x.txt\n+++ /dev/null\n@@ -1,1 +0,0 @@\n-a\n",
+			want:     "",
+		},
+		{
+			name:     "Context mismatch",
+			original: "a\nb\n",
+			diff:     "--- a/x.txt\n+++ b/x.txt\n@@ -1,1 +1,1 @@\n-nope\n+yes\n",
+			wantErr:  true,
+		},
+		{
+			name:     "Malformed hunk header",
+			original: "a\n",
+			diff:     "--- a/x.txt\n+++ b/x.txt\n@@ -1,1 +one @@\n-a\n+b\n",
+			wantErr:  true,
+		},
+		{
+			name:     "Negative hunk start",
+			original: "a\n",
+			diff:     "--- a/x.txt\n+++ b/x.txt\n@@ --1,1 +1,1 @@\n-a\n+b\n",
+			wantErr:  true,
+		},
+		{
+			name:     "Several files",
+			original: "a\n",
+			diff:     "--- a/x.txt\n+++ b/x.txt\n@@ -1,1 +1,1 @@\n-a\n+b\n--- a/y.txt\n+++ b/y.txt\n@@ -1,1 +1,1 @@\n-a\n+b\n",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyUnifiedDiff(tt.original, tt.diff)
+			if tt.wantErr {
+				if !IsParseError(err) {
+					t.Errorf("ApplyUnifiedDiff() error = %v, want a ParseError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyUnifiedDiff() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ApplyUnifiedDiff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyChangesToFiles(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.txt")
+	created := filepath.Join(dir, "created.txt")
+	if err := os.WriteFile(existing, []byte("a\nb\nc\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", existing, err)
+	}
+
+	diff := "```diff\n" +
+		"--- a/" + existing + "\n+++ b/" + existing + "\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n" +
+		"--- /dev/null\n+++ b/" + created + "\n@@ -0,0 +1,1 @@\n+new\n" +
+		"```"
+	result, err := ApplyChangesToFiles(diff, Options{})
+	if err != nil {
+		t.Fatalf("ApplyChangesToFiles() error = %v", err)
+	}
+	want := ApplyResult{
+		Modified: []string{existing},
+		Created:  []string{created},
+		DiffStats: []DiffStat{
+			{Path: existing, Hunks: 1, Added: 1, Removed: 1},
+			{Path: created, Hunks: 1, Added: 1},
+		},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("ApplyChangesToFiles() result = %+v, want %+v", result, want)
+	}
+	for path, want := range map[string]string{existing: "a\nB\nc\n", created: "new\n"} {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %q: %v", path, err)
+		}
+		if string(got) != want {
+			t.Errorf("content of %q = %q, want %q", path, got, want)
+		}
+	}
+
+	// A failing hunk must leave every file untouched.
+	bad := "--- a/" + existing + "\n+++ b/" + existing + "\n@@ -1,1 +1,1 @@\n-a\n+A\n" +
+		"--- a/" + created + "\n+++ b/" + created + "\n@@ -1,1 +1,1 @@\n-missing\n+x\n"
+	if _, err := ApplyChangesToFiles(bad, Options{}); !IsParseError(err) {
+		t.Fatalf("ApplyChangesToFiles() error = %v, want a ParseError", err)
+	}
+	if got, _ := os.ReadFile(existing); string(got) != "a\nB\nc\n" {
+		t.Errorf("content of %q = %q after a failed apply, want it unchanged", existing, got)
+	}
+}
+
+func TestApplyChangesToFiles_MissingTarget(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.txt")
+	missing := filepath.Join(dir, "missing.txt")
+	if err := os.WriteFile(existing, []byte("a\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", existing, err)
+	}
+
+	diff := "--- a/" + existing + "\n+++ b/" + existing + "\n@@ -1,1 +1,1 @@\n-a\n+A\n" +
+		"--- a/" + missing + "\n+++ b/" + missing + "\n@@ -0,0 +1,1 @@\n+new\n"
+	_, err := ApplyChangesToFiles(diff, Options{})
+	if !IsParseError(err) || !strings.Contains(err.Error(), "target file not found") || !strings.Contains(err.Error(), missing) {
+		t.Fatalf("ApplyChangesToFiles() error = %v, want a ParseError naming %q as not found", err, missing)
+	}
+	if got, _ := os.ReadFile(existing); string(got) != "a\n" {
+		t.Errorf("content of %q = %q, want it unchanged", existing, got)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("%q was created by a diff that does not start from /dev/null", missing)
+	}
+}
+
+func TestApplyChangesToFiles_DuplicateTarget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f.txt")
+	if err := os.WriteFile(path, []byte("a\nb\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", path, err)
+	}
+	diff := "--- a/" + path + "\n+++ b/" + path + "\n@@ -1,1 +1,1 @@\n-a\n+A\n" +
+		"--- a/" + path + "\n+++ b/" + path + "\n@@ -2,1 +2,1 @@\n-b\n+B\n"
+	_, err := ApplyChangesToFiles(diff, Options{})
+	if !IsParseError(err) || !strings.Contains(err.Error(), "several sections") {
+		t.Fatalf("ApplyChangesToFiles() error = %v, want a ParseError about the repeated file", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "a\nb\n" {
+		t.Errorf("content of %q = %q, want it unchanged", path, got)
+	}
+}
+
+func TestApplyChangesToFiles_DiffPaths(t *testing.T) {
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.txt")
+	extraPath := filepath.Join(dir, "extra.txt")
+	if err := os.WriteFile(aPath, []byte("a\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", aPath, err)
+	}
+
+	// A relative path leaving the workspace is refused when no files were requested.
+	escape := "--- /dev/null\n+++ b/../x.txt\n@@ -0,0 +1,1 @@\n+x\n"
+	if _, err := ApplyChangesToFiles(escape, Options{Root: dir}); !IsParseError(err) || !strings.Contains(err.Error(), "leaves the workspace") {
+		t.Fatalf("ApplyChangesToFiles() error = %v, want a ParseError about leaving the workspace", err)
+	}
+
+	// A relative path resolves to the requested file, and a file that was not
+	// requested is reported rather than created.
+	diff := "--- a/a.txt\n+++ b/a.txt\n@@ -1,1 +1,1 @@\n-a\n+A\n" +
+		"--- /dev/null\n+++ b/" + extraPath + "\n@@ -0,0 +1,1 @@\n+extra\n"
+	result, err := ApplyChangesToFiles(diff, Options{Requested: []string{aPath}, Root: dir})
+	if err != nil {
+		t.Fatalf("ApplyChangesToFiles() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Modified, []string{aPath}) || !reflect.DeepEqual(result.Unrequested, []string{extraPath}) {
+		t.Errorf("result = %+v, want %q modified and %q unrequested", result, aPath, extraPath)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "A\n" {
+		t.Errorf("content of %q = %q, want %q", aPath, got, "A\n")
+	}
+	if _, err := os.Stat(extraPath); !os.IsNotExist(err) {
+		t.Errorf("unrequested file %q was created", extraPath)
+	}
+}
+
+func TestApplyChangesToFiles_RollbackOnWriteError(t *testing.T) {
+	dir := t.TempDir()
+	modified := filepath.Join(dir, "modified.txt")
+	deleted := filepath.Join(dir, "deleted.txt")
+	created := filepath.Join(dir, "created.txt")
+	blocked := filepath.Join(dir, "blocked") // A directory, so writing a file there fails
+	for path, content := range map[string]string{modified: "a\n", deleted: "gone\n"} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+	}
+	if err := os.Mkdir(blocked, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	diff := "--- a/" + modified + "\n+++ b/" + modified + "\n@@ -1,1 +1,1 @@\n-a\n+A\n" +
+		"--- a/" + deleted + "\n+++ /dev/null\n@@ -1,1 +0,0 @@\n-gone\n" +
+		"--- /dev/null\n+++ b/" + created + "\n@@ -0,0 +1,1 @@\n+new\n" +
+		"--- /dev/null\n+++ b/" + blocked + "\n@@ -0,0 +1,1 @@\n+blocked\n"
+	result, err := ApplyChangesToFiles(diff, Options{})
+	if err == nil {
+		t.Fatal("ApplyChangesToFiles() succeeded, want the write to the directory to fail")
+	}
+	if len(result.Modified)+len(result.Created)+len(result.Deleted) > 0 {
+		t.Errorf("ApplyChangesToFiles() result = %+v, want no files reported as written", result)
+	}
+	if got, _ := os.ReadFile(modified); string(got) != "a\n" {
+		t.Errorf("content of %q = %q, want it rolled back", modified, got)
+	}
+	if got, _ := os.ReadFile(deleted); string(got) != "gone\n" {
+		t.Errorf("content of %q = %q, want it restored", deleted, got)
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("%q still exists after the rollback", created)
+	}
+}
+
+func TestApplyChangesToFiles_DiffStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", path, err)
+	}
+	diff := "--- a/" + path + "\n+++ b/" + path + "\n" +
+		"@@ -1,3 +1,4 @@\n 1\n-2\n+two\n+2.5\n 3\n" +
+		"@@ -8,3 +9,2 @@\n 8\n-9\n-10\n+nine\n"
+	result, err := ApplyChangesToFiles(diff, Options{})
+	if err != nil {
+		t.Fatalf("ApplyChangesToFiles() error = %v", err)
+	}
+	if want := []DiffStat{{Path: path, Hunks: 2, Added: 3, Removed: 3}}; !reflect.DeepEqual(result.DiffStats, want) {
+		t.Errorf("DiffStats = %+v, want %+v", result.DiffStats, want)
+	}
+	if got, want := result.DiffSummary(), "1 file changed, +3 -3"; got != want {
+		t.Errorf("DiffSummary() = %q, want %q", got, want)
+	}
+}
+
+func TestApplyChangesToFiles_ContextMismatchMessage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", path, err)
+	}
+	diff := "--- a/" + path + "\n+++ b/" + path + "\n@@ -1,3 +1,3 @@\n one\n-TWO\n+2\n three\n"
+	_, err := ApplyChangesToFiles(diff, Options{})
+	if !IsParseError(err) {
+		t.Fatalf("ApplyChangesToFiles() error = %v, want a ParseError", err)
+	}
+	for _, want := range []string{path, "@@ -1,3 +1,3 @@", `line 2: expected "TWO", found "two"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestApplyChangesToFiles_Only(t *testing.T) {
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.txt")
+	bPath := filepath.Join(dir, "b.txt")
+	for _, path := range []string{aPath, bPath} {
+		if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", path, err)
+		}
+	}
+	diff := func(path string) string {
+		return "--- a/" + path + "\n+++ b/" + path + "\n@@ -1,1 +1,1 @@\n-old\n+new\n"
+	}
+
+	// A selected file missing from the diff is reported before anything is written.
+	missing := filepath.Join(dir, "missing.txt")
+	if _, err := ApplyChangesToFiles(diff(aPath)+diff(bPath), Options{Only: []string{aPath, missing}}); err == nil || !strings.Contains(err.Error(), missing) {
+		t.Errorf("ApplyChangesToFiles() error = %v, want it to name %q", err, missing)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "old\n" {
+		t.Errorf("content of %q = %q after a rejected apply, want it unchanged", aPath, got)
+	}
+
+	result, err := ApplyChangesToFiles(diff(aPath)+diff(bPath), Options{Only: []string{aPath}})
+	if err != nil {
+		t.Fatalf("ApplyChangesToFiles() error = %v", err)
+	}
+	want := ApplyResult{Modified: []string{aPath}, Skipped: []string{bPath}, DiffStats: []DiffStat{{Path: aPath, Hunks: 1, Added: 1, Removed: 1}}}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("ApplyChangesToFiles() result = %+v, want %+v", result, want)
+	}
+	for path, want := range map[string]string{aPath: "new\n", bPath: "old\n"} {
+		if got, _ := os.ReadFile(path); string(got) != want {
+			t.Errorf("content of %q = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestApplyChangesToFiles_LineEndings(t *testing.T) {
+	diff := func(path string) string {
+		return "--- a/" + path + "\n+++ b/" + path + "\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"
+	}
+	tests := []struct {
+		name       string
+		original   string
+		lineEnding string
+		want       string
+	}{
+		{name: "LF diff on CRLF file keeps CRLF", original: "a\r\nb\r\nc\r\n", lineEnding: LineEndingAuto, want: "a\r\nB\r\nc\r\n"},
+		{name: "LF diff on LF file keeps LF", original: "a\nb\nc\n", lineEnding: LineEndingAuto, want: "a\nB\nc\n"},
+		{name: "Force LF on CRLF file", original: "a\r\nb\r\nc\r\n", lineEnding: LineEndingLF, want: "a\nB\nc\n"},
+		{name: "Force CRLF on LF file", original: "a\nb\nc\n", lineEnding: LineEndingCRLF, want: "a\r\nB\r\nc\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file.txt")
+			if err := os.WriteFile(path, []byte(tt.original), 0644); err != nil {
+				t.Fatalf("Failed to write %q: %v", path, err)
+			}
+			if _, err := ApplyChangesToFiles(diff(path), Options{LineEnding: tt.lineEnding}); err != nil {
+				t.Fatalf("ApplyChangesToFiles() error = %v", err)
+			}
+			got, _ := os.ReadFile(path)
+			if string(got) != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyHunks_Fuzzy(t *testing.T) {
+	original := "func f() {\n\ta := 1\n\n\tb := 2\n\treturn a + b\n}\n"
+	tests := []struct {
+		name      string
+		diff      string
+		want      string
+		wantFuzzy int
+	}{
+		{
+			name:      "Blank line missing from the hunk",
+			diff:      "--- a/f\n+++ b/f\n@@ -2,3 +2,3 @@\n \ta := 1\n \tb := 2\n-\treturn a + b\n+\treturn a * b\n",
+			want:      "func f() {\n\ta := 1\n\n\tb := 2\n\treturn a * b\n}\n",
+			wantFuzzy: 1,
+		},
+		{
+			name:      "Extra blank line in the hunk",
+			diff:      "--- a/f\n+++ b/f\n@@ -4,4 +4,4 @@\n \tb := 2\n \n \treturn a + b\n-}\n+} // f\n",
+			want:      "func f() {\n\ta := 1\n\n\tb := 2\n\treturn a + b\n} // f\n",
+			wantFuzzy: 1,
+		},
+		{
+			name:      "Trailing whitespace in the context",
+			diff:      "--- a/f\n+++ b/f\n@@ -1,2 +1,2 @@\n func f() {  \n-\ta := 1\n+\ta := 3\n",
+			want:      "func f() {\n\ta := 3\n\n\tb := 2\n\treturn a + b\n}\n",
+			wantFuzzy: 1,
+		},
+		{
+			name: "Exact match is not fuzzy",
+			diff: "--- a/f\n+++ b/f\n@@ -2,1 +2,1 @@\n-\ta := 1\n+\ta := 3\n",
+			want: "func f() {\n\ta := 3\n\n\tb := 2\n\treturn a + b\n}\n",
+		},
+	}
+	for engine, applier := range diffAppliers {
+		for _, tt := range tests {
+			t.Run(engine+"/"+tt.name, func(t *testing.T) {
+				fileDiffs, err := parseUnifiedDiffString(tt.diff)
+				if err != nil {
+					t.Fatalf("parseUnifiedDiffString() error = %v", err)
+				}
+				if _, _, err := applier.applyHunks(original, fileDiffs[0].hunks, false); err == nil && tt.wantFuzzy > 0 {
+					t.Errorf("applyHunks() without fuzzy matching succeeded, want an error")
+				}
+				got, fuzzy, err := applier.applyHunks(original, fileDiffs[0].hunks, true)
+				if err != nil {
+					t.Fatalf("applyHunks() error = %v", err)
+				}
+				if got != tt.want || fuzzy != tt.wantFuzzy {
+					t.Errorf("applyHunks() = %q, %d, want %q, %d", got, fuzzy, tt.want, tt.wantFuzzy)
+				}
+			})
+		}
+	}
+}
+
+func TestApplyHunks_FuzzyWindow(t *testing.T) {
+	// A hunk that only matches loosely far from its stated position is still rejected.
+	original := strings.Repeat("x\n", 2*fuzzyWindow) + "a\n\nb\n"
+	diff := "--- a/f\n+++ b/f\n@@ -1,2 +1,2 @@\n a\n-b\n+B\n"
+	fileDiffs, err := parseUnifiedDiffString(diff)
+	if err != nil {
+		t.Fatalf("parseUnifiedDiffString() error = %v", err)
+	}
+	if _, _, err := applyHunks(original, fileDiffs[0].hunks, true); err == nil {
+		t.Errorf("applyHunks() succeeded, want an error")
+	}
+}
+
+func TestApplyChangesToFiles_Fuzzy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("one\n\ntwo\nthree\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", path, err)
+	}
+	diff := "--- a/" + path + "\n+++ b/" + path + "\n@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n"
+	if _, err := ApplyChangesToFiles(diff, Options{}); !IsParseError(err) {
+		t.Fatalf("ApplyChangesToFiles() error = %v, want a ParseError", err)
+	}
+	result, err := ApplyChangesToFiles(diff, Options{Fuzzy: true})
+	if err != nil {
+		t.Fatalf("ApplyChangesToFiles() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Fuzzy, []string{path}) {
+		t.Errorf("Fuzzy = %q, want %q", result.Fuzzy, []string{path})
+	}
+	if got, _ := os.ReadFile(path); string(got) != "one\n\n2\nthree\n" {
+		t.Errorf("content = %q, want %q", got, "one\n\n2\nthree\n")
+	}
+}
+
+func TestApplyChangesToFiles_Gofmt(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(path, []byte("package main\n\nfunc main() {\n}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", path, err)
+	}
+	// Valid Go, but indented with spaces and without gofmt's spacing.
+	diff := "--- a/" + path + "\n+++ b/" + path + "\n@@ -3,2 +3,4 @@\n func main() {\n+  x:=1\n+     _ = x\n }\n"
+	result, err := ApplyChangesToFiles(diff, Options{Gofmt: true})
+	if err != nil {
+		t.Fatalf("ApplyChangesToFiles() error = %v", err)
+	}
+	want := "package main\n\nfunc main() {\n\tx := 1\n\t_ = x\n}\n"
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Errorf("content = %q, want %q", got, want)
+	}
+	if len(result.GofmtErrors) != 0 {
+		t.Errorf("GofmtErrors = %v, want none", result.GofmtErrors)
+	}
+
+	// Invalid Go is written as patched and reported.
+	diff = "--- a/" + path + "\n+++ b/" + path + "\n@@ -5,1 +5,0 @@\n-}\n"
+	result, err = ApplyChangesToFiles(diff, Options{Gofmt: true})
+	if err != nil {
+		t.Fatalf("ApplyChangesToFiles() error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != strings.TrimSuffix(want, "}\n") {
+		t.Errorf("content = %q, want the unformatted patch result", got)
+	}
+	if len(result.GofmtErrors) != 1 || result.GofmtErrors[0].Path != path {
+		t.Errorf("GofmtErrors = %v, want one error for %q", result.GofmtErrors, path)
+	}
+}
+
+func TestParseDiff(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.txt")
+	created := filepath.Join(dir, "created.txt")
+	if err := os.WriteFile(existing, []byte("a\r\nb\r\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", existing, err)
+	}
+	diff := "--- a/" + existing + "\n+++ b/" + existing + "\n@@ -1,2 +1,2 @@\n a\n-b\n+B\n" +
+		"--- /dev/null\n+++ b/" + created + "\n@@ -0,0 +1,1 @@\n+new\n"
+
+	changes, err := ParseDiff(diff, Options{})
+	if err != nil {
+		t.Fatalf("ParseDiff() error = %v", err)
+	}
+	want := []FileChange{
+		{OldPath: existing, NewPath: existing, Original: "a\r\nb\r\n", Content: "a\r\nB\r\n", Stat: DiffStat{Path: existing, Hunks: 1, Added: 1, Removed: 1}},
+		{OldPath: devNull, NewPath: created, Content: "new\n", Stat: DiffStat{Path: created, Hunks: 1, Added: 1}},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("ParseDiff() = %+v, want %+v", changes, want)
+	}
+	// Nothing is written until the changes are applied.
+	if got, _ := os.ReadFile(existing); string(got) != "a\r\nb\r\n" {
+		t.Errorf("content of %q = %q, want it untouched", existing, got)
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("%q exists after ParseDiff, want it not created", created)
+	}
+
+	result, err := ApplyFileChanges(changes, Options{})
+	if err != nil {
+		t.Fatalf("ApplyFileChanges() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Modified, []string{existing}) || !reflect.DeepEqual(result.Created, []string{created}) {
+		t.Errorf("result = %+v, want %q modified and %q created", result, existing, created)
+	}
+	for path, want := range map[string]string{existing: "a\r\nB\r\n", created: "new\n"} {
+		if got, _ := os.ReadFile(path); string(got) != want {
+			t.Errorf("content of %q = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestApplyFileChanges_ReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", path, err)
+	}
+	changes := []FileChange{{OldPath: path, NewPath: path, Original: "old\n", Content: "new\n"}}
+	result, err := ApplyFileChanges(changes, Options{ReadOnly: []string{path}})
+	if err != nil {
+		t.Fatalf("ApplyFileChanges() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.ReadOnly, []string{path}) || len(result.Modified) != 0 {
+		t.Errorf("result = %+v, want %q rejected as read-only", result, path)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "old\n" {
+		t.Errorf("content = %q, want it untouched", got)
+	}
+}
+
+func TestApplyChangesToFiles_ManyFiles(t *testing.T) {
+	dir := t.TempDir()
+	diff, paths := manyFileDiff(t, dir, 40)
+	result, err := ApplyChangesToFiles(diff, Options{})
+	if err != nil {
+		t.Fatalf("ApplyChangesToFiles() error = %v", err)
+	}
+	// Files are written concurrently but reported in the order of the diff.
+	if !reflect.DeepEqual(result.Modified, paths) {
+		t.Errorf("modified files = %q, want %q", result.Modified, paths)
+	}
+	for _, path := range paths {
+		if got, _ := os.ReadFile(path); string(got) != "one\nTWO\nthree\n" {
+			t.Errorf("content of %q = %q, want it patched", path, got)
+		}
+	}
+
+	// With several failing files, the first in the diff is reported, every time.
+	diff, paths = manyFileDiff(t, t.TempDir(), 40)
+	for _, i := range []int{31, 7, 19} {
+		if err := os.WriteFile(paths[i], []byte("changed\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %q: %v", paths[i], err)
+		}
+	}
+	for run := 0; run < 5; run++ {
+		_, err := ApplyChangesToFiles(diff, Options{})
+		if !IsParseError(err) || !strings.Contains(err.Error(), paths[7]) {
+			t.Fatalf("ApplyChangesToFiles() error = %v, want a ParseError for %q", err, paths[7])
+		}
+	}
+	if got, _ := os.ReadFile(paths[0]); string(got) != "one\ntwo\nthree\n" {
+		t.Errorf("content of %q = %q after a failed apply, want it unchanged", paths[0], got)
+	}
+}
+
+// manyFileDiff writes n files to dir and returns a diff changing each, and their paths.
+func manyFileDiff(tb testing.TB, dir string, n int) (string, []string) {
+	tb.Helper()
+	var diff strings.Builder
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("file%03d.txt", i))
+		if err := os.WriteFile(paths[i], []byte("one\ntwo\nthree\n"), 0644); err != nil {
+			tb.Fatalf("Failed to write %q: %v", paths[i], err)
+		}
+		diff.WriteString("--- a/" + paths[i] + "\n+++ b/" + paths[i] + "\n@@ -1,3 +1,3 @@\n one\n-two\n+TWO\n three\n")
+	}
+	return diff.String(), paths
+}
+
+func BenchmarkApplyChangesToFiles_100Files(b *testing.B) {
+	dir := b.TempDir()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		diff, _ := manyFileDiff(b, dir, 100)
+		b.StartTimer()
+		if _, err := ApplyChangesToFiles(diff, Options{}); err != nil {
+			b.Fatalf("ApplyChangesToFiles() error = %v", err)
+		}
+	}
+}
+
+func TestApplyChangesToFiles_DumpTag(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "f.txt")
+	if err := os.WriteFile(path, []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	diff := "--- a/" + path + "\n+++ b/" + path + "\n@@ -1,1 +1,1 @@\n-a\n+b\n"
+
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	if _, err := ApplyChangesToFiles(diff, Options{DumpTag: "_file2", DryRun: true, DiffOutput: io.Discard}); err != nil {
+		t.Fatalf("ApplyChangesToFiles() error = %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(tmp, "unifiedDiff_file2.txt")); err != nil || string(got) != strings.TrimSpace(diff) {
+		t.Errorf("dump = %q, %v, want the diff", got, err)
+	}
+
+	// A dump that cannot be written does not stop the apply.
+	t.Setenv("TMPDIR", filepath.Join(tmp, "missing"))
+	if _, err := ApplyChangesToFiles(diff, Options{}); err != nil {
+		t.Fatalf("ApplyChangesToFiles() with an unwritable dump error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "b\n" {
+		t.Errorf("content = %q, want %q", got, "b\n")
+	}
+}
\ No newline at end of file
@@ -0,0 +1,43 @@
+package modifyFiles
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// applyWorkers is the number of files ParseDiff patches, and ApplyFileChanges writes, at
+// once. Large multi-file diffs spend most of their time reading and writing files.
+var applyWorkers = 8
+
+// forEachFile calls fn(i) for every i in [0, n) on up to applyWorkers goroutines. Once a
+// call fails no further calls are started, though those in flight finish. It returns the
+// error of the lowest i that failed, so the error reported does not depend on scheduling
+// and is the one a sequential loop would have stopped at.
+func forEachFile(n int, fn func(i int) error) error {
+	errs := make([]error, n)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(applyWorkers, 1))
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		if failed.Load() {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if errs[i] = fn(i); errs[i] != nil {
+				failed.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
\ No newline at end of file
@@ -0,0 +1,177 @@
+package prompt
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// compressibleExtensions lists the extensions CompressSource handles: languages with
+// C-style comments whose string literals the stripper understands. The value tells
+// whether the file is JavaScript, whose regular expression literals need extra care.
+var compressibleExtensions = map[string]bool{
+	".go":  false,
+	".js":  true,
+	".jsx": true,
+	".mjs": true,
+	".cjs": true,
+}
+
+// keptCommentPrefixes are line comments that carry meaning for the toolchain, e.g. build
+// constraints and compiler directives, and are therefore never stripped.
+var keptCommentPrefixes = []string{"//go:", "// +build", "//line ", "//export "}
+
+// regexKeywords are the JavaScript keywords after which a "/" starts a regular expression
+// literal rather than a division.
+var regexKeywords = map[string]bool{
+	"return": true, "typeof": true, "instanceof": true, "in": true, "of": true, "new": true,
+	"delete": true, "void": true, "throw": true, "case": true, "do": true, "else": true,
+	"yield": true, "await": true,
+}
+
+// compressedNotePrefix tells the model that a file in the prompt was abridged.
+const compressedNotePrefix = "Comments and blank lines were stripped from %s to save space; its code is unchanged.\n"
+
+// CompressSource strips comments and collapses runs of blank lines in content, the text
+// of the file at path, so that the file takes less of the context window. Only files
+// with an extension in compressibleExtensions are compressed, and Go files using cgo
+// are left alone because their preamble comment is code. If the content cannot be
+// scanned, e.g. because of an unterminated string, it is returned unchanged. The second
+// result reports whether anything was removed.
+func CompressSource(path, content string) (string, bool) {
+	js, ok := compressibleExtensions[strings.ToLower(filepath.Ext(path))]
+	if !ok || (!js && strings.Contains(content, `import "C"`)) {
+		return content, false
+	}
+	stripped, ok := stripComments(content, js)
+	if !ok {
+		return content, false
+	}
+	compressed := collapseBlankLines(stripped)
+	return compressed, compressed != content
+}
+
+// stripComments removes the "//" and "/* */" comments from src, leaving string, rune and
+// (for JavaScript) regular expression literals intact. It returns false if src ends
+// inside a literal or a comment.
+func stripComments(src string, js bool) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case strings.HasPrefix(src[i:], "//"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src) - i
+			}
+			if comment := src[i : i+end]; keepComment(comment) {
+				b.WriteString(comment)
+			}
+			i += end
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return src, false
+			}
+			comment := src[i : i+2+end+2]
+			// A comment spanning lines still separates the code around it like a newline.
+			if strings.Contains(comment, "\n") {
+				b.WriteString("\n")
+			} else {
+				b.WriteString(" ")
+			}
+			i += len(comment)
+		case c == '"' || c == '\'' || c == '`' || (js && c == '/' && regexAllowed(b.String())):
+			end, ok := literalEnd(src, i, js)
+			if !ok {
+				return src, false
+			}
+			b.WriteString(src[i:end])
+			i = end
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), true
+}
+
+// keepComment reports whether the line comment must survive compression.
+func keepComment(comment string) bool {
+	for _, prefix := range keptCommentPrefixes {
+		if strings.HasPrefix(comment, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// regexAllowed reports whether a "/" following the JavaScript code out starts a regular
+// expression literal: at the start, after an operator or punctuation, or after a
+// keyword such as return.
+func regexAllowed(out string) bool {
+	out = strings.TrimRight(out, " \t\r\n")
+	if out == "" {
+		return true
+	}
+	if strings.IndexByte("(,=:[!&|?{};+-*%<>~^", out[len(out)-1]) >= 0 {
+		return true
+	}
+	word := out[strings.LastIndexFunc(out, func(r rune) bool { return !isIdentRune(r) })+1:]
+	return regexKeywords[word]
+}
+
+// isIdentRune reports whether r can be part of a JavaScript identifier.
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '$' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+// literalEnd returns the index just past the literal that opens at src[start]. Go raw
+// strings span lines without escapes; JavaScript template literals span lines with
+// escapes; all other literals end at the line's end. Regular expression literals may
+// contain "/" inside a character class.
+func literalEnd(src string, start int, js bool) (int, bool) {
+	quote := src[start]
+	multiline := quote == '`'
+	escapes := quote != '`' || js
+	inClass := false
+	for i := start + 1; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == '\\' && escapes:
+			i++
+		case c == '\n' && !multiline:
+			return 0, false
+		case quote == '/' && c == '[':
+			inClass = true
+		case quote == '/' && c == ']':
+			inClass = false
+		case c == quote && !inClass:
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// collapseBlankLines trims trailing whitespace from each line of s, drops leading blank
+// lines and replaces each run of blank lines with a single one.
+func collapseBlankLines(s string) string {
+	lines := strings.Split(s, "\n")
+	kept := make([]string, 0, len(lines))
+	blank := true // Drop blank lines at the start
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			if blank {
+				continue
+			}
+			blank = true
+		} else {
+			blank = false
+		}
+		kept = append(kept, line)
+	}
+	out := strings.TrimRight(strings.Join(kept, "\n"), "\n")
+	if strings.HasSuffix(s, "\n") && out != "" {
+		out += "\n"
+	}
+	return out
+}
\ No newline at end of file
@@ -0,0 +1,79 @@
+package prompt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompressSource(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		in   string
+		want string
+	}{
+		{
+			name: "Go comments and blank lines",
+			path: "/src/a.go",
+			in:   "//go:build linux\n\n// Package a does things.\npackage a\n\n\n/* A block\ncomment. */\nconst s = \"// not a comment\" // trailing\n\nvar r = `/* raw */`\nvar c = '/'\n",
+			want: "//go:build linux\n\npackage a\n\nconst s = \"// not a comment\"\n\nvar r = `/* raw */`\nvar c = '/'\n",
+		},
+		{
+			name: "JavaScript regular expressions and templates",
+			path: "/src/a.js",
+			in:   "// Header.\nconst re = /\\/\\/[/*]/g; // slashes\nconst half = total / 2 / count; /* inline */\nconst t = `multi\n// line`;\nfunction f() { return /a*/.test(x); }\n",
+			want: "const re = /\\/\\/[/*]/g;\nconst half = total / 2 / count;\nconst t = `multi\n// line`;\nfunction f() { return /a*/.test(x); }\n",
+		},
+		{
+			name: "Unsupported extension",
+			path: "/src/a.py",
+			in:   "# comment\nx = 1\n",
+			want: "# comment\nx = 1\n",
+		},
+		{
+			name: "cgo preamble",
+			path: "/src/c.go",
+			in:   "package c\n\n// #include <stdio.h>\nimport \"C\"\n",
+			want: "package c\n\n// #include <stdio.h>\nimport \"C\"\n",
+		},
+		{
+			name: "Unterminated string",
+			path: "/src/a.go",
+			in:   "// doc\nconst s = \"open\n",
+			want: "// doc\nconst s = \"open\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := CompressSource(tt.path, tt.in)
+			if got != tt.want {
+				t.Errorf("CompressSource(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+			if changed != (tt.want != tt.in) {
+				t.Errorf("CompressSource(%q) changed = %t, want %t", tt.path, changed, tt.want != tt.in)
+			}
+		})
+	}
+}
+
+func TestGeneratePrompt_Compress(t *testing.T) {
+	files := map[string]string{"/src/foo.go": "// Package foo.\npackage foo\n"}
+	contextFiles := map[string]string{"/src/api.go": "package foo\n\n// API is the interface.\ntype API interface{}\n"}
+
+	got := GeneratePrompt("Explain.", files, Options{Compress: true, ContextFiles: contextFiles})
+	if strings.Contains(got, "// Package foo.") || strings.Contains(got, "// API is the interface.") {
+		t.Errorf("GeneratePrompt() kept comments of compressed files:\n%s", got)
+	}
+	if !strings.Contains(got, "stripped from /src/foo.go") || !strings.Contains(got, "stripped from /src/api.go") {
+		t.Errorf("GeneratePrompt() does not note the compressed files:\n%s", got)
+	}
+
+	// In place, the files to edit are sent verbatim; only the context files are compressed.
+	got = GeneratePrompt("Fix.", files, Options{Inplace: true, Compress: true, ContextFiles: contextFiles})
+	if !strings.Contains(got, "// Package foo.") || strings.Contains(got, "stripped from /src/foo.go") {
+		t.Errorf("GeneratePrompt() compressed a file to be edited in place:\n%s", got)
+	}
+	if strings.Contains(got, "// API is the interface.") {
+		t.Errorf("GeneratePrompt() did not compress the context file:\n%s", got)
+	}
+}
\ No newline at end of file
@@ -2,9 +2,10 @@ package prompt
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
-	"github.com/golang/glog" // Import glog
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
 	"github.com/zicongmei/ai-coder/v2/pkg/utils"
 )
 
@@ -15,62 +16,272 @@ Do not include any introductory text, explanations, or other formatting outside
 Always return full text. Never return diff.
 Ensure the ABSOLUTE file paths in the BEGIN/END markers match the requested files: 
 `
+	additionalInstructionsSearchReplace string = `
+
+Do not include any introductory text, explanations, or other formatting outside of these blocks.
+Never return the full text of a file unless it is short; return one block per change instead.
+The SEARCH part must be copied exactly from the current file, including whitespace and indentation, and must consist of whole lines.
+Include just enough lines in the SEARCH part for it to occur exactly once in the file. Blocks for the same file are applied in order.
+To delete lines, leave the REPLACE part empty. Only existing files can be changed this way.
+Ensure the ABSOLUTE file paths in the SEARCH lines match the requested files: 
+`
+	additionalInstructionsDiff string = `
+
+Do not include any introductory text, explanations, or other formatting outside of the diff.
+Always return a unified diff. Never return the full text of a file.
+Start each file with "--- a/" and "+++ b/" headers followed by the ABSOLUTE file path, exactly as shown above.
+Begin each hunk with an "@@ -<old start>,<old count> +<new start>,<new count> @@" header.
+Prefix unchanged context lines with a single space, removed lines with "-" and added lines with "+".
+Include at least 3 lines of unchanged context around each change, copied exactly from the original file.
+For a new file use "--- /dev/null"; for a deleted file use "+++ /dev/null".
+Ensure the ABSOLUTE file paths in the diff headers match the requested files: 
+`
+)
+
+// Output formats for in-place modification.
+const (
+	FormatFullText = "fulltext" // The AI returns the full text of each modified file
+	FormatDiff     = "diff"     // The AI returns a unified diff of its changes
+
+	FormatSearchReplace = "search-replace" // The AI returns search/replace blocks anchored on unique snippets
 )
 
+// Options controls how GeneratePrompt builds the prompt.
+type Options struct {
+	Inplace bool   // Whether to add instructions for a machine-applicable response
+	Format  string // FormatFullText (default), FormatDiff or FormatSearchReplace; only used when Inplace is set
+
+	// FileNotes holds optional per-file guidance, keyed by file path. Each note is
+	// emitted immediately before that file's BEGIN block. May be nil.
+	FileNotes map[string]string
+
+	// Prefix and Suffix, if non-empty, are placed on their own lines immediately
+	// before and after the user input, e.g. a team's standard preamble.
+	Prefix string
+	Suffix string
+
+	// ContextFiles holds read-only reference files, keyed by path. They are included
+	// after the user input with an instruction not to modify them, and are left out of
+	// the list of files the response may change. May be nil.
+	ContextFiles map[string]string
+
+	// Markers frame each file; the zero value means utils.DefaultMarkers. A full-text
+	// response must be parsed with the same markers.
+	Markers utils.Markers
+
+	// Compress strips comments and blank lines (see CompressSource) from the context
+	// files, and from the other files unless Inplace is set: a full-text response
+	// replaces the files, so editable files are always sent verbatim.
+	Compress bool
+
+	// LengthHints states each file's length in bytes in its begin marker (see
+	// utils.LengthHintFormat) and asks the model to do the same in a full-text response,
+	// so that truncated blocks can be detected.
+	LengthHints bool
+
+	// Base64 asks the model to return the content of each file base64-encoded in a
+	// full-text response (see modifyFiles.Options.Base64), so content that contains marker
+	// text or significant whitespace survives intact. The files are still sent as text.
+	Base64 bool
+}
+
+// contextFilesIntro introduces the read-only context files in the prompt.
+const contextFilesIntro = "\nThe following files are provided for reference only. Do NOT modify them and do NOT include them in your response:\n"
+
+// editableFilesIntro separates the editable files from the context files that precede them.
+const editableFilesIntro = "\nThe following files may be modified:\n"
+
+// fileNotePrefix introduces a per-file note in the prompt.
+const fileNotePrefix = "Note for %s: "
+
+// languagePrefix introduces the detected language of a file in the prompt.
+const languagePrefix = "Language of %s: "
+
+// lengthHintPlaceholder stands for the length hint in the response format shown to the model.
+const lengthHintPlaceholder = " ({length of the content in bytes} bytes)"
+
+// lengthHintInstruction asks the model to state the length of each file it returns.
+const lengthHintInstruction = "\nIn each BEGIN marker, state the exact length of that file's content in bytes, as in the BEGIN markers of the files above.\n"
+
+// base64Instruction asks the model to base64-encode the content of each file it returns.
+const base64Instruction = "\nBetween each BEGIN and END marker, return the complete content of the file encoded in standard base64 (RFC 4648, with padding), not as plain text. The base64 text may be wrapped across lines.\n"
+
+// formattingInstruction asks the model to follow each language's formatting conventions.
+const formattingInstruction = "\nKeep each file idiomatically formatted for its language (e.g. gofmt for Go, prettier for JavaScript/TypeScript, PEP 8 for Python).\n"
+
 // GeneratePrompt constructs a complete AI prompt based on user input,
 // file contents, and specific instructions for the AI.
 //
 // The prompt will contain:
-// 1. The user input from the argument.
-// 2. The full text of the files in the fileContents map, with start/end markers.
+// 1. The user input from the argument, wrapped in opts.Prefix and opts.Suffix.
+// 2. The full text of the read-only opts.ContextFiles and of the files in the
+// fileContents map, with start/end markers.
 // 3. A specific instruction for the AI regarding the output format.
-func GeneratePrompt(userInput string, fileContents map[string]string, inplace bool) string {
-	glog.V(1).Info("Starting prompt generation process.")
-	glog.V(2).Infof("Received user input for prompt (truncated): %q", utils.TruncateString(userInput, 100))
-	glog.V(2).Infof("Number of files provided for prompt generation: %d", len(fileContents))
+// Each file whose language is known from its extension is preceded by a language line,
+// and each file compressed per opts.Compress by a note saying so.
+func GeneratePrompt(userInput string, fileContents map[string]string, opts Options) string {
+	logging.V(1).Info("Starting prompt generation process.")
+	logging.V(2).Infof("Received user input for prompt (truncated): %q", utils.TruncateString(userInput, 100))
+	logging.V(2).Infof("Number of files provided for prompt generation: %d", len(fileContents))
 
+	markers := opts.Markers.OrDefault()
 	var builder strings.Builder
 
 	// 1. Add the user input
-	glog.V(3).Info("Appending user input to the prompt.")
+	if opts.Prefix != "" {
+		logging.V(3).Info("Appending prompt prefix to the prompt.")
+		builder.WriteString(opts.Prefix)
+		builder.WriteString("\n")
+	}
+	logging.V(3).Info("Appending user input to the prompt.")
 	builder.WriteString(userInput)
 	builder.WriteString("\n") // Add a newline after user input for separation
+	if opts.Suffix != "" {
+		logging.V(3).Info("Appending prompt suffix to the prompt.")
+		builder.WriteString(opts.Suffix)
+		builder.WriteString("\n")
+	}
 
-	// 2. Add the full text of the files
+	// 2. Add the full text of the files, read-only context files first
+	if len(opts.ContextFiles) > 0 {
+		builder.WriteString(contextFilesIntro)
+		for _, filePath := range sortedKeys(opts.ContextFiles) {
+			content := opts.ContextFiles[filePath]
+			logging.V(2).Infof("Adding read-only context file %q (length: %d characters) to the prompt.", filePath, len(content))
+			writeLanguage(&builder, filePath)
+			content = compressForPrompt(&builder, filePath, content, opts.Compress)
+			builder.WriteString(beginMarker(markers, filePath, content, opts.LengthHints))
+			builder.WriteString(content)
+			builder.WriteString(markers.End(filePath))
+		}
+		builder.WriteString(editableFilesIntro)
+	}
 	// Iterating through the map. The order of files in the prompt will depend on map iteration order.
 	for filePath, content := range fileContents {
-		glog.V(2).Infof("Adding file %q (length: %d characters) to the prompt.", filePath, len(content))
-		builder.WriteString(utils.BeginMarkerPrefix + filePath + utils.BeginMarkerSuffix)
+		logging.V(2).Infof("Adding file %q (length: %d characters) to the prompt.", filePath, len(content))
+		writeLanguage(&builder, filePath)
+		content = compressForPrompt(&builder, filePath, content, opts.Compress && !opts.Inplace)
+		if note := strings.TrimSpace(opts.FileNotes[filePath]); note != "" {
+			logging.V(3).Infof("Adding note for file %q.", filePath)
+			builder.WriteString(fmt.Sprintf(fileNotePrefix, filePath) + note + "\n")
+		}
+		builder.WriteString(beginMarker(markers, filePath, content, opts.LengthHints))
 		builder.WriteString(content)
 		// // Ensure the last line of content has a newline if it doesn't already, to prevent
 		// // the file end marker from being on the same line.
 		// if !strings.HasSuffix(content, "\n") {
 		// 	builder.WriteString("\n")
 		// }
-		builder.WriteString(utils.EndMarkerPrefix + filePath + utils.EndMarkerSuffix)
+		builder.WriteString(markers.End(filePath))
 	}
 
 	// 3. Add the instruction based on the requested output format
-	if inplace {
-		glog.V(3).Info("Appending additional instructions for AI output format.")
+	if opts.Inplace && opts.Format == FormatDiff {
+		logging.V(3).Info("Appending additional instructions for unified diff output format.")
+		builder.WriteString("\nIMPORTANT: Respond ONLY with a unified diff (as produced by `git diff`) of your changes, formatted exactly as follows, using the ABSOLUTE file paths provided:\n")
+		allPaths := []string{}
+		for filePath := range fileContents {
+			builder.WriteString(fmt.Sprintf("--- a/%s\n", filePath))
+			builder.WriteString(fmt.Sprintf("+++ b/%s\n", filePath))
+			builder.WriteString("@@ -{old start},{old count} +{new start},{new count} @@\n")
+			builder.WriteString(" {unchanged context line}\n")
+			builder.WriteString("-{removed line}\n")
+			builder.WriteString("+{added line}\n")
+			allPaths = append(allPaths, filePath)
+		}
+		builder.WriteString(additionalInstructionsDiff)
+		builder.WriteString(strings.Join(allPaths, ", "))
+		builder.WriteString(formattingInstruction)
+	} else if opts.Inplace && opts.Format == FormatSearchReplace {
+		logging.V(3).Info("Appending additional instructions for search/replace output format.")
+		builder.WriteString("\nIMPORTANT: Respond ONLY with search/replace blocks describing your changes, formatted exactly as follows, using the ABSOLUTE file paths provided:\n")
+		allPaths := []string{}
+		for filePath := range fileContents {
+			builder.WriteString(utils.SearchMarkerPrefix + filePath + "\n")
+			builder.WriteString("{exact lines to find}\n")
+			builder.WriteString(utils.SnippetDivider + "\n")
+			builder.WriteString("{lines to put in their place}\n")
+			builder.WriteString(utils.ReplaceMarker + "\n")
+			allPaths = append(allPaths, filePath)
+		}
+		builder.WriteString(additionalInstructionsSearchReplace)
+		builder.WriteString(strings.Join(allPaths, ", "))
+		builder.WriteString(formattingInstruction)
+	} else if opts.Inplace {
+		logging.V(3).Info("Appending additional instructions for AI output format.")
 		builder.WriteString("\nIMPORTANT: Respond ONLY with the complete, modified content for each file, formatted exactly as follows, using the ABSOLUTE file paths provided:\n")
 		allPaths := []string{}
 		for filePath, _ := range fileContents {
-			builder.WriteString(utils.BeginMarkerPrefix + filePath + utils.BeginMarkerSuffix)
-			builder.WriteString(fmt.Sprintf("{content for %s}", filePath))
-			builder.WriteString(utils.EndMarkerPrefix + filePath + utils.EndMarkerSuffix)
+			if opts.LengthHints {
+				builder.WriteString(markers.BeginPrefix + filePath + lengthHintPlaceholder + markers.BeginSuffix)
+			} else {
+				builder.WriteString(markers.Begin(filePath))
+			}
+			if opts.Base64 {
+				builder.WriteString(fmt.Sprintf("{base64 of the content for %s}", filePath))
+			} else {
+				builder.WriteString(fmt.Sprintf("{content for %s}", filePath))
+			}
+			builder.WriteString(markers.End(filePath))
 			allPaths = append(allPaths, filePath)
 		}
 		builder.WriteString("\n") // Add a newline before the instruction for clarity
 		builder.WriteString(additionalInstructionsFullText)
 		builder.WriteString(strings.Join(allPaths, ", "))
+		if opts.LengthHints {
+			builder.WriteString(lengthHintInstruction)
+		}
+		if opts.Base64 {
+			builder.WriteString(base64Instruction)
+		}
+		builder.WriteString(formattingInstruction)
 
 	}
 
 	finalPrompt := builder.String()
-	glog.V(1).Infof("Prompt generation complete. Final prompt length: %d bytes.", len(finalPrompt))
+	logging.V(1).Infof("Prompt generation complete. Final prompt length: %d bytes.", len(finalPrompt))
 	// Log the full generated prompt only at a very high verbosity level, as it can be very large.
-	glog.V(4).Infof("Full generated prompt content: %q", finalPrompt)
+	logging.V(4).Infof("Full generated prompt content: %q", finalPrompt)
 
 	return finalPrompt
+}
+
+// writeLanguage emits the detected language of filePath, if known, on its own line.
+func writeLanguage(builder *strings.Builder, filePath string) {
+	if lang := LanguageForPath(filePath); lang != "" {
+		builder.WriteString(fmt.Sprintf(languagePrefix, filePath) + lang + "\n")
+	}
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// compressForPrompt returns content compressed by CompressSource if compress is set,
+// and emits a note for the model when that removed anything.
+func compressForPrompt(builder *strings.Builder, filePath, content string, compress bool) string {
+	if !compress {
+		return content
+	}
+	compressed, changed := CompressSource(filePath, content)
+	if changed {
+		logging.V(2).Infof("Compressed file %q for the prompt: %d -> %d bytes.", filePath, len(content), len(compressed))
+		builder.WriteString(fmt.Sprintf(compressedNotePrefix, filePath))
+	}
+	return compressed
+}
+
+// beginMarker returns the marker that opens the block for content, with a length hint
+// if lengthHint is set.
+func beginMarker(markers utils.Markers, filePath, content string, lengthHint bool) string {
+	if lengthHint {
+		return markers.BeginWithLength(filePath, len(content))
+	}
+	return markers.Begin(filePath)
 }
\ No newline at end of file
@@ -0,0 +1,124 @@
+package prompt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+func TestGeneratePrompt_FileNotes(t *testing.T) {
+	files := map[string]string{
+		"/src/foo.go": "package foo\n",
+		"/src/bar.go": "package bar\n",
+	}
+	got := GeneratePrompt("Fix the bug.", files, Options{
+		Inplace:   true,
+		FileNotes: map[string]string{"/src/foo.go": "Only fix the bug here."},
+	})
+
+	note := "Note for /src/foo.go: Only fix the bug here.\n"
+	begin := utils.BeginMarkerPrefix + "/src/foo.go" + utils.BeginMarkerSuffix
+	if !strings.Contains(got, note+begin) {
+		t.Errorf("GeneratePrompt() does not emit the note immediately before the BEGIN block of /src/foo.go:\n%s", got)
+	}
+	if strings.Contains(got, "Note for /src/bar.go") {
+		t.Errorf("GeneratePrompt() emitted a note for /src/bar.go, which has none:\n%s", got)
+	}
+
+	// A nil map must produce the same prompt as before notes existed.
+	withNil := GeneratePrompt("Fix the bug.", map[string]string{"/src/foo.go": "package foo\n"}, Options{Inplace: true})
+	if strings.Contains(withNil, "Note for") {
+		t.Errorf("GeneratePrompt() with nil notes emitted a note:\n%s", withNil)
+	}
+}
+
+func TestGeneratePrompt_PrefixSuffix(t *testing.T) {
+	files := map[string]string{"/src/foo.go": "package foo\n"}
+	got := GeneratePrompt("Fix the bug.", files, Options{
+		Inplace: true,
+		Prefix:  "Follow our style guide.",
+		Suffix:  "Keep changes minimal.",
+	})
+
+	if want := "Follow our style guide.\nFix the bug.\nKeep changes minimal.\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("GeneratePrompt() does not start with the wrapped user input %q:\n%s", want, got)
+	}
+	suffix := strings.Index(got, "Keep changes minimal.")
+	begin := strings.Index(got, utils.BeginMarkerPrefix+"/src/foo.go")
+	instructions := strings.Index(got, "IMPORTANT:")
+	if !(suffix < begin && begin < instructions) {
+		t.Errorf("GeneratePrompt() places the suffix at %d, the file at %d and the instructions at %d; want them in that order:\n%s", suffix, begin, instructions, got)
+	}
+
+	// Without a prefix or suffix, the prompt starts with the user input.
+	if plain := GeneratePrompt("Fix the bug.", files, Options{Inplace: true}); !strings.HasPrefix(plain, "Fix the bug.\n") {
+		t.Errorf("GeneratePrompt() without prefix/suffix changed the layout:\n%s", plain)
+	}
+}
+
+func TestGeneratePrompt_ContextFiles(t *testing.T) {
+	files := map[string]string{"/src/foo.go": "package foo\n"}
+	got := GeneratePrompt("Implement the interface.", files, Options{
+		Inplace:      true,
+		ContextFiles: map[string]string{"/src/api.go": "package api\n"},
+	})
+
+	contextBlock := utils.BeginMarkerPrefix + "/src/api.go" + utils.BeginMarkerSuffix + "package api\n"
+	intro := strings.Index(got, contextFilesIntro)
+	block := strings.Index(got, contextBlock)
+	if intro == -1 || block < intro {
+		t.Errorf("GeneratePrompt() does not include the context file after the read-only instruction:\n%s", got)
+	}
+	instructions := got[strings.Index(got, "IMPORTANT:"):]
+	if strings.Contains(instructions, "/src/api.go") {
+		t.Errorf("GeneratePrompt() lists the context file among the files to return:\n%s", instructions)
+	}
+	if !strings.Contains(instructions, "/src/foo.go") {
+		t.Errorf("GeneratePrompt() does not list the editable file among the files to return:\n%s", instructions)
+	}
+}
+
+func TestGeneratePrompt_Markers(t *testing.T) {
+	markers := utils.NewMarkers("abc123")
+	got := GeneratePrompt("Fix it.", map[string]string{"/src/foo.go": "package foo\n"}, Options{Inplace: true, Markers: markers})
+	if !strings.Contains(got, markers.Begin("/src/foo.go")+"package foo\n"+markers.End("/src/foo.go")) {
+		t.Errorf("prompt does not frame the file with the custom markers:\n%s", got)
+	}
+	if strings.Contains(got, utils.BeginMarkerPrefix) {
+		t.Errorf("prompt still contains the default begin marker:\n%s", got)
+	}
+}
+
+func TestGeneratePrompt_LengthHints(t *testing.T) {
+	files := map[string]string{"/src/foo.go": "package foo\n"}
+	got := GeneratePrompt("Fix the bug.", files, Options{Inplace: true, LengthHints: true})
+
+	if want := utils.DefaultMarkers.BeginWithLength("/src/foo.go", 12); !strings.Contains(got, want+"package foo\n") {
+		t.Errorf("GeneratePrompt() does not frame /src/foo.go with %q:\n%s", want, got)
+	}
+	if !strings.Contains(got, "/src/foo.go"+lengthHintPlaceholder) || !strings.Contains(got, lengthHintInstruction) {
+		t.Errorf("GeneratePrompt() does not ask for length hints in the response:\n%s", got)
+	}
+
+	path, length := utils.SplitLengthHint("/src/foo.go (12 bytes)")
+	if path != "/src/foo.go" || length != 12 {
+		t.Errorf("SplitLengthHint() = %q, %d, want %q, 12", path, length, "/src/foo.go")
+	}
+}
+
+func TestGeneratePrompt_Base64(t *testing.T) {
+	files := map[string]string{"/src/foo.go": "package foo\n"}
+	got := GeneratePrompt("Fix the bug.", files, Options{Inplace: true, Base64: true})
+
+	// The files are sent as text; only the response is encoded.
+	if !strings.Contains(got, utils.DefaultMarkers.Begin("/src/foo.go")+"package foo\n") {
+		t.Errorf("GeneratePrompt() does not send /src/foo.go as text:\n%s", got)
+	}
+	if !strings.Contains(got, "{base64 of the content for /src/foo.go}") || !strings.Contains(got, base64Instruction) {
+		t.Errorf("GeneratePrompt() does not ask for base64 content in the response:\n%s", got)
+	}
+	if plain := GeneratePrompt("Fix the bug.", files, Options{Inplace: true}); strings.Contains(plain, base64Instruction) {
+		t.Errorf("GeneratePrompt() without Base64 asks for base64 content:\n%s", plain)
+	}
+}
\ No newline at end of file
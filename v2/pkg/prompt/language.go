@@ -0,0 +1,51 @@
+package prompt
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// languageByExtension maps lower-case file extensions to the language name shown to the model.
+var languageByExtension = map[string]string{
+	".go":    "Go",
+	".py":    "Python",
+	".js":    "JavaScript",
+	".jsx":   "JavaScript (JSX)",
+	".ts":    "TypeScript",
+	".tsx":   "TypeScript (TSX)",
+	".java":  "Java",
+	".kt":    "Kotlin",
+	".c":     "C",
+	".h":     "C",
+	".cc":    "C++",
+	".cpp":   "C++",
+	".hpp":   "C++",
+	".rs":    "Rust",
+	".rb":    "Ruby",
+	".sh":    "Shell",
+	".md":    "Markdown",
+	".yaml":  "YAML",
+	".yml":   "YAML",
+	".json":  "JSON",
+	".proto": "Protocol Buffers",
+	".sql":   "SQL",
+	".html":  "HTML",
+	".css":   "CSS",
+}
+
+// languageByName maps well-known file names without a telling extension to their language.
+var languageByName = map[string]string{
+	"Makefile":   "Makefile",
+	"Dockerfile": "Dockerfile",
+	"go.mod":     "Go module file",
+}
+
+// LanguageForPath returns the language of the file at path, derived from its name
+// or extension, or "" if it is not known.
+func LanguageForPath(path string) string {
+	base := filepath.Base(path)
+	if lang, ok := languageByName[base]; ok {
+		return lang
+	}
+	return languageByExtension[strings.ToLower(filepath.Ext(base))]
+}
\ No newline at end of file
@@ -0,0 +1,40 @@
+package prompt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/utils"
+)
+
+func TestLanguageForPath(t *testing.T) {
+	tests := map[string]string{
+		"/src/main.go":       "Go",
+		"/src/App.TSX":       "TypeScript (TSX)",
+		"scripts/build.sh":   "Shell",
+		"/repo/Makefile":     "Makefile",
+		"/repo/go.mod":       "Go module file",
+		"/repo/LICENSE":      "",
+		"/repo/data.unknown": "",
+	}
+	for path, want := range tests {
+		if got := LanguageForPath(path); got != want {
+			t.Errorf("LanguageForPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestGeneratePrompt_Language(t *testing.T) {
+	files := map[string]string{"/src/foo.go": "package foo\n", "/src/LICENSE": "MIT\n"}
+	got := GeneratePrompt("Fix the bug.", files, Options{Inplace: true})
+
+	if !strings.Contains(got, "Language of /src/foo.go: Go\n"+utils.BeginMarkerPrefix+"/src/foo.go") {
+		t.Errorf("GeneratePrompt() does not tag the Go file with its language right before its BEGIN block:\n%s", got)
+	}
+	if strings.Contains(got, "Language of /src/LICENSE") {
+		t.Errorf("GeneratePrompt() tagged a file of unknown language:\n%s", got)
+	}
+	if !strings.Contains(got, "idiomatically formatted") {
+		t.Errorf("GeneratePrompt() does not ask for idiomatic formatting:\n%s", got)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,28 @@
+package prompt
+
+import (
+	"strings"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// fileSelectionInstruction asks the model for a bare list of paths that the response
+// parser can read line by line.
+const fileSelectionInstruction = "\nIMPORTANT: Do not make any changes yet. Respond ONLY with the paths from the list above that are needed to carry out the task, one per line, exactly as they are written. Do not include explanations or any other text.\n"
+
+// GenerateFileSelectionPrompt constructs the sub-prompt asking the model which of paths
+// are relevant to the task described by userInput. Only the paths are sent, not the
+// contents, so the prompt stays small however large the files are.
+func GenerateFileSelectionPrompt(userInput string, paths []string) string {
+	logging.V(1).Infof("Generating file selection prompt for %d files.", len(paths))
+	var builder strings.Builder
+	builder.WriteString("Here is a coding task:\n\n")
+	builder.WriteString(userInput)
+	builder.WriteString("\n\nThe following files are available:\n\n")
+	for _, path := range paths {
+		builder.WriteString(path)
+		builder.WriteString("\n")
+	}
+	builder.WriteString(fileSelectionInstruction)
+	return builder.String()
+}
\ No newline at end of file
@@ -0,0 +1,43 @@
+package prompt
+
+import (
+	"strings"
+
+	"github.com/zicongmei/ai-coder/v2/pkg/logging"
+)
+
+// Delimiters around the content in a single-file prompt. The response is expected
+// without them, so they only need to be recognizable to the model.
+const (
+	singleFileBegin = "=== BEGIN INPUT ===\n"
+	singleFileEnd   = "\n=== END INPUT ===\n"
+)
+
+// singleFileInstruction asks the model for nothing but the new content of the file.
+const singleFileInstruction = "\nIMPORTANT: Respond ONLY with the complete, modified content of the input. Do not include the BEGIN/END INPUT lines, markdown code fences, explanations or any other text.\n"
+
+// GenerateSingleFilePrompt constructs a prompt for editing one unnamed piece of content,
+// such as a file piped on stdin. The user input, wrapped in opts.Prefix and opts.Suffix,
+// is followed by the content and an instruction to return only the modified content, so
+// the response can be used as is. Only opts.Prefix and opts.Suffix are used.
+func GenerateSingleFilePrompt(userInput, content string, opts Options) string {
+	logging.V(1).Infof("Generating single-file prompt for %d bytes of content.", len(content))
+	var builder strings.Builder
+	if opts.Prefix != "" {
+		builder.WriteString(opts.Prefix)
+		builder.WriteString("\n")
+	}
+	builder.WriteString(userInput)
+	builder.WriteString("\n")
+	if opts.Suffix != "" {
+		builder.WriteString(opts.Suffix)
+		builder.WriteString("\n")
+	}
+	builder.WriteString("\n")
+	builder.WriteString(singleFileBegin)
+	builder.WriteString(content)
+	builder.WriteString(singleFileEnd)
+	builder.WriteString(singleFileInstruction)
+	builder.WriteString(formattingInstruction)
+	return builder.String()
+}
\ No newline at end of file
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dump.txt")
+	for _, content := range []string{"first\n", "second\n"} {
+		if err := WriteFileAtomic(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFileAtomic() error = %v", err)
+		}
+		if got, _ := os.ReadFile(path); string(got) != content {
+			t.Errorf("content of %q = %q, want %q", path, got, content)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %q: %v", path, err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("mode of %q = %v, want 0644", path, info.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the written file", len(entries))
+	}
+
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "dump.txt"), []byte("x"), 0644); err == nil {
+		t.Error("WriteFileAtomic() into a missing directory succeeded, want an error")
+	}
+}
\ No newline at end of file
@@ -1,6 +1,123 @@
 package utils
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
 const BeginMarkerPrefix = "--- Start of File: "
 const BeginMarkerSuffix = " ---\n"
 const EndMarkerPrefix = "\n--- End of File: "
-const EndMarkerSuffix = " ---\n"
\ No newline at end of file
+const EndMarkerSuffix = " ---\n"
+
+// LengthHintFormat follows the path in a begin marker that states the length of the
+// file's content in bytes, e.g. "--- Start of File: /a.go (1234 bytes) ---".
+const LengthHintFormat = " (%d bytes)"
+
+// lengthHintPattern matches LengthHintFormat at the end of a begin marker's path.
+var lengthHintPattern = regexp.MustCompile(` \((\d+) bytes\)$`)
+
+// Markers holds the strings that frame each file in the prompt and in a full-text response.
+// The prompt generator and the parser must use the same Markers. The zero value stands
+// for DefaultMarkers.
+type Markers struct {
+	BeginPrefix string
+	BeginSuffix string
+	EndPrefix   string
+	EndSuffix   string
+}
+
+// DefaultMarkers are the markers built from the constants above.
+var DefaultMarkers = Markers{
+	BeginPrefix: BeginMarkerPrefix,
+	BeginSuffix: BeginMarkerSuffix,
+	EndPrefix:   EndMarkerPrefix,
+	EndSuffix:   EndMarkerSuffix,
+}
+
+// NewMarkers returns markers that include nonce, e.g. "--- Start of File [3f9a0c1d]: ",
+// so that file content containing the default marker text is not mistaken for framing.
+// An empty nonce returns DefaultMarkers.
+func NewMarkers(nonce string) Markers {
+	if nonce == "" {
+		return DefaultMarkers
+	}
+	return Markers{
+		BeginPrefix: fmt.Sprintf("--- Start of File [%s]: ", nonce),
+		BeginSuffix: BeginMarkerSuffix,
+		EndPrefix:   fmt.Sprintf("\n--- End of File [%s]: ", nonce),
+		EndSuffix:   EndMarkerSuffix,
+	}
+}
+
+// RandomNonce returns a random 8-character hex string for NewMarkers.
+func RandomNonce() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate marker nonce: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// OrDefault returns m, or DefaultMarkers if m is the zero value.
+func (m Markers) OrDefault() Markers {
+	if m == (Markers{}) {
+		return DefaultMarkers
+	}
+	return m
+}
+
+// Begin returns the marker that opens the block for path.
+func (m Markers) Begin(path string) string {
+	return m.BeginPrefix + path + m.BeginSuffix
+}
+
+// End returns the marker that closes the block for path.
+func (m Markers) End(path string) string {
+	return m.EndPrefix + path + m.EndSuffix
+}
+
+// FoundIn reports whether content contains the opening text of a begin or end marker of
+// m, so that a block framed with m could be cut short or split when parsed.
+func (m Markers) FoundIn(content string) bool {
+	m = m.OrDefault()
+	return strings.Contains(content, m.BeginPrefix) || strings.Contains(content, strings.TrimPrefix(m.EndPrefix, "\n"))
+}
+
+// BeginWithLength returns the marker that opens the block for path, with a hint that
+// the content is length bytes long.
+func (m Markers) BeginWithLength(path string, length int) string {
+	return m.BeginPrefix + path + fmt.Sprintf(LengthHintFormat, length) + m.BeginSuffix
+}
+
+// SplitLengthHint splits the text between a begin marker's prefix and suffix into the
+// path and the length from its hint, or -1 if there is no hint.
+func SplitLengthHint(segment string) (string, int) {
+	m := lengthHintPattern.FindStringSubmatchIndex(segment)
+	if m == nil {
+		return segment, -1
+	}
+	length, err := strconv.Atoi(segment[m[2]:m[3]])
+	if err != nil {
+		return segment, -1
+	}
+	return segment[:m[0]], length
+}
+
+// Lines framing an anchored edit in a search/replace response: the SEARCH line names
+// the file, and the snippet to find is separated from its replacement by the divider.
+//
+//	<<<<<<< SEARCH /path/to/file
+//	old lines
+//	=======
+//	new lines
+//	>>>>>>> REPLACE
+const (
+	SearchMarkerPrefix = "<<<<<<< SEARCH "
+	SnippetDivider     = "======="
+	ReplaceMarker      = ">>>>>>> REPLACE"
+)
\ No newline at end of file
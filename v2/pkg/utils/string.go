@@ -1,11 +1,39 @@
 package utils
 
+import "unicode/utf8"
+
 // TruncateString is a helper function to shorten long strings for logging or display,
 // preventing them from becoming excessively long. If the string's length exceeds
-// maxLen, it is truncated and "..." is appended.
+// maxLen bytes, it is truncated and "..." is appended. The cut is moved back to a rune
+// boundary, so a multi-byte UTF-8 character is never split and the result stays valid UTF-8.
+// See TruncateRunes to limit by character count instead.
 func TruncateString(s string, maxLen int) string {
 	if len(s) <= maxLen {
 		return s
 	}
-	return s[:maxLen] + "..."
+	cut := max(maxLen, 0)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
+
+// TruncateRunes is like TruncateString but limits s to maxRunes characters (runes) instead
+// of bytes, so CJK text and emoji keep as many characters in logs as ASCII does.
+// Use TruncateString where the byte size of the result matters.
+func TruncateRunes(s string, maxRunes int) string {
+	runes := 0
+	for i := range s {
+		if runes == max(maxRunes, 0) {
+			return s[:i] + "..."
+		}
+		runes++
+	}
+	return s
+}
+
+// ApproxTokenCount returns a rough token count for s, assuming about four bytes per token.
+// It is used when the AI endpoint cannot count tokens itself.
+func ApproxTokenCount(s string) int {
+	return (len(s) + 3) / 4
 }
\ No newline at end of file
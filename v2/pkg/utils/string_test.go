@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateString(t *testing.T) {
+	tests := []struct {
+		name   string
+		s      string
+		maxLen int
+		want   string
+	}{
+		{name: "Short string is unchanged", s: "abc", maxLen: 5, want: "abc"},
+		{name: "Exact length is unchanged", s: "abcde", maxLen: 5, want: "abcde"},
+		{name: "ASCII is cut at maxLen", s: "abcdef", maxLen: 3, want: "abc..."},
+		{name: "Cut inside a two-byte rune", s: "aé b", maxLen: 2, want: "a..."},
+		{name: "Cut after a two-byte rune", s: "aé b", maxLen: 3, want: "aé..."},
+		{name: "Cut inside a four-byte rune", s: "ab😀cd", maxLen: 4, want: "ab..."},
+		{name: "Cut inside the first rune", s: "日本語", maxLen: 2, want: "..."},
+		{name: "Non-positive maxLen", s: "abc", maxLen: 0, want: "..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TruncateString(tt.s, tt.maxLen)
+			if got != tt.want {
+				t.Errorf("TruncateString(%q, %d) = %q, want %q", tt.s, tt.maxLen, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("TruncateString(%q, %d) = %q is not valid UTF-8", tt.s, tt.maxLen, got)
+			}
+		})
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        string
+		maxRunes int
+		want     string
+	}{
+		{name: "Short string is unchanged", s: "日本語", maxRunes: 3, want: "日本語"},
+		{name: "ASCII", s: "abcdef", maxRunes: 3, want: "abc..."},
+		{name: "CJK", s: "日本語のテキスト", maxRunes: 3, want: "日本語..."},
+		{name: "Emoji", s: "😀😃😄😁", maxRunes: 2, want: "😀😃..."},
+		{name: "Non-positive maxRunes", s: "abc", maxRunes: 0, want: "..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TruncateRunes(tt.s, tt.maxRunes); got != tt.want {
+				t.Errorf("TruncateRunes(%q, %d) = %q, want %q", tt.s, tt.maxRunes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateRunesVersusTruncateString(t *testing.T) {
+	// "héllo 世界 👋" mixes one-, two-, three- and four-byte runes.
+	s := "héllo 世界 👋!"
+	if got, want := TruncateString(s, 9), "héllo ..."; got != want {
+		t.Errorf("TruncateString(%q, 9) = %q, want %q", s, got, want)
+	}
+	if got, want := TruncateRunes(s, 9), "héllo 世界 ..."; got != want {
+		t.Errorf("TruncateRunes(%q, 9) = %q, want %q", s, got, want)
+	}
+	if got, want := TruncateRunes(s, 10), "héllo 世界 👋..."; got != want {
+		t.Errorf("TruncateRunes(%q, 10) = %q, want %q", s, got, want)
+	}
+}
\ No newline at end of file
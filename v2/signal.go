@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/golang/glog"
+)
+
+// interrupted is set once SIGINT or SIGTERM has been received.
+var interrupted atomic.Bool
+
+// cancelOnSignal returns a context that is canceled on the first SIGINT or SIGTERM.
+// Passed to flow.Run, it aborts the in-flight AI request and rolls back an apply in
+// progress, so the run shuts down gracefully through its normal error path: logs are
+// flushed and no file or dump is left behind half-written. The handler is then removed,
+// so a second signal terminates the process immediately.
+func cancelOnSignal() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		interrupted.Store(true)
+		glog.Warningf("Received %v; shutting down gracefully. Send it again to exit immediately.", sig)
+		cancel()
+	}()
+	return ctx
+}
\ No newline at end of file
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCancelOnSignal(t *testing.T) {
+	defer interrupted.Store(false)
+	ctx := cancelOnSignal()
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatalf("Failed to send SIGINT: %v", err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context was not canceled after SIGINT")
+	}
+	if !interrupted.Load() {
+		t.Error("interrupted is not set after SIGINT")
+	}
+}
\ No newline at end of file
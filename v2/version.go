@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, overridable at build time, e.g.:
+//
+//	go build -ldflags "-X main.version=v2.1.0 -X main.commit=$(git rev-parse HEAD)" -o coder .
+var (
+	version = "" // Release version; falls back to the module version from the build info
+	commit  = "" // Git commit; falls back to the VCS revision stamped by the Go toolchain
+)
+
+// versionString returns a human-readable description of this build.
+func versionString() string {
+	v, c, goVersion := version, commit, runtime.Version()
+	modified := false
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "" {
+			v = info.Main.Version
+		}
+		if info.GoVersion != "" {
+			goVersion = info.GoVersion
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if c == "" {
+					c = setting.Value
+				}
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+	}
+	if v == "" {
+		v = "(devel)"
+	}
+	if c == "" {
+		c = "unknown"
+	} else if modified && commit == "" {
+		c += "-dirty"
+	}
+	return fmt.Sprintf("coder version %s (commit %s, built with %s, %s/%s)", v, c, goVersion, runtime.GOOS, runtime.GOARCH)
+}
\ No newline at end of file